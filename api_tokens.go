@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ScopeItemsRead  = "items:read"
+	ScopeItemsWrite = "items:write"
+	ScopeAdmin      = "admin"
+
+	// apiTokenPrefix starts every token, so leaked tokens are easy to find
+	// with secret scanners.
+	apiTokenPrefix = "spk_"
+)
+
+var knownScopes = []string{ScopeItemsRead, ScopeItemsWrite, ScopeAdmin}
+
+// APIToken is an API key as it is stored: only the SHA-256 hash of its
+// secret is kept, so the file doesn't give the keys away. Tokens are long
+// random strings, so a fast hash is enough.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenView is a token as the API shows it, without its hash. Secret is
+// only set in the responses that create or rotate it.
+type APITokenView struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Secret     string     `json:"secret,omitempty"`
+}
+
+func (t *APIToken) view() APITokenView {
+	return APITokenView{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		RotatedAt:  t.RotatedAt,
+		RevokedAt:  t.RevokedAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+// tokenStore keeps the API tokens in a JSON file. Creating, rotating and
+// revoking a token save the file right away; when a token was last used is
+// only saved by the api-token-flush job, so authenticating doesn't write.
+type tokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens map[string]*APIToken
+	dirty  bool
+	now    func() time.Time
+}
+
+// apiTokens holds the API tokens; nil unless -api-tokens-path is set.
+var apiTokens *tokenStore
+
+func openTokenStore(path string) (*tokenStore, error) {
+	store := &tokenStore{path: path, tokens: map[string]*APIToken{}, now: time.Now}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*APIToken
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		store.tokens[token.ID] = token
+	}
+	return store, nil
+}
+
+// Create stores a new token and returns it with its secret.
+func (s *tokenStore) Create(name string, scopes []string, expiresAt *time.Time) (APITokenView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := randomHex(8)
+	if err != nil {
+		return APITokenView{}, err
+	}
+	token := &APIToken{ID: id, Name: name, Scopes: scopes, CreatedAt: s.now().UTC(), ExpiresAt: expiresAt}
+	secret, err := s.newSecret(token)
+	if err != nil {
+		return APITokenView{}, err
+	}
+	s.tokens[id] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return APITokenView{}, err
+	}
+	view := token.view()
+	view.Secret = secret
+	return view, nil
+}
+
+// List returns every token, revoked and expired ones included, oldest first.
+func (s *tokenStore) List() []APITokenView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	views := make([]APITokenView, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		views = append(views, token.view())
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].CreatedAt.Before(views[j].CreatedAt) })
+	return views
+}
+
+// Rotate gives the token a new secret; the old one stops working at once.
+func (s *tokenStore) Rotate(id string) (APITokenView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || token.RevokedAt != nil {
+		return APITokenView{}, NotFoundError
+	}
+	oldHash := token.Hash
+	secret, err := s.newSecret(token)
+	if err != nil {
+		return APITokenView{}, err
+	}
+	now := s.now().UTC()
+	token.RotatedAt = &now
+	if err := s.save(); err != nil {
+		token.Hash = oldHash
+		return APITokenView{}, err
+	}
+	view := token.view()
+	view.Secret = secret
+	return view, nil
+}
+
+// Revoke disables the token for good. It stays listed, to tell what it was.
+func (s *tokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || token.RevokedAt != nil {
+		return NotFoundError
+	}
+	now := s.now().UTC()
+	token.RevokedAt = &now
+	if err := s.save(); err != nil {
+		token.RevokedAt = nil
+		return err
+	}
+	return nil
+}
+
+// Authenticate returns the token the secret belongs to, provided it is
+// neither revoked nor expired, and records that it was used.
+func (s *tokenStore) Authenticate(secret string) (*APIToken, bool) {
+	rest := strings.TrimPrefix(secret, apiTokenPrefix)
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok || rest == secret {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, false
+	}
+	now := s.now().UTC()
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && !now.Before(*token.ExpiresAt)) {
+		return nil, false
+	}
+	token.LastUsedAt = &now
+	s.dirty = true
+	copied := *token
+	return &copied, true
+}
+
+// flush saves when tokens were last used; it runs as a job.
+func (s *tokenStore) flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// newSecret sets a new random secret on token and returns it. The secret
+// names the token's ID, so authenticating needs to hash just once.
+func (s *tokenStore) newSecret(token *APIToken) (string, error) {
+	random, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	secret := apiTokenPrefix + token.ID + "_" + random
+	token.Hash = hashSecret(secret)
+	return secret, nil
+}
+
+func (s *tokenStore) save() error {
+	tokens := make([]*APIToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(s.path, content); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+type apiTokenContextKey struct{}
+
+// requestToken returns the API token the request authenticated with, if any.
+func requestToken(r *http.Request) *APIToken {
+	token, _ := r.Context().Value(apiTokenContextKey{}).(*APIToken)
+	return token
+}
+
+// caller is whoever a request authenticated as: an API token or a user.
+type caller interface {
+	HasScope(scope string) bool
+}
+
+// requestCaller returns the API token or user of the request, if any.
+func requestCaller(r *http.Request) caller {
+	if token := requestToken(r); token != nil {
+		return token
+	}
+	if user := requestUser(r); user != nil {
+		return user
+	}
+	return nil
+}
+
+// callerName names whoever the request of ctx authenticated as, like
+// "token:ID" or "user:ID", and "anonymous" when it didn't.
+func callerName(ctx context.Context) string {
+	if token, _ := ctx.Value(apiTokenContextKey{}).(*APIToken); token != nil {
+		return "token:" + token.ID
+	}
+	if authenticated, ok := ctx.Value(userContextKey{}).(authenticatedUser); ok {
+		return "user:" + authenticated.user.ID
+	}
+	return "anonymous"
+}
+
+// apiTokenMiddleware authenticates requests that carry an
+// "Authorization: Bearer" header and rejects those whose token isn't valid.
+// Requests without one pass on anonymously; requireScope decides whether
+// that's enough. With user accounts, bearer tokens that aren't API tokens are
+// left to accessTokenMiddleware.
+func apiTokenMiddleware(store *tokenStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			secret, ok := strings.CutPrefix(header, "Bearer ")
+			if header == "" || requestCaller(r) != nil || (ok && users != nil && !strings.HasPrefix(strings.TrimSpace(secret), apiTokenPrefix)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !ok {
+				ErrorCodeResponse(w, InvalidAPITokenCode)
+				return
+			}
+			token, ok := store.Authenticate(strings.TrimSpace(secret))
+			if !ok {
+				ErrorCodeResponse(w, InvalidAPITokenCode)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenContextKey{}, token)))
+		})
+	}
+}
+
+// requireScope lets only requests through whose token or user has the
+// scope that scopeFor picks for them.
+func requireScope(scopeFor func(r *http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := requestCaller(r)
+			if token == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				ErrorCodeResponse(w, APITokenRequiredCode)
+				return
+			}
+			if !token.HasScope(scopeFor(r)) {
+				ErrorCodeResponse(w, MissingScopeCode)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// itemScope asks for items:read to read and items:write to change items.
+func itemScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeItemsRead
+	}
+	return ScopeItemsWrite
+}
+
+func adminScope(*http.Request) string {
+	return ScopeAdmin
+}
+
+// createAPITokenRequest is the body of POST /admin/tokens. The expiry is
+// given either as a point in time or as a duration from now.
+type createAPITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn string     `json:"expires_in"`
+}
+
+func (req createAPITokenRequest) validate(now time.Time) (*time.Time, []FieldError) {
+	var errs []FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		errs = append(errs, newFieldError("name", APITokenNameRequiredCode))
+	}
+	if len(req.Scopes) == 0 {
+		errs = append(errs, newFieldError("scopes", UnknownScopeCode))
+	}
+	for _, scope := range req.Scopes {
+		known := false
+		for _, s := range knownScopes {
+			known = known || s == scope
+		}
+		if !known {
+			errs = append(errs, newFieldError("scopes", UnknownScopeCode))
+			break
+		}
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		in, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || in <= 0 || expiresAt != nil {
+			errs = append(errs, newFieldError("expires_in", InvalidExpiryCode))
+		} else {
+			at := now.Add(in).UTC()
+			expiresAt = &at
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		errs = append(errs, newFieldError("expires_at", InvalidExpiryCode))
+	}
+	return expiresAt, errs
+}
+
+func createAPIToken(w http.ResponseWriter, r *http.Request) {
+	var req createAPITokenRequest
+	if err := decodeBody(r, &req); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	expiresAt, errs := req.validate(apiTokens.now())
+	if len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
+	}
+
+	created, err := apiTokens.Create(strings.TrimSpace(req.Name), req.Scopes, expiresAt)
+	if err != nil {
+		InternalErrorResponse(w, "could not create the token")
+		return
+	}
+	CreatedResponse(w, created)
+}
+
+func listAPITokens(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, apiTokens.List())
+}
+
+func rotateAPIToken(w http.ResponseWriter, r *http.Request) {
+	rotated, err := apiTokens.Rotate(mux.Vars(r)["id"])
+	if errors.Is(err, NotFoundError) {
+		NotFoundResponse(w, "token with ID does not exist or is revoked")
+		return
+	}
+	if err != nil {
+		InternalErrorResponse(w, "could not rotate the token")
+		return
+	}
+	SuccessResponse(w, rotated)
+}
+
+func revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := apiTokens.Revoke(mux.Vars(r)["id"])
+	if errors.Is(err, NotFoundError) {
+		NotFoundResponse(w, "token with ID does not exist or is revoked")
+		return
+	}
+	if err != nil {
+		InternalErrorResponse(w, "could not revoke the token")
+		return
+	}
+	NoContentResponse(w)
+}
+
+// registerAPITokenRoutes adds the token management endpoints, which take a
+// token with the admin scope.
+func registerAPITokenRoutes(r *mux.Router) {
+	tokens := r.PathPrefix("/admin/tokens").Subrouter()
+	tokens.HandleFunc("", listAPITokens).Methods(http.MethodGet)
+	tokens.HandleFunc("", createAPIToken).Methods(http.MethodPost)
+	tokens.HandleFunc("/{id}/rotate", rotateAPIToken).Methods(http.MethodPost)
+	tokens.HandleFunc("/{id}", revokeAPIToken).Methods(http.MethodDelete)
+	tokens.Use(apiTokenMiddleware(apiTokens), requireScope(adminScope))
+}
+
+// setupAPITokens opens the token store when -api-tokens-path is set.
+func setupAPITokens(cfg Config) error {
+	if cfg.APITokensPath == "" {
+		if cfg.RequireAPIToken || cfg.CreateAdminToken != "" {
+			return errors.New("API tokens need -api-tokens-path")
+		}
+		return nil
+	}
+	store, err := openTokenStore(cfg.APITokensPath)
+	if err != nil {
+		return err
+	}
+	apiTokens = store
+	jobs.Add(Job{Name: "api-token-flush", Every: time.Minute, Run: store.flush})
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_apiTokenLifecycle(t *testing.T) {
+	defer func(original *tokenStore) { apiTokens = original }(apiTokens)
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := openTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiTokens = store
+	admin, _ := store.Create("bootstrap", []string{ScopeAdmin}, nil)
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, RequireAPIToken: true}
+	router := newRouter(cfg)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/items/", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	if w := do("GET", "/items/", admin.Secret, ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without the items:read scope, got %d", w.Code)
+	}
+
+	w := do("POST", "/admin/tokens", admin.Secret, `{"name":"reader","scopes":["items:read"],"expires_in":"24h"}`)
+	var reader APITokenView
+	json.Unmarshal(w.Body.Bytes(), &reader)
+	if w.Code != http.StatusCreated || reader.Secret == "" || reader.ExpiresAt == nil {
+		t.Fatalf("unexpected token creation response %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/items/", reader.Secret, ""); w.Code != http.StatusOK {
+		t.Errorf("expected the reader to list items, got %d", w.Code)
+	}
+	if w := do("POST", "/items/", reader.Secret, `{"name":"x"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected the reader not to create items, got %d", w.Code)
+	}
+
+	w = do("POST", "/admin/tokens/"+reader.ID+"/rotate", admin.Secret, "")
+	var rotated APITokenView
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+	if w := do("GET", "/items/", reader.Secret, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the old secret to stop working after rotation, got %d", w.Code)
+	}
+	if w := do("GET", "/items/", rotated.Secret, ""); w.Code != http.StatusOK {
+		t.Errorf("expected the rotated secret to work, got %d", w.Code)
+	}
+
+	if w := do("DELETE", "/admin/tokens/"+reader.ID, admin.Secret, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 on revoke, got %d", w.Code)
+	}
+	if w := do("GET", "/items/", rotated.Secret, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a revoked token to be rejected, got %d", w.Code)
+	}
+
+	// the file keeps hashes only, and survives a restart
+	store.flush(context.Background())
+	reopened, err := openTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	views := reopened.List()
+	if len(views) != 2 || views[1].RevokedAt == nil || views[1].LastUsedAt == nil {
+		t.Errorf("unexpected tokens after reopening: %+v", views)
+	}
+	if _, ok := reopened.Authenticate(admin.Secret); !ok {
+		t.Error("the admin token does not work after reopening")
+	}
+}
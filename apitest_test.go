@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testAPI is the full router in front of a fresh in-memory store of its own.
+// The handlers read the store and the optional features from globals, so
+// newTestAPI swaps those out for the test and puts them back after it; tests
+// using it may run in any order, but not in parallel.
+type testAPI struct {
+	t      *testing.T
+	Router http.Handler
+	// Admin is the router of the admin listener.
+	Admin http.Handler
+	Items *InMemoryItemRepository
+}
+
+// newTestAPI starts the API with nothing but the items given, and none of the
+// optional features. Tests turn features on by setting their globals with
+// isolate afterwards, and call Reroute when the feature adds routes.
+func newTestAPI(t *testing.T, items ...Item) *testAPI {
+	t.Helper()
+	repo := NewInMemoryItemRepository(items...)
+	isolate(t, &itemRepository, ItemRepository(repo))
+	isolate(t, &itemClock, itemClock)
+	isolate(t, &clientIDs, false)
+	isolate(t, &readOnly, false)
+	isolate(t, &uuidIDs, false)
+	isolate(t, &auditLog, nil)
+	isolate(t, &itemEvents, nil)
+	isolate(t, &recordings, nil)
+	isolate(t, &usage, nil)
+	isolate(t, &exportFiles, nil)
+	isolate(t, &listVersion, nil)
+	isolate(t, &pagingSnapshots, newPagingSnapshotStore())
+	rules := validationRules.Load()
+	validationRules.Store(nil)
+	t.Cleanup(func() { validationRules.Store(rules) })
+	api := &testAPI{t: t, Items: repo}
+	api.Reroute()
+	return api
+}
+
+// Reroute builds the router again, for the features turned on since.
+func (api *testAPI) Reroute() {
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}}
+	api.Router, api.Admin = newRouter(cfg), newAdminRouter(cfg)
+}
+
+// isolate sets a global for the rest of the test and restores it afterwards.
+func isolate[T any](t *testing.T, global *T, value T) {
+	original := *global
+	*global = value
+	t.Cleanup(func() { *global = original })
+}
+
+// Request sends a request through the router. A body other than a string or
+// nil is sent as JSON; headers come as name, value pairs.
+func (api *testAPI) Request(method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.serve(api.Router, method, path, body, headers)
+}
+
+// AdminRequest sends a request to the admin listener, like Request.
+func (api *testAPI) AdminRequest(method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.serve(api.Admin, method, path, body, headers)
+}
+
+func (api *testAPI) serve(router http.Handler, method, path string, body any, headers []string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	var reader *bytes.Reader
+	switch body := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(body))
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// decodeResponse checks the status of the response and decodes its body.
+func decodeResponse[T any](t *testing.T, w *httptest.ResponseRecorder, status int) T {
+	t.Helper()
+	var value T
+	if w.Code != status {
+		t.Fatalf("expected %d, got %d: %s", status, w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+		t.Fatalf("expected a JSON body, got %s: %v", w.Body, err)
+	}
+	return value
+}
+
+// assertJSON checks the status of the response and that its body is the
+// expected JSON, whatever the order of the keys and the spacing.
+func assertJSON(t *testing.T, w *httptest.ResponseRecorder, status int, expected string) {
+	t.Helper()
+	var got, want any
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Fatalf("the expected body isn't JSON: %v", err)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != status || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %d %s, got %d %s", status, expected, w.Code, w.Body)
+	}
+}
+
+func Test_testAPIIsolation(t *testing.T) {
+	original := itemRepository
+	t.Run("first", func(t *testing.T) {
+		api := newTestAPI(t, Item{ID: 0, Name: "only"})
+		api.Request("POST", "/items/", Item{Name: "added"})
+		items := decodeResponse[[]Item](t, api.Request("GET", "/items/", nil), http.StatusOK)
+		if len(items) != 2 {
+			t.Errorf("expected the seeded and the added item, got %+v", items)
+		}
+	})
+	t.Run("second", func(t *testing.T) {
+		api := newTestAPI(t)
+		assertJSON(t, api.Request("GET", "/items/", nil), http.StatusOK, `[]`)
+	})
+	if itemRepository != original {
+		t.Error("expected the store to be put back")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+)
+
+// The states of an item, as ?state= on GET /items/ selects them.
+const (
+	ItemStateActive   = "active"
+	ItemStateArchived = "archived"
+)
+
+// State tells whether the item is archived.
+func (item Item) State() string {
+	if item.ArchivedAt != nil {
+		return ItemStateArchived
+	}
+	return ItemStateActive
+}
+
+// archiveItem takes the item out of the default listing without deleting
+// it. Archiving an archived item keeps the time it was first archived.
+func archiveItem(w http.ResponseWriter, r *http.Request) {
+	setArchived(w, r, true)
+}
+
+// unarchiveItem lists the item again.
+func unarchiveItem(w http.ResponseWriter, r *http.Request) {
+	setArchived(w, r, false)
+}
+
+func setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+
+	var item *Item
+	err = itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		item, err = tx.Get(r.Context(), *id)
+		if err != nil {
+			return err
+		}
+		if (item.ArchivedAt != nil) == archived {
+			return nil
+		}
+		item.ArchivedAt = nil
+		if archived {
+			now := itemClock().UTC()
+			item.ArchivedAt = &now
+		}
+		return tx.Update(r.Context(), *item)
+	})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not archive item")
+		return
+	}
+
+	SuccessResponse(w, item)
+}
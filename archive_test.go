@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_archiveItem(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "current"}, Item{ID: 1, Name: "old"})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/", listItems)
+	router.HandleFunc("/items/{id}/archive", archiveItem)
+	router.HandleFunc("/items/{id}/unarchive", unarchiveItem)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+	list := func(path string) string {
+		var items []Item
+		json.Unmarshal(do("GET", path).Body.Bytes(), &items)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	rr := do("POST", "/items/1/archive")
+	var archived Item
+	json.Unmarshal(rr.Body.Bytes(), &archived)
+	if rr.Code != http.StatusOK || archived.ArchivedAt == nil {
+		t.Fatalf("expected the item archived, got %d %s", rr.Code, rr.Body)
+	}
+	var again Item
+	json.Unmarshal(do("POST", "/items/1/archive").Body.Bytes(), &again)
+	if again.ArchivedAt == nil || !again.ArchivedAt.Equal(*archived.ArchivedAt) {
+		t.Errorf("expected archiving twice to keep the first time, got %+v", again)
+	}
+
+	cases := map[string]string{
+		"/items/":                "current",
+		"/items/?state=active":   "current",
+		"/items/?state=archived": "old",
+		"/items/?state=all":      "current,old",
+	}
+	for path, want := range cases {
+		if got := list(path); got != want {
+			t.Errorf("%s listed %q, want %q", path, got, want)
+		}
+	}
+	if rr := do("GET", "/items/?state=deleted"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown state to be rejected, got %d", rr.Code)
+	}
+
+	do("POST", "/items/1/unarchive")
+	if got := list("/items/"); got != "current,old" {
+		t.Errorf("expected the unarchived item listed again, got %q", got)
+	}
+	if rr := do("POST", "/items/7/archive"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing item, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The states of an AsyncJob.
+const (
+	AsyncJobRunning   = "running"
+	AsyncJobSucceeded = "succeeded"
+	AsyncJobFailed    = "failed"
+)
+
+// maxFinishedAsyncJobs is how many finished jobs are kept for their clients
+// to look up; older ones are forgotten first.
+const maxFinishedAsyncJobs = 100
+
+// AsyncJob is a long-running operation a client asked to run in the
+// background with Prefer: respond-async. GET /jobs/{id} reports it.
+type AsyncJob struct {
+	ID         string       `json:"id"`
+	Kind       string       `json:"kind"`
+	Status     string       `json:"status"`
+	Progress   *JobProgress `json:"progress,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	// Location is where the result can be fetched, for jobs that make one.
+	Location string      `json:"location,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// JobProgress counts the units of work an AsyncJob has done.
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// AsyncJobOutcome is what a finished job leaves behind.
+type AsyncJobOutcome struct {
+	Location string
+	Result   interface{}
+}
+
+// asyncJobRegistry holds the jobs that are running and the latest finished
+// ones.
+type asyncJobRegistry struct {
+	mu       sync.Mutex
+	jobs     map[string]*AsyncJob
+	finished []string
+}
+
+var asyncJobs = newAsyncJobRegistry()
+
+func newAsyncJobRegistry() *asyncJobRegistry {
+	return &asyncJobRegistry{jobs: map[string]*AsyncJob{}}
+}
+
+// Start runs fn in the background as a job of the kind. fn reports progress
+// through the function it is handed. ctx keeps the values of the request,
+// but not its deadline, as the job outlives it.
+func (reg *asyncJobRegistry) Start(ctx context.Context, kind string, fn func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error)) AsyncJob {
+	var random [16]byte
+	rand.Read(random[:])
+	job := &AsyncJob{ID: hex.EncodeToString(random[:]), Kind: kind, Status: AsyncJobRunning, StartedAt: time.Now().UTC()}
+	reg.mu.Lock()
+	reg.jobs[job.ID] = job
+	started := *job
+	reg.mu.Unlock()
+
+	go func() {
+		outcome, err := runAsyncJob(context.WithoutCancel(ctx), fn, func(done, total int) {
+			reg.mu.Lock()
+			defer reg.mu.Unlock()
+			job.Progress = &JobProgress{Done: done, Total: total}
+		})
+		reg.finish(job, outcome, err)
+	}()
+	return started
+}
+
+// runAsyncJob runs fn, turning a panic into an error so the job is marked
+// failed instead of taking the process down.
+func runAsyncJob(ctx context.Context, fn func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error), progress func(done, total int)) (outcome AsyncJobOutcome, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("async job panicked: %v\n%s", p, debug.Stack())
+			outcome, err = AsyncJobOutcome{}, fmt.Errorf("the job failed unexpectedly: %v", p)
+		}
+	}()
+	return fn(ctx, progress)
+}
+
+func (reg *asyncJobRegistry) finish(job *AsyncJob, outcome AsyncJobOutcome, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status, job.Error = AsyncJobFailed, err.Error()
+	} else {
+		job.Status, job.Location, job.Result = AsyncJobSucceeded, outcome.Location, outcome.Result
+	}
+	reg.finished = append(reg.finished, job.ID)
+	if len(reg.finished) > maxFinishedAsyncJobs {
+		delete(reg.jobs, reg.finished[0])
+		reg.finished = reg.finished[1:]
+	}
+}
+
+// Get returns a copy of the job with the ID.
+func (reg *asyncJobRegistry) Get(id string) (AsyncJob, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	job, ok := reg.jobs[id]
+	if !ok {
+		return AsyncJob{}, false
+	}
+	return *job, true
+}
+
+// prefersAsync tells whether the client sent Prefer: respond-async.
+func prefersAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(preference, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AcceptedJobResponse answers 202 with the job, pointing Location at it.
+func AcceptedJobResponse(w http.ResponseWriter, job AsyncJob) {
+	w.Header().Set("Location", jobLocation(job.ID))
+	w.Header().Set("Preference-Applied", "respond-async")
+	JSONResponse(w, http.StatusAccepted, job)
+}
+
+// asyncJobRoute is GET /jobs/{id} in the current router, which builds the
+// Location of jobs under the base path.
+var asyncJobRoute *mux.Route
+
+func jobLocation(id string) string {
+	if asyncJobRoute != nil {
+		if url, err := asyncJobRoute.URLPath("id", id); err == nil {
+			return url.Path
+		}
+	}
+	return "/jobs/" + id
+}
+
+func getAsyncJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := asyncJobs.Get(mux.Vars(r)["id"])
+	if !ok {
+		ErrorCodeResponse(w, JobNotFoundCode)
+		return
+	}
+	SuccessResponse(w, job)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForJob polls the job until it is no longer running.
+func waitForJob(t *testing.T, api *testAPI, location string) AsyncJob {
+	t.Helper()
+	for range 100 {
+		job := decodeResponse[AsyncJob](t, api.Request("GET", location, nil), http.StatusOK)
+		if job.Status != AsyncJobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the job at %s is still running", location)
+	return AsyncJob{}
+}
+
+func Test_asyncImportAndExport(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "Scarf"}, Item{ID: 1, Name: "Hat"})
+	isolate(t, &jobs, newScheduler())
+	isolate(t, &asyncJobs, newAsyncJobRegistry())
+	if err := setupExports(Config{ExportDir: t.TempDir(), ExportTTL: time.Hour, ExportMaxFiles: 10}); err != nil {
+		t.Fatal(err)
+	}
+	api.Reroute()
+
+	w := api.Request("GET", "/items/export.xlsx", nil, "Prefer", "respond-async, wait=0")
+	if w.Code != http.StatusAccepted || w.Header().Get("Preference-Applied") != "respond-async" || !strings.HasPrefix(w.Header().Get("Location"), "/jobs/") {
+		t.Fatalf("expected the export to be accepted as a job, got %d %v", w.Code, w.Header())
+	}
+	export := waitForJob(t, api, w.Header().Get("Location"))
+	if export.Status != AsyncJobSucceeded || export.Kind != "export" || !strings.HasPrefix(export.Location, "/items/exports/") {
+		t.Fatalf("expected the export to point at its workbook, got %+v", export)
+	}
+	w = api.Request("GET", export.Location, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != xlsxMediaType {
+		t.Fatalf("expected the workbook at the job's location, got %d", w.Code)
+	}
+	workbook := w.Body.String()
+
+	isolate(t, &itemRepository, ItemRepository(NewInMemoryItemRepository()))
+	w = api.Request("POST", "/items/import.xlsx", workbook, "Content-Type", xlsxMediaType, "Prefer", "respond-async")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected the import to be accepted as a job, got %d %s", w.Code, w.Body)
+	}
+	imported := waitForJob(t, api, w.Header().Get("Location"))
+	if imported.Status != AsyncJobSucceeded || imported.Progress == nil || *imported.Progress != (JobProgress{Done: 2, Total: 2}) {
+		t.Errorf("expected the import to have created both items, got %+v", imported)
+	}
+	if items, _ := itemRepository.List(context.Background(), ItemFilter{}); len(items) != 2 {
+		t.Errorf("expected the imported items to be stored, got %+v", items)
+	}
+
+	if w := api.Request("GET", "/jobs/unknown", nil); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "JOB_NOT_FOUND") {
+		t.Errorf("expected 404 for an unknown job, got %d %s", w.Code, w.Body)
+	}
+}
+
+func Test_asyncJobRegistry(t *testing.T) {
+	registry := newAsyncJobRegistry()
+	failed := registry.Start(context.Background(), "test", func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error) {
+		return AsyncJobOutcome{}, errors.New("broken")
+	})
+	for range 100 {
+		if job, _ := registry.Get(failed.ID); job.Status != AsyncJobRunning {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if job, _ := registry.Get(failed.ID); job.Status != AsyncJobFailed || job.Error != "broken" || job.FinishedAt == nil {
+		t.Errorf("expected the job to have failed, got %+v", job)
+	}
+
+	panicked := registry.Start(context.Background(), "test", func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error) {
+		var items []Item
+		return AsyncJobOutcome{Result: items[1]}, nil
+	})
+	for range 100 {
+		if job, _ := registry.Get(panicked.ID); job.Status != AsyncJobRunning {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if job, _ := registry.Get(panicked.ID); job.Status != AsyncJobFailed || !strings.Contains(job.Error, "index out of range") || job.FinishedAt == nil {
+		t.Errorf("expected the panicking job to have failed, got %+v", job)
+	}
+
+	for i := range maxFinishedAsyncJobs {
+		registry.finish(&AsyncJob{ID: strconv.Itoa(i)}, AsyncJobOutcome{}, nil)
+	}
+	if _, ok := registry.Get(failed.ID); ok {
+		t.Error("expected the oldest finished job to be forgotten")
+	}
+}
+
+func Test_asyncReindexReportsProgress(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "Scarf"}, Item{ID: 1, Name: "Hat"})
+	isolate(t, &asyncJobs, newAsyncJobRegistry())
+	isolate(t, &searchIndex, nil)
+	if err := setupSearch(Config{Search: "bleve"}); err != nil {
+		t.Fatal(err)
+	}
+	api.Reroute()
+
+	w := api.AdminRequest("POST", "/admin/search/rebuild", nil, "Prefer", "respond-async")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected the reindex to be accepted as a job, got %d %s", w.Code, w.Body)
+	}
+	job := waitForJob(t, api, w.Header().Get("Location"))
+	if job.Status != AsyncJobSucceeded || job.Progress == nil || *job.Progress != (JobProgress{Done: 2, Total: 2}) {
+		t.Errorf("expected the reindex to report both items indexed, got %+v", job)
+	}
+}
+
+func Test_asyncJobsTakeItemScope(t *testing.T) {
+	api := newTestAPI(t)
+	store, err := openTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isolate(t, &apiTokens, store)
+	reader, _ := store.Create("reader", []string{ScopeItemsRead}, nil)
+	api.Router = newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, RequireAPIToken: true})
+
+	if w := api.Request("GET", "/jobs/unknown", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	if w := api.Request("GET", "/jobs/unknown", nil, "Authorization", "Bearer "+reader.Secret); w.Code != http.StatusNotFound {
+		t.Errorf("expected a reader to look up jobs, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ndjsonMediaType is the media type of the audit log export, a JSON object
+// per line.
+const ndjsonMediaType = "application/x-ndjson"
+
+// defaultAuditLimit is how many entries GET /audit returns without ?limit=.
+const defaultAuditLimit = 100
+
+// The actions the audit log records.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditEntry records who changed which item how. Actor is named like in the
+// usage report; changes made by jobs, like expiring items, are anonymous.
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	ItemID int       `json:"item_id"`
+}
+
+// AuditLog keeps the audit entries, oldest first. With a file every entry is
+// a line of JSON appended to it; unlike the event log it isn't synced on
+// every write, since the items don't depend on it.
+type AuditLog struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	entries []AuditEntry
+	lastID  int64
+	now     func() time.Time
+}
+
+// auditLog records the changes to items; nil without -audit-log-path.
+var auditLog *AuditLog
+
+// OpenAuditLog reads the entries already in the file at path and appends new
+// ones to it. An empty path keeps the entries in memory only.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	log := &AuditLog{path: path, now: time.Now}
+	if path == "" {
+		return log, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, err
+		}
+		log.entries = append(log.entries, entry)
+		log.lastID = entry.ID
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.file = file
+	return log, nil
+}
+
+// Record numbers and stores the entries, by the actor of ctx.
+func (l *AuditLog) Record(ctx context.Context, entries ...AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	actor := callerName(ctx)
+	now := l.now().UTC()
+	var lines []byte
+	for i := range entries {
+		entries[i].ID = l.lastID + int64(i) + 1
+		entries[i].At = now
+		entries[i].Actor = actor
+		line, err := json.Marshal(entries[i])
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if l.file != nil {
+		if _, err := l.file.Write(lines); err != nil {
+			return err
+		}
+	}
+	l.entries = append(l.entries, entries...)
+	l.lastID += int64(len(entries))
+	return nil
+}
+
+// AuditFilter picks entries; its zero value picks them all. From is
+// inclusive and To exclusive.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	ItemID *int
+	From   time.Time
+	To     time.Time
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.ItemID == nil || entry.ItemID == *f.ItemID) &&
+		(f.From.IsZero() || !entry.At.Before(f.From)) &&
+		(f.To.IsZero() || entry.At.Before(f.To))
+}
+
+// Query returns the entries the filter picks, oldest first.
+func (l *AuditLog) Query(filter AuditFilter) []AuditEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	result := []AuditEntry{}
+	for _, entry := range l.entries {
+		if filter.matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Prune drops the entries recorded before before and rewrites the file
+// without them.
+func (l *AuditLog) Prune(before time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pruned := 0
+	for pruned < len(l.entries) && l.entries[pruned].At.Before(before) {
+		pruned++
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	remaining := append([]AuditEntry(nil), l.entries[pruned:]...)
+	if l.file != nil {
+		var content []byte
+		for _, entry := range remaining {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return 0, err
+			}
+			content = append(append(content, line...), '\n')
+		}
+		if err := writeFileAtomically(l.path, content); err != nil {
+			return 0, err
+		}
+		file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return 0, err
+		}
+		l.file.Close()
+		l.file = file
+	}
+	l.entries = remaining
+	return pruned, nil
+}
+
+// auditingRepository records every write that goes through it in the audit
+// log. Writes in a transaction are only recorded once it commits.
+type auditingRepository struct {
+	ItemRepository
+	log *AuditLog
+}
+
+func (repo *auditingRepository) record(ctx context.Context, entries ...AuditEntry) {
+	if err := repo.log.Record(ctx, entries...); err != nil {
+		log.Printf("recording %d audit entries failed: %v", len(entries), err)
+	}
+}
+
+func (repo *auditingRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := repo.ItemRepository.Create(ctx, item)
+	if err == nil {
+		repo.record(ctx, AuditEntry{Action: AuditCreate, ItemID: created.ID})
+	}
+	return created, err
+}
+
+func (repo *auditingRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		repo.record(ctx, AuditEntry{Action: AuditCreate, ItemID: inserted.ID})
+	}
+	return inserted, err
+}
+
+func (repo *auditingRepository) Update(ctx context.Context, item Item) error {
+	err := repo.ItemRepository.Update(ctx, item)
+	if err == nil {
+		repo.record(ctx, AuditEntry{Action: AuditUpdate, ItemID: item.ID})
+	}
+	return err
+}
+
+func (repo *auditingRepository) Delete(ctx context.Context, id int) error {
+	err := repo.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		repo.record(ctx, AuditEntry{Action: AuditDelete, ItemID: id})
+	}
+	return err
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *auditingRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+func (repo *auditingRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	var recorder *auditRecorder
+	err := repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		recorder = &auditRecorder{ItemRepository: tx}
+		return fn(recorder)
+	})
+	if err != nil {
+		return err
+	}
+	if len(recorder.entries) > 0 {
+		repo.record(ctx, recorder.entries...)
+	}
+	return nil
+}
+
+// auditRecorder collects the audit entries of the writes in a transaction.
+type auditRecorder struct {
+	ItemRepository
+	entries []AuditEntry
+}
+
+func (r *auditRecorder) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := r.ItemRepository.Create(ctx, item)
+	if err == nil {
+		r.entries = append(r.entries, AuditEntry{Action: AuditCreate, ItemID: created.ID})
+	}
+	return created, err
+}
+
+func (r *auditRecorder) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := r.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		r.entries = append(r.entries, AuditEntry{Action: AuditCreate, ItemID: inserted.ID})
+	}
+	return inserted, err
+}
+
+func (r *auditRecorder) Update(ctx context.Context, item Item) error {
+	err := r.ItemRepository.Update(ctx, item)
+	if err == nil {
+		r.entries = append(r.entries, AuditEntry{Action: AuditUpdate, ItemID: item.ID})
+	}
+	return err
+}
+
+func (r *auditRecorder) Delete(ctx context.Context, id int) error {
+	err := r.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		r.entries = append(r.entries, AuditEntry{Action: AuditDelete, ItemID: id})
+	}
+	return err
+}
+
+// Tx within a transaction simply joins it.
+func (r *auditRecorder) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return fn(r)
+}
+
+// requestAuditFilter parses ?actor=, ?action=, ?item_id=, and ?from= and ?to=
+// as times like 2024-05-01T12:00:00Z. It answers the request when that fails.
+func requestAuditFilter(w http.ResponseWriter, r *http.Request) (AuditFilter, bool) {
+	params := r.URL.Query()
+	filter := AuditFilter{Actor: params.Get("actor"), Action: params.Get("action")}
+	switch filter.Action {
+	case "", AuditCreate, AuditUpdate, AuditDelete:
+	default:
+		ErrorCodeResponse(w, InvalidAuditActionCode)
+		return filter, false
+	}
+	if value := params.Get("item_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			ErrorCodeResponse(w, InvalidItemIDCode)
+			return filter, false
+		}
+		filter.ItemID = &id
+	}
+	for _, bound := range []struct {
+		param string
+		time  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if value := params.Get(bound.param); value != "" {
+			var err error
+			if *bound.time, err = time.Parse(time.RFC3339, value); err != nil {
+				ErrorCodeResponse(w, InvalidTimeRangeCode)
+				return filter, false
+			}
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		ErrorCodeResponse(w, InvalidTimeRangeCode)
+		return filter, false
+	}
+	return filter, true
+}
+
+// listAudit returns the audit entries the filter picks, oldest first, a page
+// of ?limit= (100 by default) from ?offset= at a time. At /audit.ndjson it
+// exports all of them instead, an entry per line.
+func listAudit(w http.ResponseWriter, r *http.Request) {
+	filter, ok := requestAuditFilter(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	limit, offset := defaultAuditLimit, 0
+	if value := params.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			ErrorCodeResponse(w, InvalidLimitCode)
+			return
+		}
+	}
+	if value := params.Get("offset"); value != "" {
+		var err error
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			ErrorCodeResponse(w, InvalidOffsetCode)
+			return
+		}
+	}
+
+	entries := auditLog.Query(filter)
+	if fileMediaType(r) == ndjsonMediaType {
+		w.Header().Set("Content-Type", ndjsonMediaType)
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		controller := http.NewResponseController(w)
+		for i, entry := range entries {
+			if r.Context().Err() != nil {
+				return
+			}
+			if i > 0 && i%streamFlushEvery == 0 {
+				controller.Flush()
+			}
+			if encoder.Encode(entry) != nil {
+				return
+			}
+		}
+		return
+	}
+
+	total := len(entries)
+	page := entries[min(offset, total):]
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	meta := ResponseMeta{Total: total, Limit: limit, Offset: offset}
+	SetResponseMeta(w, meta)
+	SetPageLinks(w, r, meta)
+	SuccessResponse(w, page)
+}
+
+// setupAudit records the changes to items when -audit-log-path is set, and
+// prunes the entries older than -audit-retention every hour.
+func setupAudit(cfg Config) error {
+	if cfg.AuditLogPath == "" {
+		return nil
+	}
+	audit, err := OpenAuditLog(cfg.AuditLogPath)
+	if err != nil {
+		return err
+	}
+	auditLog = audit
+	itemRepository = &auditingRepository{ItemRepository: itemRepository, log: audit}
+	if cfg.AuditRetention > 0 {
+		jobs.Add(Job{Name: "audit-prune", Every: time.Hour, Run: func(ctx context.Context) error {
+			_, err := audit.Prune(audit.now().Add(-cfg.AuditRetention))
+			return err
+		}})
+	}
+	return nil
+}
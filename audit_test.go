@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_auditingRepository(t *testing.T) {
+	audit, err := OpenAuditLog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &auditingRepository{ItemRepository: NewInMemoryItemRepository(Item{ID: 0, Name: "first"}), log: audit}
+	ctx := context.WithValue(context.Background(), apiTokenContextKey{}, &APIToken{ID: "ops"})
+
+	created, _ := repo.Create(ctx, Item{Name: "second"})
+	repo.Tx(ctx, func(tx ItemRepository) error {
+		tx.Update(ctx, Item{ID: 0, Name: "renamed"})
+		tx.Delete(ctx, created.ID)
+		return nil
+	})
+	repo.Tx(ctx, func(tx ItemRepository) error {
+		tx.Delete(ctx, 0)
+		return errors.New("rolled back")
+	})
+	repo.Delete(context.Background(), 42)
+
+	var got []string
+	for _, entry := range audit.Query(AuditFilter{}) {
+		got = append(got, fmt.Sprintf("%s %s %d", entry.Actor, entry.Action, entry.ItemID))
+	}
+	expected := []string{"token:ops create 1", "token:ops update 0", "token:ops delete 1"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func Test_listAudit(t *testing.T) {
+	original := auditLog
+	t.Cleanup(func() { auditLog = original })
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	audit.now = func() time.Time { return now }
+	ops := context.WithValue(context.Background(), apiTokenContextKey{}, &APIToken{ID: "ops"})
+	audit.Record(ops, AuditEntry{Action: AuditCreate, ItemID: 1}, AuditEntry{Action: AuditCreate, ItemID: 2})
+	now = now.Add(time.Hour)
+	audit.Record(context.Background(), AuditEntry{Action: AuditDelete, ItemID: 1})
+	auditLog = audit
+	router := mux.NewRouter()
+	router.HandleFunc("/audit", listAudit)
+	router.HandleFunc("/audit.ndjson", listAudit)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	tests := []struct {
+		query    string
+		expected []int64
+	}{
+		{"", []int64{1, 2, 3}},
+		{"?actor=token:ops", []int64{1, 2}},
+		{"?item_id=1", []int64{1, 3}},
+		{"?action=delete", []int64{3}},
+		{"?from=2024-05-01T12:30:00Z", []int64{3}},
+		{"?to=2024-05-01T12:30:00Z", []int64{1, 2}},
+		{"?limit=1&offset=1", []int64{2}},
+	}
+	for _, tt := range tests {
+		w := get("/audit" + tt.query)
+		var entries []AuditEntry
+		json.Unmarshal(w.Body.Bytes(), &entries)
+		var ids []int64
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if w.Code != http.StatusOK || len(ids) != len(tt.expected) || (len(ids) > 0 && ids[0] != tt.expected[0]) {
+			t.Errorf("%s: expected %v, got %d %v", tt.query, tt.expected, w.Code, ids)
+		}
+	}
+
+	for _, query := range []string{"?action=read", "?item_id=one", "?from=yesterday", "?from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z"} {
+		if w := get("/audit" + query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+
+	w := get("/audit.ndjson?actor=anonymous")
+	if w.Header().Get("Content-Type") != ndjsonMediaType || strings.Count(w.Body.String(), "\n") != 1 || !strings.Contains(w.Body.String(), `"action":"delete"`) {
+		t.Errorf("expected one line of JSON, got %v %q", w.Header(), w.Body)
+	}
+
+	if pruned, err := audit.Prune(now); err != nil || pruned != 2 {
+		t.Fatalf("expected the two older entries to be pruned, got %d %v", pruned, err)
+	}
+	audit.Record(ops, AuditEntry{Action: AuditUpdate, ItemID: 2})
+	reopened, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := reopened.Query(AuditFilter{})
+	if len(entries) != 2 || entries[0].ID != 3 || entries[1].ID != 4 {
+		t.Errorf("expected the entries left after pruning to be kept in the file, got %+v", entries)
+	}
+}
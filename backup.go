@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// RestoreResult tells how many items a restore or reset left.
+type RestoreResult struct {
+	Items int `json:"items"`
+}
+
+// backupItems answers every item, archived and unpublished ones included, in
+// the form POST /admin/restore takes back. With -export-dir the backup is
+// written there item by item and kept like an export, so its download can be
+// resumed from GET /admin/backups/{export}.json.
+func backupItems(w http.ResponseWriter, r *http.Request) {
+	items, err := itemRepository.List(r.Context(), ItemFilter{})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not list items")
+		return
+	}
+	if exportFiles != nil {
+		id, err := exportFiles.Create(backupExport, func(w io.Writer) error {
+			return writeBackup(w, items)
+		})
+		if err != nil {
+			ExportErrorResponse(w, err, "could not back up the items")
+			return
+		}
+		serveExport(w, r, id, backupExport)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="items-backup.json"`)
+	SuccessResponse(w, items)
+}
+
+// writeBackup writes the items as a JSON array, one at a time.
+func writeBackup(w io.Writer, items []Item) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, item := range items {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// restoreItems replaces every item with those of a backup, keeping their IDs.
+func restoreItems(w http.ResponseWriter, r *http.Request) {
+	var items []Item
+	if err := decodeBody(r, &items); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	if errs := validateBackup(items); len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
+	}
+	replaceItemsResponse(w, r, items)
+}
+
+// validateBackup tells what keeps the items of a backup from being restored:
+// the same as keeps them from being created, plus IDs out of range. The
+// fields are named after the item's index, like "[1].name".
+func validateBackup(items []Item) []FieldError {
+	var errs []FieldError
+	for i, item := range items {
+		if !validClientID(item.ID) {
+			errs = append(errs, newFieldError(fmt.Sprintf("[%d].id", i), InvalidClientIDCode))
+		}
+		for _, err := range validateItem(item) {
+			err.Field = fmt.Sprintf("[%d].%s", i, err.Field)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// resetItems replaces every item with the ones a new in-memory store starts
+// out with.
+func resetItems(w http.ResponseWriter, r *http.Request) {
+	replaceItemsResponse(w, r, seedItems)
+}
+
+func replaceItemsResponse(w http.ResponseWriter, r *http.Request, items []Item) {
+	if readOnly {
+		ErrorCodeResponse(w, ReadOnlyCode)
+		return
+	}
+	if err := replaceItems(r.Context(), items); err != nil {
+		RepositoryErrorResponse(w, err, "could not replace the items")
+		return
+	}
+	SuccessResponse(w, RestoreResult{Items: len(items)})
+}
+
+// replaceItems deletes every item and inserts items in one transaction, so
+// readers see either the old items or the new ones.
+func replaceItems(ctx context.Context, items []Item) error {
+	return itemRepository.Tx(ctx, func(tx ItemRepository) error {
+		stored, err := tx.List(ctx, ItemFilter{})
+		if err != nil {
+			return err
+		}
+		for _, item := range stored {
+			if err := tx.Delete(ctx, item.ID); err != nil {
+				return err
+			}
+		}
+		for _, item := range items {
+			if _, err := tx.Insert(ctx, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
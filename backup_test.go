@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_backupAndRestore(t *testing.T) {
+	archivedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"}, Item{ID: 3, Name: "old", ArchivedAt: &archivedAt})
+
+	w := api.AdminRequest("GET", "/admin/backup", nil)
+	backup := decodeResponse[[]Item](t, w, http.StatusOK)
+	if len(backup) != 2 || w.Header().Get("Content-Disposition") == "" {
+		t.Fatalf("expected every item in the backup, got %+v", backup)
+	}
+
+	api.Request("DELETE", "/items/0", nil)
+	api.Request("POST", "/items/", Item{Name: "desk"})
+	if result := decodeResponse[RestoreResult](t, api.AdminRequest("POST", "/admin/restore", backup), http.StatusOK); result.Items != 2 {
+		t.Errorf("expected 2 items restored, got %+v", result)
+	}
+	items, _ := itemRepository.List(context.Background(), ItemFilter{})
+	if len(items) != 2 || items[0].Name != "lamp" || items[1].ID != 3 || items[1].State() != ItemStateArchived {
+		t.Errorf("expected the backed up items back, got %+v", items)
+	}
+
+	if w := api.AdminRequest("POST", "/admin/restore", []Item{{ID: 1, Name: "a"}, {ID: 1, Name: "b"}}); w.Code != http.StatusConflict {
+		t.Errorf("expected a backup with a doubled ID to be refused, got %d %s", w.Code, w.Body)
+	}
+	problem := decodeResponse[Problem](t, api.AdminRequest("POST", "/admin/restore", []Item{{ID: 1, Name: "a"}, {ID: 9007199254740992, Name: "b"}}), http.StatusUnprocessableEntity)
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "[1].id" || problem.Errors[0].Code != "INVALID_CLIENT_ID" {
+		t.Errorf("expected the ID out of range to be refused, got %+v", problem)
+	}
+	problem = decodeResponse[Problem](t, api.AdminRequest("POST", "/admin/restore", []Item{{ID: 1, Name: "a"}, {ID: 2, Quantity: -1}}), http.StatusUnprocessableEntity)
+	if len(problem.Errors) != 2 || problem.Errors[0].Field != "[1].name" || problem.Errors[1].Field != "[1].quantity" {
+		t.Errorf("expected the invalid item to be refused, got %+v", problem)
+	}
+	if items, _ := itemRepository.List(context.Background(), ItemFilter{}); len(items) != 2 {
+		t.Errorf("expected a failed restore to leave the items alone, got %+v", items)
+	}
+
+	if result := decodeResponse[RestoreResult](t, api.AdminRequest("POST", "/admin/reset", nil), http.StatusOK); result.Items != len(seedItems) {
+		t.Errorf("expected the seed items back, got %+v", result)
+	}
+
+	isolate(t, &readOnly, true)
+	if w := api.AdminRequest("POST", "/admin/reset", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected a read-only instance to refuse the reset, got %d", w.Code)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltItemsBucket       = []byte("items")
+	boltExternalIDsBucket = []byte("external_ids")
+	boltUUIDsBucket       = []byte("uuids")
+	boltMetaBucket        = []byte("meta")
+	boltNextIDKey         = []byte("next_id")
+)
+
+// BoltItemRepository keeps items in a single bbolt file, for deployments that
+// want durability without running a database server. Items are JSON documents
+// in the items bucket keyed by their big-endian ID, so iterating the bucket
+// lists them in ID order. The external_ids and uuids buckets point the external
+// IDs and UUIDs at the keys of their items, and the meta bucket holds the ID
+// counter. Every write happens in one transaction.
+type BoltItemRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltItemRepository(db *bolt.DB) (*BoltItemRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltItemsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltExternalIDsBucket); err != nil {
+			return err
+		}
+		if tx.Bucket(boltUUIDsBucket) == nil {
+			if err := indexBoltUUIDs(tx); err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltItemRepository{db: db}, nil
+}
+
+// indexBoltUUIDs creates the uuids bucket for a file written before it
+// existed, from the UUIDs of the items already in it.
+func indexBoltUUIDs(tx *bolt.Tx) error {
+	uuids, err := tx.CreateBucket(boltUUIDsBucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltItemsBucket).ForEach(func(k, v []byte) error {
+		var item Item
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+		if item.UUID == "" {
+			return nil
+		}
+		return uuids.Put([]byte(item.UUID), k)
+	})
+}
+
+func (repo *BoltItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	var result []Item
+	err := repo.view(ctx, func(tx boltTx) (err error) {
+		result, err = tx.List(ctx, filter)
+		return err
+	})
+	return result, err
+}
+
+func (repo *BoltItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	var item *Item
+	err := repo.view(ctx, func(tx boltTx) (err error) {
+		item, err = tx.Get(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (repo *BoltItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	var created *Item
+	err := repo.update(ctx, func(tx boltTx) (err error) {
+		created, err = tx.Create(ctx, item)
+		return err
+	})
+	return created, err
+}
+
+func (repo *BoltItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	var inserted *Item
+	err := repo.update(ctx, func(tx boltTx) (err error) {
+		inserted, err = tx.Insert(ctx, item)
+		return err
+	})
+	return inserted, err
+}
+
+func (repo *BoltItemRepository) Update(ctx context.Context, item Item) error {
+	return repo.update(ctx, func(tx boltTx) error {
+		return tx.Update(ctx, item)
+	})
+}
+
+func (repo *BoltItemRepository) Delete(ctx context.Context, id int) error {
+	return repo.update(ctx, func(tx boltTx) error {
+		return tx.Delete(ctx, id)
+	})
+}
+
+// Tx runs fn inside a single bbolt read-write transaction.
+func (repo *BoltItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.update(ctx, func(tx boltTx) error {
+		return fn(tx)
+	})
+}
+
+func (repo *BoltItemRepository) view(ctx context.Context, fn func(tx boltTx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return repo.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+func (repo *BoltItemRepository) update(ctx context.Context, fn func(tx boltTx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return repo.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+// boltTx is the repository as seen from inside a bbolt transaction.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (b boltTx) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	if filter.ExternalID != "" {
+		return b.lookup(ctx, boltExternalIDsBucket, filter.ExternalID, filter)
+	}
+	if filter.UUID != "" {
+		return b.lookup(ctx, boltUUIDsBucket, filter.UUID, filter)
+	}
+	result := []Item{}
+	err := b.tx.Bucket(boltItemsBucket).ForEach(func(k, v []byte) error {
+		var item Item
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+		if filter.Matches(item) {
+			result = append(result, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lookup lists the item the index bucket points key at, instead of reading
+// them all.
+func (b boltTx) lookup(ctx context.Context, bucket []byte, key string, filter ItemFilter) ([]Item, error) {
+	result := []Item{}
+	itemKey := b.tx.Bucket(bucket).Get([]byte(key))
+	if itemKey == nil {
+		return result, nil
+	}
+	item, err := b.Get(ctx, int(binary.BigEndian.Uint64(itemKey)))
+	if err != nil {
+		return nil, err
+	}
+	if filter.Matches(*item) {
+		result = append(result, *item)
+	}
+	return result, nil
+}
+
+func (b boltTx) Get(ctx context.Context, id int) (*Item, error) {
+	value := b.tx.Bucket(boltItemsBucket).Get(boltKey(id))
+	if value == nil {
+		return nil, NotFoundError
+	}
+	var item Item
+	if err := json.Unmarshal(value, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (b boltTx) Create(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	item.ID = b.nextID()
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	if err := b.tx.Bucket(boltMetaBucket).Put(boltNextIDKey, boltKey(item.ID+1)); err != nil {
+		return nil, err
+	}
+	if err := b.put(item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (b boltTx) Insert(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	if b.tx.Bucket(boltItemsBucket).Get(boltKey(item.ID)) != nil {
+		return nil, IDTakenError
+	}
+	if item.ID >= b.nextID() {
+		if err := b.tx.Bucket(boltMetaBucket).Put(boltNextIDKey, boltKey(idAfter(item.ID))); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.put(item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// nextID is the ID the next created item gets.
+func (b boltTx) nextID() int {
+	if value := b.tx.Bucket(boltMetaBucket).Get(boltNextIDKey); value != nil {
+		return int(binary.BigEndian.Uint64(value))
+	}
+	return 0
+}
+
+func (b boltTx) Update(ctx context.Context, item Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.tx.Bucket(boltItemsBucket).Get(boltKey(item.ID)) == nil {
+		return NotFoundError
+	}
+	return b.put(item)
+}
+
+func (b boltTx) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	old, err := b.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, index := range boltKeyIndexes {
+		if key := index.key(*old); key != "" {
+			if err := b.tx.Bucket(index.bucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return b.tx.Bucket(boltItemsBucket).Delete(boltKey(id))
+}
+
+// Tx within a transaction simply joins it.
+func (b boltTx) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return fn(b)
+}
+
+// boltKeyIndexes are the buckets that point a key of the items at the item.
+var boltKeyIndexes = []struct {
+	bucket []byte
+	key    func(item Item) string
+}{
+	{boltExternalIDsBucket, func(item Item) string { return item.ExternalID }},
+	{boltUUIDsBucket, func(item Item) string { return item.UUID }},
+}
+
+// put stores the item and moves its keys in the indexes along.
+func (b boltTx) put(item Item) error {
+	old, err := b.Get(context.Background(), item.ID)
+	if err != nil && !errors.Is(err, NotFoundError) {
+		return err
+	}
+	for _, index := range boltKeyIndexes {
+		bucket, key := b.tx.Bucket(index.bucket), index.key(item)
+		if old != nil && index.key(*old) != "" && index.key(*old) != key {
+			if err := bucket.Delete([]byte(index.key(*old))); err != nil {
+				return err
+			}
+		}
+		if key != "" {
+			if err := bucket.Put([]byte(key), boltKey(item.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	value, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return b.tx.Bucket(boltItemsBucket).Put(boltKey(item.ID), value)
+}
+
+func boltKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
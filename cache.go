@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// cachingRepository serves getting an item by ID from an LRU cache in front
+// of the wrapped repository, for backends that are a network round trip
+// away. Every write evicts the items it touches, also when it fails, since a
+// failed write may still have been applied. Listings are not cached.
+type cachingRepository struct {
+	ItemRepository
+
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[int]*list.Element
+	// recency holds the cached items, most recently used first.
+	recency *list.List
+	// generation counts evictions. A Get only caches what it loaded when no
+	// eviction happened meanwhile, so a concurrent write can't be undone by
+	// a read that started before it.
+	generation uint64
+	now        func() time.Time
+}
+
+type cacheEntry struct {
+	item    Item
+	expires time.Time
+}
+
+func newCachingRepository(repo ItemRepository, size int, ttl time.Duration) *cachingRepository {
+	return &cachingRepository{
+		ItemRepository: repo,
+		size:           size,
+		ttl:            ttl,
+		entries:        map[int]*list.Element{},
+		recency:        list.New(),
+		now:            time.Now,
+	}
+}
+
+func (repo *cachingRepository) Get(ctx context.Context, id int) (*Item, error) {
+	repo.mu.Lock()
+	if element, ok := repo.entries[id]; ok {
+		entry := element.Value.(*cacheEntry)
+		if repo.now().Before(entry.expires) {
+			repo.recency.MoveToFront(element)
+			item := entry.item
+			repo.mu.Unlock()
+			metrics.CountCacheLookup(true)
+			return &item, nil
+		}
+		repo.remove(element)
+	}
+	generation := repo.generation
+	repo.mu.Unlock()
+	metrics.CountCacheLookup(false)
+
+	item, err := repo.ItemRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if generation == repo.generation {
+		repo.add(*item)
+	}
+	return item, nil
+}
+
+func (repo *cachingRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := repo.ItemRepository.Create(ctx, item)
+	if err == nil {
+		repo.evict(created.ID)
+	}
+	return created, err
+}
+
+func (repo *cachingRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		repo.evict(inserted.ID)
+	}
+	return inserted, err
+}
+
+func (repo *cachingRepository) Update(ctx context.Context, item Item) error {
+	defer repo.evict(item.ID)
+	return repo.ItemRepository.Update(ctx, item)
+}
+
+func (repo *cachingRepository) Delete(ctx context.Context, id int) error {
+	defer repo.evict(id)
+	return repo.ItemRepository.Delete(ctx, id)
+}
+
+// Tx evicts what fn wrote once the transaction is over.
+func (repo *cachingRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	var recorder *writeRecorder
+	defer func() {
+		if recorder != nil {
+			repo.evict(recorder.order...)
+		}
+	}()
+	return repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		recorder = newWriteRecorder(tx)
+		return fn(recorder)
+	})
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *cachingRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+func (repo *cachingRepository) evict(ids ...int) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.generation++
+	for _, id := range ids {
+		if element, ok := repo.entries[id]; ok {
+			repo.remove(element)
+		}
+	}
+}
+
+// add caches item, dropping the least recently used item when full.
+func (repo *cachingRepository) add(item Item) {
+	if element, ok := repo.entries[item.ID]; ok {
+		repo.remove(element)
+	}
+	if repo.recency.Len() >= repo.size {
+		repo.remove(repo.recency.Back())
+	}
+	entry := &cacheEntry{item: item, expires: repo.now().Add(repo.ttl)}
+	repo.entries[item.ID] = repo.recency.PushFront(entry)
+}
+
+func (repo *cachingRepository) remove(element *list.Element) {
+	repo.recency.Remove(element)
+	delete(repo.entries, element.Value.(*cacheEntry).item.ID)
+}
+
+// setupCache puts the cache in front of the repository unless -cache-size
+// is 0.
+func setupCache(cfg Config) {
+	if cfg.CacheSize <= 0 {
+		return
+	}
+	itemRepository = newCachingRepository(itemRepository, cfg.CacheSize, cfg.CacheTTL)
+}
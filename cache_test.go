@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingRepository counts the gets that reach it.
+type countingRepository struct {
+	ItemRepository
+	gets int
+}
+
+func (repo *countingRepository) Get(ctx context.Context, id int) (*Item, error) {
+	repo.gets++
+	return repo.ItemRepository.Get(ctx, id)
+}
+
+func Test_cachingRepository(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingRepository{ItemRepository: NewInMemoryItemRepository(seedItems...)}
+	extra, _ := backend.Create(ctx, Item{Name: "third"})
+	cache := newCachingRepository(backend, 2, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	first, second, third := seedItems[0].ID, seedItems[1].ID, extra.ID
+
+	cache.Get(ctx, first)
+	cache.Get(ctx, first)
+	if backend.gets != 1 {
+		t.Errorf("expected the second get to be a hit, backend saw %d gets", backend.gets)
+	}
+
+	cache.Update(ctx, Item{ID: first, Name: "renamed"})
+	if item, _ := cache.Get(ctx, first); item.Name != "renamed" || backend.gets != 2 {
+		t.Errorf("update did not evict the item: %+v after %d gets", item, backend.gets)
+	}
+
+	// second and third push out first, the least recently used
+	cache.Get(ctx, second)
+	cache.Get(ctx, third)
+	cache.Get(ctx, first)
+	if backend.gets != 5 {
+		t.Errorf("expected the least recently used item to be dropped, backend saw %d gets", backend.gets)
+	}
+
+	now = now.Add(2 * time.Minute)
+	cache.Get(ctx, first)
+	if backend.gets != 6 {
+		t.Errorf("expected an expired item to be read again, backend saw %d gets", backend.gets)
+	}
+
+	cache.Tx(ctx, func(tx ItemRepository) error { return tx.Delete(ctx, first) })
+	if _, err := cache.Get(ctx, first); err != NotFoundError {
+		t.Errorf("transaction did not evict the deleted item, got %v", err)
+	}
+}
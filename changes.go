@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultChangesLimit is how many changes GET /changes returns without
+// ?limit=.
+const defaultChangesLimit = 100
+
+// maxChangesWait caps how long GET /changes?wait= holds a request.
+const maxChangesWait = time.Minute
+
+// ChangeFeed is a page of the change feed. Next is the sequence number to
+// pass as ?since= for the changes after this page; it stays the same while
+// nothing changes.
+type ChangeFeed struct {
+	Changes []ItemEvent `json:"changes"`
+	Next    int64       `json:"next"`
+}
+
+// listChanges returns the events of the event log after ?since=, oldest
+// first, so consumers can sync in batches by remembering the last sequence
+// number they saw. Sequence numbers only grow and the log is never
+// rewritten, so a consumer resuming from a stored number misses nothing.
+//
+// With ?wait=30s and nothing after since yet, the request is held until
+// changes arrive or the wait is over, for clients that can't keep a stream
+// open through their firewall. An empty page after the wait is answered
+// normally; the client simply asks again.
+func listChanges(w http.ResponseWriter, r *http.Request) {
+	feed, ok := readChangeFeed(w, r)
+	if !ok {
+		return
+	}
+	SuccessResponse(w, feed)
+}
+
+// readChangeFeed reads the page of the change feed the request asks for, as
+// described at listChanges. It answers the request when that fails.
+func readChangeFeed(w http.ResponseWriter, r *http.Request) (ChangeFeed, bool) {
+	params := r.URL.Query()
+	var since int64
+	if value := params.Get("since"); value != "" {
+		var err error
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || since < 0 {
+			ErrorCodeResponse(w, InvalidSinceCode)
+			return ChangeFeed{}, false
+		}
+	}
+	limit := defaultChangesLimit
+	if value := params.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			ErrorCodeResponse(w, InvalidLimitCode)
+			return ChangeFeed{}, false
+		}
+	}
+
+	var wait time.Duration
+	if value := params.Get("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 || wait > maxChangesWait {
+			ErrorCodeResponse(w, InvalidWaitCode)
+			return ChangeFeed{}, false
+		}
+	}
+	if wait > 0 {
+		waitForChanges(w, r, since, wait)
+	}
+
+	feed := ChangeFeed{Changes: itemEvents.Next(since, limit), Next: since}
+	if err := decryptEvents(feed.Changes); err != nil {
+		InternalErrorResponse(w, "could not decrypt the changes")
+		return ChangeFeed{}, false
+	}
+	if len(feed.Changes) > 0 {
+		feed.Next = feed.Changes[len(feed.Changes)-1].Sequence
+	}
+	// a full page may be followed by more; the feed is only read forward, so
+	// there are no other pages to link to
+	if len(feed.Changes) == limit {
+		query := r.URL.Query()
+		query.Set("since", strconv.FormatInt(feed.Next, 10))
+		w.Header().Add("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+	}
+	return feed, true
+}
+
+// waitForChanges holds the request until there are events after since, the
+// wait is over, the client goes away or the server shuts down.
+func waitForChanges(w http.ResponseWriter, r *http.Request, since int64, wait time.Duration) {
+	// The server's write timeout is shorter than the longest wait.
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 15*time.Second))
+	closing, done := drainer.Track()
+	defer done()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-itemEvents.Appended(since):
+	case <-timer.C:
+	case <-closing:
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_listChanges(t *testing.T) {
+	defer func(original ItemRepository, events *EventLog) { itemRepository, itemEvents = original, events }(itemRepository, itemEvents)
+	itemEvents, _ = OpenEventLog("")
+	itemRepository = NewEventSourcedItemRepository(itemEvents)
+	ctx := context.Background()
+	first, _ := itemRepository.Create(ctx, Item{Name: "first"})
+	itemRepository.Create(ctx, Item{Name: "second"})
+	itemRepository.Delete(ctx, first.ID)
+
+	get := func(query string) (*httptest.ResponseRecorder, ChangeFeed) {
+		w := httptest.NewRecorder()
+		listChanges(w, httptest.NewRequest("GET", "/changes"+query, nil))
+		var feed ChangeFeed
+		json.Unmarshal(w.Body.Bytes(), &feed)
+		return w, feed
+	}
+
+	var types []string
+	since := int64(0)
+	for page := 0; page < 3; page++ {
+		_, feed := get("?limit=2&since=" + strconv.FormatInt(since, 10))
+		for _, change := range feed.Changes {
+			types = append(types, change.Type)
+		}
+		since = feed.Next
+	}
+	if len(types) != 3 || types[0] != ItemCreated || types[2] != ItemDeleted || since != 3 {
+		t.Errorf("expected to page through all three changes, got %v up to %d", types, since)
+	}
+
+	if w, _ := get("?limit=2&since=1"); w.Header().Get("Link") != `</changes?limit=2&since=3>; rel="next"` {
+		t.Errorf("expected a full page to link to the next, got %q", w.Header().Get("Link"))
+	}
+	if w, _ := get("?limit=2&since=2"); w.Header().Get("Link") != "" {
+		t.Errorf("expected no link after the last page, got %q", w.Header().Get("Link"))
+	}
+	if _, feed := get("?since=3"); len(feed.Changes) != 0 || feed.Next != 3 {
+		t.Errorf("expected an empty page that resumes from the same place, got %+v", feed)
+	}
+	for _, query := range []string{"?since=-1", "?since=latest", "?limit=0"} {
+		if w, _ := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func Test_listChangesLongPoll(t *testing.T) {
+	defer func(original ItemRepository, events *EventLog) { itemRepository, itemEvents = original, events }(itemRepository, itemEvents)
+	itemEvents, _ = OpenEventLog("")
+	itemRepository = NewEventSourcedItemRepository(itemEvents)
+
+	answered := make(chan ChangeFeed)
+	go func() {
+		w := httptest.NewRecorder()
+		listChanges(w, httptest.NewRequest("GET", "/changes?since=0&wait=10s", nil))
+		var feed ChangeFeed
+		json.Unmarshal(w.Body.Bytes(), &feed)
+		answered <- feed
+	}()
+	select {
+	case feed := <-answered:
+		t.Fatalf("expected the request to wait for a change, got %+v", feed)
+	case <-time.After(50 * time.Millisecond):
+	}
+	itemRepository.Create(context.Background(), Item{Name: "awaited"})
+	select {
+	case feed := <-answered:
+		if len(feed.Changes) != 1 || feed.Next != 1 {
+			t.Errorf("expected the new change, got %+v", feed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be answered once the change arrived")
+	}
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	listChanges(w, httptest.NewRequest("GET", "/changes?since=1&wait=20ms", nil))
+	if time.Since(start) < 20*time.Millisecond || !strings.Contains(w.Body.String(), `"changes":[]`) {
+		t.Errorf("expected an empty page after the wait, got %s after %v", w.Body, time.Since(start))
+	}
+	w = httptest.NewRecorder()
+	listChanges(w, httptest.NewRequest("GET", "/changes?wait=2m", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a wait above a minute to be refused, got %d", w.Code)
+	}
+}
+
+func Test_listChangesRequiresToken(t *testing.T) {
+	api := newTestAPI(t)
+	itemEvents, _ = OpenEventLog("")
+	store, err := openTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isolate(t, &apiTokens, store)
+	reader, _ := store.Create("reader", []string{ScopeItemsRead}, nil)
+	api.Router = newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, RequireAPIToken: true})
+
+	if w := api.Request("GET", "/changes", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	if w := api.Request("GET", "/changes", nil, "Authorization", "Bearer "+reader.Secret); w.Code != http.StatusOK {
+		t.Errorf("expected a reader to get the changes, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// ChaosConfig says which faults to inject into what share of the requests,
+// in percent. Every fault is rolled for independently.
+type ChaosConfig struct {
+	Latency        time.Duration
+	LatencyPercent float64
+	ErrorPercent   float64
+	DropPercent    float64
+}
+
+func (c ChaosConfig) enabled() bool {
+	return c.LatencyPercent > 0 || c.ErrorPercent > 0 || c.DropPercent > 0
+}
+
+// chaosRoll returns a number in [0, 100) to compare the percentages with; tests
+// pin it.
+var chaosRoll = func() float64 { return rand.Float64() * 100 }
+
+// chaosMiddleware makes the API misbehave on purpose, so clients can check
+// their timeouts and retries against it: it delays requests, answers them with
+// a 500, or drops the connection without an answer. It is meant for test
+// environments only.
+func chaosMiddleware(cfg ChaosConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if chaosRoll() < cfg.LatencyPercent {
+				timer := time.NewTimer(cfg.Latency)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+				}
+			}
+			if chaosRoll() < cfg.DropPercent {
+				// The server closes the connection without logging a panic.
+				panic(http.ErrAbortHandler)
+			}
+			if chaosRoll() < cfg.ErrorPercent {
+				ErrorCodeResponse(w, InjectedFaultCode)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setupChaos checks the -chaos-* flags.
+func setupChaos(cfg Config) error {
+	for _, percent := range []float64{cfg.Chaos.LatencyPercent, cfg.Chaos.ErrorPercent, cfg.Chaos.DropPercent} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("chaos percentages must be from 0 to 100, got %g", percent)
+		}
+	}
+	if cfg.Chaos.enabled() {
+		log.Printf("chaos mode: injecting %s latency into %g%%, errors into %g%% and dropped connections into %g%% of the requests",
+			cfg.Chaos.Latency, cfg.Chaos.LatencyPercent, cfg.Chaos.ErrorPercent, cfg.Chaos.DropPercent)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_chaosMiddleware(t *testing.T) {
+	defer func(original func() float64) { chaosRoll = original }(chaosRoll)
+	cfg := ChaosConfig{Latency: 30 * time.Millisecond, LatencyPercent: 10, ErrorPercent: 10, DropPercent: 10}
+	var requests atomic.Int32
+	chaos := chaosMiddleware(cfg)(http.HandlerFunc(ping))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		chaos.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	// a fresh connection for every request, so the transport never retries a
+	// dropped one
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	// the rolls come in the order latency, drop, error
+	tests := []struct {
+		name    string
+		rolls   []float64
+		status  int
+		dropped bool
+		slow    bool
+	}{
+		{"no fault", []float64{50, 50, 50}, http.StatusOK, false, false},
+		{"latency", []float64{5, 50, 50}, http.StatusOK, false, true},
+		{"error", []float64{50, 50, 5}, http.StatusInternalServerError, false, false},
+		{"drop", []float64{50, 5, 50}, 0, true, false},
+	}
+	for _, tt := range tests {
+		rolls := tt.rolls
+		chaosRoll = func() float64 {
+			if len(rolls) == 0 {
+				return 100
+			}
+			roll := rolls[0]
+			rolls = rolls[1:]
+			return roll
+		}
+		requests.Store(0)
+		start := time.Now()
+		resp, err := client.Get(server.URL)
+		if got := requests.Load(); got != 1 {
+			t.Errorf("%s: expected the server to see one request, got %d", tt.name, got)
+		}
+		if tt.dropped {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: expected the connection to be dropped, got %d", tt.name, resp.StatusCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+		if slow := time.Since(start) >= cfg.Latency; slow != tt.slow {
+			t.Errorf("%s: expected slow to be %v, took %v", tt.name, tt.slow, time.Since(start))
+		}
+	}
+
+	if err := setupChaos(Config{Chaos: ChaosConfig{ErrorPercent: 120}}); err == nil {
+		t.Error("expected a percentage above 100 to be refused")
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// forwardedHeader marks a write a follower forwarded to the leader, so it is
+// not forwarded again when leadership moved in the meantime.
+const forwardedHeader = "X-Forwarded-To-Leader"
+
+// clusterPeer is a member of the cluster: the address its raft transport
+// listens on and the URL its API is served on.
+type clusterPeer struct {
+	ID       raft.ServerID
+	RaftAddr raft.ServerAddress
+	APIURL   *url.URL
+}
+
+// parseClusterPeers reads -raft-peers: comma-separated id=raft-address=api-url
+// entries, e.g. a=10.0.0.1:7000=http://10.0.0.1:8000.
+func parseClusterPeers(value string) ([]clusterPeer, error) {
+	var peers []clusterPeer
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("raft peer %q is not of the form id=raft-address=api-url", entry)
+		}
+		apiURL, err := url.Parse(parts[2])
+		if err != nil || apiURL.Host == "" {
+			return nil, fmt.Errorf("raft peer %q has an invalid api url", entry)
+		}
+		peers = append(peers, clusterPeer{ID: raft.ServerID(parts[0]), RaftAddr: raft.ServerAddress(parts[1]), APIURL: apiURL})
+	}
+	return peers, nil
+}
+
+// clusterNode is this instance's membership in the raft cluster; nil unless
+// -storage raft.
+var clusterNode *RaftItemRepository
+
+// RaftItemRepository replicates the items to every member of the cluster
+// through the raft log. Each member applies the log to its own in-memory
+// copy, which reads are served from; on a follower they may lag a little
+// behind the leader. Writes are decided on the leader: they run against a
+// draft of its copy, and what they changed is committed to the log as a
+// batch of item events, which every member then applies alike.
+type RaftItemRepository struct {
+	// mu serializes writes, so each one is decided on the state the
+	// previous one left behind.
+	mu    sync.Mutex
+	id    raft.ServerID
+	raft  *raft.Raft
+	fsm   *itemFSM
+	peers []clusterPeer
+}
+
+// errDraftDecided discards the draft a write ran against, once its events
+// have been recorded.
+var errDraftDecided = errors.New("draft decided")
+
+func (repo *RaftItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	return repo.fsm.current().List(ctx, filter)
+}
+
+func (repo *RaftItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	return repo.fsm.current().Get(ctx, id)
+}
+
+func (repo *RaftItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	var created *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) (err error) {
+		created, err = tx.Create(ctx, item)
+		return err
+	})
+	return created, err
+}
+
+func (repo *RaftItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	var inserted *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) (err error) {
+		inserted, err = tx.Insert(ctx, item)
+		return err
+	})
+	return inserted, err
+}
+
+func (repo *RaftItemRepository) Update(ctx context.Context, item Item) error {
+	return repo.Tx(ctx, func(tx ItemRepository) error {
+		return tx.Update(ctx, item)
+	})
+}
+
+func (repo *RaftItemRepository) Delete(ctx context.Context, id int) error {
+	return repo.Tx(ctx, func(tx ItemRepository) error {
+		return tx.Delete(ctx, id)
+	})
+}
+
+// Tx decides fn on a draft and commits its writes to the raft log. It returns
+// once this member has applied them, so the caller reads its own writes.
+func (repo *RaftItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.raft.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+
+	var recorder *eventRecorder
+	err := repo.fsm.current().Tx(ctx, func(tx ItemRepository) error {
+		recorder = &eventRecorder{ItemRepository: tx}
+		if err := fn(recorder); err != nil {
+			return err
+		}
+		return errDraftDecided
+	})
+	if err != errDraftDecided {
+		return err
+	}
+	if len(recorder.events) == 0 {
+		return nil
+	}
+
+	command, err := json.Marshal(recorder.events)
+	if err != nil {
+		return err
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	future := repo.raft.Apply(command, timeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// IndexStats reports the indexes of this member's copy.
+func (repo *RaftItemRepository) IndexStats() []IndexStats {
+	return repo.fsm.current().IndexStats()
+}
+
+// itemFSM is the state machine the raft log is applied to.
+type itemFSM struct {
+	mu    sync.RWMutex
+	state *InMemoryItemRepository
+}
+
+func (f *itemFSM) current() *InMemoryItemRepository {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.state
+}
+
+// Apply applies a batch of item events in one go.
+func (f *itemFSM) Apply(entry *raft.Log) interface{} {
+	var events []ItemEvent
+	if err := json.Unmarshal(entry.Data, &events); err != nil {
+		return err
+	}
+	return f.current().Tx(context.Background(), func(tx ItemRepository) error {
+		draft := tx.(*InMemoryItemRepository)
+		for _, event := range events {
+			if event.Type == ItemDeleted {
+				if err := draft.Delete(context.Background(), event.ItemID); err != nil {
+					return err
+				}
+				continue
+			}
+			draft.put(*event.Item)
+		}
+		return nil
+	})
+}
+
+// itemSnapshot is the state at some point of the log. NextID is kept apart
+// from the items, so IDs of deleted items are not handed out again after a
+// restore.
+type itemSnapshot struct {
+	Items  []Item `json:"items"`
+	NextID int    `json:"next_id"`
+}
+
+// Snapshot is never called concurrently with Apply, so the state holds still.
+func (f *itemFSM) Snapshot() (raft.FSMSnapshot, error) {
+	state := f.current()
+	items, err := state.List(context.Background(), ItemFilter{})
+	if err != nil {
+		return nil, err
+	}
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return &itemSnapshot{Items: items, NextID: state.nextID}, nil
+}
+
+func (f *itemFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+	var restored itemSnapshot
+	if err := json.NewDecoder(snapshot).Decode(&restored); err != nil {
+		return err
+	}
+	state := NewInMemoryItemRepository(restored.Items...)
+	state.nextID = max(state.nextID, restored.NextID)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = state
+	return nil
+}
+
+func (s *itemSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *itemSnapshot) Release() {}
+
+// newRaftItemRepository joins this instance to the cluster described by
+// -raft-peers, keeping the raft log and snapshots in -raft-dir. A member
+// without any state yet bootstraps the cluster with all peers; every member
+// does so with the same configuration, whichever starts first.
+func newRaftItemRepository(cfg Config) (*RaftItemRepository, error) {
+	peers, err := parseClusterPeers(cfg.RaftPeers)
+	if err != nil {
+		return nil, err
+	}
+	var self *clusterPeer
+	for i := range peers {
+		if string(peers[i].ID) == cfg.RaftID {
+			self = &peers[i]
+		}
+	}
+	if self == nil {
+		return nil, fmt.Errorf("-raft-id %q is not one of -raft-peers", cfg.RaftID)
+	}
+
+	if err := os.MkdirAll(cfg.RaftDir, 0700); err != nil {
+		return nil, err
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.RaftDir, "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.RaftDir, 2, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", string(self.RaftAddr))
+	if err != nil {
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(string(self.RaftAddr), advertise, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return startRaft(self.ID, peers, store, store, snapshots, transport)
+}
+
+func startRaft(id raft.ServerID, peers []clusterPeer, logs raft.LogStore, stable raft.StableStore, snapshots raft.SnapshotStore, transport raft.Transport) (*RaftItemRepository, error) {
+	config := raft.DefaultConfig()
+	config.LocalID = id
+	repo := &RaftItemRepository{id: id, fsm: &itemFSM{state: NewInMemoryItemRepository()}, peers: peers}
+
+	existing, err := raft.HasExistingState(logs, stable, snapshots)
+	if err != nil {
+		return nil, err
+	}
+	if !existing {
+		var configuration raft.Configuration
+		for _, peer := range peers {
+			configuration.Servers = append(configuration.Servers, raft.Server{ID: peer.ID, Address: peer.RaftAddr})
+		}
+		if err := raft.BootstrapCluster(config, logs, stable, snapshots, transport, configuration); err != nil {
+			return nil, err
+		}
+	}
+
+	repo.raft, err = raft.NewRaft(config, repo.fsm, logs, stable, snapshots, transport)
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// leaderForwardingMiddleware passes writes a follower receives on to the
+// leader's API. Reads are answered locally.
+func leaderForwardingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if clusterNode.raft.State() == raft.Leader || r.Header.Get(forwardedHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader := clusterNode.leader()
+		if leader == nil {
+			ServiceUnavailableResponse(w, "the cluster has no leader")
+			return
+		}
+		r.Header.Set(forwardedHeader, string(leader.ID))
+		httputil.NewSingleHostReverseProxy(leader.APIURL).ServeHTTP(w, r)
+	})
+}
+
+func (repo *RaftItemRepository) leader() *clusterPeer {
+	_, id := repo.raft.LeaderWithID()
+	for i := range repo.peers {
+		if repo.peers[i].ID == id {
+			return &repo.peers[i]
+		}
+	}
+	return nil
+}
+
+// ClusterStatus is what /cluster/status reports about this member.
+type ClusterStatus struct {
+	ID           string              `json:"id"`
+	State        string              `json:"state"`
+	Leader       string              `json:"leader,omitempty"`
+	Term         uint64              `json:"term"`
+	LastIndex    uint64              `json:"last_index"`
+	AppliedIndex uint64              `json:"applied_index"`
+	Peers        []ClusterPeerStatus `json:"peers"`
+}
+
+type ClusterPeerStatus struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_address"`
+	APIURL   string `json:"api_url,omitempty"`
+	Suffrage string `json:"suffrage"`
+}
+
+func clusterStatus(w http.ResponseWriter, r *http.Request) {
+	future := clusterNode.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		InternalErrorResponse(w, "could not read the cluster configuration")
+		return
+	}
+
+	_, leader := clusterNode.raft.LeaderWithID()
+	status := ClusterStatus{
+		ID:           string(clusterNode.id),
+		State:        clusterNode.raft.State().String(),
+		Leader:       string(leader),
+		Term:         clusterNode.raft.CurrentTerm(),
+		LastIndex:    clusterNode.raft.LastIndex(),
+		AppliedIndex: clusterNode.raft.AppliedIndex(),
+		Peers:        []ClusterPeerStatus{},
+	}
+	for _, server := range future.Configuration().Servers {
+		peer := ClusterPeerStatus{ID: string(server.ID), RaftAddr: string(server.Address), Suffrage: server.Suffrage.String()}
+		for _, known := range clusterNode.peers {
+			if known.ID == server.ID {
+				peer.APIURL = known.APIURL.String()
+			}
+		}
+		status.Peers = append(status.Peers, peer)
+	}
+	SuccessResponse(w, status)
+}
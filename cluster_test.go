@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// startSingleNodeCluster starts a cluster of one, which elects itself leader.
+func startSingleNodeCluster(t *testing.T) *RaftItemRepository {
+	addr, transport := raft.NewInmemTransport("")
+	store := raft.NewInmemStore()
+	peers := []clusterPeer{{ID: "a", RaftAddr: addr}}
+	repo, err := startRaft("a", peers, store, store, raft.NewInmemSnapshotStore(), transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.raft.Shutdown().Error() })
+	select {
+	case <-repo.raft.LeaderCh():
+	case <-time.After(10 * time.Second):
+		t.Fatal("no leader was elected")
+	}
+	return repo
+}
+
+func Test_raftRepositoryReplicatesWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := startSingleNodeCluster(t)
+
+	first, err := repo.Create(ctx, Item{Name: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Create(ctx, Item{Name: "second"})
+	if err := repo.Update(ctx, Item{ID: first.ID, Name: "first", Description: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, 1); err != NotFoundError {
+		t.Errorf("deleting twice returned %v", err)
+	}
+
+	items, _ := repo.List(ctx, ItemFilter{})
+	if len(items) != 1 || items[0].Description != "updated" {
+		t.Errorf("unexpected items %+v", items)
+	}
+	if repo.raft.AppliedIndex() < 4 {
+		t.Errorf("writes did not go through the log, applied index %d", repo.raft.AppliedIndex())
+	}
+}
+
+func Test_itemFSMSnapshotKeepsNextID(t *testing.T) {
+	ctx := context.Background()
+	fsm := &itemFSM{state: NewInMemoryItemRepository(seedItems...)}
+	last := seedItems[len(seedItems)-1].ID
+	fsm.state.Delete(ctx, last)
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := snapshot.Persist(&bufferSink{Buffer: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	restored := &itemFSM{}
+	if err := restored.Restore(io.NopCloser(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := restored.current().Create(ctx, Item{Name: "after restore"})
+	if created.ID != last+1 {
+		t.Errorf("restored state hands out ID %d again", created.ID)
+	}
+}
+
+type bufferSink struct {
+	*bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
@@ -0,0 +1,303 @@
+// Command loadgen drives a mix of reads and writes against a running instance
+// of the API and reports the latency percentiles and error rate per
+// operation, so storage backends and cache settings can be compared on the
+// same load:
+//
+//	go run ./cmd/loadgen -url http://localhost:8000 -duration 30s -concurrency 20 -mix get=70,list=10,create=10,update=8,delete=2
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// operations are the requests loadgen knows how to send, in report order.
+var operations = []string{"get", "list", "create", "update", "delete"}
+
+// loadConfig is what the flags set.
+type loadConfig struct {
+	URL         string
+	Token       string
+	Duration    time.Duration
+	Concurrency int
+	Rate        int
+	Mix         map[string]int
+}
+
+func main() {
+	var cfg loadConfig
+	var mix string
+	flag.StringVar(&cfg.URL, "url", "http://localhost:8000", "base URL of the API, with its base path")
+	flag.StringVar(&cfg.Token, "token", "", "API or access token sent as Authorization: Bearer")
+	flag.DurationVar(&cfg.Duration, "duration", 30*time.Second, "how long to send requests for")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 10, "number of clients sending requests at the same time")
+	flag.IntVar(&cfg.Rate, "rate", 0, "requests per second over all clients; 0 sends as fast as the API answers")
+	flag.StringVar(&mix, "mix", "get=70,list=10,create=10,update=8,delete=2", "relative weights of the operations: "+strings.Join(operations, ", "))
+	flag.Parse()
+
+	var err error
+	if cfg.Mix, err = parseMix(mix); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+	results, elapsed, err := run(cfg, &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(os.Stdout, results, elapsed)
+}
+
+// parseMix reads weights like get=70,list=10,create=20. Operations left out
+// aren't sent.
+func parseMix(s string) (map[string]int, error) {
+	mix := map[string]int{}
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		weight, err := strconv.Atoi(value)
+		if !slices.Contains(operations, name) || err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid mix entry %q, want an operation out of %s with a weight like get=70", part, strings.Join(operations, ", "))
+		}
+		mix[name] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("the mix %q sends nothing", s)
+	}
+	return mix, nil
+}
+
+// pick chooses an operation at random, as often as its weight says.
+func pick(mix map[string]int) string {
+	total := 0
+	for _, weight := range mix {
+		total += weight
+	}
+	roll := rand.IntN(total)
+	for _, name := range operations {
+		if roll < mix[name] {
+			return name
+		}
+		roll -= mix[name]
+	}
+	return operations[0]
+}
+
+// result is the outcome of one operation.
+type result struct {
+	latency time.Duration
+	failed  bool
+}
+
+// client sends the operations and keeps track of the IDs there are to read,
+// update and delete.
+type client struct {
+	cfg  loadConfig
+	http *http.Client
+
+	mu  sync.Mutex
+	ids []int
+	seq int
+}
+
+type loadItem struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// run sends requests for cfg.Duration and returns the results per operation.
+// It first lists the first page of items, so reads have something to hit.
+func run(cfg loadConfig, httpClient *http.Client) (map[string][]result, time.Duration, error) {
+	c := &client{cfg: cfg, http: httpClient}
+	var items []loadItem
+	status, err := c.do(http.MethodGet, "/items/?limit=100", nil, &items)
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != http.StatusOK {
+		return nil, 0, fmt.Errorf("listing the items answered %d", status)
+	}
+	for _, item := range items {
+		c.ids = append(c.ids, item.ID)
+	}
+
+	var ticks <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(cfg.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	results := map[string][]result{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+	for range cfg.Concurrency {
+		wg.Go(func() {
+			for time.Now().Before(deadline) {
+				if ticks != nil {
+					<-ticks
+				}
+				op := pick(cfg.Mix)
+				began := time.Now()
+				ok := c.send(op)
+				r := result{latency: time.Since(began), failed: !ok}
+				mu.Lock()
+				results[op] = append(results[op], r)
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return results, time.Since(start), nil
+}
+
+// send runs op once and tells whether the API answered as expected. Reads and
+// writes of a random known item count a 404 as fine, as another client may
+// have just deleted it.
+func (c *client) send(op string) bool {
+	switch op {
+	case "list":
+		status, err := c.do(http.MethodGet, "/items/?limit=20", nil, nil)
+		return err == nil && status == http.StatusOK
+	case "create":
+		c.mu.Lock()
+		c.seq++
+		name := fmt.Sprintf("loadgen-%d-%d", time.Now().UnixNano(), c.seq)
+		c.mu.Unlock()
+		var item loadItem
+		status, err := c.do(http.MethodPost, "/items/", loadItem{Name: name, Description: "created by loadgen"}, &item)
+		if err != nil || status != http.StatusCreated {
+			return false
+		}
+		c.mu.Lock()
+		c.ids = append(c.ids, item.ID)
+		c.mu.Unlock()
+		return true
+	}
+
+	id, ok := c.randomID(op == "delete")
+	if !ok {
+		// nothing to read or change yet; list instead
+		return c.send("list")
+	}
+	path := "/items/" + strconv.Itoa(id)
+	var status int
+	var err error
+	switch op {
+	case "get":
+		status, err = c.do(http.MethodGet, path, nil, nil)
+	case "update":
+		status, err = c.do(http.MethodPut, path, loadItem{Name: fmt.Sprintf("loadgen-%d-updated", id), Description: "updated by loadgen"}, nil)
+	case "delete":
+		status, err = c.do(http.MethodDelete, path, nil, nil)
+		return err == nil && (status == http.StatusNoContent || status == http.StatusNotFound)
+	}
+	return err == nil && (status == http.StatusOK || status == http.StatusNotFound)
+}
+
+// randomID picks a known ID, forgetting it when it's about to be deleted.
+func (c *client) randomID(remove bool) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.ids) == 0 {
+		return 0, false
+	}
+	i := rand.IntN(len(c.ids))
+	id := c.ids[i]
+	if remove {
+		c.ids[i] = c.ids[len(c.ids)-1]
+		c.ids = c.ids[:len(c.ids)-1]
+	}
+	return id, true
+}
+
+// do sends a request with body as JSON and decodes the response into
+// response, if given.
+func (c *client) do(method, path string, body, response any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.cfg.URL, "/")+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if response != nil && resp.StatusCode < 300 {
+		err = json.NewDecoder(resp.Body).Decode(response)
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	return resp.StatusCode, err
+}
+
+// percentile returns the latency p percent of the sorted latencies stay at or
+// under.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// report writes a table with a row per operation and one for all of them.
+func report(w io.Writer, results map[string][]result, elapsed time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\trequests\treq/s\terrors\tp50\tp90\tp99\tmax\t")
+	var all []result
+	row := func(name string, rs []result) {
+		latencies := make([]time.Duration, len(rs))
+		failed := 0
+		for i, r := range rs {
+			latencies[i] = r.latency
+			if r.failed {
+				failed++
+			}
+		}
+		slices.Sort(latencies)
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%v\t%v\t%v\t%v\t\n", name, len(rs), float64(len(rs))/elapsed.Seconds(),
+			100*float64(failed)/float64(max(1, len(rs))), percentile(latencies, 50).Round(time.Microsecond),
+			percentile(latencies, 90).Round(time.Microsecond), percentile(latencies, 99).Round(time.Microsecond),
+			percentile(latencies, 100).Round(time.Microsecond))
+	}
+	for _, name := range operations {
+		if rs := results[name]; len(rs) > 0 {
+			row(name, rs)
+			all = append(all, rs...)
+		}
+	}
+	row("total", all)
+	tw.Flush()
+}
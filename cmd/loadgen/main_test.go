@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_parseMix(t *testing.T) {
+	mix, err := parseMix("get=3, create=1")
+	if err != nil || mix["get"] != 3 || mix["create"] != 1 || mix["delete"] != 0 {
+		t.Errorf("expected the weights given, got %v %v", mix, err)
+	}
+	for _, invalid := range []string{"get", "fetch=1", "get=-1", "get=0"} {
+		if _, err := parseMix(invalid); err == nil {
+			t.Errorf("expected %q to be refused", invalid)
+		}
+	}
+}
+
+func Test_percentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := percentile(latencies, p); got != expected {
+			t.Errorf("p%v: expected %v, got %v", p, expected, got)
+		}
+	}
+}
+
+func Test_run(t *testing.T) {
+	var created, failedWrites atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/items/":
+			w.Write([]byte(`[{"id":0,"name":"first"}]`))
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(loadItem{ID: int(created.Add(1)), Name: "new"})
+		case r.Method == http.MethodPut:
+			failedWrites.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Write([]byte(`{"id":0}`))
+		}
+	}))
+	defer server.Close()
+
+	mix, _ := parseMix("get=1,list=1,create=1,update=1,delete=1")
+	results, elapsed, err := run(loadConfig{URL: server.URL, Duration: 50 * time.Millisecond, Concurrency: 4, Mix: mix}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range operations {
+		if len(results[op]) == 0 {
+			t.Errorf("expected %s requests to be sent", op)
+		}
+	}
+	failed := 0
+	for _, r := range results["update"] {
+		if r.failed {
+			failed++
+		}
+	}
+	if failed == 0 || failed != int(failedWrites.Load()) {
+		t.Errorf("expected the %d updates answering 500 to count as errors, got %d", failedWrites.Load(), failed)
+	}
+
+	var out strings.Builder
+	report(&out, results, elapsed)
+	if !strings.Contains(out.String(), "update") || !strings.Contains(out.String(), "total") {
+		t.Errorf("expected a row per operation and a total, got\n%s", out.String())
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listVersion tracks when the item collection last changed, so pollers of
+// GET /items/ get a 304 instead of the same list again. It only sees the
+// writes of this process, so it is nil for the backends other instances write
+// to as well: redis, mongo and raft.
+var listVersion *collectionVersion
+
+// collectionVersion counts the changes to the items. Besides writes, an item
+// being published or expiring changes the listing, so the publish_at and
+// expires_at still to come are kept and count as changes once they pass.
+type collectionVersion struct {
+	mu       sync.Mutex
+	boot     string
+	version  uint64
+	modified time.Time
+	upcoming []time.Time
+	now      func() time.Time
+}
+
+// newCollectionVersion starts counting at the items there are. The boot ID
+// keeps the ETags of an earlier run from matching.
+func newCollectionVersion(items []Item, now func() time.Time) *collectionVersion {
+	var random [8]byte
+	rand.Read(random[:])
+	v := &collectionVersion{boot: hex.EncodeToString(random[:]), modified: now(), now: now}
+	for _, item := range items {
+		v.schedule(item)
+	}
+	return v
+}
+
+// changed records a write of the items; deleted items are left out.
+func (v *collectionVersion) changed(items ...Item) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.version++
+	v.modified = v.now()
+	for _, item := range items {
+		v.schedule(item)
+	}
+}
+
+func (v *collectionVersion) schedule(item Item) {
+	now := v.now()
+	for _, t := range []*time.Time{item.PublishAt, item.ExpiresAt} {
+		if t != nil && t.After(now) {
+			i, _ := slices.BinarySearchFunc(v.upcoming, *t, time.Time.Compare)
+			v.upcoming = slices.Insert(v.upcoming, i, *t)
+		}
+	}
+}
+
+// current returns the ETag of the collection and when it last changed.
+func (v *collectionVersion) current() (string, time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	for len(v.upcoming) > 0 && !v.upcoming[0].After(now) {
+		v.version++
+		v.modified = later(v.modified, v.upcoming[0])
+		v.upcoming = v.upcoming[1:]
+	}
+	return fmt.Sprintf(`W/"%s.%d"`, v.boot, v.version), v.modified
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// listNotModified sets the ETag and Last-Modified of the item collection and
+// answers 304 when the client's copy is current, by If-None-Match or, without
+// it, If-Modified-Since.
+//
+// Last-Modified has whole seconds, so it is rounded up and only sent once
+// that second has passed: a later write then always has a later
+// Last-Modified, and a client can't miss it by having fetched the list
+// earlier in the same second.
+func listNotModified(w http.ResponseWriter, r *http.Request) bool {
+	if listVersion == nil {
+		return false
+	}
+	etag, modified := listVersion.current()
+	lastModified := modified.Truncate(time.Second)
+	if lastModified.Before(modified) {
+		lastModified = lastModified.Add(time.Second)
+	}
+	settled := !listVersion.now().Before(lastModified)
+
+	w.Header().Set("ETag", etag)
+	if settled {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	notModified := false
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		notModified = etagMatches(match, etag)
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		notModified = settled && !lastModified.After(since)
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// etagMatches compares the ETags of an If-None-Match with etag, weakly as
+// RFC 9110 asks for it.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// versionedRepository counts the writes that go through it towards
+// listVersion, those of transactions once they commit.
+type versionedRepository struct {
+	ItemRepository
+	version *collectionVersion
+}
+
+func (repo *versionedRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := repo.ItemRepository.Create(ctx, item)
+	if err == nil {
+		repo.version.changed(*created)
+	}
+	return created, err
+}
+
+func (repo *versionedRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		repo.version.changed(*inserted)
+	}
+	return inserted, err
+}
+
+func (repo *versionedRepository) Update(ctx context.Context, item Item) error {
+	err := repo.ItemRepository.Update(ctx, item)
+	if err == nil {
+		repo.version.changed(item)
+	}
+	return err
+}
+
+func (repo *versionedRepository) Delete(ctx context.Context, id int) error {
+	err := repo.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		repo.version.changed()
+	}
+	return err
+}
+
+func (repo *versionedRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	var recorder *eventRecorder
+	err := repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		recorder = &eventRecorder{ItemRepository: tx}
+		return fn(recorder)
+	})
+	if err != nil || len(recorder.events) == 0 {
+		return err
+	}
+	var items []Item
+	for _, event := range recorder.events {
+		if event.Item != nil {
+			items = append(items, *event.Item)
+		}
+	}
+	repo.version.changed(items...)
+	return nil
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *versionedRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+// setupConditionalLists tracks the version of the item collection for the
+// backends only this process writes to.
+func setupConditionalLists(cfg Config) error {
+	switch cfg.Storage {
+	case "redis", "mongo", "raft":
+		return nil
+	}
+	items, err := itemRepository.List(context.Background(), ItemFilter{})
+	if err != nil {
+		return err
+	}
+	listVersion = newCollectionVersion(items, time.Now)
+	itemRepository = &versionedRepository{ItemRepository: itemRepository, version: listVersion}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_conditionalLists(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 300_000_000, time.UTC)
+	publishAt := now.Add(time.Minute)
+	isolate(t, &listVersion, newCollectionVersion([]Item{{PublishAt: &publishAt}}, func() time.Time { return now }))
+	isolate(t, &itemRepository, ItemRepository(&versionedRepository{ItemRepository: itemRepository, version: listVersion}))
+
+	// the second the collection changed in hasn't passed
+	w := api.Request("GET", "/items/", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("expected an ETag but no Last-Modified yet, got %d %v", w.Code, w.Header())
+	}
+	if w := api.Request("GET", "/items/", nil, "If-None-Match", `"other", `+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304 for the current ETag, got %d %s", w.Code, w.Body)
+	}
+
+	now = now.Add(time.Second)
+	w = api.Request("GET", "/items/?limit=10", nil)
+	if lastModified := w.Header().Get("Last-Modified"); lastModified != "Wed, 01 May 2024 12:00:01 GMT" {
+		t.Fatalf("expected Last-Modified rounded up to the next second, got %q", lastModified)
+	}
+	if w := api.Request("GET", "/items/", nil, "If-Modified-Since", "Wed, 01 May 2024 12:00:01 GMT"); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged collection, got %d", w.Code)
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+	}{
+		{"write", func() { api.Request("POST", "/items/", Item{Name: "desk"}) }},
+		{"transaction", func() { api.Request("POST", "/items/0/duplicate", nil) }},
+		{"publication", func() { now = publishAt }},
+	}
+	for _, tt := range tests {
+		before := api.Request("GET", "/items/", nil)
+		tt.change()
+		now = now.Add(time.Second)
+		if w := api.Request("GET", "/items/", nil, "If-None-Match", before.Header().Get("ETag")); w.Code != http.StatusOK {
+			t.Errorf("%s: expected the changed list by ETag, got %d", tt.name, w.Code)
+		}
+		if w := api.Request("GET", "/items/", nil, "If-Modified-Since", before.Header().Get("Last-Modified")); w.Code != http.StatusOK {
+			t.Errorf("%s: expected the changed list by date, got %d", tt.name, w.Code)
+		}
+	}
+
+	// a dry run changes nothing
+	before := api.Request("GET", "/items/", nil).Header().Get("ETag")
+	api.Request("POST", "/items/?dry_run=true", Item{Name: "chair"})
+	if w := api.Request("GET", "/items/", nil, "If-None-Match", before); w.Code != http.StatusNotModified {
+		t.Errorf("expected a dry run to keep the ETag, got %d", w.Code)
+	}
+}
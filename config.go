@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config gathers everything that can be tuned from the command line or the
+// -config file.
+type Config struct {
+	ConfigFile string
+
+	GracefulTimeout  time.Duration
+	RestartTimeout   time.Duration
+	RouteTimeout     time.Duration
+	RouteTimeouts    RouteTimeouts
+	HoneypotDenylist time.Duration
+	BasePath         string
+	AdminAddr        string
+	Envelope         bool
+	Pretty           bool
+
+	LenientMediaTypes    bool
+	DatasetStatsInterval time.Duration
+	Metrics              string
+	APITokensPath        string
+	RequireAPIToken      bool
+	CreateAdminToken     string
+	UsersPath            string
+	UserScopes           string
+	UsagePath            string
+	DebugRecordings      int
+	DebugRedact          string
+	Chaos                ChaosConfig
+	FixturesMode         string
+	FixturesDir          string
+	AuditLogPath         string
+	AuditRetention       time.Duration
+	JWTSecret            string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	RateLimit            int
+	RateLimitWindow      time.Duration
+	DailyQuota           int
+	StatsdAddr           string
+	StatsdPrefix         string
+	StatsdTags           string
+
+	Storage       string
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	MongoURI      string
+	MongoDatabase string
+	MongoTimeout  time.Duration
+	BoltPath      string
+	EventLogPath  string
+	RaftID        string
+	RaftPeers     string
+	RaftDir       string
+
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+	MemoryShards     int
+	ExportDir        string
+	ExportTTL        time.Duration
+	ExportMaxFiles   int
+
+	StorageStartupTimeout time.Duration
+
+	BreakerThreshold    int
+	BreakerCooldown     time.Duration
+	StorageRetries      int
+	StorageRetryBackoff time.Duration
+
+	CacheSize int
+	CacheTTL  time.Duration
+
+	EncryptedFields string
+	EncryptionKey   string
+
+	UniqueNames bool
+	ClientIDs   bool
+	IDFormat    string
+	ReadOnly    bool
+
+	ReplicateFrom       string
+	ReplicationInterval time.Duration
+
+	ValidationRulesPath string
+	// ValidationRules are read from ValidationRulesPath.
+	ValidationRules ValidationRules
+
+	Search             string
+	SearchIndexPath    string
+	ElasticsearchURL   string
+	ElasticsearchIndex string
+	Reindex            bool
+
+	KafkaBrokers       string
+	KafkaTopic         string
+	KafkaOffsetPath    string
+	KafkaRelayInterval time.Duration
+
+	NatsURL           string
+	NatsSubjectPrefix string
+
+	ScheduleInterval time.Duration
+	ReapInterval     time.Duration
+
+	SecretsSource          string
+	SecretsRefreshInterval time.Duration
+	VaultAddr              string
+	VaultToken             string
+	VaultPath              string
+	AWSRegion              string
+	AWSSecretID            string
+}
+
+// parseConfig reads the command line and the -config file on startup,
+// exiting on errors.
+func parseConfig() Config {
+	runningArgs = os.Args[1:]
+	cfg, fs, err := loadConfig(runningArgs, flag.ExitOnError)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runningFlags = fs
+	return cfg
+}
+
+// loadConfig parses args, then applies the -config file on top. The file is a
+// JSON object keyed by flag name, e.g. {"route-timeout": "5s", "pretty": true}.
+func loadConfig(args []string, errorHandling flag.ErrorHandling) (Config, *flag.FlagSet, error) {
+	cfg := Config{RouteTimeouts: RouteTimeouts{}}
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	if errorHandling == flag.ContinueOnError {
+		fs.SetOutput(io.Discard)
+	}
+	fs.StringVar(&cfg.ConfigFile, "config", "", "JSON file with flag values, keyed by flag name; reloaded on SIGHUP")
+	fs.DurationVar(&cfg.GracefulTimeout, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
+	fs.DurationVar(&cfg.RestartTimeout, "restart-timeout", time.Minute, "how long a restart triggered by SIGUSR2 waits for the new process to be ready before giving up on it")
+	fs.DurationVar(&cfg.RouteTimeout, "route-timeout", time.Second*10, "the default deadline for handling a request - e.g. 500ms or 10s")
+	fs.Var(cfg.RouteTimeouts, "route-timeouts", "deadlines per route group overriding -route-timeout - e.g. items=2s,ping=100ms")
+	fs.DurationVar(&cfg.HoneypotDenylist, "honeypot-denylist", 0, "how long to block an IP after it requested a honeypot route, 0 disables blocking - e.g. 1h")
+	fs.StringVar(&cfg.BasePath, "base-path", os.Getenv("BASE_PATH"), "prefix the whole API is served under, e.g. /api/items-service; defaults to $BASE_PATH")
+	fs.StringVar(&cfg.AdminAddr, "admin-addr", "127.0.0.1:8001", "address of the listener for the operational endpoints (/ready, /admin/..., /debug/pprof/), empty serves them with the API and without pprof")
+	fs.BoolVar(&cfg.Envelope, "envelope", false, `wrap every response in {"data", "meta", "errors"}; without it clients opt in per request with Accept: application/json; profile="envelope"`)
+	fs.BoolVar(&cfg.Pretty, "pretty", false, "indent JSON responses, handy during development; clients can override it per request with ?pretty=false or ?pretty=true")
+	fs.BoolVar(&cfg.LenientMediaTypes, "lenient-media-types", false, "decode request bodies whatever their Content-Type and ignore the Accept header, for legacy clients")
+	fs.DurationVar(&cfg.DatasetStatsInterval, "dataset-stats-interval", time.Hour, "how often the item count is sampled for the growth rate on /admin/dataset-stats, 0 disables sampling")
+	fs.StringVar(&cfg.APITokensPath, "api-tokens-path", "", "file the API tokens managed on /admin/tokens are kept in, empty disables API tokens")
+	fs.BoolVar(&cfg.RequireAPIToken, "require-api-token", false, "only serve /items to requests with an API token with the items:read or items:write scope")
+	fs.StringVar(&cfg.CreateAdminToken, "create-admin-token", "", "create an API token with the admin scope under this name, print it and exit")
+	fs.StringVar(&cfg.UsersPath, "users-path", "", "file the users registered on /auth/register and their sessions are kept in, empty disables /auth")
+	fs.StringVar(&cfg.UserScopes, "user-scopes", ScopeItemsRead, "comma-separated scopes every user has: items:read, items:write")
+	fs.StringVar(&cfg.FixturesMode, "fixtures-mode", "", "record to save every request and response to -fixtures-dir, replay to answer requests with the responses saved there without touching the store; empty serves the API normally")
+	fs.StringVar(&cfg.FixturesDir, "fixtures-dir", "", "directory of the fixture files of -fixtures-mode")
+	fs.DurationVar(&cfg.Chaos.Latency, "chaos-latency", time.Second, "delay -chaos-latency-percent of the requests get, for testing clients")
+	fs.Float64Var(&cfg.Chaos.LatencyPercent, "chaos-latency-percent", 0, "percentage of requests delayed by -chaos-latency, for testing clients; 0 disables it")
+	fs.Float64Var(&cfg.Chaos.ErrorPercent, "chaos-error-percent", 0, "percentage of requests answered with a 500 INJECTED_FAULT, for testing clients; 0 disables it")
+	fs.Float64Var(&cfg.Chaos.DropPercent, "chaos-drop-percent", 0, "percentage of requests whose connection is dropped without an answer, for testing clients; 0 disables it")
+	fs.IntVar(&cfg.DebugRecordings, "debug-recordings", 0, "how many of the latest requests to record with their responses for GET /admin/recordings, 0 disables recording")
+	fs.StringVar(&cfg.DebugRedact, "debug-redact", "password,access_token,refresh_token,secret", "comma-separated JSON field paths like items.secret whose values are redacted in recorded bodies; arrays on the way are looked into")
+	fs.StringVar(&cfg.UsagePath, "usage-path", "", "file the requests and bytes per API token or user and day reported on /admin/usage are kept in, empty disables usage accounting")
+	fs.StringVar(&cfg.AuditLogPath, "audit-log-path", "", "file every change to an item is recorded in with who made it, queried on /audit; empty disables the audit log")
+	fs.DurationVar(&cfg.AuditRetention, "audit-retention", 0, "how long audit entries are kept before the hourly pruning drops them, 0 keeps them forever - e.g. 2160h")
+	fs.StringVar(&cfg.JWTSecret, "jwt-secret", os.Getenv("JWT_SECRET"), "key the access tokens are signed with; defaults to $JWT_SECRET, and to a random key that changes on restart when neither is set")
+	fs.DurationVar(&cfg.AccessTokenTTL, "access-token-ttl", 15*time.Minute, "how long an access token issued on /auth/login is valid")
+	fs.DurationVar(&cfg.RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "how long a session started on /auth/login lasts")
+	fs.StringVar(&cfg.SecretsSource, "secrets-source", "none", "where redis-password, mongo-uri, jwt-secret and encryption-key are read from on startup, overriding their flags: vault, aws or none")
+	fs.DurationVar(&cfg.SecretsRefreshInterval, "secrets-refresh-interval", 5*time.Minute, "how often the secrets are read again from -secrets-source, 0 reads them on startup only")
+	fs.StringVar(&cfg.VaultAddr, "vault-addr", os.Getenv("VAULT_ADDR"), "address of the Vault server used by -secrets-source vault; defaults to $VAULT_ADDR")
+	fs.StringVar(&cfg.VaultToken, "vault-token", os.Getenv("VAULT_TOKEN"), "token -secrets-source vault authenticates with; defaults to $VAULT_TOKEN")
+	fs.StringVar(&cfg.VaultPath, "vault-path", "secret/data/items-api", "API path of the KV version 2 secret -secrets-source vault reads")
+	fs.StringVar(&cfg.AWSRegion, "aws-region", "", "region of AWS Secrets Manager used by -secrets-source aws; empty takes it from the AWS configuration")
+	fs.StringVar(&cfg.AWSSecretID, "aws-secret-id", "items-api", "name or ARN of the secret -secrets-source aws reads")
+	fs.IntVar(&cfg.RateLimit, "rate-limit", 0, "requests a client (by IP) may make per -rate-limit-window, 0 disables the limit")
+	fs.DurationVar(&cfg.RateLimitWindow, "rate-limit-window", time.Minute, "the window -rate-limit counts requests in")
+	fs.IntVar(&cfg.DailyQuota, "daily-quota", 0, "requests a client (by IP) may make per UTC day, 0 disables the quota")
+	fs.StringVar(&cfg.Metrics, "metrics", "prometheus", "where request, storage and cache metrics go: prometheus (scraped from /metrics on the admin listener), statsd, dogstatsd or none")
+	fs.StringVar(&cfg.StatsdAddr, "statsd-addr", "127.0.0.1:8125", "address of the StatsD agent used by -metrics statsd and dogstatsd")
+	fs.StringVar(&cfg.StatsdPrefix, "statsd-prefix", "items_api.", "prefix of the metric names sent to StatsD")
+	fs.StringVar(&cfg.StatsdTags, "statsd-tags", "", "comma-separated tags added to every metric sent with -metrics dogstatsd, e.g. env:prod,team:items")
+	fs.StringVar(&cfg.Storage, "storage", "memory", "where items are stored: memory, redis, mongo, bolt, events or raft")
+	fs.StringVar(&cfg.RedisAddr, "redis-addr", "localhost:6379", "address of the redis server used by -storage redis")
+	fs.StringVar(&cfg.RedisPassword, "redis-password", "", "password of the redis server used by -storage redis")
+	fs.IntVar(&cfg.RedisDB, "redis-db", 0, "database number used by -storage redis")
+	fs.StringVar(&cfg.MongoURI, "mongo-uri", "mongodb://localhost:27017", "connection string of the MongoDB deployment used by -storage mongo")
+	fs.StringVar(&cfg.MongoDatabase, "mongo-database", "items", "database used by -storage mongo")
+	fs.DurationVar(&cfg.MongoTimeout, "mongo-timeout", time.Second*10, "how long connecting to MongoDB and creating its indexes may take on startup")
+	fs.StringVar(&cfg.BoltPath, "bolt-path", "items.db", "file used by -storage bolt, created when it doesn't exist")
+	fs.StringVar(&cfg.EventLogPath, "event-log-path", "events.jsonl", "file -storage events appends the item events to and replays them from on startup, empty keeps them in memory only")
+	fs.StringVar(&cfg.RaftID, "raft-id", "", "ID of this instance among -raft-peers, used by -storage raft")
+	fs.StringVar(&cfg.RaftPeers, "raft-peers", "", "members of the cluster used by -storage raft, comma-separated id=raft-address=api-url, e.g. a=10.0.0.1:7000=http://10.0.0.1:8000")
+	fs.StringVar(&cfg.RaftDir, "raft-dir", "raft", "directory -storage raft keeps its log and snapshots in")
+	fs.StringVar(&cfg.SnapshotPath, "snapshot-path", "", "file -storage memory saves its items to every -snapshot-interval and restores them from on startup, empty disables snapshots")
+	fs.DurationVar(&cfg.SnapshotInterval, "snapshot-interval", 5*time.Minute, "how often -storage memory saves a snapshot to -snapshot-path")
+	fs.IntVar(&cfg.MemoryShards, "memory-shards", 0, "number of shards -storage memory spreads the items over, each with its own lock, for heavy concurrent writes; 0 keeps a single map")
+	fs.StringVar(&cfg.ExportDir, "export-dir", "", "directory exports are kept in for -export-ttl, so their downloads can be resumed with Range requests; empty sends them without keeping them")
+	fs.DurationVar(&cfg.ExportTTL, "export-ttl", time.Hour, "how long an export in -export-dir can be downloaded")
+	fs.IntVar(&cfg.ExportMaxFiles, "export-max-files", 100, "how many exports and backups -export-dir keeps at most; more answer 503")
+	fs.DurationVar(&cfg.StorageStartupTimeout, "storage-startup-timeout", 30*time.Second, "how long to keep retrying to reach the storage backend on startup, 0 tries once")
+	fs.IntVar(&cfg.BreakerThreshold, "breaker-threshold", 5, "storage failures in a row after which the circuit breaker stops calling the backend, 0 disables the breaker")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 30*time.Second, "how long the open circuit breaker rejects storage calls before trying again")
+	fs.IntVar(&cfg.StorageRetries, "storage-retries", 2, "how often failed storage reads and updates are retried")
+	fs.DurationVar(&cfg.StorageRetryBackoff, "storage-retry-backoff", 50*time.Millisecond, "wait before the first storage retry, doubled for every further one")
+	fs.IntVar(&cfg.CacheSize, "cache-size", 0, "how many items to keep in a read cache in front of the storage backend, 0 disables the cache")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", 30*time.Second, "how long a cached item is served before it is read from the storage backend again")
+	fs.StringVar(&cfg.EncryptedFields, "encrypted-fields", "", "comma-separated item fields encrypted before they are stored: name, description; empty stores them as they are")
+	fs.StringVar(&cfg.EncryptionKey, "encryption-key", os.Getenv("ENCRYPTION_KEY"), "comma-separated base64 AES-256 keys for -encrypted-fields, the first encrypts and all decrypt; defaults to $ENCRYPTION_KEY")
+	fs.StringVar(&cfg.IDFormat, "id-format", IDFormatInt, "how clients identify items: int, or uuidv7 to give every item a UUID version 7 that the item routes take in place of the id")
+	fs.BoolVar(&cfg.ClientIDs, "client-ids", false, "let POST /items/ take the new item's id from the body, for imports that keep the IDs of another system")
+	fs.BoolVar(&cfg.ReadOnly, "read-only", false, "refuse every write to the items with 405 READ_ONLY, for replicas serving a copy of the data kept up to date elsewhere")
+	fs.StringVar(&cfg.ReplicateFrom, "replicate-from", "", "admin listener of a primary running -storage events, e.g. http://10.0.0.1:8001; this instance then serves a read-only copy of its items")
+	fs.DurationVar(&cfg.ReplicationInterval, "replication-interval", time.Second, "how often a replica pulls the changes of -replicate-from")
+	fs.BoolVar(&cfg.UniqueNames, "unique-names", false, "refuse to store an item under a name another item has, ignoring case")
+	fs.StringVar(&cfg.ValidationRulesPath, "validation-rules", "", `JSON file with rules items must meet besides the built-in ones, by field, e.g. {"name": {"pattern": "^[A-Z]", "max_length": 40}, "price": {"required": true}}; reloaded on SIGHUP`)
+	fs.StringVar(&cfg.Search, "search", "bleve", "full-text search index for /items/search: bleve, elasticsearch or none")
+	fs.StringVar(&cfg.SearchIndexPath, "search-index-path", "", "directory of the bleve index, empty keeps the index in memory and fills it on startup")
+	fs.StringVar(&cfg.ElasticsearchURL, "elasticsearch-url", "http://localhost:9200", "Elasticsearch or OpenSearch endpoint used by -search elasticsearch")
+	fs.StringVar(&cfg.ElasticsearchIndex, "elasticsearch-index", "items", "index used by -search elasticsearch")
+	fs.BoolVar(&cfg.Reindex, "reindex", false, "rebuild the search index from the stored items and exit")
+	fs.StringVar(&cfg.KafkaBrokers, "kafka-brokers", "", "comma-separated Kafka brokers the item events of -storage events are published to, empty disables publishing")
+	fs.StringVar(&cfg.KafkaTopic, "kafka-topic", "item-events", "Kafka topic the item events are published to")
+	fs.StringVar(&cfg.KafkaOffsetPath, "kafka-offset-path", "kafka.offset", "file keeping the sequence number of the last event published to Kafka")
+	fs.DurationVar(&cfg.KafkaRelayInterval, "kafka-relay-interval", time.Second, "how often new item events are published to Kafka")
+	fs.StringVar(&cfg.NatsURL, "nats-url", "", "NATS server item changes are announced on, e.g. nats://localhost:4222; empty disables the announcements")
+	fs.StringVar(&cfg.NatsSubjectPrefix, "nats-subject-prefix", "items", "prefix of the NATS subjects, as in items.created and items.42.updated")
+	fs.DurationVar(&cfg.ScheduleInterval, "schedule-interval", time.Minute, "how often items whose publish_at or expires_at passed are announced, 0 disables the announcements")
+	fs.DurationVar(&cfg.ReapInterval, "reap-interval", time.Minute, "how often items whose ttl has passed are deleted, 0 keeps them")
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.ConfigFile != "" {
+		if err := applyConfigFile(fs, cfg.ConfigFile); err != nil {
+			return cfg, nil, err
+		}
+	}
+	cfg.BasePath = normalizeBasePath(cfg.BasePath)
+	if cfg.ValidationRulesPath != "" {
+		rules, err := loadValidationRules(cfg.ValidationRulesPath)
+		if err != nil {
+			return cfg, nil, err
+		}
+		cfg.ValidationRules = rules
+	}
+	return cfg, fs, cfg.validate()
+}
+
+// applyConfigFile sets the flags named in the JSON object in the file at path.
+// Numbers keep the digits they were written with, so 1000000 doesn't reach
+// an integer flag as 1e+06.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, value := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		text := fmt.Sprint(value)
+		if number, ok := value.(json.Number); ok {
+			text = number.String()
+		}
+		if err := fs.Set(name, text); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// validate catches values that parse but make no sense.
+func (cfg Config) validate() error {
+	if cfg.RouteTimeout <= 0 {
+		return errors.New("route-timeout must be positive")
+	}
+	for group, timeout := range cfg.RouteTimeouts {
+		if timeout <= 0 {
+			return fmt.Errorf("route-timeouts: the timeout of %s must be positive", group)
+		}
+	}
+	if cfg.HoneypotDenylist < 0 {
+		return errors.New("honeypot-denylist must not be negative")
+	}
+	return nil
+}
+
+// normalizeBasePath gives a prefix exactly one leading and no trailing slash,
+// and turns "/" into no prefix at all.
+func normalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
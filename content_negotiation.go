@@ -0,0 +1,107 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// producedMediaTypes are the media types responses come in.
+var producedMediaTypes = []string{"application/json", "application/problem+json"}
+
+// contentNegotiationMiddleware answers 415 to requests with a body that isn't
+// declared as JSON, or as the file an endpoint for files takes, and 406 to
+// requests whose Accept header rules out every media type the API produces.
+// A missing Accept header accepts anything. With lenient set, for legacy
+// clients, it lets everything through.
+func contentNegotiationMiddleware(lenient bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if lenient {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength != 0 && !hasBodyOf(r, bodyMediaTypes(r)) {
+				ErrorCodeResponse(w, UnsupportedMediaTypeCode)
+				return
+			}
+			if !accepts(r, responseMediaTypes(r)) {
+				ErrorCodeResponse(w, NotAcceptableCode)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fileMediaTypes are the media types of the endpoints that send or receive
+// files instead of JSON, by the extension their path ends in.
+var fileMediaTypes = map[string]string{".xlsx": xlsxMediaType, ".csv": csvMediaType, ".ndjson": ndjsonMediaType}
+
+// fileMediaType returns the media type of the file the request is for, or ""
+// when it isn't for a file.
+func fileMediaType(r *http.Request) string {
+	return fileMediaTypes[path.Ext(r.URL.Path)]
+}
+
+// bodyMediaTypes are the media types the request's body may come in: JSON,
+// a JSON Patch for PATCH, or a file for the file endpoints.
+func bodyMediaTypes(r *http.Request) []string {
+	if mediaType := fileMediaType(r); mediaType != "" {
+		return []string{mediaType}
+	}
+	if r.Method == http.MethodPatch {
+		return []string{jsonPatchMediaType}
+	}
+	return []string{"application/json"}
+}
+
+// responseMediaTypes are the media types the response may come in. The file
+// endpoints answer with a file, or JSON when they fail.
+func responseMediaTypes(r *http.Request) []string {
+	if mediaType := fileMediaType(r); mediaType != "" {
+		return append([]string{mediaType}, producedMediaTypes...)
+	}
+	return producedMediaTypes
+}
+
+func hasBodyOf(r *http.Request, mediaTypes []string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && slices.Contains(mediaTypes, mediaType)
+}
+
+func accepts(r *http.Request, mediaTypes []string) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			// explicitly refused
+			continue
+		}
+		for _, produced := range mediaTypes {
+			if mediaRangeMatches(mediaRange, produced) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mediaRangeMatches reports whether a media range from an Accept header, like
+// */* or application/*, covers mediaType.
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+}
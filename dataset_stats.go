@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxGrowthSamples bounds the item count history; at the default interval of
+// an hour it covers a week.
+const maxGrowthSamples = 168
+
+// DatasetStats describes the size and shape of the stored items, to tell when
+// a storage backend is about to be outgrown.
+type DatasetStats struct {
+	Items   int              `json:"items"`
+	Payload PayloadSizeStats `json:"payload_bytes"`
+	Indexes []IndexStats     `json:"indexes"`
+	Growth  *GrowthStats     `json:"growth,omitempty"`
+}
+
+// PayloadSizeStats summarizes the JSON size of the items.
+type PayloadSizeStats struct {
+	Average float64 `json:"average"`
+	P50     int     `json:"p50"`
+	P90     int     `json:"p90"`
+	P99     int     `json:"p99"`
+	Max     int     `json:"max"`
+}
+
+// IndexStats is the size of one index, counted in whatever it keys on.
+type IndexStats struct {
+	Name    string `json:"name"`
+	Entries uint64 `json:"entries"`
+}
+
+// indexStatsReporter is implemented by the repositories and search indexes
+// that can tell how big their indexes are.
+type indexStatsReporter interface {
+	IndexStats() []IndexStats
+}
+
+// GrowthStats is the item count over time, sampled in the background.
+type GrowthStats struct {
+	Samples     []GrowthSample `json:"samples"`
+	ItemsPerDay float64        `json:"items_per_day"`
+}
+
+type GrowthSample struct {
+	At    time.Time `json:"at"`
+	Items int       `json:"items"`
+}
+
+// growthHistory keeps the most recent item count samples.
+type growthHistory struct {
+	mu      sync.Mutex
+	samples []GrowthSample
+}
+
+var datasetGrowth = &growthHistory{}
+
+func (h *growthHistory) record(sample GrowthSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, sample)
+	if len(h.samples) > maxGrowthSamples {
+		h.samples = h.samples[len(h.samples)-maxGrowthSamples:]
+	}
+}
+
+// stats returns nil until there are two samples to compute a rate from.
+func (h *growthHistory) stats() *GrowthStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < 2 {
+		return nil
+	}
+	first, last := h.samples[0], h.samples[len(h.samples)-1]
+	days := last.At.Sub(first.At).Hours() / 24
+	growth := &GrowthStats{Samples: append([]GrowthSample(nil), h.samples...)}
+	if days > 0 {
+		growth.ItemsPerDay = float64(last.Items-first.Items) / days
+	}
+	return growth
+}
+
+// sampleDatasetGrowth records the current item count; it runs as a job.
+func sampleDatasetGrowth(ctx context.Context) error {
+	items, err := itemRepository.List(ctx, ItemFilter{})
+	if err != nil {
+		return err
+	}
+	datasetGrowth.record(GrowthSample{At: time.Now().UTC(), Items: len(items)})
+	return nil
+}
+
+func computeDatasetStats(items []Item) (DatasetStats, error) {
+	stats := DatasetStats{Items: len(items), Indexes: []IndexStats{}}
+	if reporter, ok := itemRepository.(indexStatsReporter); ok {
+		stats.Indexes = append(stats.Indexes, reporter.IndexStats()...)
+	}
+	stats.Growth = datasetGrowth.stats()
+	if len(items) == 0 {
+		return stats, nil
+	}
+
+	sizes := make([]int, len(items))
+	total := 0
+	for i, item := range items {
+		encoded, err := json.Marshal(item)
+		if err != nil {
+			return stats, err
+		}
+		sizes[i] = len(encoded)
+		total += len(encoded)
+	}
+	sort.Ints(sizes)
+	stats.Payload = PayloadSizeStats{
+		Average: float64(total) / float64(len(sizes)),
+		P50:     percentile(sizes, 50),
+		P90:     percentile(sizes, 90),
+		P99:     percentile(sizes, 99),
+		Max:     sizes[len(sizes)-1],
+	}
+	return stats, nil
+}
+
+// percentile uses the nearest-rank method on the sorted values.
+func percentile(sorted []int, p int) int {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func datasetStats(w http.ResponseWriter, r *http.Request) {
+	items, err := itemRepository.List(r.Context(), ItemFilter{})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not list items")
+		return
+	}
+
+	stats, err := computeDatasetStats(items)
+	if err != nil {
+		InternalErrorResponse(w, "could not compute the dataset statistics")
+		return
+	}
+
+	SuccessResponse(w, stats)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_growthHistoryItemsPerDay(t *testing.T) {
+	history := &growthHistory{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if history.stats() != nil {
+		t.Error("expected no growth stats without samples")
+	}
+
+	for i := 0; i < maxGrowthSamples+2; i++ {
+		history.record(GrowthSample{At: start.Add(time.Duration(i) * time.Hour), Items: 10 * i})
+	}
+
+	stats := history.stats()
+	if len(stats.Samples) != maxGrowthSamples {
+		t.Errorf("expected %d samples, got %d", maxGrowthSamples, len(stats.Samples))
+	}
+	if stats.ItemsPerDay != 240 {
+		t.Errorf("expected 240 items per day, got %v", stats.ItemsPerDay)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Deprecation announces that a route is on its way out.
+type Deprecation struct {
+	// Since is when the route was deprecated.
+	Since time.Time
+	// Sunset is when the route stops working; zero when not decided yet.
+	Sunset time.Time
+	// Successor is the path of the route replacing it, if any.
+	Successor string
+}
+
+// deprecateRoute marks a registered route as deprecated, e.g.
+//
+//	deprecateRoute(itemRoutes.HandleFunc("/old", old), Deprecation{Since: ..., Successor: "/items/new"})
+//
+// Its responses then carry a Deprecation header (RFC 9745), a Sunset header
+// (RFC 8594) and a Link to the successor, so clients learn about it before it
+// is gone.
+func deprecateRoute(route *mux.Route, d Deprecation) *mux.Route {
+	return route.Handler(deprecationMiddleware(d)(route.GetHandler()))
+}
+
+func deprecationMiddleware(d Deprecation) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+			if !d.Sunset.IsZero() {
+				w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.Successor != "" {
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Successor))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// FieldChange is a field that differs between two items. Field is the JSON
+// path, like "price" or "translations.de.name"; From or To is missing when
+// only one of the items has the field.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from,omitempty"`
+	To    any    `json:"to,omitempty"`
+}
+
+// diffItems compares two items field by field as they appear in JSON, going
+// into objects like translations and rating. The IDs are left out: they
+// differ anyway.
+func diffItems(from, to Item) ([]FieldChange, error) {
+	a, err := jsonFields(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := jsonFields(to)
+	if err != nil {
+		return nil, err
+	}
+	delete(a, "id")
+	delete(b, "id")
+	delete(a, "uuid")
+	delete(b, "uuid")
+	changes := []FieldChange{}
+	diffFields("", a, b, &changes)
+	return changes, nil
+}
+
+func jsonFields(item Item) (map[string]any, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	return fields, json.Unmarshal(data, &fields)
+}
+
+func diffFields(prefix string, a, b map[string]any, changes *[]FieldChange) {
+	keys := map[string]bool{}
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		from, to := a[key], b[key]
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		fromObject, fromOK := from.(map[string]any)
+		toObject, toOK := to.(map[string]any)
+		if fromOK && toOK {
+			diffFields(prefix+key+".", fromObject, toObject, changes)
+			continue
+		}
+		*changes = append(*changes, FieldChange{Field: prefix + key, From: from, To: to})
+	}
+}
+
+// diffTwoItems returns what differs from the item {id} to the item {other}.
+func diffTwoItems(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	other, err := strconv.Atoi(mux.Vars(r)["other"])
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+
+	from, err := itemRepository.Get(r.Context(), *id)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not diff items")
+		return
+	}
+	to, err := itemRepository.Get(r.Context(), other)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not diff items")
+		return
+	}
+	diffResponse(w, *from, *to)
+}
+
+// diffItemRevision returns what changed in the item since ?revision=, the
+// sequence number of one of its events. It needs the event log, so it only
+// exists when items are event sourced.
+func diffItemRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	revision, err := strconv.ParseInt(r.URL.Query().Get("revision"), 10, 64)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidRevisionCode)
+		return
+	}
+
+	var from *Item
+	for _, event := range itemEvents.ForItem(*id) {
+		if event.Sequence == revision {
+			from = event.Item
+		}
+	}
+	if from == nil {
+		NotFoundResponse(w, "item has no revision with this sequence number")
+		return
+	}
+	if fieldEncryption != nil {
+		decrypted, err := fieldEncryption.decryptItem(*from)
+		if err != nil {
+			InternalErrorResponse(w, "could not decrypt the revision")
+			return
+		}
+		from = &decrypted
+	}
+	to, err := itemRepository.Get(r.Context(), *id)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not diff item")
+		return
+	}
+	diffResponse(w, *from, *to)
+}
+
+func diffResponse(w http.ResponseWriter, from, to Item) {
+	changes, err := diffItems(from, to)
+	if err != nil {
+		InternalErrorResponse(w, "could not diff items")
+		return
+	}
+	SuccessResponse(w, changes)
+}
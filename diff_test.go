@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_diffItems(t *testing.T) {
+	from := Item{ID: 1, Name: "scarf", Price: "9.99", Currency: "EUR", Translations: map[string]Translation{"de": {Name: "Schal"}}}
+	to := Item{ID: 2, Name: "scarf", Quantity: 3, Currency: "EUR", Translations: map[string]Translation{"de": {Name: "Halstuch"}}}
+	changes, err := diffItems(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldChange{
+		{Field: "price", From: "9.99"},
+		{Field: "quantity", To: 3.0},
+		{Field: "translations.de.name", From: "Schal", To: "Halstuch"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %+v, want %+v", changes, want)
+	}
+	if changes, _ := diffItems(from, from); len(changes) != 0 {
+		t.Errorf("expected no changes between an item and itself, got %+v", changes)
+	}
+}
+
+func Test_diffItemRevision(t *testing.T) {
+	defer func(original ItemRepository, events *EventLog) { itemRepository, itemEvents = original, events }(itemRepository, itemEvents)
+	itemEvents, _ = OpenEventLog("")
+	itemRepository = NewEventSourcedItemRepository(itemEvents)
+	ctx := context.Background()
+	itemRepository.Create(ctx, Item{Name: "draft"})
+	itemRepository.Create(ctx, Item{Name: "other", Quantity: 1})
+	itemRepository.Update(ctx, Item{ID: 0, Name: "final"})
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+
+	diff := func(path string) (int, []FieldChange) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var changes []FieldChange
+		json.Unmarshal(w.Body.Bytes(), &changes)
+		return w.Code, changes
+	}
+
+	first := itemEvents.ForItem(0)[0].Sequence
+	if _, changes := diff("/items/0/diff?revision=" + strconv.FormatInt(first, 10)); len(changes) != 1 || changes[0].From != "draft" || changes[0].To != "final" {
+		t.Errorf("expected the rename since the first revision, got %+v", changes)
+	}
+	if _, changes := diff("/items/0/diff/1"); len(changes) != 2 {
+		t.Errorf("expected name and quantity to differ, got %+v", changes)
+	}
+	for path, code := range map[string]int{
+		"/items/0/diff?revision=x":  http.StatusBadRequest,
+		"/items/0/diff?revision=99": http.StatusNotFound,
+		"/items/0/diff/9":           http.StatusNotFound,
+	} {
+		if got, _ := diff(path); got != code {
+			t.Errorf("%s returned %d, want %d", path, got, code)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// The actions a write with ?dry_run=true reports it would take.
+const (
+	DryRunCreate = "create"
+	DryRunUpdate = "update"
+	DryRunDelete = "delete"
+)
+
+// DryRun is the answer to a write with ?dry_run=true: what it would have done
+// and the item, or items, it would have answered with. Created items carry the
+// ID they would get, unless something else is created first.
+type DryRun struct {
+	DryRun bool        `json:"dry_run"`
+	Action string      `json:"action"`
+	Result interface{} `json:"result"`
+}
+
+// errDryRun rolls back the transaction of a dry run once its writes are made.
+var errDryRun = errors.New("dry run")
+
+// isDryRun tells whether the request only asks what it would do.
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dry_run") == "true"
+}
+
+// writeTx runs fn in a transaction like itemRepository.Tx. For a dry run the
+// transaction is rolled back after fn succeeds, so the writes go through the
+// same validation and unique checks as real ones but are never stored, and no
+// audit entry, event or notification comes of them.
+func writeTx(r *http.Request, fn func(tx ItemRepository) error) error {
+	if !isDryRun(r) {
+		return itemRepository.Tx(r.Context(), fn)
+	}
+	err := itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+// DryRunResponse answers a dry run with what the write would have done.
+func DryRunResponse(w http.ResponseWriter, action string, result interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	SuccessResponse(w, DryRun{DryRun: true, Action: action, Result: result})
+}
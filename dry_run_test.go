@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_dryRun(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp", Quantity: 1}, Item{ID: 1, Name: "desk"})
+	if err := setupAudit(Config{AuditLogPath: filepath.Join(t.TempDir(), "audit.jsonl")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    any
+		headers []string
+		action  string
+		item    Item
+	}{
+		{"create", "POST", "/items/?dry_run=true", Item{Name: "chair"}, nil, DryRunCreate, Item{ID: 2, Name: "chair", Slug: "chair"}},
+		{"update", "PUT", "/items/0?dry_run=true", Item{Name: "lamp", Quantity: 4}, nil, DryRunUpdate, Item{ID: 0, Name: "lamp", Quantity: 4, Slug: "lamp"}},
+		{"upsert", "PUT", "/items/9?upsert=true&dry_run=true", Item{Name: "stool"}, nil, DryRunCreate, Item{ID: 9, Name: "stool", Slug: "stool"}},
+		{"patch", "PATCH", "/items/0?dry_run=true", `[{"op": "replace", "path": "/quantity", "value": 7}]`, []string{"Content-Type", jsonPatchMediaType}, DryRunUpdate, Item{ID: 0, Name: "lamp", Quantity: 7}},
+		{"delete", "DELETE", "/items/1?dry_run=true", nil, nil, DryRunDelete, Item{ID: 1, Name: "desk"}},
+		{"duplicate", "POST", "/items/1/duplicate?dry_run=true", nil, nil, DryRunCreate, Item{ID: 2, Name: "desk", Slug: "desk"}},
+	}
+	for _, tt := range tests {
+		w := api.Request(tt.method, tt.path, tt.body, tt.headers...)
+		result := decodeResponse[struct {
+			DryRun bool   `json:"dry_run"`
+			Action string `json:"action"`
+			Result Item   `json:"result"`
+		}](t, w, http.StatusOK)
+		result.Result.CreatedAt = nil
+		if !result.DryRun || result.Action != tt.action || !reflect.DeepEqual(result.Result, tt.item) {
+			t.Errorf("%s: expected to %s %+v, got %s", tt.name, tt.action, tt.item, w.Body)
+		}
+	}
+
+	assertJSON(t, api.Request("GET", "/items/", nil), http.StatusOK,
+		`[{"id":0,"name":"lamp","description":"","quantity":1},{"id":1,"name":"desk","description":""}]`)
+	if entries := auditLog.Query(AuditFilter{}); len(entries) != 0 {
+		t.Errorf("expected dry runs to leave no audit entries, got %+v", entries)
+	}
+
+	// a dry run fails like the write would
+	if w := api.Request("POST", "/items/?dry_run=true", Item{}); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected an invalid item to fail validation, got %d %s", w.Code, w.Body)
+	}
+	if w := api.Request("DELETE", "/items/5?dry_run=true", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected deleting a missing item to fail, got %d %s", w.Code, w.Body)
+	}
+	created := decodeResponse[Item](t, api.Request("POST", "/items/", Item{Name: "chair"}), http.StatusCreated)
+	if created.ID != 2 {
+		t.Errorf("expected the item to get the ID the dry run showed, got %d", created.ID)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const elasticsearchBulkSize = 1000
+
+// ElasticsearchSearchIndex mirrors the items into an Elasticsearch (or
+// OpenSearch, which speaks the same REST API for everything used here) index.
+// It talks plain HTTP, so it needs no client library.
+type ElasticsearchSearchIndex struct {
+	client  *http.Client
+	baseURL string
+	index   string
+}
+
+func NewElasticsearchSearchIndex(baseURL, index string) *ElasticsearchSearchIndex {
+	return &ElasticsearchSearchIndex{
+		client:  &http.Client{},
+		baseURL: strings.TrimRight(baseURL, "/"),
+		index:   index,
+	}
+}
+
+var elasticsearchMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"name":        map[string]string{"type": "text"},
+			"description": map[string]string{"type": "text"},
+		},
+	},
+}
+
+// EnsureIndex creates the index with its mapping unless it already exists.
+func (es *ElasticsearchSearchIndex) EnsureIndex(ctx context.Context) error {
+	status, _, err := es.do(ctx, http.MethodHead, "/"+es.index, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	return es.createIndex(ctx)
+}
+
+func (es *ElasticsearchSearchIndex) Index(ctx context.Context, item Item) error {
+	return es.expect(ctx, http.MethodPut, es.documentPath(item.ID), searchDocument{Name: item.Name, Description: item.Description}, http.StatusOK, http.StatusCreated)
+}
+
+func (es *ElasticsearchSearchIndex) Delete(ctx context.Context, id int) error {
+	return es.expect(ctx, http.MethodDelete, es.documentPath(id), nil, http.StatusOK, http.StatusNotFound)
+}
+
+func (es *ElasticsearchSearchIndex) Search(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	multiMatch := map[string]interface{}{
+		"query":  q.Text,
+		"fields": []string{"name", "description"},
+	}
+	switch q.Mode {
+	case SearchModePrefix:
+		multiMatch["type"] = "phrase_prefix"
+	case SearchModeFuzzy:
+		multiMatch["fuzziness"] = "AUTO"
+	}
+	request := map[string]interface{}{
+		"size":    q.Limit,
+		"_source": false,
+		"query":   map[string]interface{}{"multi_match": multiMatch},
+	}
+
+	status, body, err := es.do(ctx, http.MethodPost, "/"+es.index+"/_search", request)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("elasticsearch search returned %d: %s", status, body)
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				ID    string  `json:"_id"`
+				Score float64 `json:"_score"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	hits := make([]SearchHit, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		id, err := strconv.Atoi(hit.ID)
+		if err != nil {
+			continue
+		}
+		hits = append(hits, SearchHit{ID: id, Score: hit.Score})
+	}
+	return hits, nil
+}
+
+// Rebuild recreates the index and fills it through the bulk API. Searches
+// return partial results while it runs.
+func (es *ElasticsearchSearchIndex) Rebuild(ctx context.Context, items []Item, progress func(indexed int)) error {
+	if err := es.expect(ctx, http.MethodDelete, "/"+es.index, nil, http.StatusOK, http.StatusNotFound); err != nil {
+		return err
+	}
+	if err := es.createIndex(ctx); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(items); start += elasticsearchBulkSize {
+		end := start + elasticsearchBulkSize
+		if end > len(items) {
+			end = len(items)
+		}
+		if err := es.bulkIndex(ctx, items[start:end]); err != nil {
+			return err
+		}
+		progress(end)
+	}
+	return es.expect(ctx, http.MethodPost, "/"+es.index+"/_refresh", nil, http.StatusOK)
+}
+
+func (es *ElasticsearchSearchIndex) bulkIndex(ctx context.Context, items []Item) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, item := range items {
+		action := map[string]interface{}{"index": map[string]string{"_id": strconv.Itoa(item.ID)}}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(searchDocument{Name: item.Name, Description: item.Description}); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, es.baseURL+"/"+es.index+"/_bulk", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("elasticsearch bulk request returned %d: %s", resp.StatusCode, responseBody)
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(responseBody, &result); err != nil {
+		return err
+	}
+	if result.Errors {
+		return fmt.Errorf("elasticsearch bulk request had failures: %s", responseBody)
+	}
+	return nil
+}
+
+func (es *ElasticsearchSearchIndex) createIndex(ctx context.Context) error {
+	return es.expect(ctx, http.MethodPut, "/"+es.index, elasticsearchMapping, http.StatusOK)
+}
+
+func (es *ElasticsearchSearchIndex) documentPath(id int) string {
+	return "/" + es.index + "/_doc/" + url.PathEscape(strconv.Itoa(id))
+}
+
+// expect performs the request and fails unless it answers with one of the
+// accepted status codes.
+func (es *ElasticsearchSearchIndex) expect(ctx context.Context, method, path string, payload interface{}, accepted ...int) error {
+	status, body, err := es.do(ctx, method, path, payload)
+	if err != nil {
+		return err
+	}
+	for _, code := range accepted {
+		if status == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("elasticsearch %s %s returned %d: %s", method, path, status, body)
+}
+
+func (es *ElasticsearchSearchIndex) do(ctx context.Context, method, path string, payload interface{}) (int, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, es.baseURL+path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, responseBody, err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_elasticsearchSearchIndex_Search(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/items/_search" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"hits":{"hits":[{"_id":"1","_score":2.5},{"_id":"0","_score":1}]}}`))
+	}))
+	defer server.Close()
+
+	index := NewElasticsearchSearchIndex(server.URL+"/", "items")
+	hits, err := index.Search(context.Background(), SearchQuery{Text: "sec", Mode: SearchModePrefix, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hits) != 2 || hits[0] != (SearchHit{ID: 1, Score: 2.5}) || hits[1] != (SearchHit{ID: 0, Score: 1}) {
+		t.Errorf("unexpected hits %v", hits)
+	}
+	multiMatch := request["query"].(map[string]interface{})["multi_match"].(map[string]interface{})
+	if multiMatch["type"] != "phrase_prefix" || multiMatch["query"] != "sec" || request["size"] != float64(5) {
+		t.Errorf("unexpected search request %v", request)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks an encrypted field value: the prefix, the ID of the
+// key and the base64 of nonce and ciphertext, separated by colons. Values
+// without it are read as they are, so switching encryption on needs no
+// migration; they are encrypted on their next update.
+const encryptedPrefix = "enc:v1:"
+
+// encryptableFields are the item fields -encrypted-fields can name.
+var encryptableFields = []string{"name", "description"}
+
+// fieldCipher encrypts item fields with AES-256-GCM. The first key encrypts;
+// all of them decrypt, so a new key can be rolled out before the old one is
+// dropped. The field name is authenticated along with the value, so an
+// encrypted name can't be passed off as a description.
+type fieldCipher struct {
+	fields map[string]bool
+	keyID  string
+	keys   map[string]cipher.AEAD
+}
+
+// fieldEncryption encrypts the fields named by -encrypted-fields; nil when
+// there are none.
+var fieldEncryption *fieldCipher
+
+func newFieldCipher(keys []string, fields []string) (*fieldCipher, error) {
+	c := &fieldCipher{fields: map[string]bool{}, keys: map[string]cipher.AEAD{}}
+	for _, field := range fields {
+		known := false
+		for _, f := range encryptableFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("cannot encrypt the field %q, only %s", field, strings.Join(encryptableFields, " and "))
+		}
+		c.fields[field] = true
+	}
+	for i, encoded := range keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("encryption keys must be 32 bytes, base64 encoded")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		id := hex.EncodeToString(sum[:4])
+		c.keys[id] = aead
+		if i == 0 {
+			c.keyID = id
+		}
+	}
+	if c.keyID == "" {
+		return nil, errors.New("encrypting fields needs -encryption-key")
+	}
+	return c, nil
+}
+
+// encryptItem encrypts the fields, and their translations along with them.
+func (c *fieldCipher) encryptItem(item Item) (Item, error) {
+	return c.mapTexts(item, func(field, value string) (string, error) {
+		if !c.fields[field] {
+			return value, nil
+		}
+		return c.seal(field, value)
+	})
+}
+
+// decryptItem decrypts every encrypted field, whether or not it is still
+// named by -encrypted-fields.
+func (c *fieldCipher) decryptItem(item Item) (Item, error) {
+	return c.mapTexts(item, c.open)
+}
+
+// mapTexts returns item with fn applied to its name and description and to
+// those of its translations. The translations are copied, so item keeps its
+// own.
+func (c *fieldCipher) mapTexts(item Item, fn func(field, value string) (string, error)) (Item, error) {
+	var err error
+	if item.Name, err = fn("name", item.Name); err != nil {
+		return item, err
+	}
+	if item.Description, err = fn("description", item.Description); err != nil {
+		return item, err
+	}
+	if item.Translations == nil {
+		return item, nil
+	}
+	translations := make(map[string]Translation, len(item.Translations))
+	for lang, t := range item.Translations {
+		if t.Name, err = fn("name", t.Name); err != nil {
+			return item, err
+		}
+		if t.Description, err = fn("description", t.Description); err != nil {
+			return item, err
+		}
+		translations[lang] = t
+	}
+	item.Translations = translations
+	return item, nil
+}
+
+// seal encrypts value; empty values stay empty.
+func (c *fieldCipher) seal(field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	aead := c.keys[c.keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return encryptedPrefix + c.keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *fieldCipher) open(field, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	keyID, encoded, _ := strings.Cut(rest, ":")
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("the %s is encrypted with the unknown key %s", field, keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("the encrypted %s is malformed", field)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("decrypting the %s: %w", field, err)
+	}
+	return string(plain), nil
+}
+
+func (c *fieldCipher) decryptItems(items []Item) error {
+	for i := range items {
+		decrypted, err := c.decryptItem(items[i])
+		if err != nil {
+			return err
+		}
+		items[i] = decrypted
+	}
+	return nil
+}
+
+// encryptingRepository encrypts the fields on their way into the storage
+// backend it wraps and decrypts them on their way out, so neither the
+// handlers nor the backends know about it.
+type encryptingRepository struct {
+	ItemRepository
+	cipher *fieldCipher
+}
+
+// List filters encrypted names itself, as the backend only sees ciphertext.
+func (repo *encryptingRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	stored := filter
+	byName := repo.cipher.fields["name"] && filter.NameContains != ""
+	if byName {
+		stored.NameContains = ""
+	}
+	items, err := repo.ItemRepository.List(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.cipher.decryptItems(items); err != nil {
+		return nil, err
+	}
+	if !byName {
+		return items, nil
+	}
+	matching := items[:0]
+	for _, item := range items {
+		if filter.Matches(item) {
+			matching = append(matching, item)
+		}
+	}
+	return matching, nil
+}
+
+func (repo *encryptingRepository) Get(ctx context.Context, id int) (*Item, error) {
+	item, err := repo.ItemRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := repo.cipher.decryptItem(*item)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+func (repo *encryptingRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	encrypted, err := repo.cipher.encryptItem(item)
+	if err != nil {
+		return nil, err
+	}
+	created, err := repo.ItemRepository.Create(ctx, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := repo.cipher.decryptItem(*created)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+func (repo *encryptingRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	encrypted, err := repo.cipher.encryptItem(item)
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := repo.ItemRepository.Insert(ctx, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := repo.cipher.decryptItem(*inserted)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+func (repo *encryptingRepository) Update(ctx context.Context, item Item) error {
+	encrypted, err := repo.cipher.encryptItem(item)
+	if err != nil {
+		return err
+	}
+	return repo.ItemRepository.Update(ctx, encrypted)
+}
+
+func (repo *encryptingRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		return fn(&encryptingRepository{ItemRepository: tx, cipher: repo.cipher})
+	})
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *encryptingRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+// setupEncryption wraps the storage backend in encryption when
+// -encrypted-fields names any fields.
+func setupEncryption(cfg Config) error {
+	if cfg.EncryptedFields == "" {
+		return nil
+	}
+	c, err := newFieldCipher(strings.Split(cfg.EncryptionKey, ","), strings.Split(cfg.EncryptedFields, ","))
+	if err != nil {
+		return err
+	}
+	fieldEncryption = c
+	itemRepository = &encryptingRepository{ItemRepository: itemRepository, cipher: c}
+	return nil
+}
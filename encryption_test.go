@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func Test_encryptingRepository(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	stored := NewInMemoryItemRepository(Item{ID: 0, Name: "legacy", Description: "stored before encryption"})
+	old, err := newFieldCipher([]string{oldKey}, []string{"description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&encryptingRepository{ItemRepository: stored, cipher: old}).Create(ctx, Item{Name: "rotated", Description: "under the old key"})
+
+	c, err := newFieldCipher([]string{newKey, oldKey}, []string{"name", "description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &encryptingRepository{ItemRepository: stored, cipher: c}
+	created, err := repo.Create(ctx, Item{Name: "secret name", Description: "secret description"})
+	if err != nil || created.Name != "secret name" || created.Description != "secret description" {
+		t.Fatalf("expected the created item in plain text, got %+v, %v", created, err)
+	}
+
+	raw, _ := stored.Get(ctx, created.ID)
+	if !strings.HasPrefix(raw.Name, encryptedPrefix) || !strings.HasPrefix(raw.Description, encryptedPrefix) || strings.Contains(raw.Description, "secret") {
+		t.Errorf("expected the backend to hold ciphertext, got %+v", raw)
+	}
+
+	items, err := repo.List(ctx, ItemFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"legacy": "stored before encryption", "rotated": "under the old key", "secret name": "secret description"}
+	for _, item := range items {
+		if want[item.Name] != item.Description {
+			t.Errorf("unexpected item %+v", item)
+		}
+	}
+	if len(items) != len(want) {
+		t.Errorf("expected %d items, got %+v", len(want), items)
+	}
+
+	filtered, err := repo.List(ctx, ItemFilter{NameContains: "secret"})
+	if err != nil || len(filtered) != 1 || filtered[0].ID != created.ID {
+		t.Errorf("expected the filter to match the decrypted name, got %+v, %v", filtered, err)
+	}
+
+	// an encrypted name doesn't pass as a description
+	raw.Description = raw.Name
+	stored.Update(ctx, *raw)
+	if _, err := repo.Get(ctx, created.ID); err == nil {
+		t.Error("expected a swapped field to fail to decrypt")
+	}
+
+	unknown, _ := newFieldCipher([]string{newTestKey(t)}, []string{"name"})
+	if _, err := (&encryptingRepository{ItemRepository: stored, cipher: unknown}).List(ctx, ItemFilter{}); err == nil {
+		t.Error("expected fields encrypted with an unknown key to fail")
+	}
+}
+
+func Test_newFieldCipher(t *testing.T) {
+	if _, err := newFieldCipher([]string{newTestKey(t)}, []string{"id"}); err == nil {
+		t.Error("expected an error for a field that can't be encrypted")
+	}
+	if _, err := newFieldCipher([]string{"c2hvcnQ="}, []string{"name"}); err == nil {
+		t.Error("expected an error for a short key")
+	}
+	if _, err := newFieldCipher([]string{""}, []string{"name"}); err == nil {
+		t.Error("expected an error without a key")
+	}
+}
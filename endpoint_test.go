@@ -2,11 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_getItemHandler(t *testing.T) {
@@ -59,6 +66,8 @@ func Test_listItemsHandler(t *testing.T) {
 }
 
 func Test_createItemHandler(t *testing.T) {
+	defer func(clock func() time.Time) { itemClock = clock }(itemClock)
+	itemClock = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
 	newItem := []byte(`{"name":"new_name","description":"new_description"}`)
 
 	req, err := http.NewRequest("POST", "/items/", bytes.NewBuffer(newItem))
@@ -76,9 +85,550 @@ func Test_createItemHandler(t *testing.T) {
 			status, http.StatusCreated)
 	}
 
-	expected := `{"id":2,"name":"new_name","description":"new_description"}`
+	expected := `{"id":2,"name":"new_name","description":"new_description","slug":"new-name","created_at":"2024-05-01T12:00:00Z"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
 }
+
+func Test_updateItemConditionalWrites(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "first", Slug: "first"})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}", updateItem).Methods(http.MethodPut)
+	router.HandleFunc("/items/", createItem).Methods(http.MethodPost)
+	put := func(path, ifNoneMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		path, ifNoneMatch string
+		status            int
+	}{
+		{"/items/7", "", http.StatusNotFound},
+		{"/items/7", "*", http.StatusCreated},
+		{"/items/7", "*", http.StatusPreconditionFailed},
+		{"/items/0", "*", http.StatusPreconditionFailed},
+		{"/items/8?upsert=true", "", http.StatusCreated},
+		{"/items/8?upsert=true", "", http.StatusOK},
+		{"/items/-1?upsert=true", "", http.StatusBadRequest},
+		{"/items/9223372036854775807?upsert=true", "", http.StatusBadRequest},
+		{"/items/9007199254740992?upsert=true", "", http.StatusBadRequest},
+		{"/items/0", `"v1"`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rr := put(tt.path, tt.ifNoneMatch, `{"name":"synced"}`); rr.Code != tt.status {
+			t.Errorf("PUT %s with If-None-Match %s: expected %d, got %d %s", tt.path, tt.ifNoneMatch, tt.status, rr.Code, rr.Body)
+		}
+	}
+
+	var synced Item
+	json.Unmarshal(put("/items/9?upsert=true", "", `{"name":"synced"}`).Body.Bytes(), &synced)
+	if synced.ID != 9 || synced.Slug == "" || synced.CreatedAt == nil {
+		t.Errorf("expected a created item with its managed fields, got %+v", synced)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/", strings.NewReader(`{"name":"next"}`)))
+	var next Item
+	json.Unmarshal(rr.Body.Bytes(), &next)
+	if next.ID != 10 {
+		t.Errorf("expected items created later to get IDs above the upserted ones, got %d", next.ID)
+	}
+}
+
+func Test_createItemWithClientID(t *testing.T) {
+	defer func(repo ItemRepository, enabled bool) { itemRepository, clientIDs = repo, enabled }(itemRepository, clientIDs)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "first"})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/", createItem)
+	post := func(body string) (*httptest.ResponseRecorder, Item) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/", strings.NewReader(body)))
+		var created Item
+		json.Unmarshal(rr.Body.Bytes(), &created)
+		return rr, created
+	}
+
+	if _, created := post(`{"id":40,"name":"legacy"}`); created.ID != 1 {
+		t.Errorf("expected the id to be ignored without -client-ids, got %d", created.ID)
+	}
+	clientIDs = true
+	if rr, created := post(`{"id":40,"name":"legacy"}`); rr.Code != http.StatusCreated || created.ID != 40 {
+		t.Errorf("expected the item under the legacy ID, got %d %s", rr.Code, rr.Body)
+	}
+	if rr, _ := post(`{"id":40,"name":"again"}`); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "ITEM_ID_TAKEN") {
+		t.Errorf("expected a collision to be refused, got %d %s", rr.Code, rr.Body)
+	}
+	if rr, _ := post(`{"id":-3,"name":"negative"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a negative id to be refused, got %d", rr.Code)
+	}
+	if _, created := post(`{"name":"numbered"}`); created.ID != 41 {
+		t.Errorf("expected items without an id to be numbered after the legacy ones, got %d", created.ID)
+	}
+}
+
+func Test_honeypotDenylistsIP(t *testing.T) {
+	router := mux.NewRouter()
+	denylist := NewIPDenylist()
+	registerHoneypots(router, denylist, time.Hour)
+	router.HandleFunc("/ping", ping)
+	router.Use(denylistMiddleware(denylist))
+
+	req := httptest.NewRequest("GET", "/.env", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("honeypot returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+
+	req = httptest.NewRequest("GET", "/ping", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("denylisted IP got wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+}
+
+func Test_denylistPrune(t *testing.T) {
+	denylist := NewIPDenylist()
+	denylist.Block("192.0.2.1", -time.Minute)
+	denylist.Block("192.0.2.2", time.Hour)
+
+	denylist.prune(context.Background())
+	if _, ok := denylist.blocked["192.0.2.1"]; ok {
+		t.Error("expected the expired block to be pruned")
+	}
+	if !denylist.IsBlocked("192.0.2.2") {
+		t.Error("expected the current block to stay")
+	}
+}
+
+func Test_timeoutMiddlewareRespondsServiceUnavailable(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		SuccessResponse(w, "too late")
+	})
+
+	req := httptest.NewRequest("GET", "/slow", nil)
+	rr := httptest.NewRecorder()
+	timeoutMiddleware(10*time.Millisecond)(slow).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+
+	expected := `{"error":"request timed out"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func Test_createItemHandlerRejectsInvalidItem(t *testing.T) {
+	newItem := []byte(`{"name":"","description":"no name"}`)
+
+	req, err := http.NewRequest("POST", "/items/", bytes.NewBuffer(newItem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/items/", createItem)
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"type":"/errors#VALIDATION_FAILED","title":"one or more fields are invalid, see errors","status":422,"code":"VALIDATION_FAILED","errors":[{"field":"name","code":"ITEM_NAME_REQUIRED","message":"name must not be empty"}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func Test_validatePrice(t *testing.T) {
+	cases := []struct {
+		price, currency, code string
+	}{
+		{"9.99", "EUR", ""},
+		{"0", "USD", ""},
+		{"1500", "JPY", ""},
+		{"1.250", "KWD", ""},
+		{"", "", ""},
+		{"9.99", "", "PRICE_WITHOUT_CURRENCY"},
+		{"", "EUR", "PRICE_WITHOUT_CURRENCY"},
+		{"9.99", "EURO", "INVALID_CURRENCY"},
+		{"9.99", "eur", "INVALID_CURRENCY"},
+		{"9.999", "EUR", "INVALID_PRICE"},
+		{"1.5", "JPY", "INVALID_PRICE"},
+		{"-1.00", "EUR", "INVALID_PRICE"},
+		{"09.99", "EUR", "INVALID_PRICE"},
+		{"1e3", "EUR", "INVALID_PRICE"},
+		{"1000000000", "EUR", "INVALID_PRICE"},
+	}
+	for _, c := range cases {
+		errs := validatePrice(c.price, c.currency)
+		code := ""
+		if len(errs) > 0 {
+			code = errs[0].Code
+		}
+		if code != c.code {
+			t.Errorf("validatePrice(%q, %q) = %q, want %q", c.price, c.currency, code, c.code)
+		}
+	}
+}
+
+func Test_listItemsPriceFilter(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(
+		Item{ID: 0, Name: "cheap", Quantity: 5, Price: "9.99", Currency: "EUR"},
+		Item{ID: 1, Name: "pricey", Quantity: 1, Price: "10.00", Currency: "EUR"},
+		Item{ID: 2, Name: "dollars", Quantity: 0, Price: "2.50", Currency: "USD"},
+		Item{ID: 3, Name: "unpriced", Quantity: 7},
+	)
+	router := mux.NewRouter()
+	router.HandleFunc("/items/", listItems)
+
+	cases := map[string]string{
+		"/items/?price[lt]=10.00":                      "cheap,dollars",
+		"/items/?price[gte]=10&currency=EUR":           "pricey",
+		"/items/?price[gt]=2.5&price[lte]=9.99":        "cheap",
+		"/items/?quantity[gte]=1":                      "cheap,pricey,unpriced",
+		"/items/?quantity[eq]=0":                       "dollars",
+		"/items/?currency=USD":                         "dollars",
+		"/items/?quantity[lt]=6&filter=ri&price[gt]=0": "pricey",
+	}
+	for path, want := range cases {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var items []Item
+		json.Unmarshal(rr.Body.Bytes(), &items)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		if got := strings.Join(names, ","); rr.Code != http.StatusOK || got != want {
+			t.Errorf("%s returned %d %q, want %q", path, rr.Code, got, want)
+		}
+	}
+
+	for _, path := range []string{"/items/?price[below]=1", "/items/?price[lt]=ten", "/items/?quantity[lt]=1.5", "/items/?currency=XYZ", "/items/?price[lt=1"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "INVALID_FILTER") {
+			t.Errorf("%s returned %d %s, want INVALID_FILTER", path, rr.Code, rr.Body)
+		}
+	}
+}
+
+func Test_inMemoryTxRollsBackOnError(t *testing.T) {
+	repo := NewInMemoryItemRepository(seedItems...)
+	ctx := context.Background()
+
+	failure := errors.New("abort")
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		if _, err := tx.Create(ctx, Item{Name: "doomed"}); err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, 0); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Tx returned wrong error: got %v want %v", err, failure)
+	}
+
+	items, err := repo.List(ctx, ItemFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(seedItems) {
+		t.Errorf("rolled back transaction changed the items: %+v", items)
+	}
+}
+
+func Test_searchItemsHandler(t *testing.T) {
+	defer func(repo ItemRepository, index SearchIndex) {
+		itemRepository, searchIndex = repo, index
+	}(itemRepository, searchIndex)
+	itemRepository = NewInMemoryItemRepository(seedItems...)
+	if err := setupSearch(Config{Search: "bleve"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := itemRepository.Create(context.Background(), Item{Name: "third", Description: "another thing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/items/search", searchItems)
+	for query, expected := range map[string]string{
+		"/items/search?q=second":               `[{"item":{"id":1,"name":"second","description":"second item"}`,
+		"/items/search?q=thi&mode=prefix":      `[{"item":{"id":2,"name":"third","description":"another thing"}`,
+		"/items/search?q=anotter&mode=fuzzy":   `[{"item":{"id":2,"name":"third","description":"another thing"}`,
+		"/items/search?q=nothing-like-it-here": `[]`,
+	} {
+		req := httptest.NewRequest("GET", query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s returned wrong status code: got %v want %v", query, status, http.StatusOK)
+		}
+		if !strings.HasPrefix(rr.Body.String(), expected) {
+			t.Errorf("%s returned unexpected body: got %v want it to start with %v", query, rr.Body.String(), expected)
+		}
+	}
+}
+
+func Test_envelopeOnRequest(t *testing.T) {
+	defer func(original ItemRepository) { itemRepository = original }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(seedItems...)
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+
+	req := httptest.NewRequest("GET", "/items/?limit=1", nil)
+	req.Header.Set("Accept", `application/json; profile="envelope"`)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expected := `{"data":[{"id":0,"name":"first","description":"first item"}],"meta":{"total":2,"limit":1,"offset":0}}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	req = httptest.NewRequest("GET", "/items/42", nil)
+	req.Header.Set("Accept", `application/json; profile="envelope"`)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expected = `{"data":null,"errors":[{"error":"item with ID does not exist"}]}`
+	if rr.Code != http.StatusNotFound || rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected response: got %v %v want %v %v",
+			rr.Code, rr.Body.String(), http.StatusNotFound, expected)
+	}
+}
+
+func Test_prettyResponse(t *testing.T) {
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+
+	req := httptest.NewRequest("GET", "/ping?pretty=true", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expected := "{\n  \"Ping\": \"Pong\"\n}\n"
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func Test_contentNegotiation(t *testing.T) {
+	defer func(original ItemRepository) { itemRepository = original }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(seedItems...)
+
+	tests := []struct {
+		name        string
+		lenient     bool
+		contentType string
+		accept      string
+		status      int
+	}{
+		{"json", false, "application/json; charset=utf-8", "application/json", http.StatusCreated},
+		{"any accepted", false, "application/json", "text/html, */*;q=0.8", http.StatusCreated},
+		{"form body", false, "application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+		{"missing content type", false, "", "", http.StatusUnsupportedMediaType},
+		{"xml only", false, "application/json", "application/xml", http.StatusNotAcceptable},
+		{"json refused", false, "application/json", "application/json;q=0", http.StatusNotAcceptable},
+		{"lenient", true, "text/plain", "application/xml", http.StatusCreated},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, LenientMediaTypes: tc.lenient})
+			req := httptest.NewRequest("POST", "/items/", strings.NewReader(`{"name":"third","description":"third item"}`))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					rr.Code, tc.status)
+			}
+		})
+	}
+}
+
+func Test_deprecatedRouteHeaders(t *testing.T) {
+	router := mux.NewRouter()
+	deprecateRoute(router.HandleFunc("/old-ping", ping), Deprecation{
+		Since:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:    time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		Successor: "/ping",
+	})
+
+	req := httptest.NewRequest("GET", "/old-ping", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expected := map[string]string{
+		"Deprecation": "@1704067200",
+		"Sunset":      "Mon, 01 Jul 2024 00:00:00 GMT",
+		"Link":        `</ping>; rel="successor-version"`,
+	}
+	for header, value := range expected {
+		if actual := rr.Header().Get(header); actual != value {
+			t.Errorf("unexpected %s header: got %v want %v", header, actual, value)
+		}
+	}
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func Test_basePath(t *testing.T) {
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, BasePath: normalizeBasePath("api/items-service/")})
+
+	for path, status := range map[string]int{
+		"/api/items-service/ping": http.StatusOK,
+		"/ping":                   http.StatusNotFound,
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != status {
+			t.Errorf("GET %s returned wrong status code: got %v want %v", path, rr.Code, status)
+		}
+	}
+}
+
+func Test_adminRoutesOnlyOnAdminListener(t *testing.T) {
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, AdminAddr: "127.0.0.1:8001"}
+	public, admin := newRouter(cfg), newAdminRouter(cfg)
+
+	for _, tc := range []struct {
+		router http.Handler
+		path   string
+		status int
+	}{
+		{public, "/ready", http.StatusNotFound},
+		{public, "/admin/dataset-stats", http.StatusNotFound},
+		{admin, "/ready", http.StatusOK},
+		{admin, "/admin/dataset-stats", http.StatusOK},
+		{admin, "/debug/pprof/", http.StatusOK},
+		{admin, "/items/", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rr := httptest.NewRecorder()
+		tc.router.ServeHTTP(rr, req)
+
+		if rr.Code != tc.status {
+			t.Errorf("GET %s returned wrong status code: got %v want %v", tc.path, rr.Code, tc.status)
+		}
+	}
+}
+
+func Test_adminRoutesOnPublicListenerTakeAdminScope(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"})
+	itemEvents, _ = OpenEventLog("")
+	store, err := openTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isolate(t, &apiTokens, store)
+	writer, _ := store.Create("writer", []string{ScopeItemsRead, ScopeItemsWrite}, nil)
+	admin, _ := store.Create("admin", []string{ScopeAdmin}, nil)
+	api.Reroute()
+
+	for _, route := range []struct{ method, path string }{
+		{"POST", "/admin/reset"},
+		{"POST", "/admin/restore"},
+		{"PUT", "/admin/maintenance"},
+		{"POST", "/admin/config/reload"},
+		{"GET", "/admin/backup"},
+		{"GET", "/replication/changes"},
+	} {
+		if w := api.Request(route.method, route.path, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for %s %s without a token, got %d", route.method, route.path, w.Code)
+		}
+		if w := api.Request(route.method, route.path, nil, "Authorization", "Bearer "+writer.Secret); w.Code != http.StatusForbidden {
+			t.Errorf("expected 403 for %s %s without the admin scope, got %d", route.method, route.path, w.Code)
+		}
+	}
+	if w := api.Request("GET", "/admin/backup", nil, "Authorization", "Bearer "+admin.Secret); w.Code != http.StatusOK {
+		t.Errorf("expected an admin to get the backup, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.Request("GET", "/ready", nil); w.Code != http.StatusOK {
+		t.Errorf("expected the health check to stay open, got %d", w.Code)
+	}
+	if items := decodeResponse[[]Item](t, api.Request("GET", "/items/", nil), http.StatusOK); len(items) != 1 {
+		t.Errorf("expected the item routes to be left alone, got %+v", items)
+	}
+}
+
+func Test_pageLinks(t *testing.T) {
+	var items []Item
+	for i := range 7 {
+		items = append(items, Item{ID: i, Name: "item"})
+	}
+	api := newTestAPI(t, items...)
+
+	tests := []struct {
+		query string
+		links []string
+	}{
+		{"?limit=3&offset=3&filter=item", []string{
+			`</items/?filter=item&limit=3&offset=0>; rel="first"`,
+			`</items/?filter=item&limit=3&offset=0>; rel="prev"`,
+			`</items/?filter=item&limit=3&offset=6>; rel="next"`,
+			`</items/?filter=item&limit=3&offset=6>; rel="last"`,
+		}},
+		{"?limit=3", []string{
+			`</items/?limit=3&offset=0>; rel="first"`,
+			`</items/?limit=3&offset=3>; rel="next"`,
+			`</items/?limit=3&offset=6>; rel="last"`,
+		}},
+		// pages step from the offset asked for
+		{"?limit=5&offset=1", []string{
+			`</items/?limit=5&offset=0>; rel="first"`,
+			`</items/?limit=5&offset=0>; rel="prev"`,
+			`</items/?limit=5&offset=6>; rel="next"`,
+			`</items/?limit=5&offset=6>; rel="last"`,
+		}},
+		// an offset past the end counts from the end
+		{"?limit=3&offset=9", []string{
+			`</items/?limit=3&offset=0>; rel="first"`,
+			`</items/?limit=3&offset=4>; rel="prev"`,
+			`</items/?limit=3&offset=6>; rel="last"`,
+		}},
+		{"?limit=10&filter=none", []string{
+			`</items/?filter=none&limit=10&offset=0>; rel="first"`,
+			`</items/?filter=none&limit=10&offset=0>; rel="last"`,
+		}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		w := api.Request("GET", "/items/"+tt.query, nil)
+		if links := w.Header().Values("Link"); !slices.Equal(links, tt.links) {
+			t.Errorf("%s: expected the links %q, got %q", tt.query, tt.links, links)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ItemCreated = "ItemCreated"
+	ItemUpdated = "ItemUpdated"
+	ItemDeleted = "ItemDeleted"
+	// ItemPublished and ItemExpired are only announced, see publishScheduler;
+	// the item itself doesn't change.
+	ItemPublished = "ItemPublished"
+	ItemExpired   = "ItemExpired"
+)
+
+// ItemEvent records one change to an item. Item holds the item as it was
+// after the change; it is empty for a delete.
+type ItemEvent struct {
+	Sequence int64     `json:"sequence"`
+	Type     string    `json:"type"`
+	ItemID   int       `json:"item_id"`
+	At       time.Time `json:"at"`
+	Item     *Item     `json:"item,omitempty"`
+}
+
+// EventLog is an append-only list of item events. With a file it is durable:
+// every event is a line of JSON, synced to disk before Append returns.
+type EventLog struct {
+	mu     sync.RWMutex
+	file   *os.File
+	events []ItemEvent
+	byItem map[int][]int
+	// appended is closed and replaced whenever events are appended.
+	appended chan struct{}
+}
+
+// itemEvents is the event log when items are event sourced, nil otherwise.
+var itemEvents *EventLog
+
+// maxEventLineBytes is the longest line OpenEventLog reads.
+const maxEventLineBytes = 1 << 20
+
+// OpenEventLog reads the events already in the file at path and appends new
+// ones to it. An empty path keeps the events in memory only.
+// A last line without its newline is what a crash during Append leaves
+// behind; its events were never acknowledged, so it is cut off. A line that
+// doesn't parse anywhere else means the file is damaged, and opening fails.
+func OpenEventLog(path string) (*EventLog, error) {
+	log := &EventLog{byItem: map[int][]int{}, appended: make(chan struct{})}
+	if path == "" {
+		return log, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := log.read(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.file = file
+	return log, nil
+}
+
+func (l *EventLog) read(file *os.File) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				return file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(data) > maxEventLineBytes {
+			return fmt.Errorf("event log line %d is longer than %d bytes", line, maxEventLineBytes)
+		}
+		var event ItemEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("event log line %d: %w", line, err)
+		}
+		l.add(event)
+		offset += int64(len(data))
+	}
+}
+
+// Append numbers the events and stores them. They are written in a single
+// call, so a crash leaves at most the last line incomplete.
+func (l *EventLog) Append(events ...ItemEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lines []byte
+	now := time.Now().UTC()
+	for i := range events {
+		events[i].Sequence = int64(len(l.events) + i + 1)
+		events[i].At = now
+		line, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if l.file != nil {
+		if _, err := l.file.Write(lines); err != nil {
+			return err
+		}
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, event := range events {
+		l.add(event)
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+func (l *EventLog) add(event ItemEvent) {
+	l.byItem[event.ItemID] = append(l.byItem[event.ItemID], len(l.events))
+	l.events = append(l.events, event)
+}
+
+// All returns every event in order.
+func (l *EventLog) All() []ItemEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]ItemEvent(nil), l.events...)
+}
+
+// After returns the events with a sequence number above sequence, in order.
+func (l *EventLog) After(sequence int64) []ItemEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if sequence >= int64(len(l.events)) {
+		return nil
+	}
+	return append([]ItemEvent(nil), l.events[max(sequence, 0):]...)
+}
+
+// Last returns the sequence number of the latest event, 0 while there is
+// none.
+func (l *EventLog) Last() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return int64(len(l.events))
+}
+
+// Next returns up to limit of the events after sequence, in order.
+func (l *EventLog) Next(sequence int64, limit int) []ItemEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	start := min(max(sequence, 0), int64(len(l.events)))
+	end := min(start+int64(limit), int64(len(l.events)))
+	return append([]ItemEvent{}, l.events[start:end]...)
+}
+
+// Appended returns a channel that is closed once there are events after
+// sequence, at once if there already are.
+func (l *EventLog) Appended(sequence int64) <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if sequence < int64(len(l.events)) {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return l.appended
+}
+
+// ForItem returns the events of one item in order.
+func (l *EventLog) ForItem(id int) []ItemEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var result []ItemEvent
+	for _, i := range l.byItem[id] {
+		result = append(result, l.events[i])
+	}
+	return result
+}
+
+// EventSourcedItemRepository stores every change as an event in the log. The
+// current items are a projection of those events, kept in an in-memory
+// repository and rebuilt from the log on startup. Writes are serialized, so
+// the log holds them in the order they were applied.
+type EventSourcedItemRepository struct {
+	mu     sync.Mutex
+	log    *EventLog
+	state  *InMemoryItemRepository
+	nextID int
+}
+
+// NewEventSourcedItemRepository projects the events in log.
+func NewEventSourcedItemRepository(log *EventLog) *EventSourcedItemRepository {
+	repo := &EventSourcedItemRepository{log: log, state: NewInMemoryItemRepository()}
+	for _, event := range log.All() {
+		repo.apply(event)
+	}
+	return repo
+}
+
+func (repo *EventSourcedItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	return repo.state.List(ctx, filter)
+}
+
+func (repo *EventSourcedItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	return repo.state.Get(ctx, id)
+}
+
+func (repo *EventSourcedItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.nextID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	item.ID = repo.nextID
+	if err := repo.commit(ItemEvent{Type: ItemCreated, ItemID: item.ID, Item: &item}); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (repo *EventSourcedItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	if _, err := repo.state.Get(ctx, item.ID); err == nil {
+		return nil, IDTakenError
+	}
+	if err := repo.commit(ItemEvent{Type: ItemCreated, ItemID: item.ID, Item: &item}); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (repo *EventSourcedItemRepository) Update(ctx context.Context, item Item) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, err := repo.state.Get(ctx, item.ID); err != nil {
+		return err
+	}
+	return repo.commit(ItemEvent{Type: ItemUpdated, ItemID: item.ID, Item: &item})
+}
+
+func (repo *EventSourcedItemRepository) Delete(ctx context.Context, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, err := repo.state.Get(ctx, id); err != nil {
+		return err
+	}
+	return repo.commit(ItemEvent{Type: ItemDeleted, ItemID: id})
+}
+
+// Tx runs fn against a transaction of the projection and records what it
+// writes. The events are appended before the transaction commits, so when
+// appending fails, the projection stays as it was.
+func (repo *EventSourcedItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var recorder *eventRecorder
+	err := repo.state.Tx(ctx, func(tx ItemRepository) error {
+		recorder = &eventRecorder{ItemRepository: tx}
+		if err := fn(recorder); err != nil {
+			return err
+		}
+		if len(recorder.events) == 0 {
+			return nil
+		}
+		return repo.log.Append(recorder.events...)
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range recorder.events {
+		if event.Type == ItemCreated && event.ItemID >= repo.nextID {
+			repo.nextID = idAfter(event.ItemID)
+		}
+	}
+	return nil
+}
+
+// commit appends the event and applies it to the projection.
+func (repo *EventSourcedItemRepository) commit(event ItemEvent) error {
+	if err := repo.log.Append(event); err != nil {
+		return err
+	}
+	repo.apply(event)
+	return nil
+}
+
+func (repo *EventSourcedItemRepository) apply(event ItemEvent) {
+	switch event.Type {
+	case ItemCreated, ItemUpdated:
+		repo.state.put(*event.Item)
+		if event.ItemID >= repo.nextID {
+			repo.nextID = idAfter(event.ItemID)
+		}
+	case ItemDeleted:
+		repo.state.Delete(context.Background(), event.ItemID)
+	}
+}
+
+// eventRecorder passes the writes of a transaction on and turns each into an
+// event.
+type eventRecorder struct {
+	ItemRepository
+	events []ItemEvent
+}
+
+func (r *eventRecorder) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := r.ItemRepository.Create(ctx, item)
+	if err == nil {
+		copied := *created
+		r.events = append(r.events, ItemEvent{Type: ItemCreated, ItemID: created.ID, Item: &copied})
+	}
+	return created, err
+}
+
+func (r *eventRecorder) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := r.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		copied := *inserted
+		r.events = append(r.events, ItemEvent{Type: ItemCreated, ItemID: inserted.ID, Item: &copied})
+	}
+	return inserted, err
+}
+
+func (r *eventRecorder) Update(ctx context.Context, item Item) error {
+	err := r.ItemRepository.Update(ctx, item)
+	if err == nil {
+		r.events = append(r.events, ItemEvent{Type: ItemUpdated, ItemID: item.ID, Item: &item})
+	}
+	return err
+}
+
+func (r *eventRecorder) Delete(ctx context.Context, id int) error {
+	err := r.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		r.events = append(r.events, ItemEvent{Type: ItemDeleted, ItemID: id})
+	}
+	return err
+}
+
+// Tx within a transaction simply joins it.
+func (r *eventRecorder) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return fn(r)
+}
+
+func listItemEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+
+	events := itemEvents.ForItem(*id)
+	if len(events) == 0 {
+		NotFoundResponse(w, "item with ID does not exist")
+		return
+	}
+	if err := decryptEvents(events); err != nil {
+		InternalErrorResponse(w, "could not decrypt the events")
+		return
+	}
+
+	StreamJSONResponse(w, r, events)
+}
+
+// decryptEvents decrypts the items of the events in place when fields are
+// encrypted, since the log keeps the ciphertext.
+func decryptEvents(events []ItemEvent) error {
+	if fieldEncryption == nil {
+		return nil
+	}
+	for i, event := range events {
+		if event.Item == nil {
+			continue
+		}
+		decrypted, err := fieldEncryption.decryptItem(*event.Item)
+		if err != nil {
+			return err
+		}
+		events[i].Item = &decrypted
+	}
+	return nil
+}
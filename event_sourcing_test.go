@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_eventSourcedRepositoryReplaysLog(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewEventSourcedItemRepository(events)
+
+	first, _ := repo.Create(ctx, Item{Name: "first"})
+	second, _ := repo.Create(ctx, Item{Name: "second"})
+	if err := repo.Update(ctx, Item{ID: first.ID, Name: "first", Description: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Tx(ctx, func(tx ItemRepository) error {
+		_, err := tx.Create(ctx, Item{Name: "third"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := NewEventSourcedItemRepository(reopened)
+	items, _ := replayed.List(ctx, ItemFilter{})
+	if len(items) != 2 || items[0].Description != "updated" || items[1].Name != "third" {
+		t.Errorf("unexpected items after replay: %+v", items)
+	}
+	if created, _ := replayed.Create(ctx, Item{Name: "fourth"}); created.ID != 3 {
+		t.Errorf("replayed repository reuses IDs: got %d", created.ID)
+	}
+	if history := reopened.ForItem(second.ID); len(history) != 2 || history[1].Type != ItemDeleted {
+		t.Errorf("unexpected history of deleted item: %+v", history)
+	}
+}
+
+func Test_openEventLogWithTornLastLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewEventSourcedItemRepository(events)
+	if _, err := repo.Create(ctx, Item{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	events.file.Close()
+
+	// a crash in the middle of writing the next event
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"sequence":2,"type":"ItemCreated","item_id":1,"item":{"na`)
+	file.Close()
+
+	reopened, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("expected the torn last line to be cut off, got %v", err)
+	}
+	replayed := NewEventSourcedItemRepository(reopened)
+	if _, err := replayed.Create(ctx, Item{Name: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	reopened.file.Close()
+	if again, err := OpenEventLog(path); err != nil || len(again.All()) != 2 {
+		t.Fatalf("expected the events before and after the torn line, got %v", err)
+	}
+
+	// damage anywhere but in the last line is not a crash to recover from
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append([]byte("{not json\n"), data...), 0600)
+	if _, err := OpenEventLog(path); err == nil {
+		t.Error("expected a damaged line before the last to fail opening the log")
+	}
+}
+
+func Test_listItemEvents(t *testing.T) {
+	defer func(original ItemRepository, events *EventLog) { itemRepository, itemEvents = original, events }(itemRepository, itemEvents)
+	itemEvents, _ = OpenEventLog("")
+	itemRepository = NewEventSourcedItemRepository(itemEvents)
+	created, _ := itemRepository.Create(context.Background(), Item{Name: "evented"})
+	itemRepository.Delete(context.Background(), created.ID)
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/0/events", nil))
+	var history []ItemEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(history) != 2 || history[0].Type != ItemCreated || history[0].Item.Name != "evented" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/7/events", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an item without events, got %d", w.Code)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// exportFiles keeps the generated exports and backups for -export-ttl, so an
+// interrupted download can be resumed with a Range request instead of
+// starting over. It is nil unless -export-dir is set.
+var exportFiles *exportStore
+
+// ExportsFullError is returned when -export-max-files exports are kept
+// already.
+var ExportsFullError = errors.New("too many exports are kept")
+
+// exportKind is what an export holds, which decides the extension of its file
+// and how it is downloaded.
+type exportKind struct {
+	ext       string
+	mediaType string
+	filename  string
+}
+
+var (
+	xlsxExport   = exportKind{ext: ".xlsx", mediaType: xlsxMediaType, filename: "items.xlsx"}
+	backupExport = exportKind{ext: ".json", mediaType: "application/json", filename: "items-backup.json"}
+	exportKinds  = []exportKind{xlsxExport, backupExport}
+)
+
+// exportStore keeps each export as a file named after its export ID. At most
+// maxFiles are kept at a time, counting those still being written.
+type exportStore struct {
+	dir      string
+	ttl      time.Duration
+	maxFiles int
+
+	mu      sync.Mutex
+	writing int
+}
+
+// Save stores an export and returns the ID to download it by.
+func (s *exportStore) Save(kind exportKind, content []byte) (string, error) {
+	return s.Create(kind, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// Create stores the export write writes, without holding it in memory, and
+// returns the ID to download it by. It fails with ExportsFullError when the
+// store is full even after removing the expired exports.
+func (s *exportStore) Create(kind exportKind, write func(w io.Writer) error) (string, error) {
+	if err := s.reserve(); err != nil {
+		return "", err
+	}
+	defer func() {
+		s.mu.Lock()
+		s.writing--
+		s.mu.Unlock()
+	}()
+
+	var random [16]byte
+	rand.Read(random[:])
+	id := hex.EncodeToString(random[:])
+	path := s.path(id, kind)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	buffered := bufio.NewWriter(tmp)
+	if err := write(buffered); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return id, os.Rename(tmp.Name(), path)
+}
+
+// reserve makes room for one more export, or fails with ExportsFullError.
+func (s *exportStore) reserve() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept, err := s.count()
+	if err != nil {
+		return err
+	}
+	if kept+s.writing >= s.maxFiles {
+		if err := s.Clean(context.Background()); err != nil {
+			return err
+		}
+		if kept, err = s.count(); err != nil {
+			return err
+		}
+		if kept+s.writing >= s.maxFiles {
+			return ExportsFullError
+		}
+	}
+	s.writing++
+	return nil
+}
+
+// count counts the exports kept, expired ones included.
+func (s *exportStore) count() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	kept := 0
+	for _, entry := range entries {
+		if isExportFile(entry.Name()) {
+			kept++
+		}
+	}
+	return kept, nil
+}
+
+func isExportFile(name string) bool {
+	for _, kind := range exportKinds {
+		if strings.HasSuffix(name, kind.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns the export with the ID, or os.ErrNotExist once it expired.
+func (s *exportStore) Open(id string, kind exportKind) (*os.File, time.Time, error) {
+	file, err := os.Open(s.path(id, kind))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := file.Stat()
+	if err == nil && time.Since(info.ModTime()) > s.ttl {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		file.Close()
+		return nil, time.Time{}, err
+	}
+	return file, info.ModTime(), nil
+}
+
+func (s *exportStore) path(id string, kind exportKind) string {
+	return filepath.Join(s.dir, id+kind.ext)
+}
+
+// Clean removes the exports older than the TTL.
+func (s *exportStore) Clean(ctx context.Context) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !isExportFile(entry.Name()) || time.Since(info.ModTime()) <= s.ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serveExport sends the export with the ID, honoring Range and If-Range. The
+// export ID doubles as the ETag, as an export never changes.
+func serveExport(w http.ResponseWriter, r *http.Request, id string, kind exportKind) {
+	file, modified, err := exportFiles.Open(id, kind)
+	if errors.Is(err, os.ErrNotExist) {
+		ErrorCodeResponse(w, ExportNotFoundCode)
+		return
+	}
+	if err != nil {
+		InternalErrorResponse(w, "could not read the export")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("ETag", `"`+id+`"`)
+	w.Header().Set("Content-Type", kind.mediaType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+kind.filename+`"`)
+	w.Header().Set("X-Export-Id", id)
+	http.ServeContent(w, r, "", modified, file)
+}
+
+// getExport downloads an export made earlier, whole or in parts.
+func getExport(w http.ResponseWriter, r *http.Request) {
+	serveExport(w, r, mux.Vars(r)["export"], xlsxExport)
+}
+
+// getBackup downloads a backup made earlier, whole or in parts.
+func getBackup(w http.ResponseWriter, r *http.Request) {
+	serveExport(w, r, mux.Vars(r)["export"], backupExport)
+}
+
+// ExportErrorResponse answers an export that could not be stored.
+func ExportErrorResponse(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, ExportsFullError) {
+		ErrorCodeResponse(w, ExportsFullCode)
+		return
+	}
+	InternalErrorResponse(w, message)
+}
+
+// setupExports keeps exports in -export-dir, removing them after -export-ttl.
+func setupExports(cfg Config) error {
+	if cfg.ExportDir == "" {
+		return nil
+	}
+	if cfg.ExportTTL <= 0 {
+		return fmt.Errorf("-export-ttl must be positive, got %v", cfg.ExportTTL)
+	}
+	if cfg.ExportMaxFiles < 1 {
+		return fmt.Errorf("-export-max-files must be at least 1, got %d", cfg.ExportMaxFiles)
+	}
+	if err := os.MkdirAll(cfg.ExportDir, 0700); err != nil {
+		return err
+	}
+	exportFiles = &exportStore{dir: cfg.ExportDir, ttl: cfg.ExportTTL, maxFiles: cfg.ExportMaxFiles}
+	jobs.Add(Job{Name: "export-cleanup", Every: max(cfg.ExportTTL/4, time.Minute), Run: exportFiles.Clean})
+	return nil
+}
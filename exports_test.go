@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_resumableExports(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"}, Item{ID: 1, Name: "desk"})
+	isolate(t, &jobs, newScheduler())
+	if err := setupExports(Config{ExportDir: t.TempDir(), ExportTTL: time.Hour, ExportMaxFiles: 10}); err != nil {
+		t.Fatal(err)
+	}
+	api.Reroute()
+
+	w := api.Request("GET", "/items/export.xlsx", nil)
+	id := w.Header().Get("X-Export-Id")
+	if w.Code != http.StatusOK || id == "" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected a resumable export, got %d %v", w.Code, w.Header())
+	}
+	whole := w.Body.String()
+
+	w = api.Request("GET", "/items/exports/"+id+".xlsx", nil, "Range", "bytes=10-", "If-Range", `"`+id+`"`)
+	if w.Code != http.StatusPartialContent || w.Body.String() != whole[10:] {
+		t.Errorf("expected the rest of the export from byte 10, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	w = api.Request("GET", "/items/exports/"+id+".xlsx", nil, "Range", "bytes=10-", "If-Range", `"other"`)
+	if w.Code != http.StatusOK || w.Body.String() != whole {
+		t.Errorf("expected the whole export for a range of another one, got %d", w.Code)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(exportFiles.path(id, xlsxExport), old, old)
+	if w := api.Request("GET", "/items/exports/"+id+".xlsx", nil); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "EXPORT_NOT_FOUND") {
+		t.Errorf("expected an expired export to be gone, got %d %s", w.Code, w.Body)
+	}
+	if err := exportFiles.Clean(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(exportFiles.path(id, xlsxExport)); !os.IsNotExist(err) {
+		t.Errorf("expected the expired export to be removed, got %v", err)
+	}
+}
+
+func Test_resumableBackups(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"}, Item{ID: 1, Name: "desk"})
+	isolate(t, &jobs, newScheduler())
+	if err := setupExports(Config{ExportDir: t.TempDir(), ExportTTL: time.Hour, ExportMaxFiles: 2}); err != nil {
+		t.Fatal(err)
+	}
+	api.Reroute()
+
+	w := api.AdminRequest("GET", "/admin/backup", nil)
+	id := w.Header().Get("X-Export-Id")
+	if w.Code != http.StatusOK || id == "" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected a resumable backup, got %d %v", w.Code, w.Header())
+	}
+	backup := decodeResponse[[]Item](t, w, http.StatusOK)
+	if len(backup) != 2 || backup[1].Name != "desk" {
+		t.Errorf("expected every item in the backup, got %+v", backup)
+	}
+	whole := w.Body.String()
+	w = api.AdminRequest("GET", "/admin/backups/"+id+".json", nil, "Range", "bytes=5-", "If-Range", `"`+id+`"`)
+	if w.Code != http.StatusPartialContent || w.Body.String() != whole[5:] {
+		t.Errorf("expected the rest of the backup from byte 5, got %d %s", w.Code, w.Body)
+	}
+	if w := api.AdminRequest("GET", "/admin/backups/"+id+".json", nil); w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected the backup as JSON, got %v", w.Header())
+	}
+
+	// the directory keeps two files at most, until the older ones expire
+	api.Request("GET", "/items/export.xlsx", nil)
+	if w := api.Request("GET", "/items/export.xlsx", nil); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "EXPORTS_FULL") {
+		t.Errorf("expected a full export directory to refuse, got %d %s", w.Code, w.Body)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(exportFiles.path(id, backupExport), old, old)
+	if w := api.Request("GET", "/items/export.xlsx", nil); w.Code != http.StatusOK {
+		t.Errorf("expected an expired export to make room, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ExternalIDTakenError is returned when another item has the external ID
+// already.
+type ExternalIDTakenError struct {
+	ID int
+}
+
+func (e *ExternalIDTakenError) Error() string {
+	return fmt.Sprintf("item %d has this external ID already", e.ID)
+}
+
+// externalIDsRepository refuses to store an item under an external ID another
+// item has. Like uniqueNamesRepository it checks inside a transaction of the
+// wrapped repository, looking the external ID up through the backend's index
+// on it; the unique index in MongoDB backs the check up there. Writes of items
+// without an external ID pass straight through.
+type externalIDsRepository struct {
+	ItemRepository
+}
+
+func (repo *externalIDsRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	if item.ExternalID == "" {
+		return repo.ItemRepository.Create(ctx, item)
+	}
+	var created *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		var err error
+		created, err = tx.Create(ctx, item)
+		return err
+	})
+	return created, err
+}
+
+func (repo *externalIDsRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	if item.ExternalID == "" {
+		return repo.ItemRepository.Insert(ctx, item)
+	}
+	var inserted *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		var err error
+		inserted, err = tx.Insert(ctx, item)
+		return err
+	})
+	return inserted, err
+}
+
+func (repo *externalIDsRepository) Update(ctx context.Context, item Item) error {
+	if item.ExternalID == "" {
+		return repo.ItemRepository.Update(ctx, item)
+	}
+	return repo.Tx(ctx, func(tx ItemRepository) error {
+		return tx.Update(ctx, item)
+	})
+}
+
+func (repo *externalIDsRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		return fn(&externalIDsTx{ItemRepository: tx})
+	})
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *externalIDsRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+// externalIDsTx checks the external IDs against the items as the transaction
+// sees them, its own writes included.
+type externalIDsTx struct {
+	ItemRepository
+}
+
+func (tx *externalIDsTx) Create(ctx context.Context, item Item) (*Item, error) {
+	if err := tx.checkExternalID(ctx, item.ExternalID, nil); err != nil {
+		return nil, err
+	}
+	return tx.ItemRepository.Create(ctx, item)
+}
+
+func (tx *externalIDsTx) Insert(ctx context.Context, item Item) (*Item, error) {
+	if err := tx.checkExternalID(ctx, item.ExternalID, nil); err != nil {
+		return nil, err
+	}
+	return tx.ItemRepository.Insert(ctx, item)
+}
+
+func (tx *externalIDsTx) Update(ctx context.Context, item Item) error {
+	if err := tx.checkExternalID(ctx, item.ExternalID, &item.ID); err != nil {
+		return err
+	}
+	return tx.ItemRepository.Update(ctx, item)
+}
+
+// checkExternalID fails when an item other than the one with ID self has the
+// external ID.
+func (tx *externalIDsTx) checkExternalID(ctx context.Context, externalID string, self *int) error {
+	if externalID == "" {
+		return nil
+	}
+	items, err := tx.ItemRepository.List(ctx, ItemFilter{ExternalID: externalID})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if self == nil || item.ID != *self {
+			return &ExternalIDTakenError{ID: item.ID}
+		}
+	}
+	return nil
+}
+
+// getItemByExternalID returns the item with the external ID in the path, for
+// clients that know items by the key of another system.
+func getItemByExternalID(w http.ResponseWriter, r *http.Request) {
+	items, err := itemRepository.List(r.Context(), ItemFilter{ExternalID: mux.Vars(r)["key"]})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not get item")
+		return
+	}
+	if len(items) == 0 {
+		NotFoundResponse(w, "item with external ID does not exist")
+		return
+	}
+
+	localizeItem(w, r, &items[0])
+	SuccessResponse(w, withStar(r, &items[0]))
+}
+
+// setupExternalIDs keeps the external IDs of the items unique.
+func setupExternalIDs() {
+	itemRepository = &externalIDsRepository{ItemRepository: itemRepository}
+}
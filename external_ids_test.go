@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_externalIDsRepository(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "items.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	boltRepo, err := NewBoltItemRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, backend := range map[string]ItemRepository{"memory": NewInMemoryItemRepository(), "bolt": boltRepo} {
+		t.Run(name, func(t *testing.T) {
+			repo := &externalIDsRepository{ItemRepository: backend}
+			ctx := context.Background()
+			first, err := repo.Create(ctx, Item{Name: "first", ExternalID: "erp-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, _ := repo.Create(ctx, Item{Name: "second"})
+
+			var taken *ExternalIDTakenError
+			if _, err := repo.Create(ctx, Item{Name: "copy", ExternalID: "erp-1"}); !errors.As(err, &taken) || taken.ID != first.ID {
+				t.Errorf("expected the external ID to be taken by item %d, got %v", first.ID, err)
+			}
+			second.ExternalID = "erp-1"
+			if err := repo.Update(ctx, *second); !errors.As(err, &taken) {
+				t.Errorf("expected updating another item to the external ID to fail, got %v", err)
+			}
+			err = repo.Tx(ctx, func(tx ItemRepository) error {
+				if _, err := tx.Create(ctx, Item{Name: "a", ExternalID: "erp-2"}); err != nil {
+					return err
+				}
+				_, err := tx.Create(ctx, Item{Name: "b", ExternalID: "erp-2"})
+				return err
+			})
+			if !errors.As(err, &taken) {
+				t.Errorf("expected a transaction to see its own external IDs, got %v", err)
+			}
+
+			first.ExternalID = "erp-3"
+			if err := repo.Update(ctx, *first); err != nil {
+				t.Fatal(err)
+			}
+			if items, _ := repo.List(ctx, ItemFilter{ExternalID: "erp-1"}); len(items) != 0 {
+				t.Errorf("expected the old external ID to be free, got %+v", items)
+			}
+			repo.Delete(ctx, first.ID)
+			if items, _ := repo.List(ctx, ItemFilter{ExternalID: "erp-3"}); len(items) != 0 {
+				t.Errorf("expected the deleted item's external ID to be free, got %+v", items)
+			}
+		})
+	}
+}
+
+func Test_getItemByExternalID(t *testing.T) {
+	api := newTestAPI(t)
+	isolate(t, &itemRepository, ItemRepository(&externalIDsRepository{ItemRepository: api.Items}))
+
+	api.Request("POST", "/items/", `{"name":"lamp","external_id":"sku-42"}`)
+	if item := decodeResponse[Item](t, api.Request("GET", "/items/by-external-id/sku-42", nil), http.StatusOK); item.Name != "lamp" {
+		t.Errorf("expected the item by its external ID, got %+v", item)
+	}
+	if w := api.Request("GET", "/items/by-external-id/sku-43", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown external ID, got %d", w.Code)
+	}
+	if w := api.Request("POST", "/items/", `{"name":"lamp","external_id":"sku-42"}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"conflicting_id":0`) {
+		t.Errorf("expected a conflict naming the item, got %d %s", w.Code, w.Body)
+	}
+	if w := api.Request("POST", "/items/", `{"name":"lamp","external_id":"a/b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected an external ID with a slash to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The values of -fixtures-mode.
+const (
+	FixturesRecord = "record"
+	FixturesReplay = "replay"
+)
+
+// maxFixtureBodyBytes caps the request and response bodies of fixtures, so
+// recording and replaying never hold more than that in memory. It lets
+// spreadsheet imports through.
+const maxFixtureBodyBytes = maxSpreadsheetBytes
+
+// Fixture is a request and the response the API gave it, as saved by
+// -fixtures-mode record.
+type Fixture struct {
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     string          `json:"body,omitempty"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureResponse is the response of a Fixture. Body is kept as text, so
+// the files can be edited by hand.
+type FixtureResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// fixtureFile names the file of the request's fixture. Requests with the same
+// method, path, query parameters and body share it, whatever the order of
+// their query parameters; the readable part only helps finding it.
+func fixtureFile(dir string, r *http.Request, body []byte) string {
+	url := r.URL.Path
+	if query := r.URL.Query().Encode(); query != "" {
+		url += "?" + query
+	}
+	hash := sha256.Sum256([]byte(r.Method + "\n" + url + "\n" + string(body)))
+	readable := strings.Map(func(c rune) rune {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' {
+			return c
+		}
+		return '_'
+	}, strings.Trim(r.URL.Path, "/"))
+	if len(readable) > 60 {
+		readable = readable[:60]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s-%s.json", r.Method, readable, hex.EncodeToString(hash[:8])))
+}
+
+// fixtureRecordingMiddleware saves every request with its response as a
+// fixture in dir, replacing the one an identical request left before. The
+// files hold whatever was sent, tokens included, so record with test
+// accounts. Responses longer than maxFixtureBodyBytes are served, but not
+// saved.
+func fixtureRecordingMiddleware(dir string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := readFixtureBody(w, r)
+			if !ok {
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			rw := &recordingWriter{ResponseWriter: w, buffer: limitedBuffer{limit: maxFixtureBodyBytes}}
+			next.ServeHTTP(rw, r)
+			if rw.buffer.truncated {
+				log.Printf("not saving the fixture of %s %s, its response is larger than %d bytes", r.Method, r.URL, maxFixtureBodyBytes)
+				return
+			}
+
+			header := w.Header().Clone()
+			header.Del("Date")
+			header.Del("Content-Length")
+			fixture := Fixture{
+				Method:   r.Method,
+				URL:      r.URL.RequestURI(),
+				Body:     string(body),
+				Response: FixtureResponse{Status: cmp.Or(rw.status, http.StatusOK), Header: header, Body: rw.buffer.String()},
+			}
+			content, err := json.MarshalIndent(fixture, "", "  ")
+			if err == nil {
+				err = writeFileAtomically(fixtureFile(dir, r, body), append(content, '\n'))
+			}
+			if err != nil {
+				log.Printf("saving the fixture of %s %s failed: %v", r.Method, r.URL, err)
+			}
+		})
+	}
+}
+
+// fixtureReplayMiddleware answers every request with the response recorded
+// for it, without the request ever reaching the handlers and the store, so
+// the same request always gets the same answer. Requests nothing was recorded
+// for get a 404 with FIXTURE_NOT_FOUND.
+func fixtureReplayMiddleware(dir string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := readFixtureBody(w, r)
+			if !ok {
+				return
+			}
+			content, err := os.ReadFile(fixtureFile(dir, r, body))
+			if errors.Is(err, os.ErrNotExist) {
+				ErrorCodeResponse(w, FixtureNotFoundCode)
+				return
+			}
+			var fixture Fixture
+			if err == nil {
+				err = json.Unmarshal(content, &fixture)
+			}
+			if err != nil {
+				InternalErrorResponse(w, "could not read the fixture")
+				return
+			}
+			for name, values := range fixture.Response.Header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(fixture.Response.Status)
+			io.WriteString(w, fixture.Response.Body)
+		})
+	}
+}
+
+// readFixtureBody reads the request body of at most maxFixtureBodyBytes, or
+// answers the request itself and returns false.
+func readFixtureBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFixtureBodyBytes))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		ErrorCodeResponse(w, FixtureBodyTooLargeCode)
+		return nil, false
+	case err != nil:
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return nil, false
+	}
+	return body, true
+}
+
+// fixturesMiddleware returns the middleware of -fixtures-mode, or nil when
+// fixtures are off.
+func fixturesMiddleware(cfg Config) func(http.Handler) http.Handler {
+	switch cfg.FixturesMode {
+	case FixturesRecord:
+		return fixtureRecordingMiddleware(cfg.FixturesDir)
+	case FixturesReplay:
+		return fixtureReplayMiddleware(cfg.FixturesDir)
+	}
+	return nil
+}
+
+// setupFixtures checks -fixtures-mode and -fixtures-dir, creating the
+// directory to record into.
+func setupFixtures(cfg Config) error {
+	switch cfg.FixturesMode {
+	case "":
+		return nil
+	case FixturesRecord, FixturesReplay:
+	default:
+		return fmt.Errorf("unknown fixtures mode %q", cfg.FixturesMode)
+	}
+	if cfg.FixturesDir == "" {
+		return errors.New("-fixtures-mode needs -fixtures-dir")
+	}
+	if cfg.FixturesMode == FixturesRecord {
+		return os.MkdirAll(cfg.FixturesDir, 0755)
+	}
+	return nil
+}
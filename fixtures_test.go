@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_fixtures(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	recorder := fixtureRecordingMiddleware(dir)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Total-Count", "7")
+		CreatedResponse(w, map[string]any{"call": calls, "path": r.URL.Path})
+	}))
+	replayer := fixtureReplayMiddleware(dir)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected %s to be replayed without reaching the handlers", r.URL)
+	}))
+	send := func(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	send(recorder, "GET", "/items/?limit=2&offset=4", "")
+	send(recorder, "POST", "/items/", `{"name":"a"}`)
+	send(recorder, "POST", "/items/", `{"name":"b"}`)
+	send(recorder, "POST", "/items/", `{"name":"a"}`)
+
+	w := send(replayer, "GET", "/items/?offset=4&limit=2", "")
+	if w.Code != http.StatusCreated || w.Header().Get("X-Total-Count") != "7" || !strings.Contains(w.Body.String(), `"call":1`) {
+		t.Errorf("expected the recorded response whatever the order of the query, got %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	for i := 0; i < 2; i++ {
+		for body, call := range map[string]int{`{"name":"a"}`: 4, `{"name":"b"}`: 3} {
+			if w := send(replayer, "POST", "/items/", body); !strings.Contains(w.Body.String(), fmt.Sprintf(`"call":%d`, call)) {
+				t.Errorf("%s: expected the latest recording, call %d, got %s", body, call, w.Body)
+			}
+		}
+	}
+	if w := send(replayer, "GET", "/items/1", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "FIXTURE_NOT_FOUND") {
+		t.Errorf("expected 404 for a request without a fixture, got %d %s", w.Code, w.Body)
+	}
+
+	if err := setupFixtures(Config{FixturesMode: FixturesReplay}); err == nil {
+		t.Error("expected replaying without a directory to be refused")
+	}
+	if err := setupFixtures(Config{FixturesMode: "mock", FixturesDir: dir}); err == nil {
+		t.Error("expected an unknown mode to be refused")
+	}
+}
+
+func Test_fixturesCapBodies(t *testing.T) {
+	dir := t.TempDir()
+	large := strings.Repeat("x", maxFixtureBodyBytes+1)
+	recorder := fixtureRecordingMiddleware(dir)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, large)
+	}))
+	replayer := fixtureReplayMiddleware(dir)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for name, handler := range map[string]http.Handler{"recording": recorder, "replaying": replayer} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/items/", strings.NewReader(large)))
+		if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "FIXTURE_BODY_TOO_LARGE") {
+			t.Errorf("%s: expected 413 for a body over the cap, got %d", name, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	recorder.ServeHTTP(w, httptest.NewRequest("GET", "/items/", nil))
+	if w.Body.Len() != len(large) {
+		t.Errorf("expected the large response to be served whole, got %d bytes", w.Body.Len())
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no fixture for a response over the cap, got %v", files)
+	}
+}
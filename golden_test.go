@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden with the current responses")
+
+// goldenResponse is what gets recorded per request: any change to the status,
+// the headers or the body shows up as a diff on the golden file.
+type goldenResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+var goldenCases = []struct {
+	name   string
+	method string
+	path   string
+	body   string
+}{
+	{"ping", "GET", "/ping", ""},
+	{"errors", "GET", "/errors", ""},
+	{"list_items", "GET", "/items/", ""},
+	{"list_items_filtered", "GET", "/items/?filter=sec", ""},
+	{"list_items_page", "GET", "/items/?limit=1&offset=1", ""},
+	{"list_items_snapshot_not_found", "GET", "/items/?snapshot=expired&limit=1", ""},
+	{"list_items_invalid_offset", "GET", "/items/?offset=-1", ""},
+	{"get_item", "GET", "/items/1", ""},
+	{"get_item_not_found", "GET", "/items/42", ""},
+	{"get_item_invalid_id", "GET", "/items/abc", ""},
+	{"create_item", "POST", "/items/", `{"name":"third","description":"third item"}`},
+	{"create_item_malformed", "POST", "/items/", `{"name":`},
+	{"create_item_invalid", "POST", "/items/", `{"name":"","description":"nameless"}`},
+	{"validate_item", "POST", "/items/validate", `{"name":"third","price":"1"}`},
+	{"update_item", "PUT", "/items/0", `{"name":"updated","description":"updated item"}`},
+	{"update_item_not_found", "PUT", "/items/42", `{"name":"updated","description":"updated item"}`},
+	{"duplicate_item", "POST", "/items/1/duplicate", ""},
+	{"duplicate_item_count", "POST", "/items/1/duplicate?count=2", ""},
+	{"duplicate_item_invalid_count", "POST", "/items/1/duplicate?count=0", ""},
+	{"delete_item", "DELETE", "/items/1", ""},
+	{"delete_item_not_found", "DELETE", "/items/42", ""},
+	{"get_item_by_slug_not_found", "GET", "/items/by-slug/nothing", ""},
+	{"get_item_by_external_id_not_found", "GET", "/items/by-external-id/nothing", ""},
+	{"random_item", "GET", "/items/random?filter=sec", ""},
+	{"item_stats", "GET", "/items/stats", ""},
+	{"export_items_invalid_filter", "GET", "/items/export.xlsx?state=gone", ""},
+	{"import_items_invalid", "POST", "/items/import.xlsx", "not a spreadsheet"},
+	{"import_items_invalid_duplicate_handling", "POST", "/items/import.xlsx?on_duplicate=replace", "not a spreadsheet"},
+	{"patch_item", "PATCH", "/items/0", `[{"op":"replace","path":"/description","value":"patched"}]`},
+	{"patch_item_test_failed", "PATCH", "/items/0", `[{"op":"test","path":"/name","value":"second"}]`},
+	{"put_item_translation", "PUT", "/items/0/translations/de", `{"name":"erste"}`},
+	{"put_item_translation_invalid_language", "PUT", "/items/0/translations/-", `{"name":"erste"}`},
+	{"archive_item", "POST", "/items/0/archive", ""},
+	{"unarchive_item_not_found", "POST", "/items/42/unarchive", ""},
+	{"move_item", "POST", "/items/1/move", `{"index":0}`},
+	{"move_item_invalid", "POST", "/items/1/move", `{}`},
+	{"diff_items", "GET", "/items/0/diff/1", ""},
+	{"related_items", "GET", "/items/0/related", ""},
+	{"merge_item", "POST", "/items/0/merge", `{"source":1}`},
+	{"merge_item_no_source", "POST", "/items/0/merge", `{}`},
+	{"ready", "GET", "/ready", ""},
+	{"dataset_stats", "GET", "/admin/dataset-stats", ""},
+	{"jobs", "GET", "/admin/jobs", ""},
+	{"maintenance", "GET", "/admin/maintenance", ""},
+	{"set_maintenance", "PUT", "/admin/maintenance", `{"enabled":false}`},
+	{"backup", "GET", "/admin/backup", ""},
+	{"restore", "POST", "/admin/restore", `[{"id":5,"name":"restored"}]`},
+	{"reset", "POST", "/admin/reset", ""},
+	{"async_job_not_found", "GET", "/jobs/0123456789abcdef0123456789abcdef", ""},
+	{"unknown_route", "GET", "/", ""},
+	{"honeypot", "GET", "/.env", ""},
+}
+
+// goldenContentTypes are the request bodies not sent as JSON.
+var goldenContentTypes = map[string]string{
+	"import_items_invalid":                    xlsxMediaType,
+	"import_items_invalid_duplicate_handling": xlsxMediaType,
+	"patch_item":                              jsonPatchMediaType,
+	"patch_item_test_failed":                  jsonPatchMediaType,
+}
+
+func Test_goldenResponses(t *testing.T) {
+	defer func(original ItemRepository, clock func() time.Time) { itemRepository, itemClock = original, clock }(itemRepository, itemClock)
+	itemClock = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}}
+	public, admin := newRouter(cfg), newAdminRouter(cfg)
+
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			itemRepository = NewInMemoryItemRepository(seedItems...)
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", cmp.Or(goldenContentTypes[tc.name], "application/json"))
+			}
+			// the admin routes take a token on the public listener
+			router := public
+			if strings.HasPrefix(tc.path, "/admin/") {
+				router = admin
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			actual := recordGoldenResponse(t, rr)
+			path := filepath.Join("testdata", "golden", tc.name+".json")
+			if *update {
+				if err := os.WriteFile(path, actual, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			expected, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("missing golden file, run the tests with -update: %v", err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("response differs from %s:\ngot:\n%s\nwant:\n%s", path, actual, expected)
+			}
+		})
+	}
+}
+
+// goldenExempt are the routes without a golden case, and why.
+var goldenExempt = map[string]string{
+	"POST /admin/config/reload": "it reloads the settings of the process running the tests",
+}
+
+// Test_goldenCoverage fails for a route of the router without a golden case,
+// so a new endpoint can't ship without its contract being recorded.
+func Test_goldenCoverage(t *testing.T) {
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+	covered := map[string]bool{}
+	for _, tc := range goldenCases {
+		var match mux.RouteMatch
+		if router.Match(httptest.NewRequest(tc.method, tc.path, nil), &match) && match.Route != nil {
+			template, _ := match.Route.GetPathTemplate()
+			covered[tc.method+" "+template] = true
+		}
+	}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		for _, method := range methods {
+			key := method + " " + template
+			if _, exempt := goldenExempt[key]; method != http.MethodOptions && !exempt && !covered[key] {
+				t.Errorf("%s has no golden case", key)
+			}
+		}
+		return nil
+	})
+}
+
+func recordGoldenResponse(t *testing.T, rr *httptest.ResponseRecorder) []byte {
+	response := goldenResponse{
+		Status:  rr.Code,
+		Headers: map[string]string{},
+		Body:    json.RawMessage("null"),
+	}
+	for key := range rr.Header() {
+		response.Headers[key] = rr.Header().Get(key)
+	}
+	if rr.Body.Len() > 0 && rr.Code != http.StatusNoContent {
+		if json.Valid(rr.Body.Bytes()) {
+			response.Body = rr.Body.Bytes()
+		} else {
+			response.Body, _ = json.Marshal(rr.Body.String())
+		}
+	}
+
+	recorded, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(recorded, '\n')
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// honeypotPaths are routes nobody has a legitimate reason to request from this
+// API. Anything hitting them is almost certainly a scanner.
+var honeypotPaths = []string{
+	"/admin.php",
+	"/wp-login.php",
+	"/wp-admin/",
+	"/xmlrpc.php",
+	"/phpmyadmin/",
+	"/.env",
+	"/.git/config",
+	"/config.json",
+	"/server-status",
+}
+
+type SecurityEvent struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	RemoteIP    string    `json:"remote_ip"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	UserAgent   string    `json:"user_agent"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// securityLog is the security event stream: one JSON document per line, kept
+// apart from the request log so it can be shipped and alerted on separately.
+var securityLog = log.New(os.Stderr, "security: ", 0)
+
+func logSecurityEvent(event SecurityEvent) {
+	line, _ := json.Marshal(event)
+	securityLog.Println(string(line))
+}
+
+// IPDenylist blocks remote IPs for a while after they tripped a honeypot.
+type IPDenylist struct {
+	mu      sync.Mutex
+	blocked map[string]time.Time
+}
+
+// ipDenylist outlives the routers, so a configuration reload keeps the blocks.
+var ipDenylist = NewIPDenylist()
+
+func NewIPDenylist() *IPDenylist {
+	return &IPDenylist{blocked: map[string]time.Time{}}
+}
+
+func (d *IPDenylist) Block(ip string, duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.blocked[ip] = time.Now().Add(duration)
+}
+
+func (d *IPDenylist) IsBlocked(ip string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	until, ok := d.blocked[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(d.blocked, ip)
+		return false
+	}
+	return true
+}
+
+// prune forgets the blocks that ran out, which IsBlocked only does for an IP
+// that comes back; it runs as a job.
+func (d *IPDenylist) prune(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for ip, until := range d.blocked {
+		if now.After(until) {
+			delete(d.blocked, ip)
+		}
+	}
+	return nil
+}
+
+// registerHoneypots adds the decoy routes to the router. When denylistDuration
+// is positive, the IP of anyone hitting a decoy is blocked for that long.
+func registerHoneypots(r *mux.Router, denylist *IPDenylist, denylistDuration time.Duration) {
+	handler := honeypotHandler(denylist, denylistDuration)
+	for _, path := range honeypotPaths {
+		r.PathPrefix(path).HandlerFunc(handler)
+	}
+}
+
+func honeypotHandler(denylist *IPDenylist, denylistDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		logSecurityEvent(SecurityEvent{
+			Time:        time.Now().UTC(),
+			Type:        "honeypot",
+			RemoteIP:    ip,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			UserAgent:   r.UserAgent(),
+			Fingerprint: fingerprint(r),
+		})
+		if denylistDuration > 0 {
+			denylist.Block(ip, denylistDuration)
+		}
+
+		// Look exactly like any other unknown route, so the scanner learns nothing.
+		routeDoesNotExist(w, r)
+	}
+}
+
+func denylistMiddleware(denylist *IPDenylist) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if denylist.IsBlocked(remoteIP(r)) {
+				JSONResponse(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fingerprint hashes the headers that tend to be stable per scanning tool, so
+// the same tool can be recognised across IPs.
+func fingerprint(r *http.Request) string {
+	hash := sha256.New()
+	for _, header := range []string{"User-Agent", "Accept", "Accept-Language", "Accept-Encoding", "Connection"} {
+		hash.Write([]byte(header + "=" + r.Header.Get(header) + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,251 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// The integration tests run the same CRUD flow through the real router against
+// every storage backend. Backends that need a server are started in a
+// container, so a running Docker daemon is required:
+// go test -tags integration ./...
+
+var integrationBackends = []struct {
+	name  string
+	start func(t *testing.T) ItemRepository
+}{
+	{"memory", func(t *testing.T) ItemRepository { return NewInMemoryItemRepository() }},
+	{"redis", startRedisRepository},
+	{"mongo", startMongoRepository},
+	{"bolt", openBoltRepository},
+	{"events", func(t *testing.T) ItemRepository {
+		events, err := OpenEventLog(filepath.Join(t.TempDir(), "events.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewEventSourcedItemRepository(events)
+	}},
+}
+
+func Test_integrationCRUD(t *testing.T) {
+	defer func(original ItemRepository) { itemRepository = original }(itemRepository)
+	router := newRouter(Config{RouteTimeout: 10 * time.Second, RouteTimeouts: RouteTimeouts{}})
+
+	for _, backend := range integrationBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			itemRepository = backend.start(t)
+			setupExternalIDs()
+			runCRUDFlow(t, router)
+		})
+	}
+}
+
+func runCRUDFlow(t *testing.T, router http.Handler) {
+	var created Item
+	doJSON(t, router, "POST", "/items/", `{"name":"integration","description":"created"}`, http.StatusCreated, &created)
+	if created.Name != "integration" {
+		t.Fatalf("created item has wrong name: %+v", created)
+	}
+
+	var fetched Item
+	doJSON(t, router, "GET", fmt.Sprintf("/items/%d", created.ID), "", http.StatusOK, &fetched)
+	if !reflect.DeepEqual(fetched, created) {
+		t.Errorf("fetched item differs: got %+v want %+v", fetched, created)
+	}
+
+	var updated Item
+	doJSON(t, router, "PUT", fmt.Sprintf("/items/%d", created.ID), `{"name":"integration","description":"updated"}`, http.StatusOK, &updated)
+	doJSON(t, router, "GET", fmt.Sprintf("/items/%d", created.ID), "", http.StatusOK, &fetched)
+	if fetched.Description != "updated" {
+		t.Errorf("update was not stored: %+v", fetched)
+	}
+
+	var translated Item
+	doJSON(t, router, "PUT", fmt.Sprintf("/items/%d/translations/de", created.ID), `{"name":"Integration","description":"aktualisiert"}`, http.StatusOK, &translated)
+	req := httptest.NewRequest("GET", fmt.Sprintf("/items/%d", created.ID), nil)
+	req.Header.Set("Accept-Language", "de-CH, en;q=0.5")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	json.Unmarshal(rr.Body.Bytes(), &fetched)
+	if fetched.Description != "aktualisiert" || rr.Header().Get("Content-Language") != "de" {
+		t.Errorf("expected the German translation, got %s %s", rr.Header().Get("Content-Language"), rr.Body)
+	}
+
+	var duplicate Item
+	doJSON(t, router, "POST", fmt.Sprintf("/items/%d/duplicate", created.ID), "", http.StatusCreated, &duplicate)
+	if duplicate.ID == created.ID || duplicate.Description != "updated" {
+		t.Errorf("unexpected duplicate: %+v", duplicate)
+	}
+
+	var bySlug Item
+	doJSON(t, router, "GET", "/items/by-slug/"+duplicate.Slug, "", http.StatusOK, &bySlug)
+	if duplicate.Slug == created.Slug || !reflect.DeepEqual(bySlug, duplicate) {
+		t.Errorf("expected the duplicate under its own slug, got %+v for %+v", bySlug, duplicate)
+	}
+
+	var duplicates []Item
+	doJSON(t, router, "POST", fmt.Sprintf("/items/%d/duplicate?count=2", created.ID), "", http.StatusCreated, &duplicates)
+	if len(duplicates) != 2 {
+		t.Errorf("expected 2 duplicates, got %+v", duplicates)
+	}
+
+	var listed []Item
+	doJSON(t, router, "GET", "/items/?filter=integr", "", http.StatusOK, &listed)
+	if len(listed) != 4 {
+		t.Errorf("expected 4 items in filtered list, got %+v", listed)
+	}
+
+	var priced Item
+	doJSON(t, router, "POST", "/items/", `{"name":"priced","quantity":3,"price":"4.50","currency":"EUR"}`, http.StatusCreated, &priced)
+	var cheap []Item
+	doJSON(t, router, "GET", "/items/?price[lt]=5&quantity[gte]=3&currency=EUR", "", http.StatusOK, &cheap)
+	if len(cheap) != 1 || !reflect.DeepEqual(cheap[0], priced) {
+		t.Errorf("expected only the priced item, got %+v", cheap)
+	}
+
+	var upserted, afterUpsert Item
+	doJSON(t, router, "PUT", "/items/500?upsert=true", `{"name":"upserted"}`, http.StatusCreated, &upserted)
+	doJSON(t, router, "POST", "/items/", `{"name":"after upsert"}`, http.StatusCreated, &afterUpsert)
+	if upserted.ID != 500 || afterUpsert.ID <= 500 {
+		t.Errorf("expected the upserted item under its ID and later items above it, got %d and %d", upserted.ID, afterUpsert.ID)
+	}
+	req = httptest.NewRequest("PUT", "/items/500", strings.NewReader(`{"name":"upserted"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-None-Match", "*")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected creating an existing item to fail its precondition, got %d", rr.Code)
+	}
+
+	var external, byExternalID Item
+	doJSON(t, router, "POST", "/items/", `{"name":"from erp","external_id":"erp-1"}`, http.StatusCreated, &external)
+	doJSON(t, router, "GET", "/items/by-external-id/erp-1", "", http.StatusOK, &byExternalID)
+	if byExternalID.ID != external.ID {
+		t.Errorf("expected the item by its external ID, got %+v", byExternalID)
+	}
+	doJSON(t, router, "POST", "/items/", `{"name":"again","external_id":"erp-1"}`, http.StatusConflict, nil)
+	doJSON(t, router, "DELETE", fmt.Sprintf("/items/%d", external.ID), "", http.StatusNoContent, nil)
+	doJSON(t, router, "GET", "/items/by-external-id/erp-1", "", http.StatusNotFound, nil)
+	doJSON(t, router, "POST", "/items/", `{"name":"again","external_id":"erp-1"}`, http.StatusCreated, nil)
+
+	doJSON(t, router, "DELETE", fmt.Sprintf("/items/%d", created.ID), "", http.StatusNoContent, nil)
+	doJSON(t, router, "GET", fmt.Sprintf("/items/%d", created.ID), "", http.StatusNotFound, nil)
+	doJSON(t, router, "DELETE", fmt.Sprintf("/items/%d", created.ID), "", http.StatusNotFound, nil)
+}
+
+func doJSON(t *testing.T, router http.Handler, method, path, body string, status int, target interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != status {
+		t.Fatalf("%s %s returned wrong status code: got %v want %v: %s", method, path, rr.Code, status, rr.Body.String())
+	}
+	if target != nil {
+		if err := json.Unmarshal(rr.Body.Bytes(), target); err != nil {
+			t.Fatalf("%s %s returned invalid JSON: %v", method, path, err)
+		}
+	}
+}
+
+func startContainer(t *testing.T, image, port string, strategy wait.Strategy, cmd ...string) string {
+	ctx := context.Background()
+	container, err := testcontainers.Run(ctx, image,
+		testcontainers.WithExposedPorts(port),
+		testcontainers.WithWaitStrategy(strategy),
+		testcontainers.WithCmdArgs(cmd...),
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("starting %s: %v", image, err)
+	}
+
+	endpoint, err := container.PortEndpoint(ctx, port, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return endpoint
+}
+
+func startRedisRepository(t *testing.T) ItemRepository {
+	addr := startContainer(t, "redis:7-alpine", "6379/tcp", wait.ForLog("Ready to accept connections"))
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisItemRepository(client)
+}
+
+// startMongoRepository runs MongoDB as a single node replica set, because
+// transactions are not available on a standalone server.
+func startMongoRepository(t *testing.T) ItemRepository {
+	addr := startContainer(t, "mongo:7", "27017/tcp", wait.ForLog("Waiting for connections"), "--replSet", "rs0")
+	client, err := mongo.Connect(options.Client().ApplyURI("mongodb://" + addr + "/?directConnection=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	ctx := context.Background()
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: bson.M{
+		"_id":     "rs0",
+		"members": bson.A{bson.M{"_id": 0, "host": "localhost:27017"}},
+	}}}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replica set did not elect a primary in time")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	repo := NewMongoItemRepository(client.Database("integration"))
+	if err := repo.EnsureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func openBoltRepository(t *testing.T) ItemRepository {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "items.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo, err := NewBoltItemRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
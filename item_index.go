@@ -0,0 +1,172 @@
+package main
+
+// ItemIndex is a secondary index the in-memory repository keeps up to date on
+// every write. When listing, it narrows a filter down to the IDs that may
+// match, so the repository only has to look at those instead of every item.
+type ItemIndex interface {
+	Add(item Item)
+	Remove(item Item)
+	// Candidates returns the IDs of the items that may match the filter, or
+	// false when the index can't help with this filter.
+	Candidates(filter ItemFilter) (map[int]struct{}, bool)
+}
+
+// NameIndex is a trigram index on the item name. Every run of three
+// characters in a name points at the items containing it. An item can only
+// contain the filter if it contains all of the filter's trigrams, so
+// intersecting their postings yields the candidates for NameContains.
+type NameIndex struct {
+	postings map[string]map[int]struct{}
+}
+
+func NewNameIndex() *NameIndex {
+	return &NameIndex{postings: map[string]map[int]struct{}{}}
+}
+
+func (idx *NameIndex) Add(item Item) {
+	for _, trigram := range trigrams(item.Name) {
+		ids, ok := idx.postings[trigram]
+		if !ok {
+			ids = map[int]struct{}{}
+			idx.postings[trigram] = ids
+		}
+		ids[item.ID] = struct{}{}
+	}
+}
+
+func (idx *NameIndex) Remove(item Item) {
+	for _, trigram := range trigrams(item.Name) {
+		ids := idx.postings[trigram]
+		delete(ids, item.ID)
+		if len(ids) == 0 {
+			delete(idx.postings, trigram)
+		}
+	}
+}
+
+// Candidates only helps for filters of at least three characters; anything
+// shorter has no trigram to look up.
+func (idx *NameIndex) Candidates(filter ItemFilter) (map[int]struct{}, bool) {
+	wanted := trigrams(filter.NameContains)
+	if len(wanted) == 0 {
+		return nil, false
+	}
+
+	smallest := idx.postings[wanted[0]]
+	for _, trigram := range wanted[1:] {
+		if ids := idx.postings[trigram]; len(ids) < len(smallest) {
+			smallest = ids
+		}
+	}
+
+	candidates := map[int]struct{}{}
+	for id := range smallest {
+		candidates[id] = struct{}{}
+	}
+	for _, trigram := range wanted {
+		ids := idx.postings[trigram]
+		for id := range candidates {
+			if _, ok := ids[id]; !ok {
+				delete(candidates, id)
+			}
+		}
+	}
+	return candidates, true
+}
+
+// IndexStats counts the distinct trigrams in the index.
+func (idx *NameIndex) IndexStats() []IndexStats {
+	return []IndexStats{{Name: "name_trigrams", Entries: uint64(len(idx.postings))}}
+}
+
+// ExternalIDIndex maps external IDs to the item that has it, so looking an
+// item up by its external ID, and checking that no other item has it, doesn't
+// scan the items.
+type ExternalIDIndex struct {
+	ids map[string]int
+}
+
+func NewExternalIDIndex() *ExternalIDIndex {
+	return &ExternalIDIndex{ids: map[string]int{}}
+}
+
+func (idx *ExternalIDIndex) Add(item Item) {
+	if item.ExternalID != "" {
+		idx.ids[item.ExternalID] = item.ID
+	}
+}
+
+func (idx *ExternalIDIndex) Remove(item Item) {
+	if id, ok := idx.ids[item.ExternalID]; ok && id == item.ID {
+		delete(idx.ids, item.ExternalID)
+	}
+}
+
+func (idx *ExternalIDIndex) Candidates(filter ItemFilter) (map[int]struct{}, bool) {
+	if filter.ExternalID == "" {
+		return nil, false
+	}
+	candidates := map[int]struct{}{}
+	if id, ok := idx.ids[filter.ExternalID]; ok {
+		candidates[id] = struct{}{}
+	}
+	return candidates, true
+}
+
+// IndexStats counts the items with an external ID.
+func (idx *ExternalIDIndex) IndexStats() []IndexStats {
+	return []IndexStats{{Name: "external_ids", Entries: uint64(len(idx.ids))}}
+}
+
+// UUIDIndex maps UUIDs to their item, so the item routes can resolve the
+// UUID in the path with -id-format uuidv7 without scanning the items.
+type UUIDIndex struct {
+	ids map[string]int
+}
+
+func NewUUIDIndex() *UUIDIndex {
+	return &UUIDIndex{ids: map[string]int{}}
+}
+
+func (idx *UUIDIndex) Add(item Item) {
+	if item.UUID != "" {
+		idx.ids[item.UUID] = item.ID
+	}
+}
+
+func (idx *UUIDIndex) Remove(item Item) {
+	if id, ok := idx.ids[item.UUID]; ok && id == item.ID {
+		delete(idx.ids, item.UUID)
+	}
+}
+
+func (idx *UUIDIndex) Candidates(filter ItemFilter) (map[int]struct{}, bool) {
+	if filter.UUID == "" {
+		return nil, false
+	}
+	candidates := map[int]struct{}{}
+	if id, ok := idx.ids[filter.UUID]; ok {
+		candidates[id] = struct{}{}
+	}
+	return candidates, true
+}
+
+// IndexStats counts the items with a UUID.
+func (idx *UUIDIndex) IndexStats() []IndexStats {
+	return []IndexStats{{Name: "uuids", Entries: uint64(len(idx.ids))}}
+}
+
+// trigrams returns the distinct runs of three runes in s.
+func trigrams(s string) []string {
+	runes := []rune(s)
+	seen := map[string]bool{}
+	var result []string
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			result = append(result, trigram)
+		}
+	}
+	return result
+}
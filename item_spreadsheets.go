@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSpreadsheetBytes caps the size of an uploaded workbook.
+const maxSpreadsheetBytes = 10 << 20
+
+// spreadsheetColumns are the columns of an export. The fields an import can
+// fill are those in importFields; the others are managed by the API.
+var spreadsheetColumns = []string{"id", "name", "description", "quantity", "price", "currency", "slug", "created_at"}
+
+var importFields = []string{"name", "description", "quantity", "price", "currency"}
+
+// exportItems returns the items GET /items/ lists with the same filters as an
+// Excel workbook, one row per item after a header row. With -export-dir the
+// workbook is kept, and its X-Export-Id lets the download be resumed; clients
+// that send Prefer: respond-async then get a job that points at the workbook
+// once it is ready.
+func exportItems(w http.ResponseWriter, r *http.Request) {
+	filter, ok := requestItemFilter(w, r)
+	if !ok {
+		return
+	}
+	if exportFiles != nil && prefersAsync(r) {
+		exports := strings.TrimSuffix(r.URL.Path, "export.xlsx") + "exports/"
+		job := asyncJobs.Start(r.Context(), "export", func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error) {
+			content, err := buildExport(ctx, filter)
+			if err != nil {
+				return AsyncJobOutcome{}, err
+			}
+			id, err := exportFiles.Save(xlsxExport, content)
+			return AsyncJobOutcome{Location: exports + id + ".xlsx"}, err
+		})
+		AcceptedJobResponse(w, job)
+		return
+	}
+
+	content, err := buildExport(r.Context(), filter)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not export items")
+		return
+	}
+	if exportFiles != nil {
+		id, err := exportFiles.Save(xlsxExport, content)
+		if err != nil {
+			ExportErrorResponse(w, err, "could not export items")
+			return
+		}
+		serveExport(w, r, id, xlsxExport)
+		return
+	}
+	w.Header().Set("Content-Type", xlsxMediaType)
+	w.Header().Set("Content-Disposition", `attachment; filename="items.xlsx"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// buildExport writes the workbook of the items matching the filter.
+func buildExport(ctx context.Context, filter ItemFilter) ([]byte, error) {
+	items, err := itemRepository.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]xlsxCell{make([]xlsxCell, len(spreadsheetColumns))}
+	for i, column := range spreadsheetColumns {
+		rows[0][i] = xlsxCell{Value: column}
+	}
+	for _, item := range items {
+		createdAt := ""
+		if item.CreatedAt != nil {
+			createdAt = item.CreatedAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []xlsxCell{
+			{Value: strconv.Itoa(item.ID), Number: true},
+			{Value: item.Name},
+			{Value: item.Description},
+			{Value: strconv.Itoa(item.Quantity), Number: true},
+			{Value: item.Price, Number: item.Price != ""},
+			{Value: item.Currency},
+			{Value: item.Slug},
+			{Value: createdAt},
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ImportPreview is what a dry run of an import found: the columns of the
+// header row, which of them fill which field, how many items the rows make
+// and what is wrong with them.
+type ImportPreview struct {
+	Columns []string          `json:"columns"`
+	Mapping map[string]string `json:"mapping"`
+	Items   int               `json:"items"`
+	Errors  []FieldError      `json:"errors"`
+}
+
+// The strategies of ?on_duplicate= for a row that matches a stored item.
+const (
+	DuplicateSkip      = "skip"
+	DuplicateOverwrite = "overwrite"
+	DuplicateMerge     = "merge"
+	DuplicateFail      = "fail"
+)
+
+// The outcomes of a row in an ImportReport.
+const (
+	RowCreated     = "created"
+	RowSkipped     = "skipped"
+	RowOverwritten = "overwritten"
+	RowMerged      = "merged"
+)
+
+// ImportReport tells what an import with ?on_duplicate= did with each row.
+type ImportReport struct {
+	Rows []ImportRowOutcome `json:"rows"`
+}
+
+// ImportRowOutcome is what became of a row, and the item it left behind.
+type ImportRowOutcome struct {
+	Row     int    `json:"row"`
+	Outcome string `json:"outcome"`
+	Item    Item   `json:"item"`
+}
+
+// importRow is a row of a workbook made into an item. ID is the row's id
+// cell, which imports matching by ID look items up by.
+type importRow struct {
+	Number int
+	ID     *int
+	Item   Item
+}
+
+// DuplicateRowError fails an import with ?on_duplicate=fail at the first row
+// that matches a stored item.
+type DuplicateRowError struct {
+	Row   int
+	Field string
+	ID    int
+}
+
+func (e *DuplicateRowError) Error() string {
+	return fmt.Sprintf("row %d matches item %d by %s", e.Row, e.ID, e.Field)
+}
+
+// columnMapping tells which column fills each field. A field takes the column
+// ?column[field]= names, or else the column named like the field, so an
+// export imports as it is. It returns false when a mapping names an unknown
+// field or column, or when no column fills the name or a required field.
+func columnMapping(header []string, params map[string][]string, fields []string, required ...string) (map[string]int, bool) {
+	indexes := map[string]int{}
+	for i, column := range header {
+		indexes[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	mapping := map[string]int{}
+	for _, field := range fields {
+		if i, ok := indexes[field]; ok {
+			mapping[field] = i
+		}
+	}
+	for param, values := range params {
+		field, ok := strings.CutPrefix(param, "column[")
+		if !ok {
+			continue
+		}
+		field, ok = strings.CutSuffix(field, "]")
+		i, found := indexes[strings.ToLower(strings.TrimSpace(values[0]))]
+		if !ok || !slices.Contains(fields, field) || !found {
+			return nil, false
+		}
+		mapping[field] = i
+	}
+	for _, field := range append(required, "name") {
+		if _, ok := mapping[field]; !ok {
+			return nil, false
+		}
+	}
+	return mapping, true
+}
+
+// rowItem makes an item of a row, with its errors named after the row.
+func rowItem(row xlsxRow, mapping map[string]int) (importRow, []FieldError) {
+	cell := func(field string) string {
+		if i, ok := mapping[field]; ok && i < len(row.Cells) {
+			return strings.TrimSpace(row.Cells[i])
+		}
+		return ""
+	}
+	item := Item{Name: cell("name"), Description: cell("description"), Price: cell("price"), Currency: cell("currency")}
+	var errs []FieldError
+	if quantity := cell("quantity"); quantity != "" {
+		var err error
+		if item.Quantity, err = strconv.Atoi(quantity); err != nil {
+			errs = append(errs, newFieldError("quantity", InvalidQuantityCode))
+		}
+	}
+	imported := importRow{Number: row.Number, Item: item}
+	if id := cell("id"); id != "" {
+		if n, err := strconv.Atoi(id); err == nil && validClientID(n) {
+			imported.ID = &n
+		} else {
+			errs = append(errs, newFieldError("id", InvalidClientIDCode))
+		}
+	}
+	errs = append(errs, validateItem(item)...)
+	for i := range errs {
+		errs[i].Field = fmt.Sprintf("rows[%d].%s", row.Number, errs[i].Field)
+	}
+	return imported, errs
+}
+
+// importItems creates an item for every row of the first sheet of the
+// workbook in the body, after its header row. The items are created in one
+// transaction, so either all rows are imported or none. With
+// ?dry_run=true nothing is created; the preview shows how the columns map to
+// the fields and what is wrong with the rows.
+//
+// With ?on_duplicate= a row that matches a stored item, by name or with
+// ?match=id by the id column, is skipped, overwrites the item, is merged into
+// it or fails the import, so importing the same file again is safe. The
+// response then reports the outcome of every row.
+func importItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	onDuplicate, match := query.Get("on_duplicate"), cmp.Or(query.Get("match"), "name")
+	if (onDuplicate != "" && onDuplicate != DuplicateSkip && onDuplicate != DuplicateOverwrite && onDuplicate != DuplicateMerge && onDuplicate != DuplicateFail) ||
+		(match != "name" && match != "id") || (match == "id" && onDuplicate == "") {
+		ErrorCodeResponse(w, InvalidDuplicateHandlingCode)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSpreadsheetBytes))
+	if err != nil {
+		ErrorCodeResponse(w, InvalidSpreadsheetCode)
+		return
+	}
+	rows, err := readXLSX(bytes.NewReader(body), int64(len(body)))
+	if err != nil || len(rows) == 0 {
+		ErrorCodeResponse(w, InvalidSpreadsheetCode)
+		return
+	}
+	fields, required := importFields, []string(nil)
+	if match == "id" {
+		fields, required = append([]string{"id"}, importFields...), []string{"id"}
+	}
+	mapping, ok := columnMapping(rows[0].Cells, query, fields, required...)
+	if !ok {
+		ErrorCodeResponse(w, InvalidColumnMappingCode)
+		return
+	}
+
+	var items []importRow
+	errs := []FieldError{}
+	for _, row := range rows[1:] {
+		item, rowErrs := rowItem(row, mapping)
+		items = append(items, item)
+		errs = append(errs, rowErrs...)
+	}
+
+	if isDryRun(r) {
+		preview := ImportPreview{Columns: rows[0].Cells, Mapping: map[string]string{}, Items: len(items), Errors: errs}
+		for field, i := range mapping {
+			preview.Mapping[field] = rows[0].Cells[i]
+		}
+		SuccessResponse(w, preview)
+		return
+	}
+	if len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
+	}
+
+	// without ?on_duplicate= the response stays the list of created items
+	result := func(outcomes []ImportRowOutcome) interface{} {
+		if onDuplicate != "" {
+			return ImportReport{Rows: outcomes}
+		}
+		created := make([]Item, len(outcomes))
+		for i, outcome := range outcomes {
+			created[i] = outcome.Item
+		}
+		return created
+	}
+	if prefersAsync(r) {
+		job := asyncJobs.Start(r.Context(), "import", func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error) {
+			outcomes, err := importRows(ctx, items, match, onDuplicate, progress)
+			return AsyncJobOutcome{Result: result(outcomes)}, err
+		})
+		AcceptedJobResponse(w, job)
+		return
+	}
+	outcomes, err := importRows(r.Context(), items, match, onDuplicate, func(done, total int) {})
+	var duplicate *DuplicateRowError
+	if errors.As(err, &duplicate) {
+		problem := newProblem(DuplicateRowCode)
+		problem.Errors = []FieldError{newFieldError(fmt.Sprintf("rows[%d].%s", duplicate.Row, duplicate.Field), DuplicateRowCode)}
+		problem.ConflictingID = &duplicate.ID
+		ProblemResponse(w, problem)
+		return
+	}
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not import items")
+		return
+	}
+
+	if onDuplicate != "" {
+		SuccessResponse(w, result(outcomes))
+		return
+	}
+	CreatedResponse(w, result(outcomes))
+}
+
+// importRows stores the items of an import in one transaction, reporting how
+// many are done as it goes. With onDuplicate set, each row is first matched
+// against the stored items, including those made of earlier rows: by ID, or
+// by name regardless of case. The names are listed once up front rather than
+// for every row.
+func importRows(ctx context.Context, rows []importRow, match, onDuplicate string, progress func(done, total int)) ([]ImportRowOutcome, error) {
+	var outcomes []ImportRowOutcome
+	err := itemRepository.Tx(ctx, func(tx ItemRepository) error {
+		outcomes = []ImportRowOutcome{}
+		var names map[string]Item
+		if onDuplicate != "" && match == "name" {
+			items, err := tx.List(ctx, ItemFilter{})
+			if err != nil {
+				return err
+			}
+			names = make(map[string]Item, len(items))
+			for _, item := range items {
+				if _, ok := names[strings.ToLower(item.Name)]; !ok {
+					names[strings.ToLower(item.Name)] = item
+				}
+			}
+		}
+
+		now := itemClock().UTC()
+		for _, row := range rows {
+			var stored *Item
+			switch {
+			case names != nil:
+				if item, ok := names[strings.ToLower(row.Item.Name)]; ok {
+					stored = &item
+				}
+			case onDuplicate != "" && row.ID != nil:
+				item, err := tx.Get(ctx, *row.ID)
+				if err != nil && !errors.Is(err, NotFoundError) {
+					return err
+				}
+				stored = item
+			}
+			outcome := ImportRowOutcome{Row: row.Number}
+			switch {
+			case stored == nil:
+				item := row.Item
+				item.CreatedAt = &now
+				if err := assignSlug(ctx, tx, &item); err != nil {
+					return err
+				}
+				var created *Item
+				var err error
+				if match == "id" && row.ID != nil {
+					// kept under the row's ID, so the next import finds it
+					item.ID = *row.ID
+					created, err = tx.Insert(ctx, item)
+				} else {
+					created, err = tx.Create(ctx, item)
+				}
+				if err != nil {
+					return err
+				}
+				outcome.Outcome, outcome.Item = RowCreated, *created
+			case onDuplicate == DuplicateFail:
+				return &DuplicateRowError{Row: row.Number, Field: match, ID: stored.ID}
+			case onDuplicate == DuplicateSkip:
+				outcome.Outcome, outcome.Item = RowSkipped, *stored
+			default:
+				item := *stored
+				if onDuplicate == DuplicateMerge {
+					item = mergeFields(item, row.Item, MergeReplace)
+					outcome.Outcome = RowMerged
+				} else {
+					item.Name, item.Description, item.Quantity = row.Item.Name, row.Item.Description, row.Item.Quantity
+					item.Price, item.Currency = row.Item.Price, row.Item.Currency
+					outcome.Outcome = RowOverwritten
+				}
+				if err := tx.Update(ctx, item); err != nil {
+					return err
+				}
+				outcome.Item = item
+			}
+			if names != nil {
+				names[strings.ToLower(outcome.Item.Name)] = outcome.Item
+			}
+			outcomes = append(outcomes, outcome)
+			progress(len(outcomes), len(rows))
+		}
+		return nil
+	})
+	return outcomes, err
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_xlsxColumnNames(t *testing.T) {
+	for i, name := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumnName(i); got != name {
+			t.Errorf("column %d is named %s, want %s", i, got, name)
+		}
+		if got := xlsxColumnIndex(name + "12"); got != i {
+			t.Errorf("column %s has index %d, want %d", name, got, i)
+		}
+	}
+}
+
+// sharedStringsWorkbook is a workbook as Excel saves it, with the text in a
+// shared strings table and a sheet that isn't called sheet1.
+func sharedStringsWorkbook(t *testing.T) []byte {
+	return zipWorkbook(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="` + xlsxMainNamespace + `" xmlns:r="` + xlsxRelNamespace + `"><sheets><sheet name="Stock" sheetId="1" r:id="rId7"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="` + xlsxPkgNamespace + `">` +
+			`<Relationship Id="rId7" Type="` + xlsxRelNamespace + `/worksheet" Target="/xl/worksheets/stock.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="` + xlsxMainNamespace + `"><si><t>Product</t></si><si><t>Stock</t></si>` +
+			`<si><r><t>Red </t></r><r><t>scarf</t></r></si></sst>`,
+		"xl/worksheets/stock.xml": `<worksheet xmlns="` + xlsxMainNamespace + `"><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>` +
+			`<row r="3"><c r="A3" t="s"><v>2</v></c><c r="C3"><v>12</v></c></row>` +
+			`</sheetData></worksheet>`,
+	})
+}
+
+// zipWorkbook zips the parts of a workbook.
+func zipWorkbook(t *testing.T, parts map[string]string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range parts {
+		f, _ := archive.Create(name)
+		f.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_readXLSX(t *testing.T) {
+	workbook := sharedStringsWorkbook(t)
+	rows, err := readXLSX(bytes.NewReader(workbook), int64(len(workbook)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []xlsxRow{{Number: 1, Cells: []string{"Product", "", "Stock"}}, {Number: 3, Cells: []string{"Red scarf", "", "12"}}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %+v, want %+v", rows, want)
+	}
+	if _, err := readXLSX(bytes.NewReader([]byte("name,price")), 10); err == nil {
+		t.Error("expected a CSV file not to be read")
+	}
+}
+
+func Test_readXLSXLimits(t *testing.T) {
+	sheet := func(cells string) []byte {
+		return zipWorkbook(t, map[string]string{
+			"xl/workbook.xml": `<workbook xmlns="` + xlsxMainNamespace + `" xmlns:r="` + xlsxRelNamespace + `"><sheets><sheet name="Items" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+			"xl/_rels/workbook.xml.rels": `<Relationships xmlns="` + xlsxPkgNamespace + `">` +
+				`<Relationship Id="rId1" Type="` + xlsxRelNamespace + `/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+			"xl/worksheets/sheet1.xml": `<worksheet xmlns="` + xlsxMainNamespace + `"><sheetData><row r="1">` + cells + `</row></sheetData></worksheet>`,
+		})
+	}
+	read := func(workbook []byte) error {
+		_, err := readXLSX(bytes.NewReader(workbook), int64(len(workbook)))
+		return err
+	}
+
+	if err := read(sheet(`<c r="XFD1"><v>1</v></c>`)); err != nil {
+		t.Errorf("expected the last column to be read, got %v", err)
+	}
+	for _, ref := range []string{"XFE1", "ZZZZZZ1", "ZZZZZZZZZZZZZZZZZZ1"} {
+		if err := read(sheet(`<c r="` + ref + `"><v>1</v></c>`)); !errors.Is(err, errNotXLSX) {
+			t.Errorf("%s: expected a column beyond XFD to be refused, got %v", ref, err)
+		}
+	}
+
+	isolate(t, &maxXLSXPartBytes, 1024)
+	if err := read(sheet(strings.Repeat(`<c><v>1</v></c>`, 100))); !errors.Is(err, errNotXLSX) {
+		t.Errorf("expected a sheet that unzips beyond the cap to be refused, got %v", err)
+	}
+}
+
+func Test_exportAndImportItems(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	itemRepository = NewInMemoryItemRepository(
+		Item{ID: 0, Name: "Scarf <red>", Description: " wool ", Quantity: 3, Price: "9.99", Currency: "EUR", CreatedAt: &created},
+		Item{ID: 1, Name: "Hat"},
+	)
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Accept", xlsxMediaType+", application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", xlsxMediaType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/items/export.xlsx", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != xlsxMediaType {
+		t.Fatalf("unexpected export %d %v", w.Code, w.Header())
+	}
+	exported := w.Body.Bytes()
+	rows, err := readXLSX(bytes.NewReader(exported), int64(len(exported)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[1].Cells, []string{"0", "Scarf <red>", " wool ", "3", "9.99", "EUR", "", "2024-05-01T12:00:00Z"}) {
+		t.Errorf("unexpected exported rows %+v", rows)
+	}
+
+	itemRepository = NewInMemoryItemRepository()
+	w = do("POST", "/items/import.xlsx", exported)
+	var imported []Item
+	json.Unmarshal(w.Body.Bytes(), &imported)
+	if w.Code != http.StatusCreated || len(imported) != 2 || imported[0].Name != "Scarf <red>" || imported[0].Price != "9.99" || imported[0].Slug != "scarf-red" {
+		t.Errorf("unexpected import %d: %s", w.Code, w.Body)
+	}
+
+	workbook := sharedStringsWorkbook(t)
+	w = do("POST", "/items/import.xlsx?dry_run=true&column[name]=product&column[quantity]=Stock", workbook)
+	var preview ImportPreview
+	json.Unmarshal(w.Body.Bytes(), &preview)
+	if w.Code != http.StatusOK || preview.Items != 1 || !reflect.DeepEqual(preview.Mapping, map[string]string{"name": "Product", "quantity": "Stock"}) {
+		t.Errorf("unexpected preview %d: %s", w.Code, w.Body)
+	}
+	if items, _ := itemRepository.List(t.Context(), ItemFilter{}); len(items) != 2 {
+		t.Errorf("expected a dry run not to create items, got %d", len(items))
+	}
+
+	for path, code := range map[string]int{
+		"/items/import.xlsx":                          http.StatusBadRequest,
+		"/items/import.xlsx?column[name]=Title":       http.StatusBadRequest,
+		"/items/import.xlsx?column[slug]=Product":     http.StatusBadRequest,
+		"/items/import.xlsx?column[name]=Stock":       http.StatusCreated,
+		"/items/import.xlsx?column[quantity]=Product": http.StatusBadRequest,
+	} {
+		if w := do("POST", path, workbook); w.Code != code {
+			t.Errorf("%s returned %d, want %d: %s", path, w.Code, code, w.Body)
+		}
+	}
+	if w := do("POST", "/items/import.xlsx?column[name]=Product&column[quantity]=Product", workbook); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a name as quantity to be invalid, got %d: %s", w.Code, w.Body)
+	}
+}
+
+// testWorkbook writes the rows, the first being the header, as a workbook.
+func testWorkbook(t *testing.T, rows ...[]string) string {
+	cells := make([][]xlsxCell, len(rows))
+	for i, row := range rows {
+		for _, value := range row {
+			cells[i] = append(cells[i], xlsxCell{Value: value})
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, cells); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func Test_importDuplicates(t *testing.T) {
+	stored := []Item{{ID: 0, Name: "Scarf", Description: "wool", Quantity: 3}, {ID: 1, Name: "Hat"}}
+	byName := testWorkbook(t, []string{"name", "quantity"}, []string{"scarf", "5"}, []string{"Gloves", "2"}, []string{"GLOVES", ""})
+	byID := testWorkbook(t, []string{"id", "name", "quantity"}, []string{"1", "Cap", "4"}, []string{"7", "Boots", "1"})
+	importWith := func(api *testAPI, query, workbook string) *httptest.ResponseRecorder {
+		return api.Request("POST", "/items/import.xlsx"+query, workbook, "Content-Type", xlsxMediaType)
+	}
+
+	tests := []struct {
+		query    string
+		workbook string
+		outcomes []string
+		items    []Item
+	}{
+		{"?on_duplicate=skip", byName, []string{RowSkipped, RowCreated, RowSkipped}, []Item{
+			{ID: 0, Name: "Scarf", Description: "wool", Quantity: 3}, {ID: 1, Name: "Hat"}, {ID: 2, Name: "Gloves", Quantity: 2},
+		}},
+		{"?on_duplicate=overwrite", byName, []string{RowOverwritten, RowCreated, RowOverwritten}, []Item{
+			{ID: 0, Name: "scarf", Quantity: 5}, {ID: 1, Name: "Hat"}, {ID: 2, Name: "GLOVES"},
+		}},
+		{"?on_duplicate=merge", byName, []string{RowMerged, RowCreated, RowMerged}, []Item{
+			{ID: 0, Name: "scarf", Description: "wool", Quantity: 5}, {ID: 1, Name: "Hat"}, {ID: 2, Name: "GLOVES", Quantity: 2},
+		}},
+		{"?on_duplicate=overwrite&match=id", byID, []string{RowOverwritten, RowCreated}, []Item{
+			{ID: 0, Name: "Scarf", Description: "wool", Quantity: 3}, {ID: 1, Name: "Cap", Quantity: 4}, {ID: 7, Name: "Boots", Quantity: 1},
+		}},
+	}
+	for _, tt := range tests {
+		api := newTestAPI(t, stored...)
+		w := importWith(api, tt.query, tt.workbook)
+		report := decodeResponse[ImportReport](t, w, http.StatusOK)
+		var outcomes []string
+		for _, row := range report.Rows {
+			outcomes = append(outcomes, row.Outcome)
+		}
+		if !reflect.DeepEqual(outcomes, tt.outcomes) {
+			t.Errorf("%s: expected the outcomes %v, got %v", tt.query, tt.outcomes, outcomes)
+		}
+		items, _ := itemRepository.List(t.Context(), ItemFilter{})
+		for i := range items {
+			items[i].CreatedAt, items[i].Slug = nil, ""
+		}
+		if !reflect.DeepEqual(items, tt.items) {
+			t.Errorf("%s: expected the items %+v, got %+v", tt.query, tt.items, items)
+		}
+		// importing the file again changes nothing for skip
+		if tt.query == "?on_duplicate=skip" {
+			again := decodeResponse[ImportReport](t, importWith(api, tt.query, tt.workbook), http.StatusOK)
+			if after, _ := itemRepository.List(t.Context(), ItemFilter{}); len(after) != 3 || again.Rows[1].Outcome != RowSkipped {
+				t.Errorf("expected a repeated import to skip every row, got %+v", again)
+			}
+		}
+	}
+
+	api := newTestAPI(t, stored...)
+	w := importWith(api, "?on_duplicate=fail", byName)
+	problem := decodeResponse[Problem](t, w, http.StatusConflict)
+	if problem.Code != "DUPLICATE_ROW" || problem.ConflictingID == nil || *problem.ConflictingID != 0 || problem.Errors[0].Field != "rows[2].name" {
+		t.Errorf("expected the import to fail at row 2, got %s", w.Body)
+	}
+	if items, _ := itemRepository.List(t.Context(), ItemFilter{}); len(items) != 2 {
+		t.Errorf("expected a failed import to create nothing, got %+v", items)
+	}
+	for _, query := range []string{"?on_duplicate=replace", "?match=id", "?on_duplicate=skip&match=slug"} {
+		if w := importWith(api, query, byID); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_DUPLICATE_HANDLING") {
+			t.Errorf("%s: expected 400, got %d %s", query, w.Code, w.Body)
+		}
+	}
+	if w := importWith(api, "?on_duplicate=skip&match=id", byName); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_COLUMN_MAPPING") {
+		t.Errorf("expected matching by ID to need an id column, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// The fields GET /items/stats can group by.
+var statsGroupings = map[string]func(Item) string{
+	"currency": func(item Item) string { return cmp.Or(item.Currency, "none") },
+	"state":    Item.State,
+}
+
+// ItemStats sums up the items matching a filter.
+type ItemStats struct {
+	Items int `json:"items"`
+	// Groups counts the items per value of the group_by field.
+	Groups   map[string]int        `json:"groups,omitempty"`
+	Quantity *QuantityStats        `json:"quantity,omitempty"`
+	Price    map[string]PriceStats `json:"price,omitempty"`
+	// CreatedPerDay counts the items created per UTC day, from the first day
+	// one was created to the last, days without any included.
+	CreatedPerDay []DayCount `json:"created_per_day"`
+}
+
+type QuantityStats struct {
+	Min     int     `json:"min"`
+	Max     int     `json:"max"`
+	Average float64 `json:"average"`
+}
+
+// PriceStats are decimal strings like prices, for one currency. The average
+// has two decimals more than the currency.
+type PriceStats struct {
+	Min     string `json:"min"`
+	Max     string `json:"max"`
+	Average string `json:"average"`
+}
+
+type DayCount struct {
+	Day   string `json:"day"`
+	Items int    `json:"items"`
+}
+
+// aggregateItems computes the statistics of the items the filter keeps,
+// grouped by groupBy unless it is empty. It reads the items through the
+// repository rather than letting a backend aggregate them, so the decorators
+// get to decrypt them and every backend counts the same way.
+func aggregateItems(ctx context.Context, filter ItemFilter, groupBy string) (ItemStats, error) {
+	items, err := itemRepository.List(ctx, filter)
+	if err != nil {
+		return ItemStats{}, err
+	}
+	return computeItemStats(items, groupBy), nil
+}
+
+func computeItemStats(items []Item, groupBy string) ItemStats {
+	stats := ItemStats{Items: len(items), CreatedPerDay: []DayCount{}}
+	if group, ok := statsGroupings[groupBy]; ok {
+		stats.Groups = map[string]int{}
+		for _, item := range items {
+			stats.Groups[group(item)]++
+		}
+	}
+	if len(items) == 0 {
+		return stats
+	}
+
+	quantity := QuantityStats{Min: items[0].Quantity, Max: items[0].Quantity}
+	total := 0
+	for _, item := range items {
+		quantity.Min, quantity.Max = min(quantity.Min, item.Quantity), max(quantity.Max, item.Quantity)
+		total += item.Quantity
+	}
+	quantity.Average = math.Round(float64(total)/float64(len(items))*100) / 100
+	stats.Quantity = &quantity
+
+	stats.Price = priceStats(items)
+	stats.CreatedPerDay = createdPerDay(items)
+	return stats
+}
+
+func priceStats(items []Item) map[string]PriceStats {
+	type summary struct {
+		min, max, sum *big.Rat
+		count         int64
+	}
+	summaries := map[string]*summary{}
+	for _, item := range items {
+		price, ok := new(big.Rat).SetString(item.Price)
+		if !ok {
+			continue
+		}
+		s, ok := summaries[item.Currency]
+		if !ok {
+			s = &summary{min: price, max: price, sum: new(big.Rat)}
+			summaries[item.Currency] = s
+		}
+		if price.Cmp(s.min) < 0 {
+			s.min = price
+		}
+		if price.Cmp(s.max) > 0 {
+			s.max = price
+		}
+		s.sum.Add(s.sum, price)
+		s.count++
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	result := map[string]PriceStats{}
+	for currency, s := range summaries {
+		decimals := currencyDecimals[currency]
+		average := new(big.Rat).Quo(s.sum, big.NewRat(s.count, 1))
+		result[currency] = PriceStats{
+			Min:     s.min.FloatString(decimals),
+			Max:     s.max.FloatString(decimals),
+			Average: average.FloatString(decimals + 2),
+		}
+	}
+	return result
+}
+
+func createdPerDay(items []Item) []DayCount {
+	counts := map[time.Time]int{}
+	var first, last time.Time
+	for _, item := range items {
+		if item.CreatedAt == nil {
+			continue
+		}
+		day := item.CreatedAt.UTC().Truncate(24 * time.Hour)
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
+		counts[day]++
+	}
+	days := []DayCount{}
+	if first.IsZero() {
+		return days
+	}
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, DayCount{Day: day.Format(time.DateOnly), Items: counts[day]})
+	}
+	return days
+}
+
+// getItemStats returns the statistics of the items GET /items/ would list
+// with the same filters.
+func getItemStats(w http.ResponseWriter, r *http.Request) {
+	filter, ok := requestItemFilter(w, r)
+	if !ok {
+		return
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if _, known := statsGroupings[groupBy]; groupBy != "" && !known {
+		ErrorCodeResponse(w, InvalidGroupByCode)
+		return
+	}
+
+	stats, err := aggregateItems(r.Context(), filter, groupBy)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not compute the item statistics")
+		return
+	}
+
+	SuccessResponse(w, stats)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_computeItemStats(t *testing.T) {
+	day := func(d, h int) *time.Time {
+		at := time.Date(2024, 5, d, h, 0, 0, 0, time.UTC)
+		return &at
+	}
+	archived := time.Now()
+	items := []Item{
+		{ID: 0, Name: "seed", Quantity: 4},
+		{ID: 1, Name: "a", Quantity: 1, Price: "1.00", Currency: "EUR", CreatedAt: day(1, 9)},
+		{ID: 2, Name: "b", Price: "2.50", Currency: "EUR", CreatedAt: day(1, 23)},
+		{ID: 3, Name: "c", Quantity: 7, Price: "300", Currency: "JPY", CreatedAt: day(3, 0), ArchivedAt: &archived},
+	}
+
+	stats := computeItemStats(items, "currency")
+	want := ItemStats{
+		Items:    4,
+		Groups:   map[string]int{"none": 1, "EUR": 2, "JPY": 1},
+		Quantity: &QuantityStats{Min: 0, Max: 7, Average: 3},
+		Price: map[string]PriceStats{
+			"EUR": {Min: "1.00", Max: "2.50", Average: "1.7500"},
+			"JPY": {Min: "300", Max: "300", Average: "300.00"},
+		},
+		CreatedPerDay: []DayCount{{"2024-05-01", 2}, {"2024-05-02", 0}, {"2024-05-03", 1}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v\nwant %+v", stats, want)
+	}
+	if stats := computeItemStats(items, "state"); !reflect.DeepEqual(stats.Groups, map[string]int{"active": 3, "archived": 1}) {
+		t.Errorf("unexpected groups by state %v", stats.Groups)
+	}
+	if stats := computeItemStats(nil, ""); stats.Items != 0 || stats.Quantity != nil || stats.CreatedPerDay == nil {
+		t.Errorf("unexpected statistics of no items %+v", stats)
+	}
+}
+
+func Test_getItemStats(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	archived := time.Now()
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "a", Quantity: 2}, Item{ID: 1, Name: "b", ArchivedAt: &archived})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/stats", getItemStats)
+
+	get := func(query string) (int, ItemStats) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/stats?"+query, nil))
+		var stats ItemStats
+		json.Unmarshal(rr.Body.Bytes(), &stats)
+		return rr.Code, stats
+	}
+
+	if _, stats := get("group_by=state"); stats.Items != 1 || stats.Groups["active"] != 1 {
+		t.Errorf("expected the listing's default filter to apply, got %+v", stats)
+	}
+	if _, stats := get("state=all"); stats.Items != 2 {
+		t.Errorf("expected ?state=all to count archived items too, got %+v", stats)
+	}
+	if code, _ := get("group_by=category"); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown group_by to return 400, got %d", code)
+	}
+}
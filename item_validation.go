@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// validateNewItem checks the item in the body as POST /items/ would, without
+// creating it, so forms can be checked on the server before they are
+// submitted. It answers the item as it would be stored, with the ID it would
+// get, or 422 with every field error. A name, external ID or ID another item
+// has is a field error too, with that item in conflicting_id.
+//
+// A check costs as much as a create: it runs the write in a transaction that
+// is rolled back. On memory that holds the write lock and copies the items;
+// on Redis the ID it answers is taken from the sequence, so the item gets a
+// later one when it is created.
+func validateNewItem(w http.ResponseWriter, r *http.Request) {
+	var body newItemBody
+	if err := decodeBody(r, &body); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	item, errs := body.item()
+
+	// the unique checks run in a transaction that is always rolled back
+	var normalized *Item
+	err := itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		created, err := storeNewItem(r.Context(), tx, item, body.hasClientID())
+		if err != nil {
+			return err
+		}
+		normalized = created
+		return errDryRun
+	})
+	var nameTaken *NameTakenError
+	var externalIDTaken *ExternalIDTakenError
+	var conflictingID *int
+	switch {
+	case errors.Is(err, errDryRun):
+	case errors.As(err, &nameTaken):
+		errs = append(errs, newFieldError("name", ItemNameTakenCode))
+		conflictingID = &nameTaken.ID
+	case errors.As(err, &externalIDTaken):
+		errs = append(errs, newFieldError("external_id", ExternalIDTakenCode))
+		conflictingID = &externalIDTaken.ID
+	case errors.Is(err, IDTakenError):
+		errs = append(errs, newFieldError("id", ItemIDTakenCode))
+	case errors.Is(err, IDOutOfRangeError):
+		// item reported the ID already
+	default:
+		RepositoryErrorResponse(w, err, "could not validate item")
+		return
+	}
+	if len(errs) > 0 {
+		problem := newProblem(ValidationFailedCode)
+		problem.Errors, problem.ConflictingID = errs, conflictingID
+		ProblemResponse(w, problem)
+		return
+	}
+
+	SuccessResponse(w, normalized)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_validateNewItem(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "Lamp", ExternalID: "sku-1"})
+	setupExternalIDs()
+	setupUniqueNames(Config{UniqueNames: true})
+	isolate(t, &clientIDs, true)
+	isolate(t, &itemClock, func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) })
+
+	assertJSON(t, api.Request("POST", "/items/validate", `{"name": "Crème Brûlée", "price": "9.99", "currency": "EUR", "slug": "mine", "ttl": "1h"}`), http.StatusOK,
+		`{"id": 1, "name": "Crème Brûlée", "description": "", "price": "9.99", "currency": "EUR", "slug": "creme-brulee",
+		  "delete_at": "2024-05-01T13:00:00Z", "created_at": "2024-05-01T12:00:00Z"}`)
+
+	tests := []struct {
+		name     string
+		body     string
+		fields   []string
+		conflict bool
+	}{
+		{"invalid", `{"name": "", "quantity": -1, "ttl": "soon"}`, []string{"name", "quantity", "ttl"}, false},
+		{"name taken", `{"name": "lamp"}`, []string{"name"}, true},
+		{"external ID taken", `{"name": "Desk", "external_id": "sku-1"}`, []string{"external_id"}, true},
+		{"ID taken", `{"id": 0, "name": "Desk"}`, []string{"id"}, false},
+		{"ID too large", `{"id": 9007199254740992, "name": "Desk"}`, []string{"id"}, false},
+		{"ID at the end of int", `{"id": 9223372036854775807, "name": "Desk"}`, []string{"id"}, false},
+		{"invalid and taken", `{"name": "LAMP", "price": "1"}`, []string{"currency", "name"}, true},
+	}
+	for _, tt := range tests {
+		problem := decodeResponse[Problem](t, api.Request("POST", "/items/validate", tt.body), http.StatusUnprocessableEntity)
+		var fields []string
+		for _, err := range problem.Errors {
+			fields = append(fields, err.Field)
+		}
+		if problem.Code != "VALIDATION_FAILED" || !slices.Equal(fields, tt.fields) || (problem.ConflictingID != nil) != tt.conflict {
+			t.Errorf("%s: expected errors for %v, got %+v", tt.name, tt.fields, problem)
+		}
+	}
+
+	if items, _ := itemRepository.List(t.Context(), ItemFilter{}); len(items) != 1 {
+		t.Errorf("expected validating to create nothing, got %+v", items)
+	}
+}
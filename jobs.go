@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Job is a housekeeping task the scheduler runs every interval.
+type Job struct {
+	Name  string
+	Every time.Duration
+	Run   func(ctx context.Context) error
+}
+
+// JobStatus is what /admin/jobs reports about a job.
+type JobStatus struct {
+	Name         string     `json:"name"`
+	Every        string     `json:"every"`
+	Running      bool       `json:"running"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+// scheduler runs jobs in the background, each in its own goroutine. A job
+// that is still running when it is due again skips that run.
+type scheduler struct {
+	mu     sync.Mutex
+	jobs   []Job
+	status map[string]*JobStatus
+}
+
+var jobs = newScheduler()
+
+func newScheduler() *scheduler {
+	return &scheduler{status: map[string]*JobStatus{}}
+}
+
+// Add registers a job; it has to happen before Start.
+func (s *scheduler) Add(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+	s.status[job.Name] = &JobStatus{Name: job.Name, Every: job.Every.String()}
+}
+
+// Start runs every job right away and then every interval, until ctx is done.
+func (s *scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Every)
+	defer ticker.Stop()
+	for {
+		s.run(ctx, job)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *scheduler) run(ctx context.Context, job Job) {
+	start := time.Now().UTC()
+	s.mu.Lock()
+	status := s.status[job.Name]
+	status.Running = true
+	s.mu.Unlock()
+
+	err := job.Run(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := start.Add(job.Every)
+	status.Running = false
+	status.Runs++
+	status.LastRun = &start
+	status.LastDuration = time.Since(start).String()
+	status.NextRun = &next
+	status.LastError = ""
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		log.Printf("job %s failed: %v", job.Name, err)
+	}
+}
+
+// Status lists the jobs in the order they were added.
+func (s *scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		result = append(result, *s.status[job.Name])
+	}
+	return result
+}
+
+// setupJobs registers the housekeeping jobs that are switched on.
+func setupJobs(cfg Config) {
+	// -honeypot-denylist can be switched on by a reload, so this always runs
+	jobs.Add(Job{Name: "denylist-prune", Every: time.Hour, Run: ipDenylist.prune})
+	if cfg.DatasetStatsInterval > 0 {
+		jobs.Add(Job{Name: "dataset-growth-sample", Every: cfg.DatasetStatsInterval, Run: sampleDatasetGrowth})
+	}
+	if cfg.SnapshotPath != "" && cfg.SnapshotInterval > 0 && cfg.Storage == "memory" {
+		jobs.Add(Job{Name: "memory-snapshot", Every: cfg.SnapshotInterval, Run: func(ctx context.Context) error {
+			return writeSnapshot(ctx, cfg.SnapshotPath)
+		}})
+	}
+	if cfg.ScheduleInterval > 0 {
+		jobs.Add(Job{Name: "publish-schedule", Every: cfg.ScheduleInterval, Run: newPublishScheduler().run})
+	}
+	if cfg.ReapInterval > 0 && !readOnly {
+		jobs.Add(Job{Name: "ttl-reaper", Every: cfg.ReapInterval, Run: reapExpiredItems})
+	}
+}
+
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, jobs.Status())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_schedulerRecordsRuns(t *testing.T) {
+	s := newScheduler()
+	ran := make(chan struct{}, 1)
+	s.Add(Job{Name: "failing", Every: time.Hour, Run: func(ctx context.Context) error {
+		ran <- struct{}{}
+		return errors.New("disk full")
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	<-ran
+
+	// the status is updated right after the job returns
+	var status JobStatus
+	for i := 0; i < 100; i++ {
+		if status = s.Status()[0]; status.Runs == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if status.Runs != 1 || status.Failures != 1 || status.LastError != "disk full" || status.Running || status.NextRun == nil {
+		t.Errorf("unexpected job status %+v", status)
+	}
+}
+
+func Test_snapshotRoundTrip(t *testing.T) {
+	defer func(original ItemRepository) { itemRepository = original }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(seedItems...)
+	path := filepath.Join(t.TempDir(), "items.json")
+
+	if err := writeSnapshot(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	items, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, seedItems) {
+		t.Errorf("snapshot returned %v, want %v", items, seedItems)
+	}
+}
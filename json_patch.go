@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPatchMediaType is the media type of the bodies of PATCH /items/{id}.
+const jsonPatchMediaType = "application/json-patch+json"
+
+// PatchOperation is an operation of a JSON Patch (RFC 6902). Only add,
+// remove, replace and test are supported; move and copy are refused.
+type PatchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	Value *json.RawMessage `json:"value"`
+}
+
+var (
+	// errPatchTestFailed is returned when a test operation doesn't hold.
+	errPatchTestFailed = errors.New("test operation failed")
+	// errPatchPath is returned when an operation's path doesn't lead to a
+	// place in the item it can be applied to.
+	errPatchPath = errors.New("path can't be applied to the item")
+)
+
+// jsonPointerUnescaper turns ~1 and ~0 in the tokens of a JSON Pointer back
+// into / and ~.
+var jsonPointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// parseJSONPatch checks that every operation is complete and its path is a
+// JSON Pointer (RFC 6901), and returns the paths split into their unescaped
+// tokens.
+func parseJSONPatch(operations []PatchOperation) ([][]string, bool) {
+	paths := make([][]string, len(operations))
+	for i, operation := range operations {
+		switch operation.Op {
+		case "add", "replace", "test":
+			if operation.Value == nil {
+				return nil, false
+			}
+		case "remove":
+		default:
+			return nil, false
+		}
+		if operation.Path == "" || !strings.HasPrefix(operation.Path, "/") {
+			// the whole item can't be added, removed or replaced
+			return nil, false
+		}
+		tokens := strings.Split(operation.Path[1:], "/")
+		for j, token := range tokens {
+			tokens[j] = jsonPointerUnescaper.Replace(token)
+		}
+		paths[i] = tokens
+	}
+	return paths, true
+}
+
+// applyJSONPatch applies the operations one after the other to the item as a
+// JSON document. When one fails, the document is half patched and has to be
+// thrown away.
+func applyJSONPatch(document map[string]any, operations []PatchOperation, paths [][]string) error {
+	for i, operation := range operations {
+		var value any
+		if operation.Value != nil {
+			if err := json.Unmarshal(*operation.Value, &value); err != nil {
+				return errPatchPath
+			}
+		}
+		var err error
+		switch operation.Op {
+		case "test":
+			var current any
+			current, err = patchGet(document, paths[i])
+			if err == nil && !reflect.DeepEqual(current, value) {
+				err = errPatchTestFailed
+			}
+		default:
+			var patched any
+			patched, err = patchApply(document, paths[i], operation.Op, value)
+			if err == nil {
+				document = patched.(map[string]any)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchGet returns the value the tokens point at.
+func patchGet(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch container := node.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, errPatchPath
+			}
+			node = value
+		case []any:
+			index, err := patchIndex(token, len(container))
+			if err != nil || index == len(container) {
+				return nil, errPatchPath
+			}
+			node = container[index]
+		default:
+			return nil, errPatchPath
+		}
+	}
+	return node, nil
+}
+
+// patchApply adds, removes or replaces the value the tokens point at in
+// node, and returns node with the change, as arrays may have to grow.
+func patchApply(node any, tokens []string, op string, value any) (any, error) {
+	token, rest := tokens[0], tokens[1:]
+	switch container := node.(type) {
+	case map[string]any:
+		child, ok := container[token]
+		if len(rest) > 0 {
+			if !ok {
+				return nil, errPatchPath
+			}
+			patched, err := patchApply(child, rest, op, value)
+			if err != nil {
+				return nil, err
+			}
+			container[token] = patched
+			return container, nil
+		}
+		switch {
+		case op == "add":
+			container[token] = value
+		case !ok:
+			return nil, errPatchPath
+		case op == "remove":
+			delete(container, token)
+		default:
+			container[token] = value
+		}
+		return container, nil
+	case []any:
+		index, err := patchIndex(token, len(container))
+		if err != nil || (index == len(container) && (len(rest) > 0 || op != "add")) {
+			return nil, errPatchPath
+		}
+		if len(rest) > 0 {
+			patched, err := patchApply(container[index], rest, op, value)
+			if err != nil {
+				return nil, err
+			}
+			container[index] = patched
+			return container, nil
+		}
+		switch op {
+		case "add":
+			return append(container[:index], append([]any{value}, container[index:]...)...), nil
+		case "remove":
+			return append(container[:index], container[index+1:]...), nil
+		default:
+			container[index] = value
+			return container, nil
+		}
+	default:
+		return nil, errPatchPath
+	}
+}
+
+// patchIndex parses an array index, where "-" stands for the end of the
+// array.
+func patchIndex(token string, length int) (int, error) {
+	if token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > length || (len(token) > 1 && token[0] == '0') {
+		return 0, errPatchPath
+	}
+	return index, nil
+}
+
+// patchItem applies the JSON Patch in the body to the item pointed at by
+// {id}. The operations work on the item as GET /items/{id} returns it, before
+// localization; the fields clients can't set stay as they are whatever the
+// patch does to them. Either every operation is applied or none: a failed
+// test operation answers 409, a path that leads nowhere 422, and so does an
+// item the patch leaves invalid.
+func patchItem(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil || *id < 0 {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	var operations []PatchOperation
+	if err := decodeBody(r, &operations); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	paths, ok := parseJSONPatch(operations)
+	if !ok {
+		ErrorCodeResponse(w, InvalidJSONPatchCode)
+		return
+	}
+
+	var item Item
+	var invalid []FieldError
+	err = writeTx(r, func(tx ItemRepository) error {
+		stored, err := tx.Get(r.Context(), *id)
+		if err != nil {
+			return err
+		}
+		document, err := jsonFields(*stored)
+		if err != nil {
+			return err
+		}
+		if err := applyJSONPatch(document, operations, paths); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		item = Item{}
+		if err := json.Unmarshal(encoded, &item); err != nil {
+			return errPatchPath
+		}
+		item.ID = stored.ID
+		keepManagedFields(&item, *stored)
+		if invalid = validateItem(item); len(invalid) > 0 {
+			return errItemInvalid
+		}
+		return tx.Update(r.Context(), item)
+	})
+	switch {
+	case errors.Is(err, errPatchTestFailed):
+		ErrorCodeResponse(w, JSONPatchTestFailedCode)
+	case errors.Is(err, errPatchPath):
+		ErrorCodeResponse(w, JSONPatchUnprocessableCode)
+	case errors.Is(err, errItemInvalid):
+		ValidationErrorResponse(w, invalid)
+	case err != nil:
+		RepositoryErrorResponse(w, err, "could not patch item")
+	case isDryRun(r):
+		DryRunResponse(w, DryRunUpdate, item)
+	default:
+		SuccessResponse(w, item)
+	}
+}
+
+// errItemInvalid aborts a write whose item doesn't pass validation.
+var errItemInvalid = errors.New("item invalid")
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_patchItem(t *testing.T) {
+	api := newTestAPI(t, Item{
+		ID: 0, Name: "lamp", Description: "desk", Price: "9.99", Currency: "EUR", Slug: "lamp",
+		Translations: map[string]Translation{"de": {Name: "Lampe"}},
+	})
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		return api.Request("PATCH", "/items/0", body, "Content-Type", contentType)
+	}
+	stored := func() Item {
+		item, _ := api.Items.Get(context.Background(), 0)
+		return *item
+	}
+
+	w := patch(jsonPatchMediaType, `[
+		{"op": "test", "path": "/name", "value": "lamp"},
+		{"op": "replace", "path": "/name", "value": "floor lamp"},
+		{"op": "add", "path": "/quantity", "value": 3},
+		{"op": "remove", "path": "/description"},
+		{"op": "replace", "path": "/slug", "value": "ignored"},
+		{"op": "replace", "path": "/translations/de/name", "value": "Stehlampe"}
+	]`)
+	item := stored()
+	if w.Code != http.StatusOK || item.Name != "floor lamp" || item.Quantity != 3 || item.Description != "" || item.Slug != "lamp" {
+		t.Errorf("expected the patch to be applied but for the slug, got %d %+v", w.Code, item)
+	}
+	if item.Translations["de"].Name != "Lampe" {
+		t.Errorf("expected the translations to stay as they are, got %+v", item.Translations)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"failed test", jsonPatchMediaType, `[{"op":"replace","path":"/name","value":"x"},{"op":"test","path":"/price","value":"1.00"}]`, http.StatusConflict},
+		{"missing path", jsonPatchMediaType, `[{"op":"replace","path":"/nothing/here","value":1}]`, http.StatusUnprocessableEntity},
+		{"wrong type", jsonPatchMediaType, `[{"op":"replace","path":"/quantity","value":"many"}]`, http.StatusUnprocessableEntity},
+		{"invalid item", jsonPatchMediaType, `[{"op":"remove","path":"/name"}]`, http.StatusUnprocessableEntity},
+		{"unsupported op", jsonPatchMediaType, `[{"op":"move","from":"/name","path":"/description"}]`, http.StatusBadRequest},
+		{"no value", jsonPatchMediaType, `[{"op":"add","path":"/quantity"}]`, http.StatusBadRequest},
+		{"not a patch", jsonPatchMediaType, `{"name":"x"}`, http.StatusBadRequest},
+		{"plain JSON", "application/json", `[]`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		if w := patch(tt.contentType, tt.body); w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.status, w.Code, w.Body)
+		}
+	}
+	if item := stored(); item.Name != "floor lamp" || item.Quantity != 3 {
+		t.Errorf("expected failed patches to change nothing, got %+v", item)
+	}
+}
+
+func Test_applyJSONPatchArrays(t *testing.T) {
+	document := map[string]any{"tags": []any{"a", "c"}}
+	operations := []PatchOperation{
+		{Op: "add", Path: "/tags/1", Value: rawJSON(`"b"`)},
+		{Op: "add", Path: "/tags/-", Value: rawJSON(`"d"`)},
+		{Op: "remove", Path: "/tags/0"},
+		{Op: "test", Path: "/tags", Value: rawJSON(`["b","c","d"]`)},
+		{Op: "add", Path: "/a~1b~0c", Value: rawJSON(`true`)},
+	}
+	paths, ok := parseJSONPatch(operations)
+	if !ok {
+		t.Fatal("expected the patch to parse")
+	}
+	if err := applyJSONPatch(document, operations, paths); err != nil {
+		t.Fatal(err)
+	}
+	if document["a/b~c"] != true {
+		t.Errorf("expected escaped tokens to be unescaped, got %v", document)
+	}
+	for _, path := range []string{"/tags/5", "/tags/01", "/tags/-"} {
+		operations := []PatchOperation{{Op: "replace", Path: path, Value: rawJSON(`"x"`)}}
+		paths, _ := parseJSONPatch(operations)
+		if err := applyJSONPatch(document, operations, paths); err != errPatchPath {
+			t.Errorf("%s: expected the path to be refused, got %v", path, err)
+		}
+	}
+}
+
+func rawJSON(s string) *json.RawMessage {
+	raw := json.RawMessage(s)
+	return &raw
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// streamFlushEvery is how many elements StreamJSONResponse writes between
+// flushes.
+const streamFlushEvery = 100
+
+// StreamJSONResponse answers 200 with payload like SuccessResponse does, but
+// encodes a slice one element at a time, flushing every streamFlushEvery
+// elements, so a large listing isn't held in memory a second time as JSON and
+// reaches the client as it is written. It stops when the client goes away. Indented
+// responses are written the usual way.
+func StreamJSONResponse(w http.ResponseWriter, r *http.Request, payload interface{}) {
+	value := reflect.ValueOf(payload)
+	format := findFormatWriter(w)
+	if value.Kind() != reflect.Slice || format != nil && format.pretty {
+		SuccessResponse(w, payload)
+		return
+	}
+
+	contentType, prefix, suffix := "application/json", "", ""
+	if format != nil && format.envelope {
+		contentType, prefix, suffix = envelopeContentType, `{"data":`, "}"
+		if format.meta != nil {
+			meta, _ := json.Marshal(format.meta)
+			suffix = `,"meta":` + string(meta) + "}"
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if value.IsNil() {
+		io.WriteString(w, prefix+"null"+suffix)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	if _, err := io.WriteString(w, prefix+"["); err != nil {
+		return
+	}
+	for i := range value.Len() {
+		if r.Context().Err() != nil {
+			return
+		}
+		if i > 0 && i%streamFlushEvery == 0 {
+			controller.Flush()
+		}
+		element, err := json.Marshal(value.Index(i).Interface())
+		if err != nil {
+			// the status is out already; all that's left is cutting the
+			// response short
+			log.Printf("encoding element %d of %s failed: %v", i, r.URL.Path, err)
+			return
+		}
+		if i > 0 {
+			element = append([]byte{','}, element...)
+		}
+		if _, err := w.Write(element); err != nil {
+			return
+		}
+	}
+	io.WriteString(w, "]"+suffix)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_StreamJSONResponse(t *testing.T) {
+	items := make([]Item, 250)
+	for i := range items {
+		items[i] = Item{ID: i, Name: "item"}
+	}
+	expected, _ := json.Marshal(items)
+	meta := ResponseMeta{Total: 300, Limit: 250}
+	enveloped, _ := json.Marshal(Envelope{Data: items, Meta: &meta})
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		accept   string
+		payload  interface{}
+		expected string
+	}{
+		{"plain", Config{}, "", items, string(expected)},
+		{"enveloped", Config{}, envelopeContentType, items, string(enveloped)},
+		{"empty", Config{}, "", []Item{}, `[]`},
+		{"nil", Config{}, "", []Item(nil), `null`},
+		{"not a slice", Config{}, "", map[string]int{"a": 1}, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		handler := responseFormatMiddleware(tt.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetResponseMeta(w, meta)
+			StreamJSONResponse(w, r, tt.payload)
+		}))
+		r := httptest.NewRequest("GET", "/items/", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != tt.expected {
+			t.Errorf("%s: expected the same body as a single marshal, got %d %.200s", tt.name, w.Code, w.Body)
+		}
+	}
+}
+
+// slowElement blocks marshaling until release is closed, counting how many
+// elements were encoded.
+type slowElement struct {
+	release <-chan struct{}
+	encoded *atomic.Int32
+}
+
+func (e slowElement) MarshalJSON() ([]byte, error) {
+	<-e.release
+	e.encoded.Add(1)
+	return []byte(`{}`), nil
+}
+
+func Test_StreamJSONResponseFlushes(t *testing.T) {
+	release := make(chan struct{})
+	var encoded atomic.Int32
+	elements := make([]slowElement, 2*streamFlushEvery)
+	for i := range elements {
+		elements[i] = slowElement{release: release, encoded: &encoded}
+		if i < streamFlushEvery {
+			elements[i].release = closedChannel()
+		}
+	}
+	handler := timeoutMiddleware(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamJSONResponse(w, r, elements)
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the first elements arrive while the handler still waits on the rest,
+	// through the buffering of the timeout middleware
+	if first, err := bufio.NewReader(resp.Body).ReadByte(); err != nil || first != '[' {
+		t.Fatalf("expected the start of the array, got %q %v", first, err)
+	}
+	if got := encoded.Load(); got != streamFlushEvery {
+		t.Errorf("expected the first %d elements to be out before the rest, got %d", streamFlushEvery, got)
+	}
+	close(release)
+}
+
+func Test_StreamJSONResponseStopsForGoneClients(t *testing.T) {
+	var encoded atomic.Int32
+	elements := make([]slowElement, 1000)
+	for i := range elements {
+		elements[i] = slowElement{release: closedChannel(), encoded: &encoded}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancelingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel, after: 10}
+	StreamJSONResponse(w, httptest.NewRequest("GET", "/items/", nil).WithContext(ctx), elements)
+	if got := encoded.Load(); got > 11 {
+		t.Errorf("expected encoding to stop once the client was gone, encoded %d", got)
+	}
+}
+
+func Test_timeoutMiddlewareAfterFlushing(t *testing.T) {
+	handler := timeoutMiddleware(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("started"))
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+		w.Write([]byte("too late"))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/items/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "started" {
+		t.Errorf("expected a flushed response to be cut short rather than replaced, got %d %q", w.Code, w.Body)
+	}
+}
+
+// cancelingWriter cancels the request after a number of writes, like a
+// client hanging up.
+type cancelingWriter struct {
+	*httptest.ResponseRecorder
+	cancel func()
+	after  int
+}
+
+func (w *cancelingWriter) Write(b []byte) (int, error) {
+	if w.after--; w.after == 0 {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func closedChannel() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
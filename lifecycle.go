@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// connectionDrainer keeps track of long-lived responses (streams, long polls)
+// that would otherwise keep srv.Shutdown waiting until the graceful timeout
+// runs out. On shutdown they are told to wrap up, so they can send their
+// client a final message with a reconnect hint and return.
+type connectionDrainer struct {
+	mu      sync.Mutex
+	closing chan struct{}
+	active  sync.WaitGroup
+}
+
+func newConnectionDrainer() *connectionDrainer {
+	return &connectionDrainer{closing: make(chan struct{})}
+}
+
+var drainer = newConnectionDrainer()
+
+// Track registers a long-lived response. The returned channel is closed when
+// the server starts shutting down; done must be called once the handler is
+// finished with its client.
+func (d *connectionDrainer) Track() (closing <-chan struct{}, done func()) {
+	d.active.Add(1)
+	var once sync.Once
+	return d.closing, func() { once.Do(d.active.Done) }
+}
+
+// Drain tells every tracked response to finish and waits for them, or for ctx
+// to expire.
+func (d *connectionDrainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	select {
+	case <-d.closing:
+	default:
+		close(d.closing)
+	}
+	d.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		d.active.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -4,20 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"flag"
+	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/hashicorp/raft"
 )
 
 var (
 	NotFoundError = errors.New("not found")
+	IDTakenError  = errors.New("ID taken")
 )
 
 type PingResponse struct {
@@ -25,12 +32,52 @@ type PingResponse struct {
 }
 
 type Item struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID int `json:"id" bson:"id"`
+	// UUID identifies the item with -id-format uuidv7, see uuidRepository.
+	UUID string `json:"uuid,omitempty" bson:"uuid,omitempty"`
+	// ExternalID is the item's key in another system, given when the item
+	// is created. No two items have the same one.
+	ExternalID  string `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	Name        string `json:"name" bson:"name"`
+	Description string `json:"description" bson:"description"`
+	Quantity    int    `json:"quantity,omitempty" bson:"quantity"`
+	// Price is a decimal string like "9.99", so no precision is lost on the
+	// way through JSON or a backend, in the ISO 4217 Currency.
+	Price    string `json:"price,omitempty" bson:"price,omitempty"`
+	Currency string `json:"currency,omitempty" bson:"currency,omitempty"`
+	// Slug is generated from the name when the item is created, see slugify.
+	Slug string `json:"slug,omitempty" bson:"slug,omitempty"`
+	// Translations holds the name and description in other languages, by
+	// BCP 47 language tag.
+	Translations map[string]Translation `json:"translations,omitempty" bson:"translations,omitempty"`
+	// PublishAt and ExpiresAt limit when the item is listed, see
+	// Item.PublishedAt.
+	PublishAt *time.Time `json:"publish_at,omitempty" bson:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// DeleteAt is when the reaper deletes the item, set from the ttl it was
+	// created with.
+	DeleteAt *time.Time `json:"delete_at,omitempty" bson:"delete_at,omitempty"`
+	// ArchivedAt is set while the item is archived, see archiveItem.
+	ArchivedAt *time.Time `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	// Position orders the items for ?sort=position, from 1 up; 0 until the
+	// items are first moved, see moveItem.
+	Position int `json:"position,omitempty" bson:"position,omitempty"`
+	// Rating sums up the ratings of the users, see rateItem.
+	Rating *Rating `json:"rating,omitempty" bson:"rating,omitempty"`
+	// CreatedAt is when the item was created. Items created before it was
+	// recorded have none.
+	CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
 }
 
-var itemRepository = []Item{
+// itemClock tells the time items are created at; tests stop it.
+var itemClock = time.Now
+
+// clientIDs lets clients choose the IDs of the items they create, see
+// -client-ids.
+var clientIDs bool
+
+// seedItems are the items the in-memory repository starts out with.
+var seedItems = []Item{
 	{
 		ID:          0,
 		Name:        "first",
@@ -43,49 +90,373 @@ var itemRepository = []Item{
 	},
 }
 
+var itemRepository ItemRepository = NewInMemoryItemRepository(seedItems...)
+
 func main() {
-	var wait time.Duration
-	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
-	flag.Parse()
+	cfg := parseConfig()
+	if err := setupSecrets(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupAPITokens(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupUsers(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.CreateAdminToken != "" {
+		created, err := apiTokens.Create(cfg.CreateAdminToken, []string{ScopeAdmin}, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("created API token %s: %s\n", created.ID, created.Secret)
+		return
+	}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/ping", ping).Methods(http.MethodGet)
+	repo, err := newItemRepository(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	itemRepository = repo
+	if err := setupEncryption(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupUUIDs(cfg); err != nil {
+		log.Fatal(err)
+	}
+	setupExternalIDs()
+	setupUniqueNames(cfg)
+	clientIDs = cfg.ClientIDs
+	readOnly = cfg.ReadOnly
+	if err := setupMetrics(cfg); err != nil {
+		log.Fatal(err)
+	}
+	setupResilience(cfg)
+	setupCache(cfg)
+	if err := setupSearch(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupSuggestions(); err != nil {
+		log.Fatal(err)
+	}
+	// a replica stores, indexes and caches the changes of its primary, but
+	// doesn't audit or announce them again
+	replicated := itemRepository
+	if err := setupAudit(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Reindex {
+		if searchIndex == nil {
+			log.Fatal("-reindex needs a search index, see -search")
+		}
+		indexed, err := reindex(context.Background(), searchIndex, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("reindexed %d items", indexed)
+		return
+	}
+
+	if err := setupNotifications(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupConditionalLists(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupReplication(cfg, replicated); err != nil {
+		log.Fatal(err)
+	}
+	setupRateLimits()
+	if err := setupUsage(cfg); err != nil {
+		log.Fatal(err)
+	}
+	setupRecordings(cfg)
+	if err := setupChaos(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupFixtures(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupExports(cfg); err != nil {
+		log.Fatal(err)
+	}
+	setupJobs(cfg)
+	if err := setupKafka(cfg); err != nil {
+		log.Fatal(err)
+	}
+	jobs.Start(context.Background())
+
+	srv := &http.Server{
+		Addr:         "0.0.0.0:8000",
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+		Handler:      &publicHandler,
+	}
+
+	serveConfig(cfg)
+	servers := []*http.Server{srv}
+	if cfg.AdminAddr != "" {
+		servers = append(servers, &http.Server{
+			Addr:         cfg.AdminAddr,
+			WriteTimeout: time.Minute, // long enough for a 30s CPU profile
+			ReadTimeout:  time.Second * 15,
+			IdleTimeout:  time.Second * 60,
+			Handler:      &adminHandler,
+		})
+	}
+	for _, server := range servers {
+		serve(server)
+	}
+	notifyReady()
+
+	waitUntilShutdown(cfg.RestartTimeout)
+	gracefulShutdown(cfg.GracefulTimeout, servers...)
+}
+
+// serve starts srv on its listening socket in the background.
+func serve(srv *http.Server) {
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// newRouter serves the API under cfg.BasePath. The honeypots stay at the root,
+// where scanners look for them.
+func newRouter(cfg Config) *mux.Router {
+	root := mux.NewRouter()
+	r := root
+	if cfg.BasePath != "" {
+		r = root.PathPrefix(cfg.BasePath).Subrouter()
+	}
+	// itemGuards decide who may read and change items, on /items and on the
+	// routes next to it that serve items too
+	var itemGuards []mux.MiddlewareFunc
+	if readOnly {
+		itemGuards = append(itemGuards, readOnlyMiddleware)
+	}
+	itemGuards = append(itemGuards, maintenanceMiddleware)
+	if cfg.RequireAPIToken {
+		itemGuards = append(itemGuards, requireScope(itemScope))
+	}
+	r.Handle("/ping", timeoutMiddleware(cfg.RouteTimeouts.For("ping", cfg.RouteTimeout))(http.HandlerFunc(ping))).Methods(http.MethodGet)
+	r.HandleFunc("/errors", listErrorCodes).Methods(http.MethodGet)
+	// jobs report on items, so they take the item guards, but not the item
+	// routes' UUID check, as their IDs are no item IDs
+	jobRoutes := r.PathPrefix("/jobs").Subrouter()
+	asyncJobRoute = jobRoutes.HandleFunc("/{id}", getAsyncJob).Methods(http.MethodGet)
+	jobRoutes.Use(itemGuards...)
+	if users != nil {
+		registerAuthRoutes(r)
+		r.HandleFunc("/me/starred", myStarred).Methods(http.MethodGet)
+	}
+	if rateLimits.on() {
+		r.HandleFunc("/me/usage", myUsage).Methods(http.MethodGet)
+	}
 	itemRoutes := r.PathPrefix("/items").Subrouter()
+	if searchIndex != nil {
+		itemRoutes.HandleFunc("/search", searchItems).Methods(http.MethodGet, http.MethodOptions)
+	}
+	if suggestIndex != nil {
+		itemRoutes.HandleFunc("/suggest", suggestItems).Methods(http.MethodGet, http.MethodOptions)
+	}
+	if cfg.AdminAddr == "" {
+		registerAdminRoutes(r, true)
+	}
+	if itemEvents != nil {
+		changes := r.PathPrefix("/changes").Subrouter()
+		changes.HandleFunc("", listChanges).Methods(http.MethodGet)
+		changes.Use(itemGuards...)
+		itemRoutes.HandleFunc("/{id}/events", listItemEvents).Methods(http.MethodGet, http.MethodOptions)
+		itemRoutes.HandleFunc("/{id}/diff", diffItemRevision).Methods(http.MethodGet, http.MethodOptions)
+	}
+	itemRoutes.HandleFunc("/export.xlsx", exportItems).Methods(http.MethodGet, http.MethodOptions)
+	if exportFiles != nil {
+		itemRoutes.HandleFunc("/exports/{export:[0-9a-f]{32}}.xlsx", getExport).Methods(http.MethodGet, http.MethodOptions)
+	}
+	itemRoutes.HandleFunc("/import.xlsx", importItems).Methods(http.MethodPost, http.MethodOptions)
+	validateRoute = itemRoutes.HandleFunc("/validate", validateNewItem).Methods(http.MethodPost, http.MethodOptions)
+	itemRoutes.HandleFunc("/random", getRandomItem).Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/stats", getItemStats).Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/by-slug/{slug}", getItemBySlug).Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/by-external-id/{key}", getItemByExternalID).Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}/translations/{lang}", putItemTranslation).Methods(http.MethodPut, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}/archive", archiveItem).Methods(http.MethodPost, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}/unarchive", unarchiveItem).Methods(http.MethodPost, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}/move", moveItem).Methods(http.MethodPost, http.MethodOptions)
+	if users != nil {
+		itemRoutes.HandleFunc("/{id}/ratings", rateItem).Methods(http.MethodPost, http.MethodOptions)
+		itemRoutes.HandleFunc("/{id}/star", starItem).Methods(http.MethodPut, http.MethodOptions)
+		itemRoutes.HandleFunc("/{id}/star", unstarItem).Methods(http.MethodDelete, http.MethodOptions)
+	}
+	itemRoutes.HandleFunc("/{id}/diff/{other}", diffTwoItems).Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}/related", getRelatedItems).Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}/merge", mergeItem).Methods(http.MethodPost, http.MethodOptions)
 	itemRoutes.HandleFunc("/{id}/duplicate", duplicateItem).Methods(http.MethodPost, http.MethodOptions)
 	itemRoutes.HandleFunc("/{id}", getItem).Methods(http.MethodGet, http.MethodOptions)
 	itemRoutes.HandleFunc("/{id}", deleteItem).Methods(http.MethodDelete, http.MethodOptions)
 	itemRoutes.HandleFunc("/{id}", updateItem).Methods(http.MethodPut, http.MethodOptions)
+	itemRoutes.HandleFunc("/{id}", patchItem).Methods(http.MethodPatch, http.MethodOptions)
 	itemRoutes.HandleFunc("/", createItem).Methods(http.MethodPost, http.MethodOptions)
-	itemRoutes.HandleFunc("/", listItems).Queries("filter", "{filter}").Methods(http.MethodGet, http.MethodOptions)
+	itemRoutes.HandleFunc("/", listItems).Methods(http.MethodGet, http.MethodOptions)
 	itemRoutes.HandleFunc("/", routeDoesNotExist)
-	r.Use(loggingMiddleware)
-	r.Use(mux.CORSMethodMiddleware(r))
-
-	srv := &http.Server{
-		Addr:         "0.0.0.0:8000",
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      r,
+	itemRoutes.Use(timeoutMiddleware(cfg.RouteTimeouts.For("items", cfg.RouteTimeout)))
+	if uuidIDs {
+		itemRoutes.Use(uuidRouteMiddleware)
+	}
+	if clusterNode != nil {
+		itemRoutes.Use(leaderForwardingMiddleware)
+	}
+	itemRoutes.Use(itemGuards...)
+	registerHoneypots(root, ipDenylist, cfg.HoneypotDenylist)
+	root.Use(loggingMiddleware)
+	if fixtures := fixturesMiddleware(cfg); fixtures != nil {
+		root.Use(fixtures)
+	}
+	if recordings != nil {
+		root.Use(recordingMiddleware(recordings))
+	}
+	if cfg.Chaos.enabled() {
+		root.Use(chaosMiddleware(cfg.Chaos))
+	}
+	root.Use(metricsMiddleware)
+	root.Use(responseFormatMiddleware(cfg))
+	root.Use(contentNegotiationMiddleware(cfg.LenientMediaTypes))
+	root.Use(denylistMiddleware(ipDenylist))
+	if apiTokens != nil {
+		root.Use(apiTokenMiddleware(apiTokens))
+	}
+	if users != nil {
+		root.Use(accessTokenMiddleware(users))
+	}
+	if usage != nil {
+		root.Use(usageMiddleware(usage))
+	}
+	if rateLimits.on() {
+		root.Use(rateLimitMiddleware(rateLimits))
 	}
+	root.Use(mux.CORSMethodMiddleware(root))
 
-	go log.Fatal(srv.ListenAndServe())
+	return root
+}
 
-	waitUntilShutdown()
-	gracefulShutdown(srv, wait)
+// newAdminRouter serves the operational endpoints on their own listener,
+// which -admin-addr keeps off the public network.
+func newAdminRouter(cfg Config) *mux.Router {
+	r := mux.NewRouter()
+	registerAdminRoutes(r, false)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	r.Use(loggingMiddleware)
+	r.Use(responseFormatMiddleware(cfg))
+	return r
 }
 
-func waitUntilShutdown() {
+// registerAdminRoutes adds the operational endpoints: health, statistics,
+// maintenance mode, backups and maintenance of the search index.
+// On the public listener, for an empty -admin-addr, all but the health check
+// and the metrics take a token or user with the admin scope.
+func registerAdminRoutes(r *mux.Router, public bool) {
+	r.HandleFunc("/ready", ready).Methods(http.MethodGet)
+	if metricsHandler != nil {
+		r.Handle("/metrics", metricsHandler).Methods(http.MethodGet)
+	}
+	if apiTokens != nil {
+		registerAPITokenRoutes(r)
+	}
+	if public {
+		r = r.NewRoute().Subrouter()
+		r.Use(requireScope(adminScope))
+	}
+	r.HandleFunc("/admin/dataset-stats", datasetStats).Methods(http.MethodGet)
+	r.HandleFunc("/admin/jobs", listJobs).Methods(http.MethodGet)
+	if auditLog != nil {
+		r.HandleFunc("/audit", listAudit).Methods(http.MethodGet)
+		r.HandleFunc("/audit.ndjson", listAudit).Methods(http.MethodGet)
+	}
+	if recordings != nil {
+		r.HandleFunc("/admin/recordings", listRecordings).Methods(http.MethodGet)
+		r.HandleFunc("/admin/recordings", clearRecordings).Methods(http.MethodDelete)
+	}
+	if usage != nil {
+		r.HandleFunc("/admin/usage", usageReport).Methods(http.MethodGet)
+		r.HandleFunc("/admin/usage.csv", usageReport).Methods(http.MethodGet)
+	}
+	if clusterNode != nil {
+		r.HandleFunc("/cluster/status", clusterStatus).Methods(http.MethodGet)
+	}
+	if itemEvents != nil {
+		r.HandleFunc("/replication/changes", replicationChanges).Methods(http.MethodGet)
+	}
+	if replication != nil {
+		r.HandleFunc("/admin/replication", replicationStatus).Methods(http.MethodGet)
+	}
+	r.HandleFunc("/admin/maintenance", getMaintenance).Methods(http.MethodGet)
+	r.HandleFunc("/admin/maintenance", setMaintenance).Methods(http.MethodPut)
+	r.HandleFunc("/admin/backup", backupItems).Methods(http.MethodGet)
+	if exportFiles != nil {
+		r.HandleFunc("/admin/backups/{export:[0-9a-f]{32}}.json", getBackup).Methods(http.MethodGet)
+	}
+	r.HandleFunc("/admin/restore", restoreItems).Methods(http.MethodPost)
+	r.HandleFunc("/admin/reset", resetItems).Methods(http.MethodPost)
+	r.HandleFunc("/admin/config/reload", reloadConfigHandler).Methods(http.MethodPost)
+	if searchIndex != nil {
+		r.HandleFunc("/admin/search/rebuild", rebuildSearchIndex).Methods(http.MethodPost)
+	}
+}
+
+// waitUntilShutdown returns on SIGINT or SIGTERM, or once a restart asked for
+// with SIGUSR2 has started a successor that took over the listening sockets.
+// SIGHUP reloads the configuration meanwhile.
+func waitUntilShutdown(restartTimeout time.Duration) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	restart := notifyRestart()
+	for {
+		select {
+		case <-c:
+			return
+		case <-hup:
+			if err := reloadConfig(); err != nil {
+				log.Println("configuration not reloaded, keeping the old one:", err)
+				continue
+			}
+			log.Println("configuration reloaded")
+		case <-restart:
+			if err := startSuccessor(restartTimeout); err != nil {
+				log.Println("restart failed, keeping this process running:", err)
+				continue
+			}
+			log.Println("successor started")
+			return
+		}
+	}
 }
 
-func gracefulShutdown(srv *http.Server, wait time.Duration) {
+func gracefulShutdown(wait time.Duration, servers ...*http.Server) {
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
-	srv.Shutdown(ctx)
 	log.Println("shutting down")
+	// Long-lived responses never become idle by themselves, so they are asked
+	// to finish first; otherwise Shutdown would wait out the whole window.
+	if err := drainer.Drain(ctx); err != nil {
+		log.Println("not all long-lived connections finished in time:", err)
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("graceful shutdown did not complete:", err)
+		}
+	}
 	os.Exit(0)
 }
 
@@ -93,124 +464,491 @@ func ping(w http.ResponseWriter, r *http.Request) {
 	SuccessResponse(w, PingResponse{Ping: "Pong"})
 }
 
+// listItems returns all items matching the filter, or a page of them when
+// limit and/or offset are given. A client whose copy of the list is current
+// gets a 304, see listNotModified. With ?snapshot=true the listing is kept,
+// and the pages of ?snapshot={id} come from it, whatever is written
+// meanwhile; the snapshot keeps the filter and order it was taken with.
 func listItems(w http.ResponseWriter, r *http.Request) {
-	var filter string
-	vars := mux.Vars(r)
-	if val, ok := vars["filter"]; ok {
-		filter = val
+	params := r.URL.Query()
+	filter, ok := requestItemFilter(w, r)
+	if !ok {
+		return
+	}
+	limit, offset := 0, 0
+	if value := params.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			ErrorCodeResponse(w, InvalidLimitCode)
+			return
+		}
+	}
+	if value := params.Get("offset"); value != "" {
+		var err error
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			ErrorCodeResponse(w, InvalidOffsetCode)
+			return
+		}
 	}
 
-	if len(filter) == 0 {
-		SuccessResponse(w, itemRepository)
+	sort := params.Get("sort")
+	if sort != "" && sort != "id" && sort != "position" && sort != "-rating" {
+		ErrorCodeResponse(w, InvalidSortCode)
 		return
 	}
-	var result []Item
-	for _, item := range itemRepository {
-		if strings.Contains(item.Name, filter) {
-			result = append(result, item)
+
+	var items []Item
+	switch snapshot := params.Get("snapshot"); snapshot {
+	case "", "false":
+		if listNotModified(w, r) {
+			return
+		}
+		var err error
+		if items, err = listSorted(r, filter, sort); err != nil {
+			RepositoryErrorResponse(w, err, "could not list items")
+			return
+		}
+	case "true":
+		var err error
+		if items, err = listSorted(r, filter, sort); err != nil {
+			RepositoryErrorResponse(w, err, "could not list items")
+			return
+		}
+		id, err := pagingSnapshots.Take(items, filter.PublishedAt.IsZero())
+		if err != nil {
+			ErrorCodeResponse(w, SnapshotsFullCode)
+			return
+		}
+		w.Header().Set("X-Snapshot-Id", id)
+		// the links lead to the pages of the snapshot
+		params.Set("snapshot", id)
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = params.Encode()
+	default:
+		taken, ok := pagingSnapshots.Get(snapshot)
+		if !ok {
+			ErrorCodeResponse(w, SnapshotNotFoundCode)
+			return
+		}
+		if caller := requestCaller(r); taken.unpublished && (caller == nil || !caller.HasScope(ScopeAdmin)) {
+			ErrorCodeResponse(w, MissingScopeCode)
+			return
+		}
+		w.Header().Set("X-Snapshot-Id", snapshot)
+		items = taken.items
+	}
+
+	total := len(items)
+	if limit == 0 {
+		limit = total
+	}
+	if offset > total {
+		offset = total
+	}
+	page := items[offset:]
+	if len(page) > limit {
+		page = page[:limit]
+	}
+
+	// localizing changes the items, which may belong to a snapshot
+	page = slices.Clone(page)
+	localizeItems(w, r, page)
+	meta := ResponseMeta{Total: total, Limit: limit, Offset: offset}
+	SetResponseMeta(w, meta)
+	if params.Has("limit") || params.Has("offset") {
+		SetPageLinks(w, r, meta)
+	}
+	StreamJSONResponse(w, r, withStars(r, page))
+}
+
+// listSorted lists the items matching the filter in the order ?sort= asks
+// for.
+func listSorted(r *http.Request, filter ItemFilter, sort string) ([]Item, error) {
+	items, err := itemRepository.List(r.Context(), filter)
+	if err != nil {
+		return nil, err
+	}
+	switch sort {
+	case "position":
+		sortByPosition(items)
+	case "-rating":
+		sortByRating(items)
+	}
+	return items, nil
+}
+
+// requestItemFilter parses the filter of a request listing items. Items that
+// aren't published are left out, unless an admin asks for them with
+// ?include_unpublished=true. It answers the request when that fails.
+func requestItemFilter(w http.ResponseWriter, r *http.Request) (ItemFilter, bool) {
+	params := r.URL.Query()
+	filter, ok := parseItemFilter(params)
+	if !ok {
+		ErrorCodeResponse(w, InvalidFilterCode)
+		return filter, false
+	}
+	if params.Get("include_unpublished") == "true" {
+		if caller := requestCaller(r); caller == nil || !caller.HasScope(ScopeAdmin) {
+			ErrorCodeResponse(w, MissingScopeCode)
+			return filter, false
 		}
+	} else {
+		filter.PublishedAt = time.Now()
 	}
+	return filter, true
+}
 
-	SuccessResponse(w, result)
+// parseItemFilter reads ?filter=, ?currency=, ?state= and the comparisons
+// like ?price[lt]=10.00 or ?quantity[gte]=1 from params. Archived items are
+// left out unless ?state= asks for them.
+func parseItemFilter(params url.Values) (ItemFilter, bool) {
+	filter := ItemFilter{NameContains: params.Get("filter"), Currency: params.Get("currency"), State: ItemStateActive}
+	if _, known := currencyDecimals[filter.Currency]; filter.Currency != "" && !known {
+		return filter, false
+	}
+	switch state := params.Get("state"); state {
+	case "":
+	case ItemStateActive, ItemStateArchived:
+		filter.State = state
+	case "all":
+		filter.State = ""
+	default:
+		return filter, false
+	}
+	for key, values := range params {
+		field, op, ok := strings.Cut(strings.TrimSuffix(key, "]"), "[")
+		if !ok || (field != "price" && field != "quantity") {
+			continue
+		}
+		known := false
+		for _, o := range comparisonOps {
+			known = known || o == op
+		}
+		if !known || !strings.HasSuffix(key, "]") || !decimalPattern.MatchString(values[0]) {
+			return filter, false
+		}
+		value, _ := new(big.Rat).SetString(values[0])
+		if field == "quantity" {
+			if !value.IsInt() || !value.Num().IsInt64() {
+				return filter, false
+			}
+			filter.Quantity = append(filter.Quantity, Condition{Op: op, Value: value})
+		} else {
+			filter.Price = append(filter.Price, Condition{Op: op, Value: value})
+		}
+	}
+	return filter, true
 }
 
 func getItem(w http.ResponseWriter, r *http.Request) {
 	id, err := getIDParam(r)
 	if err != nil {
-		BadRequestResponse(w, "invalid ID")
+		ErrorCodeResponse(w, InvalidIDCode)
 		return
 	}
 
-	item, err := getItemForID(*id)
+	item, err := itemRepository.Get(r.Context(), *id)
 	if err != nil {
-		NotFoundResponse(w, "item with ID does not exist")
+		RepositoryErrorResponse(w, err, "could not get item")
 		return
 	}
 
-	SuccessResponse(w, item)
+	localizeItem(w, r, item)
+	SuccessResponse(w, withStar(r, item))
 }
 
 func deleteItem(w http.ResponseWriter, r *http.Request) {
 	id, err := getIDParam(r)
 	if err != nil {
-		BadRequestResponse(w, "invalid ID")
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+
+	if isDryRun(r) {
+		var item *Item
+		err = writeTx(r, func(tx ItemRepository) error {
+			var err error
+			if item, err = tx.Get(r.Context(), *id); err != nil {
+				return err
+			}
+			return tx.Delete(r.Context(), *id)
+		})
+		if err != nil {
+			RepositoryErrorResponse(w, err, "could not delete item")
+			return
+		}
+		DryRunResponse(w, DryRunDelete, item)
 		return
 	}
 
-	err = doDeleteItem(*id)
+	err = itemRepository.Delete(r.Context(), *id)
 	if err != nil {
-		NotFoundResponse(w, "item with ID does not exist")
+		RepositoryErrorResponse(w, err, "could not delete item")
 		return
 	}
 
 	NoContentResponse(w)
 }
 
+// duplicateItem copies the item once, or ?count=N times. The copies are made
+// in one transaction, so either all of them are created or none.
 func duplicateItem(w http.ResponseWriter, r *http.Request) {
 	id, err := getIDParam(r)
 	if err != nil {
-		BadRequestResponse(w, "invalid ID")
+		ErrorCodeResponse(w, InvalidIDCode)
 		return
 	}
 
-	item, err := getItemForID(*id)
+	count := 1
+	countParam := r.URL.Query().Get("count")
+	if countParam != "" {
+		count, err = strconv.Atoi(countParam)
+		if err != nil || count < 1 || count > maxDuplicateCount {
+			ErrorCodeResponse(w, InvalidDuplicateCountCode)
+			return
+		}
+	}
+
+	var duplicates []Item
+	err = writeTx(r, func(tx ItemRepository) error {
+		duplicates = nil
+		item, err := tx.Get(r.Context(), *id)
+		if err != nil {
+			return err
+		}
+		now := itemClock().UTC()
+		item.CreatedAt = &now
+		for i := 0; i < count; i++ {
+			if err := assignSlug(r.Context(), tx, item); err != nil {
+				return err
+			}
+			duplicate, err := tx.Create(r.Context(), *item)
+			if err != nil {
+				return err
+			}
+			duplicates = append(duplicates, *duplicate)
+		}
+		return nil
+	})
 	if err != nil {
-		NotFoundResponse(w, "item with ID does not exist")
+		RepositoryErrorResponse(w, err, "could not duplicate item")
 		return
 	}
 
-	duplicate := Item{
-		ID:          getNextID(),
-		Name:        item.Name,
-		Description: item.Description,
+	var result interface{} = duplicates
+	if countParam == "" {
+		result = duplicates[0]
 	}
-	err = doCreateItem(duplicate)
-	if err != nil {
-		InternalErrorResponse(w, "could not duplicate item")
+	if isDryRun(r) {
+		DryRunResponse(w, DryRunCreate, result)
 		return
 	}
-
-	CreatedResponse(w, duplicate)
+	CreatedResponse(w, result)
 }
 
+// updateItem replaces the item with the one in the body. Sync jobs that
+// don't know whether the item exists yet make the PUT idempotent: with
+// If-None-Match: * it only creates the item, under the ID in the path, and
+// answers 412 when it exists; with ?upsert=true it creates or replaces it.
+// Created items get a 201.
 func updateItem(w http.ResponseWriter, r *http.Request) {
 	id, err := getIDParam(r)
-	if err != nil {
-		BadRequestResponse(w, "invalid ID")
+	if err != nil || *id < 0 {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	onlyIfAbsent := false
+	switch r.Header.Get("If-None-Match") {
+	case "":
+	case "*":
+		onlyIfAbsent = true
+	default:
+		ErrorCodeResponse(w, InvalidPreconditionCode)
 		return
 	}
+	upsert := r.URL.Query().Get("upsert") == "true"
 
 	var item Item
 	err = decodeBody(r, &item)
 	if err != nil {
-		BadRequestResponse(w, "could not decode request body")
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
 	}
 
 	item.ID = *id
 
-	err = doUpdateItem(item)
+	if errs := validateItem(item); len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
+	}
+
+	// Items created before slugs get one now.
+	created := false
+	err = writeTx(r, func(tx ItemRepository) error {
+		created = false
+		stored, err := tx.Get(r.Context(), item.ID)
+		switch {
+		case err == nil && onlyIfAbsent:
+			return errItemExists
+		case errors.Is(err, NotFoundError) && (onlyIfAbsent || upsert):
+			created = true
+			keepManagedFields(&item, Item{})
+			now := itemClock().UTC()
+			item.CreatedAt = &now
+			if err := assignSlug(r.Context(), tx, &item); err != nil {
+				return err
+			}
+			inserted, err := tx.Insert(r.Context(), item)
+			if err != nil {
+				return err
+			}
+			item = *inserted
+			return nil
+		case err != nil:
+			return err
+		}
+		keepManagedFields(&item, *stored)
+		if item.Slug == "" {
+			if err := assignSlug(r.Context(), tx, &item); err != nil {
+				return err
+			}
+		}
+		if uuidIDs && item.UUID == "" {
+			item.UUID = newUUIDv7(itemClock())
+		}
+		return tx.Update(r.Context(), item)
+	})
+	if errors.Is(err, errItemExists) {
+		ErrorCodeResponse(w, ItemExistsCode)
+		return
+	}
 	if err != nil {
-		InternalErrorResponse(w, "could not update item")
+		RepositoryErrorResponse(w, err, "could not update item")
+		return
 	}
 
+	if isDryRun(r) {
+		action := DryRunUpdate
+		if created {
+			action = DryRunCreate
+		}
+		DryRunResponse(w, action, item)
+		return
+	}
+	if created {
+		CreatedResponse(w, item)
+		return
+	}
 	SuccessResponse(w, item)
 }
 
+// errItemExists aborts a PUT with If-None-Match: * that found the item.
+var errItemExists = errors.New("item exists")
+
+// keepManagedFields copies the fields an update can't change from the stored
+// item. The slug stays as it was, so links to the item keep working. The
+// translations, the archive state, the position and the rating have
+// endpoints of their own, and the times the item was created and is deleted
+// at are set when it is created.
+func keepManagedFields(item *Item, stored Item) {
+	item.UUID = stored.UUID
+	item.ExternalID = stored.ExternalID
+	item.Slug = stored.Slug
+	item.Translations = stored.Translations
+	item.DeleteAt = stored.DeleteAt
+	item.ArchivedAt = stored.ArchivedAt
+	item.Position = stored.Position
+	item.Rating = stored.Rating
+	item.CreatedAt = stored.CreatedAt
+}
+
+// createItem creates the item in the body, leaving out the fields clients
+// can't set. With a ttl, like "24h", the item is ephemeral: the reaper
+// deletes it once the ttl has passed. With -client-ids the item keeps the id
+// in the body, unless another item has it already.
 func createItem(w http.ResponseWriter, r *http.Request) {
-	var item Item
-	err := decodeBody(r, &item)
+	var body newItemBody
+	err := decodeBody(r, &body)
 	if err != nil {
-		BadRequestResponse(w, "could not decode request body")
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	item, errs := body.item()
+	if len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
 	}
 
-	item.ID = getNextID()
-
-	err = doCreateItem(item)
+	var created *Item
+	err = writeTx(r, func(tx ItemRepository) error {
+		var err error
+		created, err = storeNewItem(r.Context(), tx, item, body.hasClientID())
+		return err
+	})
 	if err != nil {
-		InternalErrorResponse(w, "could not create item")
+		RepositoryErrorResponse(w, err, "could not create item")
+		return
 	}
 
-	CreatedResponse(w, item)
+	if isDryRun(r) {
+		DryRunResponse(w, DryRunCreate, created)
+		return
+	}
+	CreatedResponse(w, created)
+}
+
+// newItemBody is the body of POST /items/.
+type newItemBody struct {
+	Item
+	// ID shadows the item's, so a body without an ID is told from ID 0.
+	ID  *int   `json:"id"`
+	TTL string `json:"ttl"`
+}
+
+// hasClientID tells whether the item is to be stored under the body's ID.
+func (body newItemBody) hasClientID() bool {
+	return clientIDs && body.ID != nil
+}
+
+// item makes the item to create of the body, with what is wrong with it.
+func (body newItemBody) item() (Item, []FieldError) {
+	item := body.Item
+	keepManagedFields(&item, Item{})
+	item.ExternalID = body.ExternalID
+	now := itemClock().UTC()
+	item.CreatedAt = &now
+
+	errs := validateItem(item)
+	if body.hasClientID() {
+		item.ID = *body.ID
+		if !validClientID(item.ID) {
+			errs = append(errs, newFieldError("id", InvalidClientIDCode))
+		}
+	}
+	if body.TTL != "" {
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			errs = append(errs, newFieldError("ttl", InvalidTTLCode))
+		} else {
+			deleteAt := now.Add(ttl)
+			item.DeleteAt = &deleteAt
+		}
+	}
+	return item, errs
+}
+
+// storeNewItem gives the item a slug and creates it, under its own ID when
+// clientID is set.
+func storeNewItem(ctx context.Context, tx ItemRepository, item Item, clientID bool) (*Item, error) {
+	if err := assignSlug(ctx, tx, &item); err != nil {
+		return nil, err
+	}
+	if clientID {
+		return tx.Insert(ctx, item)
+	}
+	return tx.Create(ctx, item)
 }
 
 func routeDoesNotExist(w http.ResponseWriter, r *http.Request) {
@@ -236,59 +974,10 @@ func getIDParam(r *http.Request) (*int, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &id, nil
-}
-
-func getItemForID(id int) (*Item, error) {
-	for _, item := range itemRepository {
-		if item.ID == id {
-			return &item, nil
-		}
+	if id > maxClientID {
+		return nil, IDOutOfRangeError
 	}
-	return nil, NotFoundError
-}
-
-func doCreateItem(item Item) error {
-	itemRepository = append(itemRepository, item)
-	return nil
-}
-
-func doDeleteItem(id int) error {
-	var index *int
-	for i, item := range itemRepository {
-		if item.ID == id {
-			index = &i
-			break
-		}
-	}
-
-	if index == nil {
-		return NotFoundError
-	}
-	itemRepository = append(itemRepository[:*index], itemRepository[*index+1:]...)
-	return nil
-}
-
-func doUpdateItem(updateValues Item) error {
-	var index *int
-	for i, item := range itemRepository {
-		if item.ID == updateValues.ID {
-			index = &i
-			break
-		}
-	}
-
-	if index == nil {
-		return NotFoundError
-	}
-	itemRepository = append(itemRepository[:*index], updateValues)
-	itemRepository = append(itemRepository, itemRepository[*index+1:]...)
-
-	return nil
-}
-
-func getNextID() int {
-	return len(itemRepository)
+	return &id, nil
 }
 
 func SuccessResponse(w http.ResponseWriter, payload interface{}) {
@@ -303,29 +992,75 @@ func InternalErrorResponse(w http.ResponseWriter, message string) {
 	JSONResponse(w, http.StatusInternalServerError, map[string]string{"error": message})
 }
 
-func BadRequestResponse(w http.ResponseWriter, message string) {
-	JSONResponse(w, http.StatusBadRequest, map[string]string{"error": message})
+func ErrorCodeResponse(w http.ResponseWriter, code ErrorCode) {
+	ProblemResponse(w, newProblem(code))
+}
+
+func ValidationErrorResponse(w http.ResponseWriter, errs []FieldError) {
+	problem := newProblem(ValidationFailedCode)
+	problem.Errors = errs
+	ProblemResponse(w, problem)
+}
+
+func ProblemResponse(w http.ResponseWriter, problem Problem) {
+	writeJSON(w, problem.Status, "application/problem+json", problem)
 }
 
 func NotFoundResponse(w http.ResponseWriter, message string) {
 	JSONResponse(w, http.StatusNotFound, map[string]string{"error": message})
 }
 
+func ServiceUnavailableResponse(w http.ResponseWriter, message string) {
+	JSONResponse(w, http.StatusServiceUnavailable, map[string]string{"error": message})
+}
+
+// RepositoryErrorResponse translates an error returned by the item repository
+// into the matching response; message is used when nothing more specific fits.
+func RepositoryErrorResponse(w http.ResponseWriter, err error, message string) {
+	var open *CircuitOpenError
+	var taken *NameTakenError
+	var externalIDTaken *ExternalIDTakenError
+	switch {
+	case errors.Is(err, NotFoundError):
+		NotFoundResponse(w, "item with ID does not exist")
+	case errors.Is(err, IDTakenError):
+		ErrorCodeResponse(w, ItemIDTakenCode)
+	case errors.As(err, &taken):
+		problem := newProblem(ItemNameTakenCode)
+		problem.ConflictingID = &taken.ID
+		ProblemResponse(w, problem)
+	case errors.As(err, &externalIDTaken):
+		problem := newProblem(ExternalIDTakenCode)
+		problem.ConflictingID = &externalIDTaken.ID
+		ProblemResponse(w, problem)
+	case errors.As(err, &open):
+		CircuitOpenResponse(w, open.RetryAfter)
+	case errors.Is(err, context.DeadlineExceeded):
+		ServiceUnavailableResponse(w, "request timed out")
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost):
+		ServiceUnavailableResponse(w, "the cluster leader changed, retry the request")
+	default:
+		InternalErrorResponse(w, message)
+	}
+}
+
 func NoContentResponse(w http.ResponseWriter) {
 	JSONResponse(w, http.StatusNoContent, map[string]string{})
 }
 
 func JSONResponse(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload)
+	writeJSON(w, code, "application/json", payload)
+}
+
+func writeJSON(w http.ResponseWriter, code int, contentType string, payload interface{}) {
+	var response []byte
+	if format := findFormatWriter(w); format != nil {
+		response, contentType = format.encode(code, contentType, payload)
+	} else {
+		response, _ = json.Marshal(payload)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(code)
 	w.Write(response)
 }
-
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println(r.RequestURI)
-		next.ServeHTTP(w, r)
-	})
-}
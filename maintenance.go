@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// maintenance refuses writes to the items for as long as operators need, e.g.
+// while restoring a backup. Unlike -read-only it is switched at runtime, on
+// /admin/maintenance.
+var maintenance atomic.Bool
+
+// MaintenanceMode is what /admin/maintenance reports and takes.
+type MaintenanceMode struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maintenanceMiddleware answers the requests that would change items with 503
+// MAINTENANCE_MODE while maintenance is on.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !maintenance.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil && route == validateRoute {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ErrorCodeResponse(w, MaintenanceModeCode)
+	})
+}
+
+func getMaintenance(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, MaintenanceMode{Enabled: maintenance.Load()})
+}
+
+func setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var mode MaintenanceMode
+	if err := decodeBody(r, &mode); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	maintenance.Store(mode.Enabled)
+	SuccessResponse(w, mode)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_maintenanceMode(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"})
+	t.Cleanup(func() { maintenance.Store(false) })
+
+	if mode := decodeResponse[MaintenanceMode](t, api.AdminRequest("PUT", "/admin/maintenance", MaintenanceMode{Enabled: true}), http.StatusOK); !mode.Enabled {
+		t.Fatalf("expected maintenance mode to be on, got %+v", mode)
+	}
+	if w := api.Request("PUT", "/items/0", Item{Name: "desk"}); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "MAINTENANCE_MODE") {
+		t.Errorf("expected writes to be refused, got %d %s", w.Code, w.Body)
+	}
+	if w := api.Request("GET", "/items/0", nil); w.Code != http.StatusOK {
+		t.Errorf("expected reads to go on, got %d", w.Code)
+	}
+	if w := api.Request("POST", "/items/validate", Item{Name: "desk"}); w.Code != http.StatusOK {
+		t.Errorf("expected validation to go on, got %d %s", w.Code, w.Body)
+	}
+
+	api.AdminRequest("PUT", "/admin/maintenance", MaintenanceMode{Enabled: false})
+	if mode := decodeResponse[MaintenanceMode](t, api.AdminRequest("GET", "/admin/maintenance", nil), http.StatusOK); mode.Enabled {
+		t.Errorf("expected maintenance mode to be off, got %+v", mode)
+	}
+	if w := api.Request("PUT", "/items/0", Item{Name: "desk"}); w.Code != http.StatusOK {
+		t.Errorf("expected writes after maintenance, got %d %s", w.Code, w.Body)
+	}
+}
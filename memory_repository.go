@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// InMemoryItemRepository keeps the items in a map keyed by ID, so getting,
+// updating and deleting an item doesn't depend on how many there are. The
+// order slice keeps the IDs sorted, as listings are ordered by ID.
+// Deleting only removes the item from the map; the stale ID is skipped when
+// listing and dropped once stale IDs make up half of the order slice.
+//
+// Secondary indexes keep filtered listings from scanning every item.
+type InMemoryItemRepository struct {
+	mu      sync.RWMutex
+	items   map[int]Item
+	order   []int
+	stale   int
+	nextID  int
+	indexes []ItemIndex
+	// touched collects the IDs written inside a transaction, so only their
+	// index entries need updating on commit.
+	touched map[int]struct{}
+	// version counts the writes, so a sharded transaction can tell whether a
+	// shard changed since it read from it.
+	version uint64
+}
+
+func NewInMemoryItemRepository(items ...Item) *InMemoryItemRepository {
+	repo := &InMemoryItemRepository{
+		items:   make(map[int]Item, len(items)),
+		indexes: []ItemIndex{NewExternalIDIndex(), NewUUIDIndex(), NewNameIndex()},
+	}
+	for _, item := range items {
+		repo.items[item.ID] = item
+		repo.order = append(repo.order, item.ID)
+		repo.index(nil, &item)
+		if item.ID >= repo.nextID {
+			repo.nextID = idAfter(item.ID)
+		}
+	}
+	slices.Sort(repo.order)
+	return repo
+}
+
+func (repo *InMemoryItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.list(filter), nil
+}
+
+// list is List for callers that hold the lock.
+func (repo *InMemoryItemRepository) list(filter ItemFilter) []Item {
+	ids := repo.order
+	if candidates, ok := repo.candidates(filter); ok {
+		ids = make([]int, 0, len(candidates))
+		for id := range candidates {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+	}
+
+	result := []Item{}
+	for _, id := range ids {
+		item, ok := repo.items[id]
+		if ok && filter.Matches(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (repo *InMemoryItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	item, ok := repo.items[id]
+	if !ok {
+		return nil, NotFoundError
+	}
+	return &item, nil
+}
+
+func (repo *InMemoryItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.nextID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	item.ID = repo.nextID
+	repo.nextID++
+	repo.version++
+	repo.items[item.ID] = item
+	repo.order = append(repo.order, item.ID)
+	repo.index(nil, &item)
+	return &item, nil
+}
+
+func (repo *InMemoryItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	if _, ok := repo.items[item.ID]; ok {
+		return nil, IDTakenError
+	}
+	repo.items[item.ID] = item
+	repo.addToOrder(item.ID)
+	repo.index(nil, &item)
+	if item.ID >= repo.nextID {
+		repo.nextID = idAfter(item.ID)
+	}
+	repo.version++
+	return &item, nil
+}
+
+func (repo *InMemoryItemRepository) Update(ctx context.Context, item Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.items[item.ID]
+	if !ok {
+		return NotFoundError
+	}
+	repo.items[item.ID] = item
+	repo.index(&old, &item)
+	repo.version++
+	return nil
+}
+
+func (repo *InMemoryItemRepository) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.items[id]
+	if !ok {
+		return NotFoundError
+	}
+	delete(repo.items, id)
+	repo.index(&old, nil)
+	repo.version++
+	repo.stale++
+	if repo.stale > len(repo.order)/2 {
+		repo.compact()
+	}
+	return nil
+}
+
+// Tx runs fn against a copy of the items and swaps the copy in when fn
+// succeeds. Other requests wait for the transaction to finish. The copy has no
+// indexes of its own; the indexes are brought up to date for the items the
+// transaction wrote when it commits.
+func (repo *InMemoryItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	draft := &InMemoryItemRepository{
+		items:   make(map[int]Item, len(repo.items)),
+		order:   append([]int(nil), repo.order...),
+		stale:   repo.stale,
+		nextID:  repo.nextID,
+		touched: map[int]struct{}{},
+	}
+	for id, item := range repo.items {
+		draft.items[id] = item
+	}
+	if err := fn(draft); err != nil {
+		return err
+	}
+	for id := range draft.touched {
+		old, hadOld := repo.items[id]
+		updated, hasUpdated := draft.items[id]
+		switch {
+		case hadOld && hasUpdated:
+			repo.index(&old, &updated)
+		case hadOld:
+			repo.index(&old, nil)
+		case hasUpdated:
+			repo.index(nil, &updated)
+		}
+	}
+	repo.items, repo.order, repo.stale, repo.nextID = draft.items, draft.order, draft.stale, draft.nextID
+	repo.version++
+	return nil
+}
+
+// put stores item under its own ID, creating or replacing it. It is how
+// projections apply changes that were decided elsewhere.
+func (repo *InMemoryItemRepository) put(item Item) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.items[item.ID]
+	repo.items[item.ID] = item
+	if ok {
+		repo.index(&old, &item)
+	} else {
+		repo.addToOrder(item.ID)
+		repo.index(nil, &item)
+	}
+	if item.ID >= repo.nextID {
+		repo.nextID = idAfter(item.ID)
+	}
+	repo.version++
+}
+
+// addToOrder puts the ID of a new item into the order slice where it sorts,
+// unless it is still there from a deleted item with the same ID that wasn't
+// compacted yet. Client IDs may be lower than those of existing items.
+func (repo *InMemoryItemRepository) addToOrder(id int) {
+	i, found := slices.BinarySearch(repo.order, id)
+	if found {
+		repo.stale--
+		return
+	}
+	repo.order = slices.Insert(repo.order, i, id)
+}
+
+// index replaces old by updated in the secondary indexes; either may be nil
+// for a create or a delete.
+func (repo *InMemoryItemRepository) index(old, updated *Item) {
+	if repo.touched != nil {
+		if old != nil {
+			repo.touched[old.ID] = struct{}{}
+		}
+		if updated != nil {
+			repo.touched[updated.ID] = struct{}{}
+		}
+	}
+	for _, idx := range repo.indexes {
+		if old != nil {
+			idx.Remove(*old)
+		}
+		if updated != nil {
+			idx.Add(*updated)
+		}
+	}
+}
+
+// candidates asks the indexes to narrow the filter down, using the first one
+// that can.
+func (repo *InMemoryItemRepository) candidates(filter ItemFilter) (map[int]struct{}, bool) {
+	for _, idx := range repo.indexes {
+		if candidates, ok := idx.Candidates(filter); ok {
+			return candidates, true
+		}
+	}
+	return nil, false
+}
+
+// IndexStats reports the indexes that can tell their size.
+func (repo *InMemoryItemRepository) IndexStats() []IndexStats {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var stats []IndexStats
+	for _, idx := range repo.indexes {
+		if reporter, ok := idx.(indexStatsReporter); ok {
+			stats = append(stats, reporter.IndexStats()...)
+		}
+	}
+	return stats
+}
+
+// compact drops the IDs of deleted items from the order slice.
+func (repo *InMemoryItemRepository) compact() {
+	order := make([]int, 0, len(repo.items))
+	for _, id := range repo.order {
+		if _, ok := repo.items[id]; ok {
+			order = append(order, id)
+		}
+	}
+	repo.order = order
+	repo.stale = 0
+}
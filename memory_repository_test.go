@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+const benchmarkItemCount = 100000
+
+// benchmarkID spreads the looked up IDs over the whole collection, so short
+// runs don't only hit the first items.
+func benchmarkID(i int) int {
+	return i * 7919 % benchmarkItemCount
+}
+
+func newBenchmarkRepository(b *testing.B) *InMemoryItemRepository {
+	repo := NewInMemoryItemRepository()
+	for i := 0; i < benchmarkItemCount; i++ {
+		if _, err := repo.Create(context.Background(), Item{Name: fmt.Sprintf("item %d", i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	return repo
+}
+
+// scanForID is how items used to be found: a linear scan over a slice. It is
+// kept here as the baseline the map lookups are compared against.
+func scanForID(items []Item, id int) (*Item, error) {
+	for _, item := range items {
+		if item.ID == id {
+			return &item, nil
+		}
+	}
+	return nil, NotFoundError
+}
+
+func BenchmarkInMemoryGet(b *testing.B) {
+	repo := newBenchmarkRepository(b)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.Get(ctx, benchmarkID(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSliceScanGet(b *testing.B) {
+	items := make([]Item, benchmarkItemCount)
+	for i := range items {
+		items[i] = Item{ID: i, Name: fmt.Sprintf("item %d", i)}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := scanForID(items, benchmarkID(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInMemoryUpdate(b *testing.B) {
+	repo := newBenchmarkRepository(b)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if err := repo.Update(ctx, Item{ID: benchmarkID(i), Name: "updated"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInMemoryCreateDelete(b *testing.B) {
+	repo := newBenchmarkRepository(b)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		item, err := repo.Create(ctx, Item{Name: "temporary"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := repo.Delete(ctx, item.ID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInMemoryList(b *testing.B) {
+	repo := newBenchmarkRepository(b)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.List(ctx, ItemFilter{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_inMemoryListKeepsOrderAfterDeletes(t *testing.T) {
+	repo := NewInMemoryItemRepository()
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if _, err := repo.Create(ctx, Item{Name: fmt.Sprintf("item %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []int{0, 2, 3, 5, 6, 8} {
+		if err := repo.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := repo.List(ctx, ItemFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	if fmt.Sprint(ids) != "[1 4 7 9]" {
+		t.Errorf("list returned wrong items: got %v want [1 4 7 9]", ids)
+	}
+}
+
+func BenchmarkInMemoryListFiltered(b *testing.B) {
+	repo := newBenchmarkRepository(b)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.List(ctx, ItemFilter{NameContains: "item 4242"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_inMemoryFilterFollowsWrites(t *testing.T) {
+	repo := NewInMemoryItemRepository(seedItems...)
+	ctx := context.Background()
+
+	if err := repo.Update(ctx, Item{ID: 0, Name: "renamed"}); err != nil {
+		t.Fatal(err)
+	}
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		if _, err := tx.Create(ctx, Item{Name: "also renamed"}); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filter, expected := range map[string]string{
+		"first":   "[]",
+		"second":  "[]",
+		"renamed": "[0 2]",
+		"re":      "[0 2]",
+	} {
+		items, err := repo.List(ctx, ItemFilter{NameContains: filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if fmt.Sprint(ids) != expected {
+			t.Errorf("filter %q returned wrong items: got %v want %v", filter, ids, expected)
+		}
+	}
+}
+
+func Test_inMemoryInsertReusesDeletedID(t *testing.T) {
+	repo := NewInMemoryItemRepository(seedItems...)
+	ctx := context.Background()
+	if err := repo.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Insert(ctx, Item{ID: 1, Name: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	repo.put(Item{ID: 0, Name: "replaced"})
+	if err := repo.Delete(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	repo.put(Item{ID: 0, Name: "put again"})
+
+	items, err := repo.List(ctx, ItemFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	if fmt.Sprint(ids) != "[0 1]" {
+		t.Errorf("expected every item listed once, got %v", ids)
+	}
+}
+
+func Test_inMemoryListsByID(t *testing.T) {
+	repo := NewInMemoryItemRepository()
+	ctx := context.Background()
+	for _, id := range []int{100, 50} {
+		if _, err := repo.Insert(ctx, Item{ID: id, Name: "lamp"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, filter := range []ItemFilter{{}, {NameContains: "lamp"}} {
+		items, err := repo.List(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if fmt.Sprint(ids) != "[50 100]" {
+			t.Errorf("filter %+v: expected the items by ID, got %v", filter, ids)
+		}
+	}
+}
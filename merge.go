@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// The strategies of POST /items/{id}/merge for the fields both items have.
+const (
+	MergeKeep    = "keep"
+	MergeReplace = "replace"
+)
+
+type mergeRequest struct {
+	Source   *int   `json:"source"`
+	Strategy string `json:"strategy"`
+	// ArchiveSource keeps the source as an archived item instead of deleting
+	// it.
+	ArchiveSource bool `json:"archive_source"`
+}
+
+// mergeFields combines the fields clients set. With MergeKeep the target's
+// values win and the source only fills in what the target lacks; with
+// MergeReplace it is the other way round. Price and currency go together,
+// and the translations are combined per language the same way.
+func mergeFields(target, source Item, strategy string) Item {
+	winner, loser := target, source
+	if strategy == MergeReplace {
+		winner, loser = source, target
+	}
+	merged := target
+	merged.Name = winner.Name
+	merged.Description = cmp.Or(winner.Description, loser.Description)
+	merged.Quantity = cmp.Or(winner.Quantity, loser.Quantity)
+	merged.Price, merged.Currency = winner.Price, winner.Currency
+	if merged.Price == "" {
+		merged.Price, merged.Currency = loser.Price, loser.Currency
+	}
+	merged.PublishAt = cmp.Or(winner.PublishAt, loser.PublishAt)
+	merged.ExpiresAt = cmp.Or(winner.ExpiresAt, loser.ExpiresAt)
+	if len(source.Translations) > 0 {
+		merged.Translations = maps.Clone(loser.Translations)
+		if merged.Translations == nil {
+			merged.Translations = map[string]Translation{}
+		}
+		maps.Copy(merged.Translations, winner.Translations)
+	}
+	return merged
+}
+
+// mergedRating adds the scores users gave the source to the target's rating.
+// Users who rated both keep their score for the target only.
+func mergedRating(target Item, sourceScores, targetScores map[string]int) *Rating {
+	rating := Rating{}
+	if target.Rating != nil {
+		rating = *target.Rating
+	}
+	for userID, score := range sourceScores {
+		if _, ok := targetScores[userID]; !ok {
+			rating.add(score, 0)
+		}
+	}
+	if rating.Count == 0 {
+		return nil
+	}
+	return &rating
+}
+
+// ItemRatings returns the scores users gave the item, by user ID.
+func (s *userStore) ItemRatings(itemID int) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scores := map[string]int{}
+	for _, user := range s.users {
+		if score, ok := user.Ratings[itemID]; ok {
+			scores[user.ID] = score
+		}
+	}
+	return scores
+}
+
+// movedStars are a user's stars and ratings from before MoveItem.
+type movedStars struct {
+	user    *User
+	starred []int
+	ratings map[int]int
+}
+
+// MoveItem moves the stars and ratings users gave the item from to the item
+// to. Users who rated both keep their score for to. The returned undo puts
+// them back as they were, for a merge that fails after all.
+func (s *userStore) MoveItem(from, to int) (undo func() error, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var moved []movedStars
+	for _, user := range s.users {
+		if slices.Contains(user.Starred, from) || user.Ratings[from] != 0 {
+			moved = append(moved, movedStars{user: user, starred: user.Starred, ratings: user.Ratings})
+		}
+		if i := slices.Index(user.Starred, from); i >= 0 {
+			starred := slices.Delete(slices.Clone(user.Starred), i, i+1)
+			if !slices.Contains(starred, to) {
+				starred = slices.Insert(starred, i, to)
+			}
+			user.Starred = starred
+		}
+		if score, ok := user.Ratings[from]; ok {
+			ratings := maps.Clone(user.Ratings)
+			delete(ratings, from)
+			if _, ok := ratings[to]; !ok {
+				ratings[to] = score
+			}
+			user.Ratings = ratings
+		}
+	}
+	restore := func() {
+		for _, m := range moved {
+			m.user.Starred, m.user.Ratings = m.starred, m.ratings
+		}
+	}
+	if err := s.save(); err != nil {
+		restore()
+		return nil, err
+	}
+	return func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		restore()
+		return s.save()
+	}, nil
+}
+
+// mergeItem merges the item in the body's source into the item in the path
+// and deletes the source, or archives it with archive_source. The stars and
+// ratings the source had move over to the merged item. The merged item has to
+// pass validation like any update. The items change in one transaction, whose
+// last step moves the users' stars and ratings; they are moved back when the
+// transaction fails to commit.
+func mergeItem(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	var req mergeRequest
+	if err := decodeBody(r, &req); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	if req.Strategy == "" {
+		req.Strategy = MergeKeep
+	}
+	if req.Source == nil || *req.Source == *id || (req.Strategy != MergeKeep && req.Strategy != MergeReplace) {
+		ErrorCodeResponse(w, InvalidMergeCode)
+		return
+	}
+
+	// rateItem can't change the scores while they are being added up
+	ratingMu.Lock()
+	defer ratingMu.Unlock()
+	var sourceScores, targetScores map[string]int
+	if users != nil {
+		sourceScores, targetScores = users.ItemRatings(*req.Source), users.ItemRatings(*id)
+	}
+
+	var item *Item
+	var invalid []FieldError
+	var undoMove func() error
+	undo := func() {
+		if undoMove == nil {
+			return
+		}
+		if err := undoMove(); err != nil {
+			log.Printf("could not move the stars and ratings back from item %d to %d: %v", *id, *req.Source, err)
+		}
+		undoMove = nil
+	}
+	err = itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		// a transaction that starts over moves them again
+		undo()
+		target, err := tx.Get(r.Context(), *id)
+		if err != nil {
+			return err
+		}
+		source, err := tx.Get(r.Context(), *req.Source)
+		if err != nil {
+			return err
+		}
+		merged := mergeFields(*target, *source, req.Strategy)
+		if users != nil {
+			merged.Rating = mergedRating(*target, sourceScores, targetScores)
+		}
+		if invalid = validateItem(merged); len(invalid) > 0 {
+			return errItemInvalid
+		}
+
+		// the source goes first, so with -unique-names a deleted source's name is
+		// free for the target
+		if req.ArchiveSource {
+			if source.ArchivedAt == nil {
+				now := time.Now().UTC()
+				source.ArchivedAt = &now
+			}
+			if err := tx.Update(r.Context(), *source); err != nil {
+				return err
+			}
+		} else if err := tx.Delete(r.Context(), source.ID); err != nil {
+			return err
+		}
+		item = &merged
+		if err := tx.Update(r.Context(), merged); err != nil {
+			return err
+		}
+		if users != nil {
+			undoMove, err = users.MoveItem(*req.Source, *id)
+		}
+		return err
+	})
+	if err != nil {
+		undo()
+	}
+	switch {
+	case errors.Is(err, errItemInvalid):
+		ValidationErrorResponse(w, invalid)
+		return
+	case err != nil:
+		RepositoryErrorResponse(w, err, "could not merge items")
+		return
+	}
+
+	SuccessResponse(w, withStar(r, item))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_mergeFields(t *testing.T) {
+	target := Item{ID: 1, Name: "scarf", Slug: "scarf", Translations: map[string]Translation{"de": {Name: "Schal"}}}
+	source := Item{ID: 2, Name: "red scarf", Description: "wool", Price: "9.99", Currency: "EUR", Translations: map[string]Translation{"de": {Name: "Roter Schal"}, "fr": {Name: "Écharpe"}}}
+
+	kept := mergeFields(target, source, MergeKeep)
+	if kept.Name != "scarf" || kept.Description != "wool" || kept.Price != "9.99" || kept.Slug != "scarf" || kept.Translations["de"].Name != "Schal" || kept.Translations["fr"].Name != "Écharpe" {
+		t.Errorf("expected the target's fields, filled in from the source, got %+v", kept)
+	}
+	replaced := mergeFields(target, source, MergeReplace)
+	if replaced.ID != 1 || replaced.Name != "red scarf" || replaced.Translations["de"].Name != "Roter Schal" {
+		t.Errorf("expected the source's fields on the target, got %+v", replaced)
+	}
+	if target.Translations["fr"].Name != "" {
+		t.Error("expected the target's translations to stay as they were")
+	}
+}
+
+func Test_mergeItem(t *testing.T) {
+	do, login := newUserTestAPI(t, Item{ID: 0, Name: "scarf"}, Item{ID: 1, Name: "red scarf"}, Item{ID: 2, Name: "hat"})
+	alice, bob := login("alice@example.com"), login("bob@example.com")
+	do("POST", "/items/0/ratings", alice, `{"score":4}`)
+	do("POST", "/items/1/ratings", alice, `{"score":1}`)
+	do("POST", "/items/1/ratings", bob, `{"score":2}`)
+	do("PUT", "/items/1/star", bob, "")
+
+	w := do("POST", "/items/0/merge", bob, `{"source":1}`)
+	var merged starredItem
+	json.Unmarshal(w.Body.Bytes(), &merged)
+	if w.Code != http.StatusOK || merged.Item == nil || merged.Name != "scarf" || !merged.Starred {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+	}
+	if want := (&Rating{Average: 3, Count: 2, Sum: 6}); !reflect.DeepEqual(merged.Rating, want) {
+		t.Errorf("expected bob's score to join alice's, got %+v", merged.Rating)
+	}
+	if w := do("GET", "/items/1", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected the source to be deleted, got %d", w.Code)
+	}
+	if starred := users.Starred(users.byEmail("bob@example.com").ID); !reflect.DeepEqual(starred, []int{0}) {
+		t.Errorf("expected bob's star to move to the target, got %v", starred)
+	}
+
+	w = do("POST", "/items/0/merge", "", `{"source":2,"strategy":"replace","archive_source":true}`)
+	json.Unmarshal(w.Body.Bytes(), &merged)
+	if w.Code != http.StatusOK || merged.Name != "hat" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body)
+	}
+	var source Item
+	json.Unmarshal(do("GET", "/items/2", "", "").Body.Bytes(), &source)
+	if source.State() != ItemStateArchived {
+		t.Errorf("expected the source to be archived, got %+v", source)
+	}
+
+	for body, code := range map[string]int{
+		`{}`:                              http.StatusUnprocessableEntity,
+		`{"source":0}`:                    http.StatusUnprocessableEntity,
+		`{"source":2,"strategy":"union"}`: http.StatusUnprocessableEntity,
+		`{"source":9}`:                    http.StatusNotFound,
+	} {
+		if w := do("POST", "/items/0/merge", "", body); w.Code != code {
+			t.Errorf("merging with %s returned %d, want %d", body, w.Code, code)
+		}
+	}
+}
+
+func Test_mergeItemFailing(t *testing.T) {
+	do, login := newUserTestAPI(t, Item{ID: 0, Name: "scarf"}, Item{ID: 1, Name: "red scarf", Description: strings.Repeat("x", maxItemDescriptionLength+1)}, Item{ID: 2, Name: "hat"})
+	bob := login("bob@example.com")
+	do("PUT", "/items/2/star", bob, "")
+	do("POST", "/items/2/ratings", bob, `{"score":3}`)
+
+	if w := do("POST", "/items/0/merge", "", `{"source":1}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a merged item too long to be refused, got %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/items/1", "", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the source of a refused merge to stay, got %d", w.Code)
+	}
+
+	stored := itemRepository
+	itemRepository = &failingCommitRepository{ItemRepository: stored}
+	if w := do("POST", "/items/0/merge", "", `{"source":2}`); w.Code < 500 {
+		t.Fatalf("expected the failed commit to fail the merge, got %d", w.Code)
+	}
+	itemRepository = stored
+	bobID := users.byEmail("bob@example.com").ID
+	if starred := users.Starred(bobID); !reflect.DeepEqual(starred, []int{2}) {
+		t.Errorf("expected bob's star to stay on the source, got %v", starred)
+	}
+	if score := users.Rating(bobID, 2); score != 3 {
+		t.Errorf("expected bob's score to stay on the source, got %d", score)
+	}
+}
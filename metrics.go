@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRecorder is what the API reports its measurements to, whichever
+// monitoring system collects them.
+type metricsRecorder interface {
+	// ObserveRequest records a handled request; route is the path template
+	// it matched, like /items/{id}.
+	ObserveRequest(route, method string, status int, duration time.Duration)
+	// ObserveStorage records a call to the storage backend.
+	ObserveStorage(backend, operation string, err error, duration time.Duration)
+	// CountCacheLookup records whether the read cache had the item.
+	CountCacheLookup(hit bool)
+}
+
+// metrics is where measurements go; -metrics none discards them.
+var metrics metricsRecorder = noMetrics{}
+
+// metricsHandler serves the metrics for scraping on the admin listener, for
+// the recorders that are scraped rather than pushing.
+var metricsHandler http.Handler
+
+type noMetrics struct{}
+
+func (noMetrics) ObserveRequest(string, string, int, time.Duration)   {}
+func (noMetrics) ObserveStorage(string, string, error, time.Duration) {}
+func (noMetrics) CountCacheLookup(bool)                               {}
+
+// prometheusMetrics keeps the measurements as Prometheus metrics.
+type prometheusMetrics struct {
+	requests     *prometheus.HistogramVec
+	storage      *prometheus.HistogramVec
+	cacheLookups *prometheus.CounterVec
+}
+
+func newPrometheusMetrics(registry prometheus.Registerer) *prometheusMetrics {
+	m := &prometheusMetrics{
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "How long handling a request took, by route, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "storage_operation_duration_seconds",
+			Help:    "How long a call to the storage backend took, by backend, operation and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"backend", "operation", "outcome"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "item_cache_lookups_total",
+			Help: "Reads of an item by ID through the read cache, by whether the cache had it.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.requests, m.storage, m.cacheLookups)
+	return m
+}
+
+func (m *prometheusMetrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	m.requests.WithLabelValues(route, method, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+func (m *prometheusMetrics) ObserveStorage(backend, operation string, err error, duration time.Duration) {
+	m.storage.WithLabelValues(backend, operation, storageOutcome(err)).Observe(duration.Seconds())
+}
+
+func (m *prometheusMetrics) CountCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheLookups.WithLabelValues(result).Inc()
+}
+
+// storageOutcome sorts the result of a storage call into few enough classes
+// to be a label. A dry run, or a validation, is rolled back on purpose, and
+// a taken ID or name is the client's conflict; neither is an error of the
+// backend.
+func storageOutcome(err error) string {
+	var taken *NameTakenError
+	var externalIDTaken *ExternalIDTakenError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, errDryRun):
+		return "rolled_back"
+	case errors.Is(err, NotFoundError):
+		return "not_found"
+	case errors.As(err, &taken), errors.As(err, &externalIDTaken), errors.Is(err, IDTakenError), errors.Is(err, errItemExists):
+		return "conflict"
+	default:
+		return "error"
+	}
+}
+
+// metricsMiddleware measures every request that matched a route.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		metrics.ObserveRequest(route, r.Method, sw.status, time.Since(start))
+	})
+}
+
+// statusWriter remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.status, sw.wroteHeader = code, true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// instrumentedRepository times every call to the storage backend it wraps.
+type instrumentedRepository struct {
+	ItemRepository
+	backend string
+}
+
+func (repo *instrumentedRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	start := time.Now()
+	items, err := repo.ItemRepository.List(ctx, filter)
+	metrics.ObserveStorage(repo.backend, "list", err, time.Since(start))
+	return items, err
+}
+
+func (repo *instrumentedRepository) Get(ctx context.Context, id int) (*Item, error) {
+	start := time.Now()
+	item, err := repo.ItemRepository.Get(ctx, id)
+	metrics.ObserveStorage(repo.backend, "get", err, time.Since(start))
+	return item, err
+}
+
+func (repo *instrumentedRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	start := time.Now()
+	created, err := repo.ItemRepository.Create(ctx, item)
+	metrics.ObserveStorage(repo.backend, "create", err, time.Since(start))
+	return created, err
+}
+
+func (repo *instrumentedRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	start := time.Now()
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	metrics.ObserveStorage(repo.backend, "insert", err, time.Since(start))
+	return inserted, err
+}
+
+func (repo *instrumentedRepository) Update(ctx context.Context, item Item) error {
+	start := time.Now()
+	err := repo.ItemRepository.Update(ctx, item)
+	metrics.ObserveStorage(repo.backend, "update", err, time.Since(start))
+	return err
+}
+
+func (repo *instrumentedRepository) Delete(ctx context.Context, id int) error {
+	start := time.Now()
+	err := repo.ItemRepository.Delete(ctx, id)
+	metrics.ObserveStorage(repo.backend, "delete", err, time.Since(start))
+	return err
+}
+
+func (repo *instrumentedRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	start := time.Now()
+	err := repo.ItemRepository.Tx(ctx, fn)
+	metrics.ObserveStorage(repo.backend, "tx", err, time.Since(start))
+	return err
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *instrumentedRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+// setupMetrics picks the metrics recorder selected by -metrics and times the
+// storage backend. It has to run before the repository gets wrapped in
+// retries or a cache, so every call that reaches the backend is measured.
+func setupMetrics(cfg Config) error {
+	switch cfg.Metrics {
+	case "none":
+		return nil
+	case "prometheus":
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		metrics = newPrometheusMetrics(registry)
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	case "statsd", "dogstatsd":
+		var tags []string
+		if cfg.StatsdTags != "" {
+			tags = strings.Split(cfg.StatsdTags, ",")
+		}
+		recorder, err := newStatsdMetrics(cfg.StatsdAddr, cfg.StatsdPrefix, cfg.Metrics == "dogstatsd", tags)
+		if err != nil {
+			return fmt.Errorf("connecting to statsd: %w", err)
+		}
+		metrics = recorder
+	default:
+		return fmt.Errorf("unknown metrics %q", cfg.Metrics)
+	}
+	itemRepository = &instrumentedRepository{ItemRepository: itemRepository, backend: cfg.Storage}
+	return nil
+}
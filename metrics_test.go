@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_prometheusMetrics(t *testing.T) {
+	defer func(original ItemRepository, recorder metricsRecorder, handler http.Handler) {
+		itemRepository, metrics, metricsHandler = original, recorder, handler
+	}(itemRepository, metrics, metricsHandler)
+	itemRepository = NewInMemoryItemRepository(seedItems...)
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, Storage: "memory", Metrics: "prometheus", CacheSize: 10, CacheTTL: time.Minute}
+	if err := setupMetrics(cfg); err != nil {
+		t.Fatal(err)
+	}
+	setupCache(cfg)
+	public, admin := newRouter(cfg), newAdminRouter(cfg)
+
+	for _, path := range []string{"/items/0", "/items/0", "/items/42"} {
+		public.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, want := range []string{
+		`http_request_duration_seconds_count{method="GET",route="/items/{id}",status="200"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/items/{id}",status="404"} 1`,
+		`storage_operation_duration_seconds_count{backend="memory",operation="get",outcome="ok"} 1`,
+		`storage_operation_duration_seconds_count{backend="memory",operation="get",outcome="not_found"} 1`,
+		`item_cache_lookups_total{result="hit"} 1`,
+		`item_cache_lookups_total{result="miss"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func Test_storageOutcome(t *testing.T) {
+	for err, want := range map[error]string{
+		nil:                              "ok",
+		NotFoundError:                    "not_found",
+		fmt.Errorf("tx: %w", errDryRun):  "rolled_back",
+		IDTakenError:                     "conflict",
+		errItemExists:                    "conflict",
+		&ExternalIDTakenError{ID: 1}:     "conflict",
+		&NameTakenError{ID: 1}:           "conflict",
+		errors.New("connection refused"): "error",
+	} {
+		if got := storageOutcome(err); got != want {
+			t.Errorf("storageOutcome(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteTimeouts holds the processing deadline per route group, parsed from a
+// flag value like "items=2s,ping=100ms".
+type RouteTimeouts map[string]time.Duration
+
+func (t RouteTimeouts) String() string {
+	var parts []string
+	for group, timeout := range t {
+		parts = append(parts, fmt.Sprintf("%s=%s", group, timeout))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (t RouteTimeouts) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("expected group=duration, got %q", part)
+		}
+		timeout, err := time.ParseDuration(pair[1])
+		if err != nil {
+			return err
+		}
+		t[strings.TrimSpace(pair[0])] = timeout
+	}
+	return nil
+}
+
+// For returns the deadline configured for the group, or fallback when the
+// group has none.
+func (t RouteTimeouts) For(group string, fallback time.Duration) time.Duration {
+	if timeout, ok := t[group]; ok {
+		return timeout
+	}
+	return fallback
+}
+
+// timeoutMiddleware puts a deadline on the request context, which the
+// repository honours so slow storage work is abandoned once it passes. When the
+// handler hasn't finished by then, the client gets a JSON 503 instead of a
+// connection cut off by the server's WriteTimeout halfway through a response.
+func timeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{header: http.Header{}, parent: w, ctx: ctx}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				tw.finish()
+			case <-ctx.Done():
+				if tw.expire() {
+					// the response is already on its way; the handler
+					// notices the deadline and stops streaming
+					select {
+					case p := <-panicked:
+						panic(p)
+					case <-done:
+					}
+					return
+				}
+				ServiceUnavailableResponse(w, "request timed out")
+			}
+		})
+	}
+}
+
+// timeoutWriter buffers a response so that it can be thrown away when the
+// deadline passes before the handler is done. A handler that flushes commits
+// to its response: what it buffered goes out, and the rest is written through
+// until ctx, the context with the deadline, is done.
+type timeoutWriter struct {
+	parent    http.ResponseWriter
+	ctx       context.Context
+	mu        sync.Mutex
+	header    http.Header
+	body      bytes.Buffer
+	code      int
+	timedOut  bool
+	streaming bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Unwrap returns the writer the response is flushed to, so handlers can find
+// what middlewares further out attached to it.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.parent
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.ctx.Err() != nil {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	if tw.streaming {
+		return tw.parent.Write(b)
+	}
+	return tw.body.Write(b)
+}
+
+// FlushError sends the response so far and writes the rest through, as
+// http.ResponseController.Flush expects.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.ctx.Err() != nil {
+		return http.ErrHandlerTimeout
+	}
+	if !tw.streaming {
+		tw.writeBuffered()
+		tw.streaming = true
+	}
+	return http.NewResponseController(tw.parent).Flush()
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// expire throws the response away, telling whether it was too late for that
+// because the handler already flushed.
+func (tw *timeoutWriter) expire() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+	return tw.streaming
+}
+
+// finish sends the buffered response once the handler is done.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.streaming {
+		tw.writeBuffered()
+	}
+}
+
+// writeBuffered sends the status, headers and body buffered so far to the
+// parent writer.
+func (tw *timeoutWriter) writeBuffered() {
+	for key, values := range tw.header {
+		tw.parent.Header()[key] = values
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	tw.parent.WriteHeader(tw.code)
+	tw.parent.Write(tw.body.Bytes())
+	tw.body.Reset()
+}
+
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Println(r.RequestURI)
+		next.ServeHTTP(w, r)
+	})
+}
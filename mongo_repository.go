@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	mongoItemsCollection    = "items"
+	mongoCountersCollection = "counters"
+)
+
+// MongoItemRepository stores items as documents in the items collection. IDs
+// are handed out by a counter document, because the API exposes small integer
+// IDs rather than ObjectIDs.
+type MongoItemRepository struct {
+	items    *mongo.Collection
+	counters *mongo.Collection
+	// uniqueNames adds a unique index on the name for -unique-names.
+	uniqueNames bool
+}
+
+func NewMongoItemRepository(db *mongo.Database) *MongoItemRepository {
+	return &MongoItemRepository{
+		items:    db.Collection(mongoItemsCollection),
+		counters: db.Collection(mongoCountersCollection),
+	}
+}
+
+// nameCollation compares names ignoring case, like -unique-names does.
+var nameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// EnsureIndexes creates the indexes the repository relies on: a unique index
+// on the item ID, a text index on name and description for searching, and a
+// unique index each on the external IDs and the UUIDs of the items that have
+// one.
+// With uniqueNames, a unique index on the name ignoring case makes sure two
+// transactions can't both take a name, as neither sees the other's insert.
+func (repo *MongoItemRepository) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("id_unique"),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetName("name_description_text"),
+		},
+		{
+			Keys: bson.D{{Key: "external_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("external_id_unique").
+				SetPartialFilterExpression(bson.M{"external_id": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{{Key: "uuid", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("uuid_unique").
+				SetPartialFilterExpression(bson.M{"uuid": bson.M{"$type": "string"}}),
+		},
+	}
+	if repo.uniqueNames {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(nameCollation).SetName("name_unique"),
+		})
+	}
+	_, err := repo.items.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func (repo *MongoItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	query := bson.M{}
+	if filter.NameContains != "" {
+		query["name"] = bson.M{"$regex": regexp.QuoteMeta(filter.NameContains)}
+	}
+	if filter.Currency != "" {
+		query["currency"] = filter.Currency
+	}
+	if filter.Slug != "" {
+		query["slug"] = filter.Slug
+	}
+	if filter.UUID != "" {
+		query["uuid"] = filter.UUID
+	}
+	if filter.ExternalID != "" {
+		query["external_id"] = filter.ExternalID
+	}
+	if filter.State != "" {
+		query["archived_at"] = bson.M{"$exists": filter.State == ItemStateArchived}
+	}
+	if len(filter.Quantity) > 0 {
+		quantity := bson.M{}
+		for _, c := range filter.Quantity {
+			quantity["$"+c.Op] = c.Value.Num().Int64()
+		}
+		query["quantity"] = quantity
+	}
+	cursor, err := repo.items.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Item{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	if len(filter.Price) == 0 && filter.PublishedAt.IsZero() {
+		return result, nil
+	}
+	// prices are decimal strings, which MongoDB can't compare as numbers, and
+	// either end of the publication may be missing
+	matching := result[:0]
+	for _, item := range result {
+		if filter.Matches(item) {
+			matching = append(matching, item)
+		}
+	}
+	return matching, nil
+}
+
+func (repo *MongoItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	var item Item
+	err := repo.items.FindOne(ctx, bson.M{"id": id}).Decode(&item)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, NotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (repo *MongoItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	id, err := repo.nextID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	item.ID = id
+
+	if _, err := repo.items.InsertOne(ctx, item); err != nil {
+		return nil, repo.externalIDTaken(ctx, item, err)
+	}
+	return &item, nil
+}
+
+func (repo *MongoItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	_, err := repo.counters.UpdateOne(ctx,
+		bson.M{"_id": mongoItemsCollection},
+		bson.M{"$max": bson.M{"value": item.ID}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repo.items.InsertOne(ctx, item); err != nil {
+		return nil, repo.idTaken(ctx, item, err)
+	}
+	return &item, nil
+}
+
+func (repo *MongoItemRepository) Update(ctx context.Context, item Item) error {
+	result, err := repo.items.ReplaceOne(ctx, bson.M{"id": item.ID}, item)
+	if err != nil {
+		return repo.externalIDTaken(ctx, item, err)
+	}
+	if result.MatchedCount == 0 {
+		return NotFoundError
+	}
+	return nil
+}
+
+func (repo *MongoItemRepository) Delete(ctx context.Context, id int) error {
+	result, err := repo.items.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return NotFoundError
+	}
+	return nil
+}
+
+// nameTaken turns a write refused by the unique index on the name into a
+// NameTakenError naming the item that has it; other errors pass unchanged.
+// The refused write aborted any transaction it was part of, so the item is
+// looked up outside of it.
+func (repo *MongoItemRepository) nameTaken(ctx context.Context, item Item, err error) error {
+	if !repo.uniqueNames || !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	var taken Item
+	query := bson.M{"name": item.Name, "id": bson.M{"$ne": item.ID}}
+	outside := mongo.NewSessionContext(ctx, nil)
+	if repo.items.FindOne(outside, query, options.FindOne().SetCollation(nameCollation)).Decode(&taken) != nil {
+		return err
+	}
+	return &NameTakenError{ID: taken.ID}
+}
+
+// externalIDTaken turns a write refused by the unique index on the external
+// ID into an ExternalIDTakenError naming the item that has it, and leaves the
+// others to nameTaken.
+func (repo *MongoItemRepository) externalIDTaken(ctx context.Context, item Item, err error) error {
+	if item.ExternalID == "" || !mongo.IsDuplicateKeyError(err) {
+		return repo.nameTaken(ctx, item, err)
+	}
+	var taken Item
+	query := bson.M{"external_id": item.ExternalID, "id": bson.M{"$ne": item.ID}}
+	outside := mongo.NewSessionContext(ctx, nil)
+	if repo.items.FindOne(outside, query).Decode(&taken) != nil {
+		return repo.nameTaken(ctx, item, err)
+	}
+	return &ExternalIDTakenError{ID: taken.ID}
+}
+
+// idTaken turns an insert refused by the unique index on the ID into an
+// IDTakenError, and leaves the others to externalIDTaken.
+func (repo *MongoItemRepository) idTaken(ctx context.Context, item Item, err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	outside := mongo.NewSessionContext(ctx, nil)
+	if repo.items.FindOne(outside, bson.M{"id": item.ID}).Err() == nil {
+		return IDTakenError
+	}
+	return repo.externalIDTaken(ctx, item, err)
+}
+
+func (repo *MongoItemRepository) nextID(ctx context.Context) (int, error) {
+	var counter struct {
+		Value int `bson:"value"`
+	}
+	err := repo.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": mongoItemsCollection},
+		bson.M{"$inc": bson.M{"value": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Value, nil
+}
+
+// Tx runs fn inside a MongoDB transaction. Transactions need a replica set or
+// a sharded cluster; a standalone server rejects them.
+func (repo *MongoItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	session, err := repo.items.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx context.Context) (interface{}, error) {
+		return nil, fn(mongoTx{repo: repo, sessionCtx: sessionCtx})
+	})
+	return err
+}
+
+// mongoTx is the repository as seen from inside a transaction. Operations only
+// join the transaction when they run with its session context, so that context
+// is used instead of the one passed in; it derives from the context Tx got, so
+// cancellation still reaches it.
+type mongoTx struct {
+	repo       *MongoItemRepository
+	sessionCtx context.Context
+}
+
+func (t mongoTx) List(_ context.Context, filter ItemFilter) ([]Item, error) {
+	return t.repo.List(t.sessionCtx, filter)
+}
+
+func (t mongoTx) Get(_ context.Context, id int) (*Item, error) {
+	return t.repo.Get(t.sessionCtx, id)
+}
+
+func (t mongoTx) Create(_ context.Context, item Item) (*Item, error) {
+	return t.repo.Create(t.sessionCtx, item)
+}
+
+func (t mongoTx) Insert(_ context.Context, item Item) (*Item, error) {
+	return t.repo.Insert(t.sessionCtx, item)
+}
+
+func (t mongoTx) Update(_ context.Context, item Item) error {
+	return t.repo.Update(t.sessionCtx, item)
+}
+
+func (t mongoTx) Delete(_ context.Context, id int) error {
+	return t.repo.Delete(t.sessionCtx, id)
+}
+
+// Tx within a transaction simply joins it.
+func (t mongoTx) Tx(_ context.Context, fn func(tx ItemRepository) error) error {
+	return fn(t)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// changeNotifier announces a change to an item to whoever listens.
+type changeNotifier interface {
+	Notify(event ItemEvent) error
+}
+
+// natsNotifier publishes item events on subjects under a prefix:
+// <prefix>.created for new items, <prefix>.<id>.updated and
+// <prefix>.<id>.deleted for changes to existing ones.
+type natsNotifier struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func (n *natsNotifier) Notify(event ItemEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return n.conn.Publish(notificationSubject(n.prefix, event), payload)
+}
+
+func notificationSubject(prefix string, event ItemEvent) string {
+	action := strings.ToLower(strings.TrimPrefix(event.Type, "Item"))
+	if event.Type == ItemCreated {
+		return prefix + "." + action
+	}
+	return fmt.Sprintf("%s.%d.%s", prefix, event.ItemID, action)
+}
+
+// itemNotifier announces the item events when -nats-url is set, nil otherwise.
+var itemNotifier changeNotifier
+
+// notifyingRepository announces every write that goes through it once it is
+// stored. Like the search index, a failing notification is only logged: the
+// write already happened. Notifications are sent at most once; consumers
+// that must not miss a change use Kafka instead.
+type notifyingRepository struct {
+	ItemRepository
+	notifier changeNotifier
+}
+
+func (repo *notifyingRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := repo.ItemRepository.Create(ctx, item)
+	if err == nil {
+		copied := *created
+		repo.notify(ItemEvent{Type: ItemCreated, ItemID: created.ID, Item: &copied})
+	}
+	return created, err
+}
+
+func (repo *notifyingRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		copied := *inserted
+		repo.notify(ItemEvent{Type: ItemCreated, ItemID: inserted.ID, Item: &copied})
+	}
+	return inserted, err
+}
+
+func (repo *notifyingRepository) Update(ctx context.Context, item Item) error {
+	err := repo.ItemRepository.Update(ctx, item)
+	if err == nil {
+		repo.notify(ItemEvent{Type: ItemUpdated, ItemID: item.ID, Item: &item})
+	}
+	return err
+}
+
+func (repo *notifyingRepository) Delete(ctx context.Context, id int) error {
+	err := repo.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		repo.notify(ItemEvent{Type: ItemDeleted, ItemID: id})
+	}
+	return err
+}
+
+// Tx records what fn writes and only announces it after the transaction
+// commits.
+func (repo *notifyingRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	var recorder *eventRecorder
+	err := repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		recorder = &eventRecorder{ItemRepository: tx}
+		return fn(recorder)
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range recorder.events {
+		repo.notify(event)
+	}
+	return nil
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *notifyingRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+func (repo *notifyingRepository) notify(event ItemEvent) {
+	if err := repo.notifier.Notify(event); err != nil {
+		log.Printf("notifying about item %d failed: %v", event.ItemID, err)
+	}
+}
+
+// setupNotifications connects to NATS when -nats-url is set and announces the
+// writes from then on. The client reconnects by itself when the connection
+// drops later.
+func setupNotifications(cfg Config) error {
+	if cfg.NatsURL == "" {
+		return nil
+	}
+	conn, err := nats.Connect(cfg.NatsURL, nats.Name("spike-simple-rest-api"), nats.MaxReconnects(-1))
+	if err != nil {
+		return fmt.Errorf("connecting to nats: %w", err)
+	}
+	itemNotifier = &natsNotifier{conn: conn, prefix: cfg.NatsSubjectPrefix}
+	itemRepository = &notifyingRepository{ItemRepository: itemRepository, notifier: itemNotifier}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type recordingNotifier struct {
+	subjects []string
+}
+
+func (n *recordingNotifier) Notify(event ItemEvent) error {
+	n.subjects = append(n.subjects, notificationSubject("items", event))
+	return nil
+}
+
+func Test_notifyingRepositoryAnnouncesCommittedWrites(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recordingNotifier{}
+	repo := &notifyingRepository{ItemRepository: NewInMemoryItemRepository(), notifier: notifier}
+
+	created, _ := repo.Create(ctx, Item{Name: "notified"})
+	repo.Update(ctx, Item{ID: created.ID, Name: "notified", Description: "updated"})
+	repo.Tx(ctx, func(tx ItemRepository) error {
+		tx.Create(ctx, Item{Name: "rolled back"})
+		return NotFoundError
+	})
+	repo.Tx(ctx, func(tx ItemRepository) error {
+		return tx.Delete(ctx, created.ID)
+	})
+
+	want := []string{"items.created", "items.0.updated", "items.0.deleted"}
+	if !reflect.DeepEqual(notifier.subjects, want) {
+		t.Errorf("got subjects %v, want %v", notifier.subjects, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// sortByPosition orders items by position. Items that have none yet come
+// last, and items with the same position keep their order, which is by ID.
+func sortByPosition(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Position, items[j].Position
+		return a != 0 && (b == 0 || a < b)
+	})
+}
+
+// moveRequest says where to move an item: right before or after another
+// item, or to an index in the list ordered by position, counting from 0.
+type moveRequest struct {
+	Before *int `json:"before"`
+	After  *int `json:"after"`
+	Index  *int `json:"index"`
+}
+
+// moveItem moves the item to the place the body asks for and numbers every
+// item from 1 in the new order, so the positions stay without gaps or ties.
+// Only the items whose position changed are written, all in one
+// transaction.
+func moveItem(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	var move moveRequest
+	if err := decodeBody(r, &move); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	given := 0
+	for _, field := range []*int{move.Before, move.After, move.Index} {
+		if field != nil {
+			given++
+		}
+	}
+	if given != 1 {
+		ErrorCodeResponse(w, InvalidMoveCode)
+		return
+	}
+
+	var moved *Item
+	invalid := false
+	err = itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		moved, invalid = nil, false
+		items, err := tx.List(r.Context(), ItemFilter{})
+		if err != nil {
+			return err
+		}
+		sortByPosition(items)
+		from := -1
+		for i, item := range items {
+			if item.ID == *id {
+				from = i
+			}
+		}
+		if from < 0 {
+			return NotFoundError
+		}
+		item := items[from]
+		rest := append(items[:from:from], items[from+1:]...)
+
+		to := -1
+		switch {
+		case move.Index != nil:
+			if *move.Index >= 0 && *move.Index <= len(rest) {
+				to = *move.Index
+			}
+		default:
+			target := move.Before
+			if target == nil {
+				target = move.After
+			}
+			for i, other := range rest {
+				if other.ID == *target {
+					to = i
+					if move.After != nil {
+						to++
+					}
+				}
+			}
+		}
+		if to < 0 {
+			invalid = true
+			return nil
+		}
+
+		ordered := append(append(append([]Item(nil), rest[:to]...), item), rest[to:]...)
+		for i := range ordered {
+			if ordered[i].Position == i+1 {
+				continue
+			}
+			ordered[i].Position = i + 1
+			if err := tx.Update(r.Context(), ordered[i]); err != nil {
+				return err
+			}
+		}
+		moved = &ordered[to]
+		return nil
+	})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not move item")
+		return
+	}
+	if invalid {
+		ErrorCodeResponse(w, InvalidMoveCode)
+		return
+	}
+
+	SuccessResponse(w, moved)
+}
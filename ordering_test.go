@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_moveItem(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "a"}, Item{ID: 1, Name: "b"}, Item{ID: 2, Name: "c"}, Item{ID: 3, Name: "d"})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/", listItems).Methods(http.MethodGet)
+	router.HandleFunc("/items/", createItem).Methods(http.MethodPost)
+	router.HandleFunc("/items/{id}/move", moveItem)
+
+	move := func(id, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/"+id+"/move", bytes.NewBufferString(body)))
+		return rr
+	}
+	order := func() string {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/?sort=position", nil))
+		var items []Item
+		json.Unmarshal(rr.Body.Bytes(), &items)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	steps := []struct{ id, body, want string }{
+		{"3", `{"index":0}`, "d,a,b,c"},
+		{"0", `{"after":2}`, "d,b,c,a"},
+		{"2", `{"before":3}`, "c,d,b,a"},
+		{"2", `{"index":3}`, "d,b,a,c"},
+	}
+	for _, step := range steps {
+		if rr := move(step.id, step.body); rr.Code != http.StatusOK {
+			t.Fatalf("moving %s with %s returned %d %s", step.id, step.body, rr.Code, rr.Body)
+		}
+		if got := order(); got != step.want {
+			t.Errorf("after moving %s with %s the order is %s, want %s", step.id, step.body, got, step.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/", bytes.NewBufferString(`{"name":"e","position":1}`)))
+	if got := order(); got != "d,b,a,c,e" {
+		t.Errorf("expected a new item to come last, got %s", got)
+	}
+
+	items, _ := itemRepository.List(context.Background(), ItemFilter{})
+	for _, item := range items {
+		if item.Name != "e" && (item.Position < 1 || item.Position > 4) {
+			t.Errorf("expected the positions renumbered from 1, got %+v", item)
+		}
+	}
+
+	for _, body := range []string{`{}`, `{"before":1,"after":2}`, `{"before":2}`, `{"after":42}`, `{"index":9}`, `{"index":-1}`} {
+		if rr := move("2", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("moving with %s returned %d, want 422", body, rr.Code)
+		}
+	}
+	if rr := move("42", `{"index":0}`); rr.Code != http.StatusNotFound {
+		t.Errorf("moving a missing item returned %d, want 404", rr.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// eventPublisher hands item events to a message broker. Publish returns once
+// the broker has accepted all of them.
+type eventPublisher interface {
+	Publish(ctx context.Context, events []ItemEvent) error
+}
+
+// outboxRelay publishes the events of the event log. The log is the outbox:
+// an event is written in the same append as the change it describes, so no
+// change goes unpublished, even when the process crashes before publishing.
+// The relay remembers the sequence number of the last published event in a
+// file. After a crash between publishing and saving that number, the events
+// since are published again; consumers drop them by their sequence number.
+type outboxRelay struct {
+	events     *EventLog
+	publisher  eventPublisher
+	offsetPath string
+	published  int64
+}
+
+func newOutboxRelay(events *EventLog, publisher eventPublisher, offsetPath string) (*outboxRelay, error) {
+	relay := &outboxRelay{events: events, publisher: publisher, offsetPath: offsetPath}
+	content, err := os.ReadFile(offsetPath)
+	if os.IsNotExist(err) {
+		return relay, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	relay.published, err = strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	return relay, err
+}
+
+// Relay publishes the events appended since the last run; it runs as a job.
+func (relay *outboxRelay) Relay(ctx context.Context) error {
+	pending := relay.events.After(relay.published)
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := relay.publisher.Publish(ctx, pending); err != nil {
+		return err
+	}
+	relay.published = pending[len(pending)-1].Sequence
+	return relay.saveOffset()
+}
+
+func (relay *outboxRelay) saveOffset() error {
+	return writeFileAtomically(relay.offsetPath, []byte(strconv.FormatInt(relay.published, 10)))
+}
+
+// kafkaPublisher writes each event as a message keyed by item ID, so the
+// events of one item stay in order on one partition.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(brokers []string, topic string) *kafkaPublisher {
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+	}}
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, events []ItemEvent) error {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = kafka.Message{
+			Key:   []byte(strconv.Itoa(event.ItemID)),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "event-type", Value: []byte(event.Type)},
+				{Key: "sequence", Value: []byte(strconv.FormatInt(event.Sequence, 10))},
+			},
+		}
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+// setupKafka adds the outbox relay to the jobs when -kafka-brokers is set.
+func setupKafka(cfg Config) error {
+	if cfg.KafkaBrokers == "" {
+		return nil
+	}
+	if itemEvents == nil {
+		return errors.New("publishing to kafka needs -storage events, whose event log serves as the outbox")
+	}
+	relay, err := newOutboxRelay(itemEvents, newKafkaPublisher(strings.Split(cfg.KafkaBrokers, ","), cfg.KafkaTopic), cfg.KafkaOffsetPath)
+	if err != nil {
+		return err
+	}
+	jobs.Add(Job{Name: "kafka-outbox-relay", Every: cfg.KafkaRelayInterval, Run: relay.Relay})
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+type recordingPublisher struct {
+	published []ItemEvent
+	err       error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, events []ItemEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, events...)
+	return nil
+}
+
+func Test_outboxRelayPublishesEachEventOnce(t *testing.T) {
+	ctx := context.Background()
+	offsetPath := filepath.Join(t.TempDir(), "kafka.offset")
+	events, _ := OpenEventLog("")
+	repo := NewEventSourcedItemRepository(events)
+	publisher := &recordingPublisher{err: errors.New("broker down")}
+	relay, err := newOutboxRelay(events, publisher, offsetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, _ := repo.Create(ctx, Item{Name: "outbox"})
+	if err := relay.Relay(ctx); err == nil {
+		t.Fatal("expected the failing publisher's error")
+	}
+	publisher.err = nil
+	repo.Update(ctx, Item{ID: created.ID, Name: "outbox", Description: "updated"})
+	if err := relay.Relay(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 2 || publisher.published[0].Type != ItemCreated || publisher.published[1].Type != ItemUpdated {
+		t.Fatalf("unexpected published events: %+v", publisher.published)
+	}
+
+	// a restarted relay continues after the last published event
+	restarted, err := newOutboxRelay(events, publisher, offsetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Delete(ctx, created.ID)
+	if err := restarted.Relay(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 3 || publisher.published[2].Type != ItemDeleted {
+		t.Errorf("unexpected published events after restart: %+v", publisher.published)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// pagingSnapshotTTL is how long a snapshot of GET /items/?snapshot=true can
+// be paged through after it was taken.
+const pagingSnapshotTTL = 10 * time.Minute
+
+// maxPagingSnapshots caps the snapshots kept at once.
+const maxPagingSnapshots = 100
+
+// maxPagingSnapshotItems caps the items all snapshots hold together, as each
+// keeps a copy of its listing in memory.
+const maxPagingSnapshotItems = 100000
+
+// SnapshotsFullError is returned when another snapshot would keep more than
+// maxPagingSnapshots snapshots or maxPagingSnapshotItems items.
+var SnapshotsFullError = errors.New("too many paging snapshots are kept")
+
+// pagingSnapshot is the listing a paging session reads its pages from.
+type pagingSnapshot struct {
+	id    string
+	items []Item
+	// unpublished tells that it holds unpublished items, which only admins
+	// may page through.
+	unpublished bool
+	taken       time.Time
+}
+
+// pagingSnapshotStore keeps the listings of paging sessions, so their pages
+// neither skip nor repeat items when items are written between two pages.
+// A listing is copied from the backend when the session starts, which works
+// the same for every backend. The snapshots live in the memory of this
+// instance.
+type pagingSnapshotStore struct {
+	mu        sync.Mutex
+	snapshots []*pagingSnapshot
+	// items counts the items of all snapshots.
+	items    int
+	maxItems int
+	now      func() time.Time
+}
+
+var pagingSnapshots = newPagingSnapshotStore()
+
+func newPagingSnapshotStore() *pagingSnapshotStore {
+	return &pagingSnapshotStore{maxItems: maxPagingSnapshotItems, now: time.Now}
+}
+
+// Take keeps the items, in their order, and returns the ID to page through
+// them by. It fails with SnapshotsFullError rather than drop the snapshots
+// others are paging through.
+func (s *pagingSnapshotStore) Take(items []Item, unpublished bool) (string, error) {
+	var random [16]byte
+	rand.Read(random[:])
+	snapshot := &pagingSnapshot{id: hex.EncodeToString(random[:]), items: items, unpublished: unpublished, taken: s.now()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if len(s.snapshots) >= maxPagingSnapshots || s.items+len(items) > s.maxItems {
+		return "", SnapshotsFullError
+	}
+	s.snapshots = append(s.snapshots, snapshot)
+	s.items += len(items)
+	return snapshot.id, nil
+}
+
+// Get returns the snapshot with the ID, unless it expired.
+func (s *pagingSnapshotStore) Get(id string) (*pagingSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	for _, snapshot := range s.snapshots {
+		if snapshot.id == id {
+			return snapshot, true
+		}
+	}
+	return nil, false
+}
+
+// expire drops the snapshots older than pagingSnapshotTTL, which are the
+// first ones.
+func (s *pagingSnapshotStore) expire() {
+	for len(s.snapshots) > 0 && s.now().Sub(s.snapshots[0].taken) > pagingSnapshotTTL {
+		s.items -= len(s.snapshots[0].items)
+		s.snapshots[0] = nil
+		s.snapshots = s.snapshots[1:]
+	}
+}
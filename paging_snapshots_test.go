@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_pagingSnapshots(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "Scarf"}, Item{ID: 1, Name: "Hat"}, Item{ID: 2, Name: "Glove"}, Item{ID: 3, Name: "Sock"})
+
+	w := api.Request("GET", "/items/?snapshot=true&limit=2", nil)
+	first := decodeResponse[[]Item](t, w, http.StatusOK)
+	id := w.Header().Get("X-Snapshot-Id")
+	if len(first) != 2 || first[0].Name != "Scarf" || id == "" {
+		t.Fatalf("expected the first page of a snapshot, got %+v %q", first, id)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, "snapshot="+id) {
+		t.Errorf("expected the links to page through the snapshot, got %s", link)
+	}
+
+	// writes between the pages don't move the items of the snapshot
+	if err := itemRepository.Delete(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := itemRepository.Create(context.Background(), Item{Name: "Boot"}); err != nil {
+		t.Fatal(err)
+	}
+	w = api.Request("GET", "/items/?snapshot="+id+"&limit=2&offset=2", nil)
+	second := decodeResponse[[]Item](t, w, http.StatusOK)
+	if len(second) != 2 || second[0].Name != "Glove" || second[1].Name != "Sock" {
+		t.Errorf("expected the second page of the snapshot, got %+v", second)
+	}
+	if live := decodeResponse[[]Item](t, api.Request("GET", "/items/?limit=2&offset=2", nil), http.StatusOK); live[0].Name != "Sock" {
+		t.Errorf("expected the live list to see the writes, got %+v", live)
+	}
+
+	if w := api.Request("GET", "/items/?snapshot=unknown", nil); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "SNAPSHOT_NOT_FOUND") {
+		t.Errorf("expected 404 for an unknown snapshot, got %d %s", w.Code, w.Body)
+	}
+}
+
+func Test_pagingSnapshotStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newPagingSnapshotStore()
+	store.now = func() time.Time { return now }
+
+	expiring, _ := store.Take([]Item{{Name: "Scarf"}}, false)
+	now = now.Add(pagingSnapshotTTL / 2)
+	kept, _ := store.Take(nil, false)
+	now = now.Add(pagingSnapshotTTL/2 + time.Second)
+	if _, ok := store.Get(expiring); ok {
+		t.Error("expected the snapshot to expire")
+	}
+	if _, ok := store.Get(kept); !ok {
+		t.Error("expected the later snapshot to be kept")
+	}
+
+	for range maxPagingSnapshots - 1 {
+		store.Take(nil, false)
+	}
+	if _, err := store.Take(nil, false); err != SnapshotsFullError {
+		t.Errorf("expected SnapshotsFullError once %d snapshots are kept, got %v", maxPagingSnapshots, err)
+	}
+	if _, ok := store.Get(kept); !ok {
+		t.Error("expected the oldest snapshot to be kept rather than dropped")
+	}
+}
+
+func Test_pagingSnapshotsCapItems(t *testing.T) {
+	api := newTestAPI(t)
+	pagingSnapshots.maxItems = 3
+	for _, name := range []string{"Scarf", "Hat"} {
+		api.Request("POST", "/items/", map[string]any{"name": name})
+	}
+
+	if w := api.Request("GET", "/items/?snapshot=true", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected the first snapshot to be taken, got %d %s", w.Code, w.Body)
+	}
+	w := api.Request("GET", "/items/?snapshot=true", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "SNAPSHOTS_FULL") {
+		t.Errorf("expected 503 once the snapshots would hold more than 3 items, got %d %s", w.Code, w.Body)
+	}
+	if w := api.Request("GET", "/items/", nil); w.Code != http.StatusOK {
+		t.Errorf("expected listing without a snapshot to keep working, got %d", w.Code)
+	}
+}
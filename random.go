@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+)
+
+// getRandomItem returns one of the items GET /items/ lists with the same
+// filters, each equally likely. Every request picks anew, so the response
+// must not be cached.
+func getRandomItem(w http.ResponseWriter, r *http.Request) {
+	filter, ok := requestItemFilter(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := itemRepository.List(r.Context(), filter)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not pick an item")
+		return
+	}
+	if len(items) == 0 {
+		NotFoundResponse(w, "no item matches the filters")
+		return
+	}
+	item := items[rand.IntN(len(items))]
+
+	w.Header().Set("Cache-Control", "no-store")
+	localizeItem(w, r, &item)
+	SuccessResponse(w, withStar(r, &item))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_getRandomItem(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "apple"}, Item{ID: 1, Name: "apricot"}, Item{ID: 2, Name: "banana"})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/random", getRandomItem)
+
+	picked := map[string]int{}
+	for i := 0; i < 200; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/random?filter=ap", nil))
+		var item Item
+		json.Unmarshal(rr.Body.Bytes(), &item)
+		if rr.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("expected the response not to be cached, got %v", rr.Header())
+		}
+		picked[item.Name]++
+	}
+	if len(picked) != 2 || picked["apple"] == 0 || picked["apricot"] == 0 {
+		t.Errorf("expected both matching items to be picked, got %v", picked)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/random?filter=cherry", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when nothing matches, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter counts the requests of every client: at most limit per
+// window, and at most quota per UTC day. Either is off when it is 0.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	quota   int
+	clients map[string]*clientUsage
+	now     func() time.Time
+}
+
+type clientUsage struct {
+	windowStart time.Time
+	inWindow    int
+	day         time.Time
+	today       int
+}
+
+// Usage is what GET /me/usage reports about the caller.
+type Usage struct {
+	Client string `json:"client"`
+
+	Limit     int        `json:"limit,omitempty"`
+	Window    string     `json:"window,omitempty"`
+	InWindow  int        `json:"requests_in_window"`
+	Remaining int        `json:"remaining,omitempty"`
+	Reset     *time.Time `json:"reset,omitempty"`
+
+	DailyQuota     int        `json:"daily_quota,omitempty"`
+	Today          int        `json:"requests_today"`
+	QuotaRemaining int        `json:"quota_remaining,omitempty"`
+	QuotaReset     *time.Time `json:"quota_reset,omitempty"`
+}
+
+// rateLimits limits the public API; it is off while neither -rate-limit nor
+// -daily-quota is set. A reload changes its limits in place.
+var rateLimits = newRateLimiter(0, 0, 0)
+
+func newRateLimiter(limit int, window time.Duration, quota int) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, quota: quota, clients: map[string]*clientUsage{}, now: time.Now}
+}
+
+// on tells whether any limit is set.
+func (l *rateLimiter) on() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit > 0 || l.quota > 0
+}
+
+// configure sets the limits. The clients keep the requests counted so far.
+func (l *rateLimiter) configure(limit int, window time.Duration, quota int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.window, l.quota = limit, window, quota
+}
+
+// take counts a request of client unless that would go over a limit, in
+// which case it returns the code of the limit that was hit.
+func (l *rateLimiter) take(client string) (Usage, *ErrorCode) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usage := l.current(client)
+	switch {
+	case l.limit > 0 && usage.inWindow >= l.limit:
+		return l.report(client, usage), &RateLimitedCode
+	case l.quota > 0 && usage.today >= l.quota:
+		return l.report(client, usage), &QuotaExceededCode
+	}
+	usage.inWindow++
+	usage.today++
+	return l.report(client, usage), nil
+}
+
+// Usage reports the requests of client without counting one.
+func (l *rateLimiter) Usage(client string) Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.report(client, l.current(client))
+}
+
+// current returns the usage of client, starting a new window or day when the
+// previous one is over.
+func (l *rateLimiter) current(client string) *clientUsage {
+	now := l.now().UTC()
+	usage, ok := l.clients[client]
+	if !ok {
+		usage = &clientUsage{}
+		l.clients[client] = usage
+	}
+	if l.window > 0 && !now.Before(usage.windowStart.Add(l.window)) {
+		usage.windowStart, usage.inWindow = now, 0
+	}
+	if day := now.Truncate(24 * time.Hour); !day.Equal(usage.day) {
+		usage.day, usage.today = day, 0
+	}
+	return usage
+}
+
+func (l *rateLimiter) report(client string, usage *clientUsage) Usage {
+	report := Usage{Client: client, InWindow: usage.inWindow, Today: usage.today}
+	if l.limit > 0 {
+		report.Limit = l.limit
+		report.Window = l.window.String()
+		report.Remaining = max(l.limit-usage.inWindow, 0)
+		reset := usage.windowStart.Add(l.window)
+		report.Reset = &reset
+	}
+	if l.quota > 0 {
+		report.DailyQuota = l.quota
+		report.QuotaRemaining = max(l.quota-usage.today, 0)
+		reset := usage.day.Add(24 * time.Hour)
+		report.QuotaReset = &reset
+	}
+	return report
+}
+
+// prune forgets the clients that made no request today and whose window is
+// over; it runs as a job.
+func (l *rateLimiter) prune(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	for client, usage := range l.clients {
+		if usage.day.Before(today) && !now.Before(usage.windowStart.Add(l.window)) {
+			delete(l.clients, client)
+		}
+	}
+	return nil
+}
+
+// rateLimitMiddleware answers 429 once a client goes over a limit. Every
+// response tells the client where it stands in X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset (Unix seconds), for the
+// per-window limit or, without one, the daily quota.
+func rateLimitMiddleware(limiter *rateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			usage, exceeded := limiter.take(rateLimitClient(r))
+			limit, remaining, reset := usage.Limit, usage.Remaining, usage.Reset
+			if limit == 0 {
+				limit, remaining, reset = usage.DailyQuota, usage.QuotaRemaining, usage.QuotaReset
+			}
+			if reset == nil {
+				// a reload switched the limits off while the request was on its way
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+			if exceeded != nil {
+				retryAt := usage.Reset
+				if exceeded.Code == QuotaExceededCode.Code {
+					retryAt = usage.QuotaReset
+				}
+				wait := math.Ceil(retryAt.Sub(limiter.now()).Seconds())
+				w.Header().Set("Retry-After", strconv.Itoa(int(max(wait, 1))))
+				ErrorCodeResponse(w, *exceeded)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func myUsage(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, rateLimits.Usage(rateLimitClient(r)))
+}
+
+// rateLimitClient tells clients apart by their API token or user, or by IP
+// when they have neither.
+func rateLimitClient(r *http.Request) string {
+	if token := requestToken(r); token != nil {
+		return "token:" + token.ID
+	}
+	if user := requestUser(r); user != nil {
+		return "user:" + user.ID
+	}
+	return remoteIP(r)
+}
+
+// setupRateLimits forgets idle clients every hour. The job runs even while
+// the limits are off, as a reload may switch them on; serveConfig sets them.
+func setupRateLimits() {
+	jobs.Add(Job{Name: "rate-limit-prune", Every: time.Hour, Run: rateLimits.prune})
+}
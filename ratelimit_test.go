@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_rateLimits(t *testing.T) {
+	defer func(original *rateLimiter) { rateLimits = original }(rateLimits)
+	rateLimits = newRateLimiter(2, time.Minute, 3)
+	now := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	rateLimits.now = func() time.Time { return now }
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/ping")
+	if w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != "1" || w.Header().Get("X-RateLimit-Reset") != "1714608000" {
+		t.Errorf("unexpected rate limit headers %v", w.Header())
+	}
+	get("/ping")
+	if w := get("/ping"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60, got %d %v", w.Code, w.Header())
+	}
+
+	now = now.Add(time.Minute)
+	get("/ping") // the day is over, so this is the first request of the new day
+	var usage Usage
+	json.Unmarshal(get("/me/usage").Body.Bytes(), &usage)
+	if usage.InWindow != 2 || usage.Today != 2 || usage.QuotaRemaining != 1 || usage.DailyQuota != 3 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	now = now.Add(time.Minute)
+	get("/ping")
+	if w := get("/ping"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the daily quota to be used up, got %d", w.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Rating sums up the scores users gave an item. Sum is kept, so a new score
+// updates the average without reading every score again.
+type Rating struct {
+	Average float64 `json:"average" bson:"average"`
+	Count   int     `json:"count" bson:"count"`
+	Sum     int     `json:"sum" bson:"sum"`
+}
+
+// add counts score, replacing the user's previous score unless that is 0.
+func (r *Rating) add(score, previous int) {
+	if previous == 0 {
+		r.Count++
+	}
+	r.Sum += score - previous
+	r.Average = math.Round(float64(r.Sum)/float64(r.Count)*100) / 100
+}
+
+// Rating returns the score the user gave the item, 0 when there is none.
+func (s *userStore) Rating(userID string, itemID int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		return user.Ratings[itemID]
+	}
+	return 0
+}
+
+// Rate records the score the user gave the item, or forgets it for a score of
+// 0. The ratings are copied rather than changed, as the users handed out by
+// Authenticate share them.
+func (s *userStore) Rate(userID string, itemID, score int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return NotFoundError
+	}
+	ratings := maps.Clone(user.Ratings)
+	if ratings == nil {
+		ratings = map[int]int{}
+	}
+	if score == 0 {
+		delete(ratings, itemID)
+	} else {
+		ratings[itemID] = score
+	}
+	previous := user.Ratings
+	user.Ratings = ratings
+	if err := s.save(); err != nil {
+		user.Ratings = previous
+		return err
+	}
+	return nil
+}
+
+// sortByRating orders items from the best average rating down. Unrated items
+// come last, and items rated alike keep their order.
+func sortByRating(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Rating, items[j].Rating
+		return a != nil && (b == nil || a.Average > b.Average)
+	})
+}
+
+// ratingMu makes reading a user's previous score and replacing it one step,
+// so two ratings of the same user can't both count as their first.
+var ratingMu sync.Mutex
+
+type ratingRequest struct {
+	Score int `json:"score"`
+}
+
+// rateItem records the score of the user the access token belongs to. Rating
+// an item again replaces the earlier score.
+// The user's score is recorded as the last step of the item's transaction, so
+// failing to record it rolls the item back, and it is put back as it was when
+// the transaction fails to commit.
+func rateItem(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		ErrorCodeResponse(w, UserRequiredCode)
+		return
+	}
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	var req ratingRequest
+	if err := decodeBody(r, &req); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	if req.Score < 1 || req.Score > 5 {
+		ValidationErrorResponse(w, []FieldError{newFieldError("score", InvalidRatingCode)})
+		return
+	}
+
+	ratingMu.Lock()
+	defer ratingMu.Unlock()
+	previous := users.Rating(user.ID, *id)
+	var item *Item
+	err = itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		item, err = tx.Get(r.Context(), *id)
+		if err != nil {
+			return err
+		}
+		rating := Rating{}
+		if item.Rating != nil {
+			rating = *item.Rating
+		}
+		rating.add(req.Score, previous)
+		item.Rating = &rating
+		if err := tx.Update(r.Context(), *item); err != nil {
+			return err
+		}
+		return users.Rate(user.ID, *id, req.Score)
+	})
+	if err != nil {
+		if undoErr := users.Rate(user.ID, *id, previous); undoErr != nil {
+			log.Printf("could not put back the score of user %s for item %d: %v", user.ID, *id, undoErr)
+		}
+		RepositoryErrorResponse(w, err, "could not rate item")
+		return
+	}
+
+	SuccessResponse(w, withStar(r, item))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_rateItem(t *testing.T) {
+	do, login := newUserTestAPI(t, Item{ID: 0, Name: "unrated"}, Item{ID: 1, Name: "good"}, Item{ID: 2, Name: "great"})
+	alice, bob := login("alice@example.com"), login("bob@example.com")
+
+	rate := func(token, id, body string) (int, *Rating) {
+		w := do("POST", "/items/"+id+"/ratings", token, body)
+		var item Item
+		json.Unmarshal(w.Body.Bytes(), &item)
+		return w.Code, item.Rating
+	}
+
+	rate(alice, "1", `{"score":4}`)
+	if _, rating := rate(bob, "1", `{"score":1}`); rating == nil || rating.Count != 2 || rating.Average != 2.5 {
+		t.Errorf("expected two ratings averaging 2.5, got %+v", rating)
+	}
+	if _, rating := rate(bob, "1", `{"score":3}`); rating == nil || rating.Count != 2 || rating.Average != 3.5 {
+		t.Errorf("expected bob's new score to replace his old one, got %+v", rating)
+	}
+	rate(alice, "2", `{"score":5}`)
+
+	for _, body := range []string{`{"score":0}`, `{"score":6}`, `{}`} {
+		if code, _ := rate(alice, "2", body); code != http.StatusUnprocessableEntity {
+			t.Errorf("rating with %s returned %d, want 422", body, code)
+		}
+	}
+	if code, _ := rate("", "2", `{"score":5}`); code != http.StatusUnauthorized {
+		t.Errorf("expected rating without a user to be refused, got %d", code)
+	}
+	if code, _ := rate(alice, "42", `{"score":5}`); code != http.StatusNotFound {
+		t.Errorf("expected rating a missing item to fail, got %d", code)
+	}
+
+	var items []Item
+	json.Unmarshal(do("GET", "/items/?sort=-rating", "", "").Body.Bytes(), &items)
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	if got := strings.Join(names, ","); got != "great,good,unrated" {
+		t.Errorf("expected the best rated first, got %s", got)
+	}
+}
+
+// failingCommitRepository runs the function of a transaction, then fails to
+// commit what it wrote.
+type failingCommitRepository struct {
+	ItemRepository
+}
+
+func (repo *failingCommitRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errors.New("connection reset during commit")
+	})
+}
+
+func Test_rateItemFailingToCommit(t *testing.T) {
+	do, login := newUserTestAPI(t, Item{ID: 0, Name: "lamp"})
+	alice := login("alice@example.com")
+	do("POST", "/items/0/ratings", alice, `{"score":4}`)
+
+	stored := itemRepository
+	itemRepository = &failingCommitRepository{ItemRepository: stored}
+	if w := do("POST", "/items/0/ratings", alice, `{"score":1}`); w.Code < 500 {
+		t.Fatalf("expected the failed commit to fail the rating, got %d", w.Code)
+	}
+	itemRepository = stored
+
+	if score := users.Rating(users.byEmail("alice@example.com").ID, 0); score != 4 {
+		t.Errorf("expected the user's earlier score to be put back, got %d", score)
+	}
+	if item, _ := itemRepository.Get(context.Background(), 0); item.Rating == nil || item.Rating.Average != 4 {
+		t.Errorf("expected the item's rating to be left alone, got %+v", item.Rating)
+	}
+
+	// the other way round: the user's score can't be saved
+	path := users.path
+	users.path = filepath.Join(t.TempDir(), "missing", "users.json")
+	if w := do("POST", "/items/0/ratings", alice, `{"score":1}`); w.Code < 500 {
+		t.Fatalf("expected the unsaved score to fail the rating, got %d", w.Code)
+	}
+	users.path = path
+	if item, _ := itemRepository.Get(context.Background(), 0); item.Rating == nil || item.Rating.Average != 4 || item.Rating.Count != 1 {
+		t.Errorf("expected the item's rating to be rolled back, got %+v", item.Rating)
+	}
+}
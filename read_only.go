@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// readOnly refuses every write to the items, for replicas serving a copy of
+// the data that is written elsewhere, see -read-only.
+var readOnly bool
+
+// validateRoute is POST /items/validate in the current router, which writes
+// nothing and so stays open on a read-only instance.
+var validateRoute *mux.Route
+
+// readOnlyMiddleware answers the requests that would change items with 405
+// READ_ONLY.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil && route == validateRoute {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		ErrorCodeResponse(w, ReadOnlyCode)
+	})
+}
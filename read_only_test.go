@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_readOnly(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "lamp"})
+	isolate(t, &readOnly, true)
+	api.Reroute()
+
+	for _, tt := range []struct {
+		method  string
+		path    string
+		body    any
+		headers []string
+	}{
+		{"POST", "/items/", Item{Name: "desk"}, nil},
+		{"PUT", "/items/0", Item{Name: "lamp", Quantity: 2}, nil},
+		{"PATCH", "/items/0", `[{"op": "add", "path": "/quantity", "value": 2}]`, []string{"Content-Type", jsonPatchMediaType}},
+		{"DELETE", "/items/0", nil, nil},
+		{"POST", "/items/0/archive", nil, nil},
+		{"POST", "/items/?dry_run=true", Item{Name: "desk"}, nil},
+	} {
+		w := api.Request(tt.method, tt.path, tt.body, tt.headers...)
+		if w.Code != http.StatusMethodNotAllowed || !strings.Contains(w.Body.String(), "READ_ONLY") || w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s %s: expected 405 READ_ONLY, got %d %s", tt.method, tt.path, w.Code, w.Body)
+		}
+	}
+	if items, _ := itemRepository.List(context.Background(), ItemFilter{}); len(items) != 1 || items[0].Quantity != 0 || items[0].ArchivedAt != nil {
+		t.Errorf("expected the items to be left alone, got %+v", items)
+	}
+
+	if items := decodeResponse[[]Item](t, api.Request("GET", "/items/", nil), http.StatusOK); len(items) != 1 {
+		t.Errorf("expected the items to be listed, got %+v", items)
+	}
+	if w := api.Request("POST", "/items/validate", Item{Name: "desk"}); w.Code != http.StatusOK {
+		t.Errorf("expected items to be validated, got %d %s", w.Code, w.Body)
+	}
+}
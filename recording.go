@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecordedBody caps how much of a request or response body a recording
+// keeps.
+const maxRecordedBody = 64 << 10
+
+// redactedValue replaces the values of redacted fields and headers.
+const redactedValue = "[REDACTED]"
+
+// redactedHeaders are never recorded, whatever -debug-redact says.
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Recording is a request and the response the API gave it. JSON bodies are
+// kept as JSON with the redacted fields replaced; others as text.
+type Recording struct {
+	ID              int64       `json:"id"`
+	At              time.Time   `json:"at"`
+	Duration        string      `json:"duration"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     any         `json:"request_body,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    any         `json:"response_body,omitempty"`
+}
+
+// requestRecorder keeps the latest recordings in a ring buffer, in memory
+// only.
+type requestRecorder struct {
+	mu         sync.Mutex
+	recordings []Recording
+	next       int
+	lastID     int64
+	// redact holds the field paths to redact, split at the dots.
+	redact [][]string
+}
+
+// recordings captures requests for debugging; nil without -debug-recordings.
+var recordings *requestRecorder
+
+// newRequestRecorder keeps the last size recordings and redacts the fields
+// of the comma-separated paths, like "password,items.secret", in their
+// bodies.
+func newRequestRecorder(size int, redact string) *requestRecorder {
+	recorder := &requestRecorder{recordings: make([]Recording, 0, size)}
+	for _, path := range strings.Split(redact, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			recorder.redact = append(recorder.redact, strings.Split(path, "."))
+		}
+	}
+	return recorder
+}
+
+func (rec *requestRecorder) add(recording Recording) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.lastID++
+	recording.ID = rec.lastID
+	if len(rec.recordings) < cap(rec.recordings) {
+		rec.recordings = append(rec.recordings, recording)
+		return
+	}
+	rec.recordings[rec.next] = recording
+	rec.next = (rec.next + 1) % len(rec.recordings)
+}
+
+// Recent returns the recordings, newest first.
+func (rec *requestRecorder) Recent() []Recording {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	result := make([]Recording, 0, len(rec.recordings))
+	for i := range rec.recordings {
+		index := (rec.next - 1 - i + 2*len(rec.recordings)) % len(rec.recordings)
+		result = append(result, rec.recordings[index])
+	}
+	return result
+}
+
+// Clear drops the recordings.
+func (rec *requestRecorder) Clear() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.recordings = rec.recordings[:0]
+	rec.next = 0
+}
+
+// body turns a captured body into what a recording keeps: the redacted JSON
+// when it is JSON, the text otherwise.
+func (rec *requestRecorder) body(captured []byte, truncated bool) any {
+	if len(captured) == 0 {
+		return nil
+	}
+	var document any
+	if !truncated && json.Unmarshal(captured, &document) == nil {
+		for _, path := range rec.redact {
+			redactField(document, path)
+		}
+		return document
+	}
+	if truncated {
+		return string(captured) + "…"
+	}
+	return string(captured)
+}
+
+// redactField replaces the value at path. Arrays on the way are looked into
+// element by element, so "items.secret" redacts the secret of every item.
+func redactField(node any, path []string) {
+	switch value := node.(type) {
+	case []any:
+		for _, element := range value {
+			redactField(element, path)
+		}
+	case map[string]any:
+		child, ok := value[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			value[path[0]] = redactedValue
+			return
+		}
+		redactField(child, path[1:])
+	}
+}
+
+// recordedHeaders copies the headers with the sensitive ones redacted.
+func recordedHeaders(header http.Header) http.Header {
+	copied := header.Clone()
+	for _, name := range redactedHeaders {
+		if copied.Get(name) != "" {
+			copied.Set(name, redactedValue)
+		}
+	}
+	return copied
+}
+
+// limitedBuffer keeps the first limit bytes written to it, all of them when
+// limit is 0.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); b.limit > 0 && len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// teeReader copies the request body into a buffer as the handler reads it.
+type teeReader struct {
+	io.ReadCloser
+	buffer *limitedBuffer
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.buffer.Write(p[:n])
+	return n, err
+}
+
+// recordingWriter copies the response as it is written.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	buffer limitedBuffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buffer.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recordingMiddleware records every request with its response, for
+// diagnosing what a client really sends and gets. Reading the recordings
+// isn't recorded itself.
+func recordingMiddleware(rec *requestRecorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/admin/recordings") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			requestHeaders := recordedHeaders(r.Header)
+			requestBody := &limitedBuffer{limit: maxRecordedBody}
+			r.Body = &teeReader{ReadCloser: r.Body, buffer: requestBody}
+			rw := &recordingWriter{ResponseWriter: w, buffer: limitedBuffer{limit: maxRecordedBody}}
+			next.ServeHTTP(rw, r)
+			rec.add(Recording{
+				At:              start.UTC(),
+				Duration:        time.Since(start).String(),
+				Method:          r.Method,
+				URL:             r.URL.String(),
+				RequestHeaders:  requestHeaders,
+				RequestBody:     rec.body(requestBody.Bytes(), requestBody.truncated),
+				Status:          cmp.Or(rw.status, http.StatusOK),
+				ResponseHeaders: recordedHeaders(w.Header()),
+				ResponseBody:    rec.body(rw.buffer.Bytes(), rw.buffer.truncated),
+			})
+		})
+	}
+}
+
+// listRecordings returns the recorded requests, newest first.
+func listRecordings(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, recordings.Recent())
+}
+
+// clearRecordings drops the recorded requests, like once an issue is
+// diagnosed.
+func clearRecordings(w http.ResponseWriter, r *http.Request) {
+	recordings.Clear()
+	NoContentResponse(w)
+}
+
+// setupRecordings records the last -debug-recordings requests when set.
+func setupRecordings(cfg Config) {
+	if cfg.DebugRecordings > 0 {
+		recordings = newRequestRecorder(cfg.DebugRecordings, cfg.DebugRedact)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_recordingMiddleware(t *testing.T) {
+	rec := newRequestRecorder(2, "password, items.secret")
+	handler := recordingMiddleware(rec)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=abc")
+		CreatedResponse(w, map[string]any{"items": []map[string]string{{"name": "a", "secret": "s1"}, {"name": "b", "secret": "s2"}}})
+	}))
+	send := func(path, body string) {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer token")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	send("/first", `{}`)
+	send("/auth/login", `{"email":"a@example.com","password":"hunter2"}`)
+	send("/third", `not json`)
+
+	recent := rec.Recent()
+	if len(recent) != 2 || recent[0].URL != "/third" || recent[1].URL != "/auth/login" || recent[0].ID != 3 {
+		t.Fatalf("expected the two latest recordings, newest first, got %+v", recent)
+	}
+	login := recent[1]
+	encoded, _ := json.Marshal(login)
+	for _, secret := range []string{"hunter2", "s1", "s2", "Bearer", "session=abc"} {
+		if strings.Contains(string(encoded), secret) {
+			t.Errorf("expected %q to be redacted, got %s", secret, encoded)
+		}
+	}
+	if login.Status != http.StatusCreated || !strings.Contains(string(encoded), `"email":"a@example.com"`) || !strings.Contains(string(encoded), `"name":"b"`) {
+		t.Errorf("expected the rest of the exchange to be kept, got %s", encoded)
+	}
+	if recent[0].RequestBody != "not json" {
+		t.Errorf("expected a body that isn't JSON to be kept as text, got %v", recent[0].RequestBody)
+	}
+
+	rec.Clear()
+	if recent := rec.Recent(); len(recent) != 0 {
+		t.Errorf("expected no recordings after clearing, got %d", len(recent))
+	}
+	send("/fourth", strings.Repeat("x", maxRecordedBody+10))
+	if body := rec.Recent()[0].RequestBody.(string); len(body) != maxRecordedBody+len("…") {
+		t.Errorf("expected a long body to be cut, got %d bytes", len(body))
+	}
+}
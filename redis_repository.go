@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisItemKeyPrefix = "item:"
+	redisIDSequenceKey = "items:next_id"
+	redisIndexKey      = "items:index"
+	redisExternalIDKey = "items:external_ids"
+	redisUUIDKey       = "items:uuids"
+)
+
+// RedisItemRepository stores every item as a hash under item:{id}. IDs come
+// from an INCR on items:next_id and a sorted set (scored by ID) indexes the
+// existing items, so listing doesn't need a KEYS scan and keeps a stable order.
+// The items:external_ids and items:uuids hashes point external IDs and UUIDs
+// at the item that was last written with them; lookups check the item, so the
+// entries of deleted items can stay behind.
+// Several instances of the API can share one Redis and thereby their state.
+type RedisItemRepository struct {
+	client *redis.Client
+}
+
+func NewRedisItemRepository(client *redis.Client) *RedisItemRepository {
+	return &RedisItemRepository{client: client}
+}
+
+func (repo *RedisItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	return listRedisItems(ctx, repo.client, filter)
+}
+
+func (repo *RedisItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	return getRedisItem(ctx, repo.client, id)
+}
+
+func (repo *RedisItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	next, err := repo.client.Incr(ctx, redisIDSequenceKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if next > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	item.ID = int(next)
+
+	fields, err := redisHashFromItem(item)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repo.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisItemKey(item.ID), fields)
+		pipe.ZAdd(ctx, redisIndexKey, redis.Z{Score: float64(item.ID), Member: strconv.Itoa(item.ID)})
+		indexRedisKeys(ctx, pipe, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Insert runs as a transaction, so the check that the ID is free and the
+// write can't be torn apart by another client.
+func (repo *RedisItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	var inserted *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) (err error) {
+		inserted, err = tx.Insert(ctx, item)
+		return err
+	})
+	return inserted, err
+}
+
+// redisRaiseSequence moves items:next_id up to ARGV[1] unless it is there
+// already, so INCR never hands out an inserted ID.
+var redisRaiseSequence = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func (repo *RedisItemRepository) Update(ctx context.Context, item Item) error {
+	fields, err := redisHashFromItem(item)
+	if err != nil {
+		return err
+	}
+
+	key := redisItemKey(item.ID)
+	return repo.client.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return NotFoundError
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, fields)
+			indexRedisKeys(ctx, pipe, item)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func (repo *RedisItemRepository) Delete(ctx context.Context, id int) error {
+	var removed *redis.IntCmd
+	_, err := repo.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, redisIndexKey, strconv.Itoa(id))
+		pipe.Del(ctx, redisItemKey(id))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if removed.Val() == 0 {
+		return NotFoundError
+	}
+	return nil
+}
+
+// Tx gives fn a view of the repository in which writes are buffered, and
+// applies them in a single MULTI/EXEC. The items fn gets are WATCHed, as are
+// the index and every item when it lists, so when another client changes one
+// of them before the commit the transaction is retried from scratch.
+//
+// IDs are taken from the sequence right away, so an aborted transaction
+// leaves a gap.
+func (repo *RedisItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	const attempts = 3
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = repo.client.Watch(ctx, func(tx *redis.Tx) error {
+			draft := &redisTx{repo: repo, tx: tx, writes: map[int]*Item{}}
+			if err := fn(draft); err != nil {
+				return err
+			}
+			return draft.commit(ctx)
+		})
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// redisTx is the repository as seen from inside Tx: reads go to Redis through
+// the watching connection, writes are kept in memory until the commit.
+type redisTx struct {
+	repo   *RedisItemRepository
+	tx     *redis.Tx
+	writes map[int]*Item
+	order  []int
+}
+
+func (t *redisTx) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	if err := t.tx.Watch(ctx, redisIndexKey).Err(); err != nil {
+		return nil, err
+	}
+	// Watching the items as well retries a transaction that decided on what
+	// it listed, like the name check of -unique-names, when one of them
+	// changes meanwhile.
+	ids, err := t.tx.ZRange(ctx, redisIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = redisItemKeyPrefix + id
+		}
+		if err := t.tx.Watch(ctx, keys...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	stored, err := listRedisItems(ctx, t.tx, ItemFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Item{}
+	seen := map[int]bool{}
+	for _, item := range stored {
+		seen[item.ID] = true
+		if written, ok := t.writes[item.ID]; ok {
+			if written == nil {
+				continue
+			}
+			item = *written
+		}
+		if filter.Matches(item) {
+			result = append(result, item)
+		}
+	}
+	for _, id := range t.order {
+		if written := t.writes[id]; written != nil && !seen[id] && filter.Matches(*written) {
+			result = append(result, *written)
+		}
+	}
+	return result, nil
+}
+
+func (t *redisTx) Get(ctx context.Context, id int) (*Item, error) {
+	if written, ok := t.writes[id]; ok {
+		if written == nil {
+			return nil, NotFoundError
+		}
+		item := *written
+		return &item, nil
+	}
+	if err := t.tx.Watch(ctx, redisItemKey(id)).Err(); err != nil {
+		return nil, err
+	}
+	return getRedisItem(ctx, t.tx, id)
+}
+
+func (t *redisTx) Create(ctx context.Context, item Item) (*Item, error) {
+	next, err := t.repo.client.Incr(ctx, redisIDSequenceKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if next > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	item.ID = int(next)
+	t.write(item.ID, &item)
+	return &item, nil
+}
+
+func (t *redisTx) Insert(ctx context.Context, item Item) (*Item, error) {
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	if _, err := t.Get(ctx, item.ID); err == nil {
+		return nil, IDTakenError
+	} else if !errors.Is(err, NotFoundError) {
+		return nil, err
+	}
+	if err := redisRaiseSequence.Run(ctx, t.repo.client, []string{redisIDSequenceKey}, item.ID).Err(); err != nil {
+		return nil, err
+	}
+	t.write(item.ID, &item)
+	return &item, nil
+}
+
+func (t *redisTx) Update(ctx context.Context, item Item) error {
+	if _, err := t.Get(ctx, item.ID); err != nil {
+		return err
+	}
+	t.write(item.ID, &item)
+	return nil
+}
+
+func (t *redisTx) Delete(ctx context.Context, id int) error {
+	if _, err := t.Get(ctx, id); err != nil {
+		return err
+	}
+	t.write(id, nil)
+	return nil
+}
+
+// Tx within a transaction simply joins it.
+func (t *redisTx) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return fn(t)
+}
+
+func (t *redisTx) write(id int, item *Item) {
+	if _, ok := t.writes[id]; !ok {
+		t.order = append(t.order, id)
+	}
+	if item != nil {
+		copied := *item
+		item = &copied
+	}
+	t.writes[id] = item
+}
+
+func (t *redisTx) commit(ctx context.Context) error {
+	if len(t.order) == 0 {
+		return nil
+	}
+	_, err := t.tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range t.order {
+			key := redisItemKey(id)
+			item := t.writes[id]
+			pipe.Del(ctx, key)
+			if item == nil {
+				pipe.ZRem(ctx, redisIndexKey, strconv.Itoa(id))
+				continue
+			}
+			fields, err := redisHashFromItem(*item)
+			if err != nil {
+				return err
+			}
+			pipe.HSet(ctx, key, fields)
+			pipe.ZAdd(ctx, redisIndexKey, redis.Z{Score: float64(id), Member: strconv.Itoa(id)})
+			indexRedisKeys(ctx, pipe, *item)
+		}
+		return nil
+	})
+	return err
+}
+
+func listRedisItems(ctx context.Context, client redis.Cmdable, filter ItemFilter) ([]Item, error) {
+	if filter.ExternalID != "" {
+		return listRedisItemByKey(ctx, client, redisExternalIDKey, filter.ExternalID, filter)
+	}
+	if filter.UUID != "" {
+		return listRedisItemByKey(ctx, client, redisUUIDKey, filter.UUID, filter)
+	}
+	ids, err := client.ZRange(ctx, redisIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := client.Pipeline()
+	commands := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		commands[i] = pipe.HGetAll(ctx, redisItemKeyPrefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	result := []Item{}
+	for _, command := range commands {
+		fields := command.Val()
+		if len(fields) == 0 {
+			// deleted between reading the index and the hashes
+			continue
+		}
+		item, err := itemFromRedisHash(fields)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Matches(*item) {
+			result = append(result, *item)
+		}
+	}
+	return result, nil
+}
+
+// listRedisItemByKey looks the item up in the index hash, items:external_ids
+// or items:uuids, instead of reading them all.
+func listRedisItemByKey(ctx context.Context, client redis.Cmdable, hash, key string, filter ItemFilter) ([]Item, error) {
+	result := []Item{}
+	id, err := client.HGet(ctx, hash, key).Int()
+	if errors.Is(err, redis.Nil) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	item, err := getRedisItem(ctx, client, id)
+	if errors.Is(err, NotFoundError) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if filter.Matches(*item) {
+		result = append(result, *item)
+	}
+	return result, nil
+}
+
+func indexRedisKeys(ctx context.Context, pipe redis.Pipeliner, item Item) {
+	if item.ExternalID != "" {
+		pipe.HSet(ctx, redisExternalIDKey, item.ExternalID, item.ID)
+	}
+	if item.UUID != "" {
+		pipe.HSet(ctx, redisUUIDKey, item.UUID, item.ID)
+	}
+}
+
+func getRedisItem(ctx context.Context, client redis.Cmdable, id int) (*Item, error) {
+	fields, err := client.HGetAll(ctx, redisItemKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, NotFoundError
+	}
+	return itemFromRedisHash(fields)
+}
+
+func redisItemKey(id int) string {
+	return fmt.Sprintf("%s%d", redisItemKeyPrefix, id)
+}
+
+// redisHashFromItem turns every JSON field of the item into a hash field
+// holding that field's JSON encoding, so new Item fields need no extra mapping.
+func redisHashFromItem(item Item) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{}, len(raw))
+	for name, value := range raw {
+		fields[name] = string(value)
+	}
+	return fields, nil
+}
+
+func itemFromRedisHash(fields map[string]string) (*Item, error) {
+	raw := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		raw[name] = json.RawMessage(value)
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var item Item
+	if err := json.Unmarshal(encoded, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultRelatedLimit = 5
+
+// RelatedItemsFinder finds the items similar to an item, most similar first.
+// tokenSimilarity is the default; a recommendation service or a search
+// engine's more-like-this query can take its place by setting relatedItems.
+type RelatedItemsFinder interface {
+	Related(ctx context.Context, item Item, limit int) ([]SearchHit, error)
+}
+
+var relatedItems RelatedItemsFinder = tokenSimilarity{}
+
+// tokenSimilarity scores items by the words they share with the item: the
+// Jaccard similarity of their name words, counted twice, plus that of their
+// description words. Only items listed right now are considered, and it
+// reads all of them, which is fine for the catalogs this API serves.
+type tokenSimilarity struct{}
+
+func (tokenSimilarity) Related(ctx context.Context, item Item, limit int) ([]SearchHit, error) {
+	candidates, err := itemRepository.List(ctx, ItemFilter{State: ItemStateActive, PublishedAt: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	name, description := words(item.Name), words(item.Description)
+	hits := []SearchHit{}
+	for _, candidate := range candidates {
+		if candidate.ID == item.ID {
+			continue
+		}
+		score := 2*jaccard(name, words(candidate.Name)) + jaccard(description, words(candidate.Description))
+		if score > 0 {
+			hits = append(hits, SearchHit{ID: candidate.ID, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits[:min(limit, len(hits))], nil
+}
+
+// words returns the distinct lowercased words of s, leaving out those
+// shorter than three letters, which are mostly articles and the like.
+func words(s string) map[string]bool {
+	result := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) >= 3 {
+			result[word] = true
+		}
+	}
+	return result
+}
+
+// jaccard is the share of the words in a or b that are in both.
+func jaccard(a, b map[string]bool) float64 {
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return 0
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// getRelatedItems returns the items similar to the one in the path with their
+// score, most similar first.
+func getRelatedItems(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	limit := defaultRelatedLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxLimit {
+			ErrorCodeResponse(w, InvalidLimitCode)
+			return
+		}
+	}
+
+	item, err := itemRepository.Get(r.Context(), *id)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not find related items")
+		return
+	}
+	hits, err := relatedItems.Related(r.Context(), *item, limit)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not find related items")
+		return
+	}
+
+	results := []SearchResult{}
+	for _, hit := range hits {
+		related, err := itemRepository.Get(r.Context(), hit.ID)
+		if errors.Is(err, NotFoundError) {
+			// deleted since it was found
+			continue
+		}
+		if err != nil {
+			RepositoryErrorResponse(w, err, "could not find related items")
+			return
+		}
+		results = append(results, SearchResult{Item: *related, Score: hit.Score})
+	}
+
+	SetResponseMeta(w, ResponseMeta{Total: len(results), Limit: limit})
+	SuccessResponse(w, results)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_getRelatedItems(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	archived := time.Now()
+	itemRepository = NewInMemoryItemRepository(
+		Item{ID: 0, Name: "Red wool scarf", Description: "Knitted from merino wool"},
+		Item{ID: 1, Name: "Blue wool scarf", Description: "Knitted by hand"},
+		Item{ID: 2, Name: "Wool socks", Description: "Warm and soft"},
+		Item{ID: 3, Name: "Garden hose"},
+		Item{ID: 4, Name: "Red wool scarf", ArchivedAt: &archived},
+	)
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}/related", getRelatedItems)
+
+	related := func(query string) (int, []SearchResult) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/"+query, nil))
+		var results []SearchResult
+		json.Unmarshal(rr.Body.Bytes(), &results)
+		return rr.Code, results
+	}
+
+	_, results := related("0/related")
+	var ids []int
+	for _, result := range results {
+		ids = append(ids, result.Item.ID)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("expected the other scarf, then the socks, got %v", ids)
+	}
+	if _, results := related("0/related?limit=1"); len(results) != 1 {
+		t.Errorf("expected the limit to apply, got %v", results)
+	}
+	if code, _ := related("42/related"); code != http.StatusNotFound {
+		t.Errorf("expected a missing item to return 404, got %d", code)
+	}
+	if code, _ := related("0/related?limit=0"); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid limit to return 400, got %d", code)
+	}
+}
+
+type fixedRelatedItems []SearchHit
+
+func (f fixedRelatedItems) Related(ctx context.Context, item Item, limit int) ([]SearchHit, error) {
+	return f, nil
+}
+
+func Test_getRelatedItemsUsesFinder(t *testing.T) {
+	defer func(repo ItemRepository, finder RelatedItemsFinder) { itemRepository, relatedItems = repo, finder }(itemRepository, relatedItems)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "a"}, Item{ID: 1, Name: "b"})
+	relatedItems = fixedRelatedItems{{ID: 7, Score: 2}, {ID: 1, Score: 1}}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}/related", getRelatedItems)
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/0/related", nil))
+	var results []SearchResult
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Item.Name != "b" || results[0].Score != 1 {
+		t.Errorf("expected the finder's hits without the missing item, got %+v", results)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// reloadableFlags are the settings that change without a restart. They only
+// shape how requests are handled, so building the routers again applies them;
+// the rate limits are changed in place, so clients keep their counts.
+var reloadableFlags = map[string]bool{
+	"route-timeout":       true,
+	"route-timeouts":      true,
+	"honeypot-denylist":   true,
+	"base-path":           true,
+	"envelope":            true,
+	"pretty":              true,
+	"lenient-media-types": true,
+	"validation-rules":    true,
+	"rate-limit":          true,
+	"rate-limit-window":   true,
+	"daily-quota":         true,
+}
+
+var (
+	reloadMu sync.Mutex
+	// runningArgs is the command line, which a reload parses again.
+	runningArgs []string
+	// runningFlags holds the settings currently in effect.
+	runningFlags *flag.FlagSet
+
+	publicHandler, adminHandler swappableHandler
+)
+
+// swappableHandler serves through a router that a reload replaces while
+// requests are in flight; those finish on the router they started on.
+type swappableHandler struct {
+	router atomic.Pointer[mux.Router]
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.Load().ServeHTTP(w, r)
+}
+
+// serveConfig makes the handlers serve cfg.
+func serveConfig(cfg Config) {
+	validationRules.Store(&cfg.ValidationRules)
+	rateLimits.configure(cfg.RateLimit, cfg.RateLimitWindow, cfg.DailyQuota)
+	publicHandler.router.Store(newRouter(cfg))
+	adminHandler.router.Store(newAdminRouter(cfg))
+}
+
+// reloadConfig reads the command line and the -config file again and applies
+// the result. When it doesn't parse, doesn't validate or changes a setting
+// that needs a restart, nothing changes and the error says why.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, fs, err := loadConfig(runningArgs, flag.ContinueOnError)
+	if err != nil {
+		return err
+	}
+	var fixed []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !reloadableFlags[f.Name] && f.Value.String() != runningFlags.Lookup(f.Name).Value.String() {
+			fixed = append(fixed, f.Name)
+		}
+	})
+	if len(fixed) > 0 {
+		return fmt.Errorf("%s can only change with a restart", strings.Join(fixed, ", "))
+	}
+
+	runningFlags = fs
+	serveConfig(cfg)
+	return nil
+}
+
+func reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if err := reloadConfig(); err != nil {
+		JSONResponse(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	SuccessResponse(w, map[string]bool{"reloaded": true})
+}
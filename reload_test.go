@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_reloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"pretty": false}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runningArgs = []string{"-config", path, "-admin-addr", ""}
+	cfg, fs, err := loadConfig(runningArgs, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runningFlags = fs
+	serveConfig(cfg)
+
+	ping := func() string {
+		rr := httptest.NewRecorder()
+		publicHandler.ServeHTTP(rr, httptest.NewRequest("GET", "/ping", nil))
+		return rr.Body.String()
+	}
+	if body := ping(); strings.Contains(body, "\n") {
+		t.Errorf("expected compact JSON before the reload, got %q", body)
+	}
+
+	os.WriteFile(path, []byte(`{"pretty": true}`), 0644)
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if body := ping(); !strings.Contains(body, "\n") {
+		t.Errorf("expected indented JSON after the reload, got %q", body)
+	}
+
+	for _, invalid := range []string{`{"pretty": false, "storage": "redis"}`, `{"route-timeout": "-1s"}`, `{"colour": "blue"}`, `{`} {
+		os.WriteFile(path, []byte(invalid), 0644)
+		if err := reloadConfig(); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+		if body := ping(); !strings.Contains(body, "\n") {
+			t.Errorf("expected the old configuration to stay after rejecting %s", invalid)
+		}
+	}
+}
+
+func Test_configFileNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"rate-limit": 1000000}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := loadConfig([]string{"-config", path}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatalf("expected large integers to be taken as written, got %v", err)
+	}
+	if cfg.RateLimit != 1000000 {
+		t.Errorf("expected a rate limit of 1000000, got %d", cfg.RateLimit)
+	}
+}
+
+func Test_reloadRateLimits(t *testing.T) {
+	isolate(t, &rateLimits, newRateLimiter(0, 0, 0))
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runningArgs = []string{"-config", path, "-admin-addr", ""}
+	cfg, fs, err := loadConfig(runningArgs, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runningFlags = fs
+	serveConfig(cfg)
+
+	ping := func() int {
+		rr := httptest.NewRecorder()
+		publicHandler.ServeHTTP(rr, httptest.NewRequest("GET", "/ping", nil))
+		return rr.Code
+	}
+	for range 3 {
+		if code := ping(); code != http.StatusOK {
+			t.Fatalf("expected no rate limit before the reload, got %d", code)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"rate-limit": 1}`), 0644)
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if first, second := ping(), ping(); first != http.StatusOK || second != http.StatusTooManyRequests {
+		t.Errorf("expected the second request after the reload to be limited, got %d and %d", first, second)
+	}
+
+	os.WriteFile(path, []byte(`{}`), 0644)
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if code := ping(); code != http.StatusOK {
+		t.Errorf("expected the limit to be off again, got %d", code)
+	}
+}
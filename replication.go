@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplicationBatch is what GET /replication/changes answers a replica: a page
+// of the change feed and how far the primary's event log goes.
+type ReplicationBatch struct {
+	ChangeFeed
+	Head int64 `json:"head"`
+}
+
+// replicationChanges serves the change feed to replicas, with the sequence
+// number of the primary's latest event, so they know how far behind they are.
+func replicationChanges(w http.ResponseWriter, r *http.Request) {
+	feed, ok := readChangeFeed(w, r)
+	if !ok {
+		return
+	}
+	SuccessResponse(w, ReplicationBatch{ChangeFeed: feed, Head: itemEvents.Last()})
+}
+
+// replication pulls the changes of the primary when this instance is a
+// replica, see -replicate-from; nil otherwise.
+var replication *replicator
+
+// replicator keeps the items of a replica in step with the primary by
+// applying the events of the primary's event log in order. The replica's
+// items are replaced with the primary's on startup, and the events are
+// replayed from the first, so nothing the replica had before lingers. The
+// changes go to repo, which leaves out the audit log and the announcements:
+// the primary made those already.
+type replicator struct {
+	client  *http.Client
+	primary string
+	repo    ItemRepository
+	now     func() time.Time
+
+	mu       sync.Mutex
+	applied  int64
+	head     int64
+	synced   time.Time
+	caughtUp time.Time
+	err      error
+}
+
+func newReplicator(primary string, repo ItemRepository) *replicator {
+	return &replicator{
+		client:  &http.Client{Timeout: maxChangesWait + 15*time.Second},
+		primary: strings.TrimRight(primary, "/"),
+		repo:    repo,
+		now:     time.Now,
+	}
+}
+
+// reset deletes the items of the replica, before the events are replayed.
+func (rep *replicator) reset(ctx context.Context) error {
+	items, err := rep.repo.List(ctx, ItemFilter{})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := rep.repo.Delete(ctx, item.ID); err != nil && !errors.Is(err, NotFoundError) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync applies the changes of the primary until it has none left; it runs as
+// a job.
+func (rep *replicator) Sync(ctx context.Context) error {
+	err := rep.sync(ctx)
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.err = err
+	return err
+}
+
+func (rep *replicator) sync(ctx context.Context) error {
+	for {
+		asked := rep.now()
+		batch, err := rep.fetch(ctx, rep.applied)
+		if err != nil {
+			return err
+		}
+		if batch.Head < rep.applied {
+			return fmt.Errorf("the primary's event log ends at %d, before the %d changes applied here; restart the replica to sync from scratch", batch.Head, rep.applied)
+		}
+		var changed []Item
+		for _, event := range batch.Changes {
+			if err := rep.apply(ctx, event); err != nil {
+				return fmt.Errorf("applying change %d: %w", event.Sequence, err)
+			}
+			rep.mu.Lock()
+			rep.applied = event.Sequence
+			rep.mu.Unlock()
+			if event.Item != nil {
+				changed = append(changed, *event.Item)
+			}
+		}
+		// repo doesn't count towards the version of the list either
+		if listVersion != nil && len(batch.Changes) > 0 {
+			listVersion.changed(changed...)
+		}
+
+		rep.mu.Lock()
+		rep.head, rep.synced = batch.Head, rep.now()
+		done := rep.applied >= batch.Head
+		if done {
+			rep.caughtUp = asked
+		}
+		rep.mu.Unlock()
+		if done || len(batch.Changes) == 0 {
+			return nil
+		}
+	}
+}
+
+// fetch asks the primary for the changes after since.
+func (rep *replicator) fetch(ctx context.Context, since int64) (ReplicationBatch, error) {
+	url := fmt.Sprintf("%s/replication/changes?since=%d&limit=%d", rep.primary, since, maxLimit)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ReplicationBatch{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := rep.client.Do(req)
+	if err != nil {
+		return ReplicationBatch{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ReplicationBatch{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ReplicationBatch{}, fmt.Errorf("replication: %s returned %d: %s", url, resp.StatusCode, body)
+	}
+	// a primary started with -envelope wraps the batch in data
+	var batch struct {
+		ReplicationBatch
+		Data *ReplicationBatch `json:"data"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return ReplicationBatch{}, fmt.Errorf("replication: %s: %w", url, err)
+	}
+	if batch.Data != nil {
+		return *batch.Data, nil
+	}
+	return batch.ReplicationBatch, nil
+}
+
+// apply makes the change to the replica's items. Creates and updates store
+// the item as the primary had it after the change, so applying an event
+// twice does no harm.
+func (rep *replicator) apply(ctx context.Context, event ItemEvent) error {
+	switch event.Type {
+	case ItemCreated, ItemUpdated:
+		if event.Item == nil {
+			return fmt.Errorf("%s of item %d comes without the item", event.Type, event.ItemID)
+		}
+		err := rep.repo.Update(ctx, *event.Item)
+		if errors.Is(err, NotFoundError) {
+			_, err = rep.repo.Insert(ctx, *event.Item)
+		}
+		return err
+	case ItemDeleted:
+		if err := rep.repo.Delete(ctx, event.ItemID); err != nil && !errors.Is(err, NotFoundError) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplicationStatus is what /admin/replication reports about a replica. Lag
+// is how long ago the replica last had every change of the primary; it keeps
+// growing while the primary can't be reached.
+type ReplicationStatus struct {
+	Primary    string     `json:"primary"`
+	Applied    int64      `json:"applied"`
+	Head       int64      `json:"head"`
+	Behind     int64      `json:"behind"`
+	Lag        string     `json:"lag"`
+	LastSync   *time.Time `json:"last_sync,omitempty"`
+	CaughtUpAt *time.Time `json:"caught_up_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// Status reports how far the replica is behind the primary.
+func (rep *replicator) Status() ReplicationStatus {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	status := ReplicationStatus{Primary: rep.primary, Applied: rep.applied, Head: rep.head, Behind: max(rep.head-rep.applied, 0)}
+	if !rep.synced.IsZero() {
+		synced := rep.synced.UTC()
+		status.LastSync = &synced
+	}
+	if !rep.caughtUp.IsZero() {
+		caughtUp := rep.caughtUp.UTC()
+		status.CaughtUpAt = &caughtUp
+		status.Lag = rep.now().Sub(rep.caughtUp).Round(time.Millisecond).String()
+	}
+	if rep.err != nil {
+		status.LastError = rep.err.Error()
+	}
+	return status
+}
+
+func replicationStatus(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, replication.Status())
+}
+
+// setupReplication makes this instance a read-only replica of the primary at
+// -replicate-from. It replaces the items with the primary's before serving,
+// waiting for the primary like for a storage backend, and then pulls the new
+// changes every -replication-interval. The changes are applied to repo,
+// itemRepository before it was wrapped in the audit log and the
+// announcements.
+func setupReplication(cfg Config, repo ItemRepository) error {
+	if cfg.ReplicateFrom == "" {
+		return nil
+	}
+	if cfg.Storage == "raft" {
+		return errors.New("-replicate-from can't be combined with -storage raft, which replicates the items itself")
+	}
+	if cfg.ReplicationInterval <= 0 {
+		return errors.New("replication-interval must be positive")
+	}
+	rep := newReplicator(cfg.ReplicateFrom, repo)
+	ctx := context.Background()
+	if err := rep.reset(ctx); err != nil {
+		return err
+	}
+	if err := waitForStorage("the primary", cfg.StorageStartupTimeout, func() error { return rep.Sync(ctx) }); err != nil {
+		return err
+	}
+	replication = rep
+	readOnly = true
+	jobs.Add(Job{Name: "replication-pull", Every: cfg.ReplicationInterval, Run: rep.Sync})
+	return nil
+}
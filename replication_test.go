@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_replication(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 7, Name: "stale"})
+	isolate(t, &jobs, newScheduler())
+	isolate(t, &replication, nil)
+	primaryEvents, _ := OpenEventLog("")
+	isolate(t, &itemEvents, primaryEvents)
+	primary := NewEventSourcedItemRepository(primaryEvents)
+	server := httptest.NewServer(http.HandlerFunc(replicationChanges))
+	defer server.Close()
+
+	ctx := context.Background()
+	lamp, _ := primary.Create(ctx, Item{Name: "lamp"})
+	desk, _ := primary.Create(ctx, Item{Name: "desk"})
+	lamp.Quantity = 3
+	primary.Update(ctx, *lamp)
+	primary.Delete(ctx, desk.ID)
+
+	// as in main, the changes are applied beneath the audit log and the
+	// announcements
+	replicated := itemRepository
+	if err := setupAudit(Config{AuditLogPath: filepath.Join(t.TempDir(), "audit.jsonl")}); err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordingNotifier{}
+	itemRepository = &notifyingRepository{ItemRepository: itemRepository, notifier: notifier}
+	isolate(t, &listVersion, newCollectionVersion(nil, time.Now))
+	if err := setupReplication(Config{ReplicateFrom: server.URL + "/", ReplicationInterval: time.Second}, replicated); err != nil {
+		t.Fatal(err)
+	}
+	items, _ := itemRepository.List(ctx, ItemFilter{})
+	if len(items) != 1 || !reflect.DeepEqual(items[0], *lamp) {
+		t.Fatalf("expected the replica to have the primary's items only, got %+v", items)
+	}
+	if status := replication.Status(); status.Applied != 4 || status.Head != 4 || status.Behind != 0 || status.CaughtUpAt == nil {
+		t.Errorf("expected the replica to have caught up, got %+v", status)
+	}
+
+	etag, _ := listVersion.current()
+	chair, _ := primary.Create(ctx, Item{Name: "chair"})
+	if err := replication.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := listVersion.current(); changed == etag {
+		t.Error("expected the replicated change to change the version of the list")
+	}
+	if entries := auditLog.Query(AuditFilter{}); len(entries) != 0 || len(notifier.subjects) != 0 {
+		t.Errorf("expected the replicated changes to be neither audited nor announced, got %+v and %v", entries, notifier.subjects)
+	}
+	if got, err := itemRepository.Get(ctx, chair.ID); err != nil || got.Name != "chair" {
+		t.Errorf("expected the new item to be replicated, got %+v %v", got, err)
+	}
+
+	api.Reroute()
+	if w := api.Request("POST", "/items/", Item{Name: "stool"}); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the replica to refuse writes, got %d", w.Code)
+	}
+	status := decodeResponse[ReplicationStatus](t, api.AdminRequest("GET", "/admin/replication", nil), http.StatusOK)
+	if status.Primary != server.URL || status.Applied != 5 || status.Behind != 0 || status.Lag == "" {
+		t.Errorf("expected the replication status, got %+v", status)
+	}
+
+	// a primary that lost its log can't be followed any further
+	isolate(t, &itemEvents, func() *EventLog { log, _ := OpenEventLog(""); return log }())
+	if err := replication.Sync(ctx); err == nil || !strings.Contains(replication.Status().LastError, "restart the replica") {
+		t.Errorf("expected the replica to notice the primary's log was reset, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ItemRepository hides how items are stored from the handlers. Every method
+// receives the request context, so a cancelled request or an expired deadline
+// stops the storage work that was started on its behalf.
+type ItemRepository interface {
+	List(ctx context.Context, filter ItemFilter) ([]Item, error)
+	Get(ctx context.Context, id int) (*Item, error)
+	Create(ctx context.Context, item Item) (*Item, error)
+	// Insert stores the item under the ID it already has, where Create picks
+	// one. It fails with IDTakenError when an item has that ID, and items
+	// created afterwards get higher IDs.
+	Insert(ctx context.Context, item Item) (*Item, error)
+	Update(ctx context.Context, item Item) error
+	Delete(ctx context.Context, id int) error
+	// Tx runs fn against a repository whose changes are applied all at once
+	// when fn returns nil, and not at all when it returns an error.
+	Tx(ctx context.Context, fn func(tx ItemRepository) error) error
+}
+
+// maxItemID is the highest ID an item can have. The ID after it still fits an
+// int, so the backends can always count on from the highest ID taken.
+const maxItemID = math.MaxInt - 1
+
+// maxClientID is the highest ID clients can name, in a path or for a new
+// item. JavaScript reads JSON numbers exactly up to it, and it stays far
+// enough below maxItemID that Create doesn't run out of IDs.
+const maxClientID = 1<<53 - 1
+
+// validClientID tells whether clients may give an item the ID.
+func validClientID(id int) bool {
+	return id >= 0 && id <= maxClientID
+}
+
+// IDOutOfRangeError is what storing an item with an ID above maxItemID fails
+// with, and what Create fails with once the IDs up to it are used up.
+var IDOutOfRangeError = errors.New("ID out of range")
+
+// idAfter is the ID items are created from once id is taken.
+func idAfter(id int) int {
+	return min(id, maxItemID) + 1
+}
+
+// ItemFilter describes which items a listing returns. Every backend translates
+// it into its own query (a MongoDB query, a WHERE clause, an index lookup);
+// Matches is the reference for what the filter means.
+type ItemFilter struct {
+	// NameContains keeps the items whose name contains it.
+	NameContains string
+	// Currency keeps the items priced in it.
+	Currency string
+	// Slug keeps the item with this slug.
+	Slug string
+	// UUID keeps the item with this UUID.
+	UUID string
+	// ExternalID keeps the item with this external ID.
+	ExternalID string
+	// PublishedAt keeps the items published at that time, unless it is zero.
+	PublishedAt time.Time
+	// State keeps the items in it, ItemStateActive or ItemStateArchived;
+	// empty keeps both.
+	State string
+	// Quantity and Price keep the items whose field meets every condition.
+	// Items without a price never meet a price condition.
+	Quantity []Condition
+	Price    []Condition
+}
+
+// Condition compares a numeric field with Value. Op is one of the
+// comparisonOps.
+type Condition struct {
+	Op    string
+	Value *big.Rat
+}
+
+var comparisonOps = []string{"lt", "lte", "gt", "gte", "eq"}
+
+func (c Condition) holds(value *big.Rat) bool {
+	cmp := value.Cmp(c.Value)
+	switch c.Op {
+	case "lt":
+		return cmp < 0
+	case "lte":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+func (f ItemFilter) Matches(item Item) bool {
+	if !strings.Contains(item.Name, f.NameContains) {
+		return false
+	}
+	if f.Currency != "" && item.Currency != f.Currency {
+		return false
+	}
+	if f.ExternalID != "" && item.ExternalID != f.ExternalID {
+		return false
+	}
+	if f.UUID != "" && item.UUID != f.UUID {
+		return false
+	}
+	if f.Slug != "" && item.Slug != f.Slug {
+		return false
+	}
+	if !f.PublishedAt.IsZero() && !item.PublishedAt(f.PublishedAt) {
+		return false
+	}
+	if f.State != "" && item.State() != f.State {
+		return false
+	}
+	for _, c := range f.Quantity {
+		if !c.holds(big.NewRat(int64(item.Quantity), 1)) {
+			return false
+		}
+	}
+	if len(f.Price) == 0 {
+		return true
+	}
+	price, ok := new(big.Rat).SetString(item.Price)
+	if !ok {
+		return false
+	}
+	for _, c := range f.Price {
+		if !c.holds(price) {
+			return false
+		}
+	}
+	return true
+}
+
+// newItemRepository returns the repository selected by -storage. A backend
+// that isn't reachable yet, like a database container that starts alongside
+// the API, is retried until -storage-startup-timeout runs out.
+func newItemRepository(cfg Config) (ItemRepository, error) {
+	switch cfg.Storage {
+	case "memory":
+		if cfg.MemoryShards < 0 {
+			return nil, fmt.Errorf("invalid number of memory shards %d", cfg.MemoryShards)
+		}
+		items, restored := seedItems, false
+		if cfg.SnapshotPath != "" {
+			snapshot, err := readSnapshot(cfg.SnapshotPath)
+			switch {
+			case err == nil:
+				items, restored = snapshot, true
+			case !os.IsNotExist(err):
+				return nil, err
+			}
+		}
+		if cfg.MemoryShards > 0 {
+			return NewShardedItemRepository(cfg.MemoryShards, items...), nil
+		}
+		if !restored {
+			return itemRepository, nil
+		}
+		return NewInMemoryItemRepository(items...), nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+		err := waitForStorage("redis", cfg.StorageStartupTimeout, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return client.Ping(ctx).Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisItemRepository(client), nil
+	case "mongo":
+		client, err := mongo.Connect(options.Client().ApplyURI(cfg.MongoURI).SetConnectTimeout(cfg.MongoTimeout))
+		if err != nil {
+			return nil, err
+		}
+		repo := NewMongoItemRepository(client.Database(cfg.MongoDatabase))
+		repo.uniqueNames = cfg.UniqueNames
+		err = waitForStorage("mongo", cfg.StorageStartupTimeout, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.MongoTimeout)
+			defer cancel()
+			if err := repo.EnsureIndexes(ctx); err != nil {
+				return fmt.Errorf("creating mongo indexes: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case "bolt":
+		var db *bolt.DB
+		// The file is locked while a previous instance still has it open.
+		err := waitForStorage("bolt", cfg.StorageStartupTimeout, func() (err error) {
+			db, err = bolt.Open(cfg.BoltPath, 0600, &bolt.Options{Timeout: time.Second})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewBoltItemRepository(db)
+	case "events":
+		events, err := OpenEventLog(cfg.EventLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening the event log: %w", err)
+		}
+		itemEvents = events
+		repo := NewEventSourcedItemRepository(events)
+		if len(events.All()) == 0 {
+			for _, item := range seedItems {
+				if _, err := repo.Create(context.Background(), item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return repo, nil
+	case "raft":
+		repo, err := newRaftItemRepository(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("joining the raft cluster: %w", err)
+		}
+		clusterNode = repo
+		return repo, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
+
+// waitForStorage calls connect until it succeeds, waiting twice as long after
+// every failure, up to 5s. It gives up when the next attempt would start after
+// timeout, so a timeout of 0 makes a single attempt.
+func waitForStorage(name string, timeout time.Duration, connect func() error) error {
+	deadline := time.Now().Add(timeout)
+	wait := 250 * time.Millisecond
+	for {
+		err := connect()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%s is not available: %w", name, err)
+		}
+		log.Printf("%s is not available yet, retrying in %s: %v", name, wait, err)
+		time.Sleep(wait)
+		wait = min(wait*2, 5*time.Second)
+	}
+}
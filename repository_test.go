@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var benchSizes = flag.String("bench-sizes", "1000,100000", "comma separated numbers of items BenchmarkRepositories fills the stores with")
+
+// benchmarkBackends open an empty store of every backend that runs without a
+// server.
+var benchmarkBackends = []struct {
+	name string
+	open func(tb testing.TB) ItemRepository
+}{
+	{"memory", func(tb testing.TB) ItemRepository { return NewInMemoryItemRepository() }},
+	{"sharded", func(tb testing.TB) ItemRepository { return NewShardedItemRepository(16) }},
+	{"bolt", func(tb testing.TB) ItemRepository {
+		db, err := bolt.Open(filepath.Join(tb.TempDir(), "items.db"), 0600, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { db.Close() })
+		repo, err := NewBoltItemRepository(db)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return repo
+	}},
+}
+
+// testBackends are the benchmarkBackends and the event-sourced one, which is
+// too slow to fill for benchmarks.
+func testBackends() []struct {
+	name string
+	open func(tb testing.TB) ItemRepository
+} {
+	events := benchmarkBackends[0]
+	events.name = "events"
+	events.open = func(tb testing.TB) ItemRepository {
+		log, _ := OpenEventLog("")
+		return NewEventSourcedItemRepository(log)
+	}
+	return append(slices.Clone(benchmarkBackends), events)
+}
+
+func Test_repositoriesRefuseIDsOutOfRange(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range testBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			repo := backend.open(t)
+			if _, err := repo.Insert(ctx, Item{ID: maxItemID + 1}); !errors.Is(err, IDOutOfRangeError) {
+				t.Errorf("expected an ID above maxItemID to be refused, got %v", err)
+			}
+			if _, err := repo.Insert(ctx, Item{ID: maxItemID}); err != nil {
+				t.Fatal(err)
+			}
+			if created, err := repo.Create(ctx, Item{}); !errors.Is(err, IDOutOfRangeError) {
+				t.Errorf("expected Create to run out of IDs instead of wrapping around, got %+v, %v", created, err)
+			}
+		})
+	}
+}
+
+func Test_repositoriesFindItemsByUUID(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range testBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			repo := backend.open(t)
+			for id := range 3 {
+				if _, err := repo.Insert(ctx, Item{ID: id, UUID: fmt.Sprintf("uuid-%d", id)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := repo.Update(ctx, Item{ID: 1, UUID: "uuid-1b"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Delete(ctx, 2); err != nil {
+				t.Fatal(err)
+			}
+			if items, _ := repo.List(ctx, ItemFilter{UUID: "uuid-1b"}); len(items) != 1 || items[0].ID != 1 {
+				t.Errorf("expected the item by its new UUID, got %+v", items)
+			}
+			for _, gone := range []string{"uuid-1", "uuid-2"} {
+				if items, _ := repo.List(ctx, ItemFilter{UUID: gone}); len(items) != 0 {
+					t.Errorf("expected no item for %s, got %+v", gone, items)
+				}
+			}
+		})
+	}
+}
+
+// fillRepository inserts size items in transactions of 10k, which keeps
+// filling a million items in bbolt down to seconds.
+func fillRepository(b *testing.B, repo ItemRepository, size int) {
+	ctx := context.Background()
+	for start := 0; start < size; start += 10000 {
+		err := repo.Tx(ctx, func(tx ItemRepository) error {
+			for id := start; id < min(start+10000, size); id++ {
+				if _, err := tx.Insert(ctx, Item{ID: id, Name: fmt.Sprintf("item %d", id), Description: "benchmark item"}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRepositories runs every operation against every backend at every
+// size of -bench-sizes. The names are Backend/size/Operation, so benchstat
+// can compare runs:
+//
+//	go test -run XXX -bench Repositories -count 6 -bench-sizes 1000,100000,1000000 ./... > new.txt
+//	benchstat old.txt new.txt
+func BenchmarkRepositories(b *testing.B) {
+	ctx := context.Background()
+	for _, backend := range benchmarkBackends {
+		for _, field := range strings.Split(*benchSizes, ",") {
+			size, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || size < 1 {
+				b.Fatalf("invalid -bench-sizes %q", *benchSizes)
+			}
+			// every operation gets a store of its own, so the writes of one
+			// don't change the size the next one sees
+			open := func(b *testing.B) ItemRepository {
+				repo := backend.open(b)
+				fillRepository(b, repo, size)
+				b.ResetTimer()
+				return repo
+			}
+			id := func(i int) int { return i * 7919 % size }
+
+			b.Run(fmt.Sprintf("%s/%d/Get", backend.name, size), func(b *testing.B) {
+				repo := open(b)
+				for i := 0; i < b.N; i++ {
+					if _, err := repo.Get(ctx, id(i)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%d/List", backend.name, size), func(b *testing.B) {
+				repo := open(b)
+				for i := 0; i < b.N; i++ {
+					if _, err := repo.List(ctx, ItemFilter{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%d/Create", backend.name, size), func(b *testing.B) {
+				repo := open(b)
+				for i := 0; i < b.N; i++ {
+					if _, err := repo.Create(ctx, Item{Name: "created", Description: "benchmark item"}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%d/Update", backend.name, size), func(b *testing.B) {
+				repo := open(b)
+				for i := 0; i < b.N; i++ {
+					if err := repo.Update(ctx, Item{ID: id(i), Name: "updated", Description: "benchmark item"}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%d/UpdateParallel", backend.name, size), func(b *testing.B) {
+				repo := open(b)
+				var next atomic.Int64
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if err := repo.Update(ctx, Item{ID: id(int(next.Add(1))), Name: "updated", Description: "benchmark item"}); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+			b.Run(fmt.Sprintf("%s/%d/TxUpdateParallel", backend.name, size), func(b *testing.B) {
+				// the way the handlers write: read and update in a transaction
+				repo := open(b)
+				var next atomic.Int64
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						itemID := id(int(next.Add(1)))
+						err := repo.Tx(ctx, func(tx ItemRepository) error {
+							item, err := tx.Get(ctx, itemID)
+							if err != nil {
+								return err
+							}
+							item.Name = "updated"
+							return tx.Update(ctx, *item)
+						})
+						if err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+			b.Run(fmt.Sprintf("%s/%d/Delete", backend.name, size), func(b *testing.B) {
+				repo := open(b)
+				for i := 0; i < b.N; i++ {
+					// put the item back untimed, so every run deletes from a
+					// store of the same size
+					b.StopTimer()
+					if i > 0 {
+						if _, err := repo.Insert(ctx, Item{ID: id(i - 1), Name: "deleted", Description: "benchmark item"}); err != nil {
+							b.Fatal(err)
+						}
+					}
+					b.StartTimer()
+					if err := repo.Delete(ctx, id(i)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CircuitOpenError is returned instead of calling the storage backend while
+// the circuit breaker is open.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("storage circuit breaker is open, retry in %s", e.RetryAfter)
+}
+
+// circuitBreaker stops calls to a failing backend. After threshold failures in
+// a row it opens and rejects every call for the cooldown; then it lets a
+// single trial call through, which closes it again on success and reopens it
+// on failure.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// storageBreaker guards the storage backend; nil when the breaker is disabled.
+var storageBreaker *circuitBreaker
+
+// allow returns a CircuitOpenError while calls are being rejected.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	wait := b.openUntil.Sub(b.now())
+	if wait <= 0 && !b.trial {
+		b.trial = true
+		return nil
+	}
+	if wait < time.Second {
+		// a trial call is under way
+		wait = time.Second
+	}
+	return &CircuitOpenError{RetryAfter: wait}
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !isStorageFailure(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// Open reports whether calls are currently rejected, and for how long.
+func (b *circuitBreaker) Open() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return false, 0
+	}
+	if wait := b.openUntil.Sub(b.now()); wait > 0 {
+		return true, wait
+	}
+	return false, 0
+}
+
+// isStorageFailure tells errors that say something about the health of the
+// backend from those that are the caller's business, like a missing item, a
+// taken ID or name or a client that went away.
+func isStorageFailure(err error) bool {
+	var taken *NameTakenError
+	var externalIDTaken *ExternalIDTakenError
+	return err != nil && !errors.Is(err, NotFoundError) && !errors.Is(err, IDTakenError) && !errors.Is(err, IDOutOfRangeError) && !errors.As(err, &taken) && !errors.As(err, &externalIDTaken) && !errors.Is(err, context.Canceled)
+}
+
+// resilientRepository puts the circuit breaker in front of the wrapped
+// repository and retries reads and updates with exponential backoff. Creates,
+// deletes and transactions are not retried: after an error it is unknown
+// whether they were applied, and repeating them could apply them twice.
+type resilientRepository struct {
+	ItemRepository
+	breaker *circuitBreaker
+	retries int
+	backoff time.Duration
+}
+
+func (repo *resilientRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	var items []Item
+	err := repo.retry(ctx, func() (err error) {
+		items, err = repo.ItemRepository.List(ctx, filter)
+		return err
+	})
+	return items, err
+}
+
+func (repo *resilientRepository) Get(ctx context.Context, id int) (*Item, error) {
+	var item *Item
+	err := repo.retry(ctx, func() (err error) {
+		item, err = repo.ItemRepository.Get(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (repo *resilientRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	var created *Item
+	err := repo.call(func() (err error) {
+		created, err = repo.ItemRepository.Create(ctx, item)
+		return err
+	})
+	return created, err
+}
+
+func (repo *resilientRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	var inserted *Item
+	err := repo.call(func() (err error) {
+		inserted, err = repo.ItemRepository.Insert(ctx, item)
+		return err
+	})
+	return inserted, err
+}
+
+func (repo *resilientRepository) Update(ctx context.Context, item Item) error {
+	return repo.retry(ctx, func() error {
+		return repo.ItemRepository.Update(ctx, item)
+	})
+}
+
+func (repo *resilientRepository) Delete(ctx context.Context, id int) error {
+	return repo.call(func() error {
+		return repo.ItemRepository.Delete(ctx, id)
+	})
+}
+
+// Tx counts only the failures of the backend towards the breaker. An error of
+// fn, like a taken ID or a failed patch test, rolls the transaction back but
+// says nothing about the backend.
+func (repo *resilientRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	if err := repo.breaker.allow(); err != nil {
+		return err
+	}
+	var fnErr error
+	err := repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		fnErr = fn(tx)
+		return fnErr
+	})
+	if fnErr != nil && errors.Is(err, fnErr) {
+		repo.breaker.record(nil)
+	} else {
+		repo.breaker.record(err)
+	}
+	return err
+}
+
+// call runs fn once, unless the breaker rejects it.
+func (repo *resilientRepository) call(fn func() error) error {
+	if err := repo.breaker.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	repo.breaker.record(err)
+	return err
+}
+
+// retry runs fn up to retries more times while it fails with a storage
+// failure, doubling the wait in between.
+func (repo *resilientRepository) retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = repo.call(fn)
+		var open *CircuitOpenError
+		if !isStorageFailure(err) || errors.As(err, &open) || attempt == repo.retries {
+			return err
+		}
+
+		wait := time.Duration(float64(repo.backoff) * math.Pow(2, float64(attempt)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// setupResilience wraps the repository in the circuit breaker unless
+// -breaker-threshold is 0.
+func setupResilience(cfg Config) {
+	if cfg.BreakerThreshold <= 0 {
+		return
+	}
+	storageBreaker = newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	itemRepository = &resilientRepository{
+		ItemRepository: itemRepository,
+		breaker:        storageBreaker,
+		retries:        cfg.StorageRetries,
+		backoff:        cfg.StorageRetryBackoff,
+	}
+}
+
+// CircuitOpenResponse answers 503 with a Retry-After header for when the
+// breaker closes again.
+func CircuitOpenResponse(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	ServiceUnavailableResponse(w, "storage is unavailable")
+}
+
+// ready is the readiness probe: it fails while the storage circuit is open, so
+// the instance is taken out of rotation until the backend recovers.
+func ready(w http.ResponseWriter, r *http.Request) {
+	if storageBreaker != nil {
+		if open, retryAfter := storageBreaker.Open(); open {
+			CircuitOpenResponse(w, retryAfter)
+			return
+		}
+	}
+	SuccessResponse(w, map[string]bool{"ready": true})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flakyRepository fails every call with err until err is cleared.
+type flakyRepository struct {
+	ItemRepository
+	err   error
+	calls int
+}
+
+func (repo *flakyRepository) Get(ctx context.Context, id int) (*Item, error) {
+	repo.calls++
+	if repo.err != nil {
+		return nil, repo.err
+	}
+	return repo.ItemRepository.Get(ctx, id)
+}
+
+func Test_resilientRepositoryOpensCircuit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(3, 30*time.Second)
+	breaker.now = func() time.Time { return now }
+	flaky := &flakyRepository{ItemRepository: NewInMemoryItemRepository(seedItems...), err: errors.New("connection refused")}
+	repo := &resilientRepository{ItemRepository: flaky, breaker: breaker, retries: 2, backoff: time.Millisecond}
+
+	// one call, retried twice, makes three failures in a row
+	if _, err := repo.Get(context.Background(), 1); err == nil || flaky.calls != 3 {
+		t.Fatalf("expected the failure after 3 calls, got %v after %d", err, flaky.calls)
+	}
+
+	var open *CircuitOpenError
+	if _, err := repo.Get(context.Background(), 1); !errors.As(err, &open) || open.RetryAfter != 30*time.Second {
+		t.Errorf("expected the open circuit, got %v", err)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("expected no call to the backend while open, got %d", flaky.calls)
+	}
+
+	rr := httptest.NewRecorder()
+	RepositoryErrorResponse(rr, open, "could not get item")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "30" {
+		t.Errorf("unexpected response %v with Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	now = now.Add(31 * time.Second)
+	flaky.err = nil
+	if item, err := repo.Get(context.Background(), 1); err != nil || item.ID != 1 {
+		t.Errorf("expected the trial call to succeed, got %v", err)
+	}
+	if isOpen, _ := breaker.Open(); isOpen {
+		t.Error("expected the circuit to be closed after a successful trial")
+	}
+}
+
+func Test_resilientRepositoryDoesNotCountNotFound(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Minute)
+	repo := &resilientRepository{ItemRepository: NewInMemoryItemRepository(seedItems...), breaker: breaker, retries: 2, backoff: time.Millisecond}
+
+	for i := 0; i < 3; i++ {
+		if _, err := repo.Get(context.Background(), 42); !errors.Is(err, NotFoundError) {
+			t.Fatalf("expected NotFoundError, got %v", err)
+		}
+	}
+	if isOpen, _ := breaker.Open(); isOpen {
+		t.Error("a missing item must not open the circuit")
+	}
+}
+
+// failingTxRepository fails every transaction with err.
+type failingTxRepository struct {
+	ItemRepository
+	err error
+}
+
+func (repo *failingTxRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.err
+}
+
+func Test_resilientRepositoryDoesNotCountTxErrors(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Minute)
+	repo := &resilientRepository{ItemRepository: NewInMemoryItemRepository(seedItems...), breaker: breaker}
+
+	for _, err := range []error{IDTakenError, errPatchTestFailed, errDryRun, errors.New("invalid")} {
+		if got := repo.Tx(context.Background(), func(tx ItemRepository) error { return err }); !errors.Is(got, err) {
+			t.Fatalf("expected %v, got %v", err, got)
+		}
+	}
+	if isOpen, _ := breaker.Open(); isOpen {
+		t.Fatal("errors of the transaction's function must not open the circuit")
+	}
+
+	repo.ItemRepository = &failingTxRepository{ItemRepository: repo.ItemRepository, err: errors.New("connection refused")}
+	repo.Tx(context.Background(), func(tx ItemRepository) error { return nil })
+	if isOpen, _ := breaker.Open(); !isOpen {
+		t.Error("a failing backend must open the circuit")
+	}
+}
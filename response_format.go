@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// envelopeContentType is sent with enveloped responses, and clients put it in
+// their Accept header to ask for one.
+const envelopeContentType = `application/json; profile="envelope"`
+
+// Envelope gives every response the same shape: the payload under data,
+// pagination under meta and failures under errors.
+type Envelope struct {
+	Data   interface{}   `json:"data"`
+	Meta   *ResponseMeta `json:"meta,omitempty"`
+	Errors []interface{} `json:"errors,omitempty"`
+}
+
+// ResponseMeta describes the page of a collection a response holds.
+type ResponseMeta struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// responseFormatMiddleware decides how the response helpers format the body
+// of a request: enveloped when cfg.Envelope is set or the Accept header asks
+// for it, indented when cfg.Pretty is set or the query has pretty=true.
+func responseFormatMiddleware(cfg Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pretty := cfg.Pretty
+			if value := r.URL.Query().Get("pretty"); value != "" {
+				pretty, _ = strconv.ParseBool(value)
+			}
+			next.ServeHTTP(&formatWriter{
+				ResponseWriter: w,
+				envelope:       cfg.Envelope || acceptsEnvelope(r),
+				pretty:         pretty,
+			}, r)
+		})
+	}
+}
+
+func acceptsEnvelope(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err == nil && mediaType == "application/json" && params["profile"] == "envelope" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatWriter carries the format of a response, and its metadata, until it is
+// written.
+type formatWriter struct {
+	http.ResponseWriter
+	envelope bool
+	pretty   bool
+	meta     *ResponseMeta
+}
+
+func (fw *formatWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// encode renders payload, enveloped and indented as requested.
+func (fw *formatWriter) encode(code int, contentType string, payload interface{}) ([]byte, string) {
+	if fw.envelope && code != http.StatusNoContent {
+		contentType = envelopeContentType
+		if code >= http.StatusBadRequest {
+			payload = Envelope{Errors: []interface{}{payload}}
+		} else {
+			payload = Envelope{Data: payload, Meta: fw.meta}
+		}
+	}
+	if fw.pretty {
+		encoded, _ := json.MarshalIndent(payload, "", "  ")
+		return append(encoded, '\n'), contentType
+	}
+	encoded, _ := json.Marshal(payload)
+	return encoded, contentType
+}
+
+// findFormatWriter looks for the formatWriter through the writers that
+// middlewares wrapped around it, returning nil when there is none.
+func findFormatWriter(w http.ResponseWriter) *formatWriter {
+	for {
+		switch writer := w.(type) {
+		case *formatWriter:
+			return writer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// SetResponseMeta records pagination metadata for the response. Only enveloped
+// responses have a place for it; for others it is dropped.
+func SetResponseMeta(w http.ResponseWriter, meta ResponseMeta) {
+	if format := findFormatWriter(w); format != nil {
+		format.meta = &meta
+	}
+}
+
+// SetPageLinks adds a Link header (RFC 8288) for the first, previous, next
+// and last page of the collection, as far as there are such pages, for the
+// clients that follow links rather than read the meta. The pages step by the
+// limit from the requested offset, and keep the other query parameters.
+func SetPageLinks(w http.ResponseWriter, r *http.Request, meta ResponseMeta) {
+	if meta.Limit <= 0 {
+		return
+	}
+	link := func(rel string, offset int) {
+		query := r.URL.Query()
+		query.Set("limit", strconv.Itoa(meta.Limit))
+		query.Set("offset", strconv.Itoa(offset))
+		w.Header().Add("Link", fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel))
+	}
+	// the last page is a whole number of pages after this one, unless this
+	// one is past the end
+	last := max(meta.Total-1, 0) / meta.Limit * meta.Limit
+	if meta.Offset < meta.Total {
+		last = meta.Offset + (meta.Total-1-meta.Offset)/meta.Limit*meta.Limit
+	}
+	link("first", 0)
+	if meta.Offset > 0 {
+		link("prev", max(meta.Offset-meta.Limit, 0))
+	}
+	if meta.Offset+meta.Limit < meta.Total {
+		link("next", meta.Offset+meta.Limit)
+	}
+	link("last", last)
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// Restarts hand the listening socket over through inherited file descriptors,
+// which only works on unix.
+
+func listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func notifyReady() {}
+
+// notifyRestart never delivers: the returned channel is nil.
+func notifyRestart() <-chan os.Signal {
+	return nil
+}
+
+func startSuccessor(timeout time.Duration) error {
+	return errors.New("restarts are not supported on this platform")
+}
@@ -0,0 +1,145 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// inheritedListenersEnv lists the addresses of the listening sockets a
+// restarted process inherits from its predecessor, in the order of their file
+// descriptors from 3 on. The descriptor after them is the pipe to report
+// readiness on.
+const inheritedListenersEnv = "SPIKE_INHERITED_LISTENERS"
+
+// openListener is a listening socket together with the address it was opened
+// for, which is what a successor looks it up by.
+type openListener struct {
+	addr string
+	ln   net.Listener
+}
+
+var openListeners []openListener
+
+// listen opens the listening socket for addr, or takes over the one inherited
+// from the process this one replaces.
+func listen(addr string) (net.Listener, error) {
+	ln, err := inheritedListener(addr)
+	if ln == nil && err == nil {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	openListeners = append(openListeners, openListener{addr: addr, ln: ln})
+	return ln, nil
+}
+
+func inheritedListener(addr string) (net.Listener, error) {
+	for i, inherited := range inheritedAddrs() {
+		if inherited == addr {
+			file := os.NewFile(uintptr(3+i), addr)
+			defer file.Close()
+			return net.FileListener(file)
+		}
+	}
+	return nil, nil
+}
+
+func inheritedAddrs() []string {
+	if value := os.Getenv(inheritedListenersEnv); value != "" {
+		return strings.Split(value, ",")
+	}
+	return nil
+}
+
+// notifyReady tells the process this one replaces that it serves requests
+// now, so it can stop.
+func notifyReady() {
+	addrs := inheritedAddrs()
+	if addrs == nil {
+		return
+	}
+	os.Unsetenv(inheritedListenersEnv)
+	pipe := os.NewFile(uintptr(3+len(addrs)), "ready")
+	pipe.Write([]byte{1})
+	pipe.Close()
+}
+
+// notifyRestart delivers SIGUSR2, which asks for a restart.
+func notifyRestart() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	return c
+}
+
+// startSuccessor runs the binary again, handing it the listening sockets, and
+// waits until it reports ready. The sockets stay open throughout, so no
+// connection is refused while the processes change over.
+func startSuccessor(timeout time.Duration) error {
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	var addrs []string
+	for _, open := range openListeners {
+		tcp, ok := open.ln.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("listener on %s can't be handed over", open.addr)
+		}
+		file, err := tcp.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		addrs = append(addrs, open.addr)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyWriter.Close()
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), inheritedListenersEnv+"="+strings.Join(addrs, ","))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter)
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan bool, 1)
+	go func() {
+		// reading fails once the successor exits without reporting ready
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err == nil
+	}()
+	select {
+	case ok := <-ready:
+		if !ok {
+			return fmt.Errorf("successor %d exited before it was ready", cmd.Process.Pid)
+		}
+		go cmd.Wait()
+		return nil
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return fmt.Errorf("successor %d wasn't ready after %s", cmd.Process.Pid, timeout)
+	}
+}
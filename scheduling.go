@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// PublishedAt reports whether the item is listed at t: its publish_at has
+// come, if it has one, and its expires_at hasn't, if it has one.
+func (item Item) PublishedAt(t time.Time) bool {
+	if item.PublishAt != nil && item.PublishAt.After(t) {
+		return false
+	}
+	return item.ExpiresAt == nil || item.ExpiresAt.After(t)
+}
+
+// publishScheduler announces the items whose publish_at or expires_at passed
+// since its previous run. Whether an item is listed only depends on the
+// clock, so nothing is written; the scheduler just tells the listeners.
+// Times that passed while the API wasn't running are not announced, and
+// every instance announces them, like the other notifications.
+type publishScheduler struct {
+	mu   sync.Mutex
+	last time.Time
+	now  func() time.Time
+}
+
+func newPublishScheduler() *publishScheduler {
+	return &publishScheduler{last: time.Now(), now: time.Now}
+}
+
+func (s *publishScheduler) run(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	items, err := itemRepository.List(ctx, ItemFilter{})
+	if err != nil {
+		return err
+	}
+	passed := func(t *time.Time) bool {
+		return t != nil && t.After(s.last) && !t.After(now)
+	}
+	for _, item := range items {
+		if passed(item.PublishAt) {
+			s.announce(ItemEvent{Type: ItemPublished, ItemID: item.ID, At: *item.PublishAt, Item: &item})
+		}
+		if passed(item.ExpiresAt) {
+			s.announce(ItemEvent{Type: ItemExpired, ItemID: item.ID, At: *item.ExpiresAt, Item: &item})
+		}
+	}
+	s.last = now
+	return nil
+}
+
+func (s *publishScheduler) announce(event ItemEvent) {
+	log.Printf("item %d: %s at %s", event.ItemID, event.Type, event.At.Format(time.RFC3339))
+	if itemNotifier == nil {
+		return
+	}
+	if err := itemNotifier.Notify(event); err != nil {
+		log.Printf("notifying about item %d failed: %v", event.ItemID, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_listItemsHidesUnpublished(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	itemRepository = NewInMemoryItemRepository(
+		Item{ID: 0, Name: "always"},
+		Item{ID: 1, Name: "published", PublishAt: &past, ExpiresAt: &future},
+		Item{ID: 2, Name: "scheduled", PublishAt: &future},
+		Item{ID: 3, Name: "expired", ExpiresAt: &past},
+	)
+
+	list := func(path string, token *APIToken) (int, string) {
+		r := httptest.NewRequest("GET", path, nil)
+		if token != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiTokenContextKey{}, token))
+		}
+		rr := httptest.NewRecorder()
+		listItems(rr, r)
+		var items []Item
+		json.Unmarshal(rr.Body.Bytes(), &items)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		return rr.Code, strings.Join(names, ",")
+	}
+
+	if code, names := list("/items/", nil); code != http.StatusOK || names != "always,published" {
+		t.Errorf("expected only the published items, got %d %q", code, names)
+	}
+	admin := &APIToken{Scopes: []string{ScopeAdmin}}
+	if code, names := list("/items/?include_unpublished=true", admin); code != http.StatusOK || names != "always,published,scheduled,expired" {
+		t.Errorf("expected an admin to see every item, got %d %q", code, names)
+	}
+	reader := &APIToken{Scopes: []string{ScopeItemsRead}}
+	if code, _ := list("/items/?include_unpublished=true", reader); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a reader asking for unpublished items, got %d", code)
+	}
+}
+
+func Test_publishScheduler(t *testing.T) {
+	defer func(repo ItemRepository, notifier changeNotifier) {
+		itemRepository, itemNotifier = repo, notifier
+	}(itemRepository, itemNotifier)
+	notifier := &recordingNotifier{}
+	itemNotifier = notifier
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	publish, expire := start.Add(30*time.Second), start.Add(90*time.Second)
+	itemRepository = NewInMemoryItemRepository(
+		Item{ID: 0, Name: "unscheduled"},
+		Item{ID: 1, Name: "campaign", PublishAt: &publish, ExpiresAt: &expire},
+	)
+	now := start
+	s := &publishScheduler{last: start, now: func() time.Time { return now }}
+	for _, minutes := range []int{1, 2, 3} {
+		now = start.Add(time.Duration(minutes) * time.Minute)
+		if err := s.run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"items.1.published", "items.1.expired"}
+	if !reflect.DeepEqual(notifier.subjects, want) {
+		t.Errorf("got subjects %v, want %v", notifier.subjects, want)
+	}
+}
+
+func Test_validateSchedule(t *testing.T) {
+	publish := time.Now()
+	expire := publish.Add(-time.Minute)
+	errs := validateItem(Item{Name: "backwards", PublishAt: &publish, ExpiresAt: &expire})
+	if len(errs) != 1 || errs[0].Code != "INVALID_SCHEDULE" {
+		t.Errorf("expected INVALID_SCHEDULE, got %+v", errs)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	SearchModeMatch  = "match"
+	SearchModePrefix = "prefix"
+	SearchModeFuzzy  = "fuzzy"
+
+	defaultSearchLimit = 20
+
+	// bleveRebuildBatchSize is how many items Rebuild indexes in one batch.
+	bleveRebuildBatchSize = 1000
+)
+
+// SearchIndex answers full-text queries over the items. It only stores what
+// it needs to find and rank them; the items themselves are always read from
+// the repository.
+type SearchIndex interface {
+	Index(ctx context.Context, item Item) error
+	Delete(ctx context.Context, id int) error
+	Search(ctx context.Context, query SearchQuery) ([]SearchHit, error)
+	// Rebuild throws the index away and indexes items from scratch, telling
+	// progress how many of them it has indexed so far.
+	Rebuild(ctx context.Context, items []Item, progress func(indexed int)) error
+}
+
+type SearchQuery struct {
+	Text  string
+	Mode  string
+	Limit int
+}
+
+type SearchHit struct {
+	ID    int
+	Score float64
+}
+
+type SearchResult struct {
+	Item  Item    `json:"item"`
+	Score float64 `json:"score"`
+}
+
+var searchIndex SearchIndex
+
+// setupSearch creates the search index selected by -search and routes item
+// writes through it. A bleve index kept in memory is filled from the
+// repository; indexes that persist are assumed to be up to date and can be
+// rebuilt on demand.
+func setupSearch(cfg Config) error {
+	var index SearchIndex
+	switch cfg.Search {
+	case "none":
+		return nil
+	case "bleve":
+		bleveIndex, err := NewBleveSearchIndex(cfg.SearchIndexPath)
+		if err != nil {
+			return err
+		}
+		index = bleveIndex
+		if cfg.SearchIndexPath == "" {
+			if _, err := reindex(context.Background(), index, nil); err != nil {
+				return err
+			}
+		}
+	case "elasticsearch":
+		es := NewElasticsearchSearchIndex(cfg.ElasticsearchURL, cfg.ElasticsearchIndex)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := es.EnsureIndex(ctx); err != nil {
+			return fmt.Errorf("creating elasticsearch index: %w", err)
+		}
+		index = es
+	default:
+		return fmt.Errorf("unknown search index %q", cfg.Search)
+	}
+
+	searchIndex = index
+	itemRepository = &searchIndexingRepository{ItemRepository: itemRepository, index: index}
+	return nil
+}
+
+// BleveSearchIndex keeps a bleve index of item names and descriptions, in
+// memory or in a directory on disk.
+type BleveSearchIndex struct {
+	mu    sync.RWMutex
+	index bleve.Index
+	path  string
+}
+
+// NewBleveSearchIndex opens the index at path, creating it when needed. An
+// empty path keeps the index in memory.
+func NewBleveSearchIndex(path string) (*BleveSearchIndex, error) {
+	index, err := openBleveIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BleveSearchIndex{index: index, path: path}, nil
+}
+
+func openBleveIndex(path string) (bleve.Index, error) {
+	if path == "" {
+		return bleve.NewMemOnly(bleve.NewIndexMapping())
+	}
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		return bleve.New(path, bleve.NewIndexMapping())
+	}
+	return index, err
+}
+
+// searchDocument is what gets indexed for an item.
+type searchDocument struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (b *BleveSearchIndex) Index(ctx context.Context, item Item) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.index.Index(strconv.Itoa(item.ID), searchDocument{Name: item.Name, Description: item.Description})
+}
+
+func (b *BleveSearchIndex) Delete(ctx context.Context, id int) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.index.Delete(strconv.Itoa(id))
+}
+
+func (b *BleveSearchIndex) Search(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	request := bleve.NewSearchRequestOptions(bleveQuery(q), q.Limit, 0, false)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	result, err := b.index.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		id, err := strconv.Atoi(hit.ID)
+		if err != nil {
+			continue
+		}
+		hits = append(hits, SearchHit{ID: id, Score: hit.Score})
+	}
+	return hits, nil
+}
+
+// IndexStats counts the indexed documents.
+func (b *BleveSearchIndex) IndexStats() []IndexStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	count, err := b.index.DocCount()
+	if err != nil {
+		return nil
+	}
+	return []IndexStats{{Name: "bleve", Entries: count}}
+}
+
+func bleveQuery(q SearchQuery) query.Query {
+	switch q.Mode {
+	case SearchModePrefix:
+		// The standard analyzer lowercases the indexed terms.
+		prefix := strings.ToLower(q.Text)
+		name := bleve.NewPrefixQuery(prefix)
+		name.SetField("name")
+		description := bleve.NewPrefixQuery(prefix)
+		description.SetField("description")
+		return bleve.NewDisjunctionQuery(name, description)
+	case SearchModeFuzzy:
+		match := bleve.NewMatchQuery(q.Text)
+		match.SetFuzziness(2)
+		return match
+	default:
+		return bleve.NewMatchQuery(q.Text)
+	}
+}
+
+// Rebuild fills a fresh index and swaps it in once it is complete, so searches
+// keep working on the old index in the meantime.
+func (b *BleveSearchIndex) Rebuild(ctx context.Context, items []Item, progress func(indexed int)) error {
+	rebuildPath := ""
+	if b.path != "" {
+		rebuildPath = b.path + ".rebuild"
+		if err := os.RemoveAll(rebuildPath); err != nil {
+			return err
+		}
+	}
+	fresh, err := openBleveIndex(rebuildPath)
+	if err != nil {
+		return err
+	}
+
+	batch := fresh.NewBatch()
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			fresh.Close()
+			return err
+		}
+		if err := batch.Index(strconv.Itoa(item.ID), searchDocument{Name: item.Name, Description: item.Description}); err != nil {
+			fresh.Close()
+			return err
+		}
+		if batch.Size() < bleveRebuildBatchSize && i < len(items)-1 {
+			continue
+		}
+		if err := fresh.Batch(batch); err != nil {
+			fresh.Close()
+			return err
+		}
+		batch.Reset()
+		progress(i + 1)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.index.Close(); err != nil {
+		return err
+	}
+	if b.path == "" {
+		b.index = fresh
+		return nil
+	}
+	if err := fresh.Close(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(b.path); err != nil {
+		return err
+	}
+	if err := os.Rename(rebuildPath, b.path); err != nil {
+		return err
+	}
+	b.index, err = bleve.Open(b.path)
+	return err
+}
+
+// searchIndexingRepository keeps the search index in sync with every write
+// that goes through it. A failing index update is only logged: the write
+// already happened, and the admin rebuild endpoint repairs the index.
+type searchIndexingRepository struct {
+	ItemRepository
+	index SearchIndex
+}
+
+func (repo *searchIndexingRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := repo.ItemRepository.Create(ctx, item)
+	if err == nil {
+		repo.indexItem(ctx, created.ID, created)
+	}
+	return created, err
+}
+
+func (repo *searchIndexingRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		repo.indexItem(ctx, inserted.ID, inserted)
+	}
+	return inserted, err
+}
+
+func (repo *searchIndexingRepository) Update(ctx context.Context, item Item) error {
+	err := repo.ItemRepository.Update(ctx, item)
+	if err == nil {
+		repo.indexItem(ctx, item.ID, &item)
+	}
+	return err
+}
+
+func (repo *searchIndexingRepository) Delete(ctx context.Context, id int) error {
+	err := repo.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		repo.indexItem(ctx, id, nil)
+	}
+	return err
+}
+
+// IndexStats reports the indexes of the wrapped repository and the search index.
+func (repo *searchIndexingRepository) IndexStats() []IndexStats {
+	var stats []IndexStats
+	for _, component := range []interface{}{repo.ItemRepository, repo.index} {
+		if reporter, ok := component.(indexStatsReporter); ok {
+			stats = append(stats, reporter.IndexStats()...)
+		}
+	}
+	return stats
+}
+
+// Tx records what fn writes and only indexes it after the transaction commits.
+func (repo *searchIndexingRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	var recorder *writeRecorder
+	err := repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		recorder = newWriteRecorder(tx)
+		return fn(recorder)
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range recorder.order {
+		repo.indexItem(ctx, id, recorder.writes[id])
+	}
+	return nil
+}
+
+func (repo *searchIndexingRepository) indexItem(ctx context.Context, id int, item *Item) {
+	var err error
+	if item == nil {
+		err = repo.index.Delete(ctx, id)
+	} else {
+		err = repo.index.Index(ctx, *item)
+	}
+	if err != nil {
+		log.Printf("updating the search index for item %d failed: %v", id, err)
+	}
+}
+
+// writeRecorder passes everything on to the wrapped repository and remembers
+// the last state it wrote for each ID, nil meaning deleted.
+type writeRecorder struct {
+	ItemRepository
+	writes map[int]*Item
+	order  []int
+}
+
+func newWriteRecorder(repo ItemRepository) *writeRecorder {
+	return &writeRecorder{ItemRepository: repo, writes: map[int]*Item{}}
+}
+
+func (w *writeRecorder) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := w.ItemRepository.Create(ctx, item)
+	if err == nil {
+		w.record(created.ID, created)
+	}
+	return created, err
+}
+
+func (w *writeRecorder) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := w.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		w.record(inserted.ID, inserted)
+	}
+	return inserted, err
+}
+
+func (w *writeRecorder) Update(ctx context.Context, item Item) error {
+	err := w.ItemRepository.Update(ctx, item)
+	if err == nil {
+		w.record(item.ID, &item)
+	}
+	return err
+}
+
+func (w *writeRecorder) Delete(ctx context.Context, id int) error {
+	err := w.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		w.record(id, nil)
+	}
+	return err
+}
+
+// Tx within a transaction simply joins it.
+func (w *writeRecorder) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return fn(w)
+}
+
+func (w *writeRecorder) record(id int, item *Item) {
+	if _, ok := w.writes[id]; !ok {
+		w.order = append(w.order, id)
+	}
+	if item != nil {
+		copied := *item
+		item = &copied
+	}
+	w.writes[id] = item
+}
+
+func searchItems(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := SearchQuery{
+		Text:  params.Get("q"),
+		Mode:  params.Get("mode"),
+		Limit: defaultSearchLimit,
+	}
+	if q.Text == "" {
+		ErrorCodeResponse(w, SearchQueryRequiredCode)
+		return
+	}
+	if q.Mode == "" {
+		q.Mode = SearchModeMatch
+	}
+	if q.Mode != SearchModeMatch && q.Mode != SearchModePrefix && q.Mode != SearchModeFuzzy {
+		ErrorCodeResponse(w, InvalidSearchModeCode)
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 1 || q.Limit > maxLimit {
+			ErrorCodeResponse(w, InvalidLimitCode)
+			return
+		}
+	}
+
+	hits, err := searchIndex.Search(r.Context(), q)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not search items")
+		return
+	}
+
+	results := []SearchResult{}
+	for _, hit := range hits {
+		item, err := itemRepository.Get(r.Context(), hit.ID)
+		if errors.Is(err, NotFoundError) {
+			// the index is lagging behind a delete
+			continue
+		}
+		if err != nil {
+			RepositoryErrorResponse(w, err, "could not search items")
+			return
+		}
+		results = append(results, SearchResult{Item: *item, Score: hit.Score})
+	}
+
+	SetResponseMeta(w, ResponseMeta{Total: len(results), Limit: q.Limit})
+	SuccessResponse(w, results)
+}
+
+func rebuildSearchIndex(w http.ResponseWriter, r *http.Request) {
+	if prefersAsync(r) {
+		job := asyncJobs.Start(r.Context(), "reindex", func(ctx context.Context, progress func(done, total int)) (AsyncJobOutcome, error) {
+			indexed, err := reindex(ctx, searchIndex, progress)
+			return AsyncJobOutcome{Result: map[string]int{"indexed": indexed}}, err
+		})
+		AcceptedJobResponse(w, job)
+		return
+	}
+	indexed, err := reindex(r.Context(), searchIndex, nil)
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not rebuild the search index")
+		return
+	}
+
+	SuccessResponse(w, map[string]int{"indexed": indexed})
+}
+
+// reindex rebuilds index from every stored item and returns how many there
+// were. progress, if given, hears how many of them are indexed so far.
+func reindex(ctx context.Context, index SearchIndex, progress func(done, total int)) (int, error) {
+	items, err := itemRepository.List(ctx, ItemFilter{})
+	if err != nil {
+		return 0, err
+	}
+	if progress == nil {
+		progress = func(done, total int) {}
+	}
+	progress(0, len(items))
+	return len(items), index.Rebuild(ctx, items, func(indexed int) { progress(indexed, len(items)) })
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// secretSource fetches the secrets: a JSON object keyed by flag name, like
+// the -config file, e.g. {"redis-password": "...", "jwt-secret": "..."}.
+type secretSource interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// secretFields are the settings a secret source may set, by flag name.
+func secretFields(cfg *Config) map[string]*string {
+	return map[string]*string{
+		"redis-password": &cfg.RedisPassword,
+		"mongo-uri":      &cfg.MongoURI,
+		"jwt-secret":     &cfg.JWTSecret,
+		"encryption-key": &cfg.EncryptionKey,
+	}
+}
+
+// vaultSource reads a secret of a KV version 2 engine of HashiCorp Vault,
+// over plain HTTP like ElasticsearchSearchIndex.
+type vaultSource struct {
+	client *http.Client
+	addr   string
+	token  string
+	path   string
+}
+
+func newVaultSource(addr, token, path string) *vaultSource {
+	return &vaultSource{
+		client: &http.Client{Timeout: 10 * time.Second},
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		path:   strings.Trim(path, "/"),
+	}
+}
+
+func (v *vaultSource) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: reading %s returned %d: %s", v.path, resp.StatusCode, body)
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("vault: %s: %w", v.path, err)
+	}
+	return secret.Data.Data, nil
+}
+
+// awsSecretSource reads a secret of AWS Secrets Manager. The credentials and
+// region come from the usual places: the environment, the shared config
+// files or the instance role.
+type awsSecretSource struct {
+	client   *secretsmanager.Client
+	secretID string
+}
+
+func newAWSSecretSource(ctx context.Context, region, secretID string) (*awsSecretSource, error) {
+	var options []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		options = append(options, awsconfig.WithRegion(region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &awsSecretSource{client: secretsmanager.NewFromConfig(awsCfg), secretID: secretID}, nil
+}
+
+func (a *awsSecretSource) Fetch(ctx context.Context) (map[string]string, error) {
+	out, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(a.secretID)})
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(aws.ToString(out.SecretString)), &values); err != nil {
+		return nil, fmt.Errorf("secrets manager: %s: %w", a.secretID, err)
+	}
+	return values, nil
+}
+
+// secretsWatcher applies the secrets on startup and fetches them again on
+// every refresh. A new jwt-secret applies at once; the other secrets are
+// connection settings, which only change with a restart.
+type secretsWatcher struct {
+	mu      sync.Mutex
+	source  secretSource
+	applied map[string]string
+}
+
+// apply fetches the secrets and sets them on cfg, overriding the flags.
+func (s *secretsWatcher) apply(ctx context.Context, cfg *Config) error {
+	values, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	fields := secretFields(cfg)
+	for name, value := range values {
+		*fields[name] = value
+	}
+	s.applied = values
+	return nil
+}
+
+// refresh fetches the secrets again; it runs as a job.
+func (s *secretsWatcher) refresh(ctx context.Context) error {
+	values, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []string
+	for name, value := range values {
+		if s.applied[name] == value {
+			continue
+		}
+		if name == "jwt-secret" && users != nil {
+			users.setSecret([]byte(value))
+			log.Print("secrets: applied the new jwt-secret")
+		} else {
+			changed = append(changed, name)
+		}
+		s.applied[name] = value
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		log.Printf("secrets: %s changed, restart to apply", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+func (s *secretsWatcher) fetch(ctx context.Context) (map[string]string, error) {
+	values, err := s.source.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	allowed := secretFields(&Config{})
+	for name := range values {
+		if _, ok := allowed[name]; !ok {
+			return nil, fmt.Errorf("secrets: unknown secret %q", name)
+		}
+	}
+	return values, nil
+}
+
+// setupSecrets pulls the secrets from -secrets-source into cfg and keeps
+// refreshing them every -secrets-refresh-interval.
+func setupSecrets(cfg *Config) error {
+	ctx := context.Background()
+	var source secretSource
+	switch cfg.SecretsSource {
+	case "", "none":
+		return nil
+	case "vault":
+		source = newVaultSource(cfg.VaultAddr, cfg.VaultToken, cfg.VaultPath)
+	case "aws":
+		awsSource, err := newAWSSecretSource(ctx, cfg.AWSRegion, cfg.AWSSecretID)
+		if err != nil {
+			return err
+		}
+		source = awsSource
+	default:
+		return fmt.Errorf("unknown secrets source %q, use vault, aws or none", cfg.SecretsSource)
+	}
+	watcher := &secretsWatcher{source: source}
+	if err := watcher.apply(ctx, cfg); err != nil {
+		return err
+	}
+	if cfg.SecretsRefreshInterval > 0 {
+		jobs.Add(Job{Name: "secrets-refresh", Every: cfg.SecretsRefreshInterval, Run: watcher.refresh})
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_vaultSecrets(t *testing.T) {
+	defer func(original *userStore) { users = original }(users)
+	secret := `{"redis-password": "from vault", "jwt-secret": "first key"}`
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/items-api" || r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data": {"data": ` + secret + `, "metadata": {"version": 1}}}`))
+	}))
+	defer vault.Close()
+
+	cfg := Config{RedisPassword: "from flag", MongoURI: "mongodb://localhost:27017"}
+	watcher := &secretsWatcher{source: newVaultSource(vault.URL, "root", "/secret/data/items-api/")}
+	if err := watcher.apply(context.Background(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RedisPassword != "from vault" || cfg.JWTSecret != "first key" || cfg.MongoURI != "mongodb://localhost:27017" {
+		t.Fatalf("unexpected config after applying the secrets: %+v", cfg)
+	}
+
+	store, err := openUserStore(filepath.Join(t.TempDir(), "users.json"), []byte(cfg.JWTSecret), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.cost = bcrypt.MinCost
+	users = store
+	store.Register("ada@example.com", "correct horse")
+	before, _, _ := store.Login("ada@example.com", "correct horse")
+
+	// a rotated jwt-secret applies at once, and tokens signed with the old one keep working
+	secret = `{"redis-password": "from vault", "jwt-secret": "second key"}`
+	if err := watcher.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	after, _, _ := store.Login("ada@example.com", "correct horse")
+	for name, token := range map[string]string{"before": before.AccessToken, "after": after.AccessToken} {
+		if _, _, ok := store.Authenticate(token); !ok {
+			t.Errorf("expected the access token issued %s the rotation to work", name)
+		}
+	}
+	if string(store.secret) != "second key" {
+		t.Errorf("expected the new key to sign, got %q", store.secret)
+	}
+
+	secret = `{"api-tokens-path": "/tmp/tokens.json"}`
+	if err := watcher.refresh(context.Background()); err == nil || !strings.Contains(err.Error(), "unknown secret") {
+		t.Errorf("expected settings other than secrets to be rejected, got %v", err)
+	}
+
+	watcher = &secretsWatcher{source: newVaultSource(vault.URL, "wrong", "secret/data/items-api")}
+	if err := watcher.apply(context.Background(), &cfg); err == nil {
+		t.Error("expected an error for a rejected Vault token")
+	}
+}
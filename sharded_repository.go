@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ShardedItemRepository spreads the items over in-memory repositories that
+// each have their own lock, so concurrent writes to different items rarely
+// wait on each other. An item lives in the shard its ID picks; consecutive
+// IDs go to consecutive shards.
+//
+// Listing asks every shard and merges the results by ID, which is the order
+// they were created in. Each shard is read at its own moment, so a listing
+// taken during writes may see a write to one shard and not an earlier one to
+// another. Transactions only lock the shards they read from or write to, and
+// only while they commit, see Tx.
+type ShardedItemRepository struct {
+	shards []*InMemoryItemRepository
+	nextID atomic.Int64
+}
+
+func NewShardedItemRepository(shards int, items ...Item) *ShardedItemRepository {
+	repo := &ShardedItemRepository{shards: make([]*InMemoryItemRepository, shards)}
+	perShard := make([][]Item, shards)
+	for _, item := range items {
+		i := repo.shardIndex(item.ID)
+		perShard[i] = append(perShard[i], item)
+		if int64(item.ID) >= repo.nextID.Load() {
+			repo.nextID.Store(int64(idAfter(item.ID)))
+		}
+	}
+	for i := range repo.shards {
+		repo.shards[i] = NewInMemoryItemRepository(perShard[i]...)
+	}
+	return repo
+}
+
+func (repo *ShardedItemRepository) shardIndex(id int) int {
+	return int(uint(id) % uint(len(repo.shards)))
+}
+
+func (repo *ShardedItemRepository) shard(id int) *InMemoryItemRepository {
+	return repo.shards[repo.shardIndex(id)]
+}
+
+func (repo *ShardedItemRepository) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	results := make([][]Item, len(repo.shards))
+	errs := make([]error, len(repo.shards))
+	var wg sync.WaitGroup
+	for i, shard := range repo.shards {
+		wg.Go(func() {
+			results[i], errs[i] = shard.List(ctx, filter)
+		})
+	}
+	wg.Wait()
+
+	merged := []Item{}
+	for i, items := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		merged = append(merged, items...)
+	}
+	slices.SortFunc(merged, func(a, b Item) int { return a.ID - b.ID })
+	return merged, nil
+}
+
+func (repo *ShardedItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	return repo.shard(id).Get(ctx, id)
+}
+
+// Create takes the next ID without a lock. A transaction may have used that ID
+// in the meantime, in which case Create takes another one.
+func (repo *ShardedItemRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	for {
+		id, err := repo.takeID()
+		if err != nil {
+			return nil, err
+		}
+		item.ID = id
+		created, err := repo.shard(item.ID).Insert(ctx, item)
+		if !errors.Is(err, IDTakenError) {
+			return created, err
+		}
+	}
+}
+
+func (repo *ShardedItemRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.shard(item.ID).Insert(ctx, item)
+	if err == nil {
+		repo.raiseNextID(idAfter(item.ID))
+	}
+	return inserted, err
+}
+
+// takeID hands out the next ID.
+func (repo *ShardedItemRepository) takeID() (int, error) {
+	for {
+		next := repo.nextID.Load()
+		if next > maxItemID {
+			return 0, IDOutOfRangeError
+		}
+		if repo.nextID.CompareAndSwap(next, next+1) {
+			return int(next), nil
+		}
+	}
+}
+
+// raiseNextID makes sure items created from now on get at least next as ID.
+func (repo *ShardedItemRepository) raiseNextID(next int) {
+	for {
+		current := repo.nextID.Load()
+		if int64(next) <= current || repo.nextID.CompareAndSwap(current, int64(next)) {
+			return
+		}
+	}
+}
+
+func (repo *ShardedItemRepository) Update(ctx context.Context, item Item) error {
+	return repo.shard(item.ID).Update(ctx, item)
+}
+
+func (repo *ShardedItemRepository) Delete(ctx context.Context, id int) error {
+	return repo.shard(id).Delete(ctx, id)
+}
+
+// Tx runs fn without holding any lock and commits what it wrote when fn
+// succeeds, locking only the shards the transaction read from or writes to.
+// Before the writes are applied, every read is checked against the shards as
+// they are then; when another write changed what fn saw, fn is run again
+// from scratch, like a Redis transaction whose WATCHed keys changed. IDs
+// taken by a run that had to start over leave a gap.
+func (repo *ShardedItemRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tx := &shardedTx{repo: repo, writes: map[int]*Item{}}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if tx.commit() {
+			return nil
+		}
+	}
+}
+
+// shardedTx is the repository as a sharded transaction sees it. It records
+// what it read, with the version of the shard it read it from, and keeps its
+// writes to itself until commit.
+type shardedTx struct {
+	repo    *ShardedItemRepository
+	gets    []shardedRead
+	lists   []shardedRead
+	created []int
+	// writes are the items written by ID, nil for the deleted ones.
+	writes map[int]*Item
+}
+
+// shardedRead is a Get or the part of a List one shard answered.
+type shardedRead struct {
+	shard   int
+	version uint64
+	id      int
+	filter  ItemFilter
+	items   []Item
+}
+
+func (tx *shardedTx) get(id int) *Item {
+	i := tx.repo.shardIndex(id)
+	shard := tx.repo.shards[i]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	read := shardedRead{shard: i, version: shard.version, id: id}
+	if item, ok := shard.items[id]; ok {
+		read.items = []Item{item}
+	}
+	tx.gets = append(tx.gets, read)
+	if len(read.items) == 0 {
+		return nil
+	}
+	return &read.items[0]
+}
+
+func (tx *shardedTx) Get(ctx context.Context, id int) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	item, written := tx.writes[id]
+	if !written {
+		item = tx.get(id)
+	}
+	if item == nil {
+		return nil, NotFoundError
+	}
+	found := *item
+	return &found, nil
+}
+
+func (tx *shardedTx) List(ctx context.Context, filter ItemFilter) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result := []Item{}
+	for i, shard := range tx.repo.shards {
+		shard.mu.RLock()
+		read := shardedRead{shard: i, version: shard.version, filter: filter, items: shard.list(filter)}
+		shard.mu.RUnlock()
+		tx.lists = append(tx.lists, read)
+		for _, item := range read.items {
+			if _, written := tx.writes[item.ID]; !written {
+				result = append(result, item)
+			}
+		}
+	}
+	for _, item := range tx.writes {
+		if item != nil && filter.Matches(*item) {
+			result = append(result, *item)
+		}
+	}
+	slices.SortFunc(result, func(a, b Item) int { return a.ID - b.ID })
+	return result, nil
+}
+
+func (tx *shardedTx) Create(ctx context.Context, item Item) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	id, err := tx.repo.takeID()
+	if err != nil {
+		return nil, err
+	}
+	item.ID = id
+	tx.created = append(tx.created, id)
+	tx.writes[id] = &item
+	created := item
+	return &created, nil
+}
+
+func (tx *shardedTx) Insert(ctx context.Context, item Item) (*Item, error) {
+	if item.ID > maxItemID {
+		return nil, IDOutOfRangeError
+	}
+	if _, err := tx.Get(ctx, item.ID); err == nil {
+		return nil, IDTakenError
+	} else if !errors.Is(err, NotFoundError) {
+		return nil, err
+	}
+	tx.writes[item.ID] = &item
+	inserted := item
+	return &inserted, nil
+}
+
+func (tx *shardedTx) Update(ctx context.Context, item Item) error {
+	if _, err := tx.Get(ctx, item.ID); err != nil {
+		return err
+	}
+	tx.writes[item.ID] = &item
+	return nil
+}
+
+func (tx *shardedTx) Delete(ctx context.Context, id int) error {
+	if _, err := tx.Get(ctx, id); err != nil {
+		return err
+	}
+	tx.writes[id] = nil
+	return nil
+}
+
+// Tx within a transaction simply joins it.
+func (tx *shardedTx) Tx(_ context.Context, fn func(tx ItemRepository) error) error {
+	return fn(tx)
+}
+
+// commit locks the shards the transaction read from or writes to, in order,
+// and applies the writes unless a read no longer holds. It reports whether it
+// did.
+func (tx *shardedTx) commit() bool {
+	locked := map[int]bool{}
+	for _, read := range tx.gets {
+		locked[read.shard] = true
+	}
+	for _, read := range tx.lists {
+		locked[read.shard] = true
+	}
+	for id := range tx.writes {
+		locked[tx.repo.shardIndex(id)] = true
+	}
+	for i, shard := range tx.repo.shards {
+		if locked[i] {
+			shard.mu.Lock()
+			defer shard.mu.Unlock()
+		}
+	}
+
+	for _, read := range tx.gets {
+		shard := tx.repo.shards[read.shard]
+		if shard.version == read.version {
+			continue
+		}
+		item, ok := shard.items[read.id]
+		if ok != (len(read.items) > 0) || ok && !reflect.DeepEqual(item, read.items[0]) {
+			return false
+		}
+	}
+	for _, read := range tx.lists {
+		shard := tx.repo.shards[read.shard]
+		if shard.version != read.version && !reflect.DeepEqual(shard.list(read.filter), read.items) {
+			return false
+		}
+	}
+	for _, id := range tx.created {
+		// an insert may have taken the ID since
+		if _, taken := tx.repo.shard(id).items[id]; taken {
+			return false
+		}
+	}
+
+	next := 0
+	for id, updated := range tx.writes {
+		shard := tx.repo.shard(id)
+		old, hadOld := shard.items[id]
+		switch {
+		case hadOld && updated != nil:
+			shard.items[id] = *updated
+			shard.index(&old, updated)
+		case hadOld:
+			delete(shard.items, id)
+			shard.index(&old, nil)
+			shard.stale++
+			if shard.stale > len(shard.order)/2 {
+				shard.compact()
+			}
+		case updated != nil:
+			shard.items[id] = *updated
+			shard.addToOrder(id)
+			shard.index(nil, updated)
+			next = max(next, idAfter(id))
+		}
+		shard.version++
+	}
+	tx.repo.raiseNextID(next)
+	return true
+}
+
+// IndexStats adds up the indexes of the shards.
+func (repo *ShardedItemRepository) IndexStats() []IndexStats {
+	var stats []IndexStats
+	for _, shard := range repo.shards {
+		for i, s := range shard.IndexStats() {
+			if i < len(stats) {
+				stats[i].Entries += s.Entries
+			} else {
+				stats = append(stats, s)
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func Test_shardedItemRepository(t *testing.T) {
+	repo := NewShardedItemRepository(4, Item{ID: 0, Name: "first"}, Item{ID: 5, Name: "second"})
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, Item{Name: "third", ExternalID: "erp-3"})
+	if err != nil || created.ID != 6 {
+		t.Fatalf("expected the next ID after the seeded ones, got %+v %v", created, err)
+	}
+	if _, err := repo.Insert(ctx, Item{ID: 5, Name: "again"}); !errors.Is(err, IDTakenError) {
+		t.Errorf("expected inserting a taken ID to fail, got %v", err)
+	}
+	if err := repo.Update(ctx, Item{ID: 5, Name: "second updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := repo.Get(ctx, 5); err != nil || item.Name != "second updated" {
+		t.Errorf("expected the update, got %+v %v", item, err)
+	}
+	if err := repo.Delete(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, 0); err != NotFoundError {
+		t.Errorf("expected the deleted item to be gone, got %v", err)
+	}
+
+	items, _ := repo.List(ctx, ItemFilter{})
+	if len(items) != 2 || items[0].ID != 5 || items[1].ID != 6 {
+		t.Errorf("expected the items of every shard in the order of their IDs, got %+v", items)
+	}
+	if items, _ := repo.List(ctx, ItemFilter{ExternalID: "erp-3"}); len(items) != 1 || items[0].ID != 6 {
+		t.Errorf("expected the shards' indexes to find the item, got %+v", items)
+	}
+}
+
+func Test_shardedItemRepositoryTx(t *testing.T) {
+	repo := NewShardedItemRepository(4, Item{ID: 0, Name: "first"}, Item{ID: 1, Name: "second"})
+	ctx := context.Background()
+
+	failed := errors.New("failed")
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		tx.Delete(ctx, 0)
+		tx.Create(ctx, Item{Name: "rolled back"})
+		return failed
+	})
+	if items, _ := repo.List(ctx, ItemFilter{}); err != failed || len(items) != 2 {
+		t.Errorf("expected a failed transaction to change nothing, got %+v %v", items, err)
+	}
+
+	var created *Item
+	err = repo.Tx(ctx, func(tx ItemRepository) error {
+		if err := tx.Delete(ctx, 0); err != nil {
+			return err
+		}
+		if err := tx.Update(ctx, Item{ID: 1, Name: "second updated"}); err != nil {
+			return err
+		}
+		created, err = tx.Create(ctx, Item{Name: "third", ExternalID: "erp-3"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ := repo.List(ctx, ItemFilter{})
+	if len(items) != 2 || items[0].Name != "second updated" || items[1].ID != created.ID {
+		t.Errorf("expected the transaction's writes to reach their shards, got %+v", items)
+	}
+	if items, _ := repo.List(ctx, ItemFilter{ExternalID: "erp-3"}); len(items) != 1 {
+		t.Errorf("expected the created item in its shard's index, got %+v", items)
+	}
+	if next, _ := repo.Create(ctx, Item{Name: "fourth"}); next.ID <= created.ID {
+		t.Errorf("expected IDs to keep growing after the transaction, got %d after %d", next.ID, created.ID)
+	}
+}
+
+func Test_shardedItemRepositoryTxConflict(t *testing.T) {
+	repo := NewShardedItemRepository(4, Item{ID: 0, Name: "first", Quantity: 0})
+	ctx := context.Background()
+
+	runs := 0
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		runs++
+		item, err := tx.Get(ctx, 0)
+		if err != nil {
+			return err
+		}
+		if runs == 1 {
+			// another writer changes the item between the read and the commit
+			if err := repo.Update(ctx, Item{ID: 0, Name: "first", Quantity: 5}); err != nil {
+				return err
+			}
+		}
+		item.Quantity++
+		return tx.Update(ctx, *item)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := repo.Get(ctx, 0); runs != 2 || item.Quantity != 6 {
+		t.Errorf("expected the transaction to run again on what the other writer left, got quantity %v after %d runs", item.Quantity, runs)
+	}
+}
+
+func Test_shardedItemRepositoryConcurrentTx(t *testing.T) {
+	repo := NewShardedItemRepository(4, Item{ID: 0, Name: "counter"})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 25 {
+				err := repo.Tx(ctx, func(tx ItemRepository) error {
+					item, err := tx.Get(ctx, 0)
+					if err != nil {
+						return err
+					}
+					item.Quantity++
+					return tx.Update(ctx, *item)
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if item, _ := repo.Get(ctx, 0); item.Quantity != 200 {
+		t.Errorf("expected no increment to be lost, got %v", item.Quantity)
+	}
+}
+
+func Test_shardedItemRepositoryConcurrentWrites(t *testing.T) {
+	repo := NewShardedItemRepository(8)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Go(func() {
+			for i := range 50 {
+				if _, err := repo.Create(ctx, Item{Name: fmt.Sprintf("item %d-%d", w, i)}); err != nil {
+					t.Error(err)
+				}
+				if i%10 == 0 {
+					repo.Tx(ctx, func(tx ItemRepository) error {
+						_, err := tx.Create(ctx, Item{Name: fmt.Sprintf("tx item %d-%d", w, i)})
+						return err
+					})
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	items, _ := repo.List(ctx, ItemFilter{})
+	ids := map[int]bool{}
+	for _, item := range items {
+		ids[item.ID] = true
+	}
+	if len(items) != 8*55 || len(ids) != len(items) {
+		t.Errorf("expected %d items with IDs of their own, got %d items with %d IDs", 8*55, len(items), len(ids))
+	}
+}
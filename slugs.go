@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSlugLength leaves room for a collision suffix within 64 characters.
+const maxSlugLength = 56
+
+// slugify turns a name into lowercase ASCII letters and digits separated by
+// single hyphens: "Crème Brûlée (large)" becomes "creme-brulee-large".
+// Accents are dropped, other characters outside ASCII separate words. A name
+// without any letters or digits becomes "item".
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			hyphen = true
+		}
+	}
+	slug := strings.TrimRight(b.String()[:min(b.Len(), maxSlugLength)], "-")
+	if slug == "" {
+		return "item"
+	}
+	return slug
+}
+
+// assignSlug sets the slug of item from its name. When another item has that
+// slug already, a suffix from -2 up makes it unique. tx must be a transaction,
+// so no concurrent write can take the slug before item is stored.
+//
+// When -encrypted-fields covers the name, a slug made from it would keep the
+// name readable in the backend, so the slug is random instead.
+func assignSlug(ctx context.Context, tx ItemRepository, item *Item) error {
+	base := slugify(item.Name)
+	if fieldEncryption != nil && fieldEncryption.fields["name"] {
+		random, err := randomHex(8)
+		if err != nil {
+			return err
+		}
+		base = "item-" + random
+	}
+	item.Slug = base
+	for n := 2; ; n++ {
+		taken, err := tx.List(ctx, ItemFilter{Slug: item.Slug})
+		if err != nil {
+			return err
+		}
+		if len(taken) == 0 {
+			return nil
+		}
+		item.Slug = base + "-" + strconv.Itoa(n)
+	}
+}
+
+func getItemBySlug(w http.ResponseWriter, r *http.Request) {
+	items, err := itemRepository.List(r.Context(), ItemFilter{Slug: mux.Vars(r)["slug"]})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not get item")
+		return
+	}
+	if len(items) == 0 {
+		NotFoundResponse(w, "item with slug does not exist")
+		return
+	}
+
+	localizeItem(w, r, &items[0])
+	SuccessResponse(w, withStar(r, &items[0]))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_slugify(t *testing.T) {
+	cases := map[string]string{
+		"Crème Brûlée (large)":   "creme-brulee-large",
+		"  --Hello,   World!-- ": "hello-world",
+		"Ärger über 2 Öfen":      "arger-uber-2-ofen",
+		"日本語":                    "item",
+		"":                       "item",
+		"a_b":                    "a-b",
+	}
+	for name, want := range cases {
+		if got := slugify(name); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", name, got, want)
+		}
+	}
+	long := slugify("word " + string(bytes.Repeat([]byte("x"), 100)))
+	if len(long) != maxSlugLength {
+		t.Errorf("expected a long slug to be cut to %d characters, got %q", maxSlugLength, long)
+	}
+}
+
+func Test_getItemBySlug(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository()
+	router := mux.NewRouter()
+	router.HandleFunc("/items/", createItem)
+	router.HandleFunc("/items/by-slug/{slug}", getItemBySlug)
+	router.HandleFunc("/items/{id}", updateItem)
+
+	var slugs []string
+	for _, name := range []string{"Desk Lamp", "desk lamp", "Desk-Lamp!"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/", bytes.NewBufferString(`{"name":"`+name+`"}`)))
+		var created Item
+		json.Unmarshal(rr.Body.Bytes(), &created)
+		slugs = append(slugs, created.Slug)
+	}
+	if slugs[0] != "desk-lamp" || slugs[1] != "desk-lamp-2" || slugs[2] != "desk-lamp-3" {
+		t.Errorf("expected collision suffixes, got %v", slugs)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PUT", "/items/1", bytes.NewBufferString(`{"name":"Floor Lamp","slug":"ignored"}`)))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/by-slug/desk-lamp-2", nil))
+	var item Item
+	json.Unmarshal(rr.Body.Bytes(), &item)
+	if rr.Code != http.StatusOK || item.ID != 1 || item.Name != "Floor Lamp" {
+		t.Errorf("expected the renamed item to keep its slug, got %d %s", rr.Code, rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/by-slug/floor-lamp", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown slug, got %d", rr.Code)
+	}
+}
+
+func Test_slugOfEncryptedName(t *testing.T) {
+	c, err := newFieldCipher([]string{newTestKey(t)}, []string{"name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isolate(t, &fieldEncryption, c)
+	api := newTestAPI(t)
+
+	created := decodeResponse[Item](t, api.Request("POST", "/items/", `{"name":"Secret Lamp"}`), http.StatusCreated)
+	if strings.Contains(created.Slug, "lamp") || !strings.HasPrefix(created.Slug, "item-") {
+		t.Errorf("expected a random slug that doesn't give the name away, got %q", created.Slug)
+	}
+	found := decodeResponse[Item](t, api.Request("GET", "/items/by-slug/"+created.Slug, nil), http.StatusOK)
+	if found.ID != created.ID {
+		t.Errorf("expected the random slug to find the item, got %+v", found)
+	}
+}
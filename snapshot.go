@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// writeSnapshot saves all items to path as a JSON array, with the fields of
+// -encrypted-fields encrypted as they are in memory.
+func writeSnapshot(ctx context.Context, path string) error {
+	items, err := itemRepository.List(ctx, ItemFilter{})
+	if err != nil {
+		return err
+	}
+	if fieldEncryption != nil {
+		for i, item := range items {
+			if items[i], err = fieldEncryption.encryptItem(item); err != nil {
+				return err
+			}
+		}
+	}
+	content, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, content)
+}
+
+// writeFileAtomically writes a temporary file next to path first and renames
+// that over path, so a crash halfway never leaves a truncated file behind.
+func writeFileAtomically(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readSnapshot loads the items saved by writeSnapshot.
+func readSnapshot(path string) ([]Item, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	if err := json.Unmarshal(content, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
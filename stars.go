@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Star adds the item to the items the user starred; starring it again
+// changes nothing.
+func (s *userStore) Star(userID string, itemID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return NotFoundError
+	}
+	if slices.Contains(user.Starred, itemID) {
+		return nil
+	}
+	user.Starred = append(slices.Clip(user.Starred), itemID)
+	return s.save()
+}
+
+// Unstar removes the item from the items the user starred.
+func (s *userStore) Unstar(userID string, itemID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return NotFoundError
+	}
+	i := slices.Index(user.Starred, itemID)
+	if i < 0 {
+		return nil
+	}
+	user.Starred = slices.Delete(slices.Clone(user.Starred), i, i+1)
+	return s.save()
+}
+
+// Starred returns the IDs of the items the user starred.
+func (s *userStore) Starred(userID string) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		return slices.Clone(user.Starred)
+	}
+	return nil
+}
+
+// starredItem is an item as a user sees it, with whether they starred it.
+type starredItem struct {
+	*Item
+	Starred bool `json:"starred"`
+}
+
+// withStar adds starred to the item when the request comes from a user.
+func withStar(r *http.Request, item *Item) interface{} {
+	user := requestUser(r)
+	if user == nil || users == nil {
+		return item
+	}
+	return starredItem{Item: item, Starred: slices.Contains(users.Starred(user.ID), item.ID)}
+}
+
+// withStars adds starred to the items when the request comes from a user.
+func withStars(r *http.Request, items []Item) interface{} {
+	user := requestUser(r)
+	if user == nil || users == nil {
+		return items
+	}
+	starred := users.Starred(user.ID)
+	result := make([]starredItem, len(items))
+	for i := range items {
+		result[i] = starredItem{Item: &items[i], Starred: slices.Contains(starred, items[i].ID)}
+	}
+	return result
+}
+
+// starItem stars the item for the user the access token belongs to.
+func starItem(w http.ResponseWriter, r *http.Request) {
+	setStar(w, r, true)
+}
+
+// unstarItem takes the star of the user away again.
+func unstarItem(w http.ResponseWriter, r *http.Request) {
+	setStar(w, r, false)
+}
+
+func setStar(w http.ResponseWriter, r *http.Request, starred bool) {
+	user := requestUser(r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		ErrorCodeResponse(w, UserRequiredCode)
+		return
+	}
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+
+	if starred {
+		if _, err := itemRepository.Get(r.Context(), *id); err != nil {
+			RepositoryErrorResponse(w, err, "could not star item")
+			return
+		}
+		err = users.Star(user.ID, *id)
+	} else {
+		err = users.Unstar(user.ID, *id)
+	}
+	if err != nil {
+		InternalErrorResponse(w, "could not star item")
+		return
+	}
+	// the listings show the user's stars
+	if listVersion != nil {
+		listVersion.changed()
+	}
+	NoContentResponse(w)
+}
+
+// myStarred lists the items the user starred, in the order they were
+// starred. Items deleted since are left out.
+func myStarred(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		ErrorCodeResponse(w, UserRequiredCode)
+		return
+	}
+
+	items := []Item{}
+	for _, id := range users.Starred(user.ID) {
+		item, err := itemRepository.Get(r.Context(), id)
+		if errors.Is(err, NotFoundError) {
+			continue
+		}
+		if err != nil {
+			RepositoryErrorResponse(w, err, "could not list starred items")
+			return
+		}
+		items = append(items, *item)
+	}
+	localizeItems(w, r, items)
+	SuccessResponse(w, withStars(r, items))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newUserTestAPI serves the API with a user store and the items, and
+// returns a way to make requests and to log new users in.
+func newUserTestAPI(t *testing.T, items ...Item) (do func(method, path, token, body string) *httptest.ResponseRecorder, login func(email string) string) {
+	original, repo := users, itemRepository
+	t.Cleanup(func() { users, itemRepository = original, repo })
+	store, err := openUserStore(filepath.Join(t.TempDir(), "users.json"), []byte("test secret"), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.cost = bcrypt.MinCost
+	users = store
+	itemRepository = NewInMemoryItemRepository(items...)
+	router := newRouter(Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}})
+	do = func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login = func(email string) string {
+		credentials := `{"email":"` + email + `","password":"correct horse battery"}`
+		do("POST", "/auth/register", "", credentials)
+		var tokens AuthTokens
+		json.Unmarshal(do("POST", "/auth/login", "", credentials).Body.Bytes(), &tokens)
+		return tokens.AccessToken
+	}
+	return do, login
+}
+
+func Test_starItems(t *testing.T) {
+	do, login := newUserTestAPI(t, Item{ID: 0, Name: "first"}, Item{ID: 1, Name: "second"}, Item{ID: 2, Name: "third"})
+	token := login("fan@example.com")
+
+	if w := do("PUT", "/items/2/star", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected starring without a user to be refused, got %d", w.Code)
+	}
+	if w := do("PUT", "/items/42/star", token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected starring a missing item to fail, got %d", w.Code)
+	}
+	for _, id := range []string{"2", "0", "2"} {
+		if w := do("PUT", "/items/"+id+"/star", token, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("starring item %s returned %d %s", id, w.Code, w.Body)
+		}
+	}
+
+	var listed []starredItem
+	json.Unmarshal(do("GET", "/items/", token, "").Body.Bytes(), &listed)
+	var stars []bool
+	for _, item := range listed {
+		stars = append(stars, item.Starred)
+	}
+	if len(stars) != 3 || !stars[0] || stars[1] || !stars[2] {
+		t.Errorf("expected the first and third item starred, got %v", stars)
+	}
+	if body := do("GET", "/items/1", "", "").Body.String(); strings.Contains(body, "starred") {
+		t.Errorf("expected no starred field without a user, got %s", body)
+	}
+
+	do("DELETE", "/items/0/star", token, "")
+	w := do("GET", "/me/starred", token, "")
+	var starred []starredItem
+	json.Unmarshal(w.Body.Bytes(), &starred)
+	if w.Code != http.StatusOK || len(starred) != 1 || starred[0].ID != 2 || !starred[0].Starred {
+		t.Errorf("expected only the third item starred, got %d %s", w.Code, w.Body)
+	}
+
+	reopened, err := openUserStore(users.path, []byte("test secret"), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := reopened.byEmail("fan@example.com")
+	if user == nil || len(user.Starred) != 1 || user.Starred[0] != 2 {
+		t.Errorf("expected the star to be saved, got %+v", user)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsdMetrics sends the measurements to a StatsD agent over UDP, one packet
+// per measurement. Sending never blocks a request: lost packets are lost
+// measurements. Plain StatsD has no tags, so the labels become part of the
+// metric name; DogStatsD gets them as tags, together with the fixed tags.
+type statsdMetrics struct {
+	conn   net.Conn
+	prefix string
+	tagged bool
+	tags   []string
+}
+
+func newStatsdMetrics(addr, prefix string, tagged bool, tags []string) (*statsdMetrics, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdMetrics{conn: conn, prefix: prefix, tagged: tagged, tags: tags}, nil
+}
+
+func (m *statsdMetrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	m.send("http.request.duration", milliseconds(duration), "ms", "route", route, "method", method, "status", strconv.Itoa(status))
+}
+
+func (m *statsdMetrics) ObserveStorage(backend, operation string, err error, duration time.Duration) {
+	m.send("storage.operation.duration", milliseconds(duration), "ms", "backend", backend, "operation", operation, "outcome", storageOutcome(err))
+}
+
+func (m *statsdMetrics) CountCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.send("item_cache.lookups", "1", "c", "result", result)
+}
+
+// send writes name:value|kind, labelled by the label name and value pairs.
+func (m *statsdMetrics) send(name, value, kind string, labels ...string) {
+	var line strings.Builder
+	line.WriteString(m.prefix)
+	line.WriteString(name)
+	if !m.tagged {
+		for i := 1; i < len(labels); i += 2 {
+			line.WriteString(".")
+			line.WriteString(statsdNamePart(labels[i]))
+		}
+	}
+	fmt.Fprintf(&line, ":%s|%s", value, kind)
+	if m.tagged {
+		tags := append([]string(nil), m.tags...)
+		for i := 0; i+1 < len(labels); i += 2 {
+			tags = append(tags, labels[i]+":"+labels[i+1])
+		}
+		if len(tags) > 0 {
+			line.WriteString("|#")
+			line.WriteString(strings.Join(tags, ","))
+		}
+	}
+	m.conn.Write([]byte(line.String()))
+}
+
+// statsdNamePart turns a label value like /items/{id} into items_id, which
+// can go into a dotted metric name.
+func statsdNamePart(value string) string {
+	part := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r == '{' || r == '}':
+			return -1
+		default:
+			return '_'
+		}
+	}, value)
+	part = strings.Trim(part, "_")
+	if part == "" {
+		return "root"
+	}
+	return part
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_statsdMetrics(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+	received := func() string {
+		buf := make([]byte, 1024)
+		agent.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	plain, _ := newStatsdMetrics(agent.LocalAddr().String(), "api.", false, nil)
+	plain.ObserveRequest("/items/{id}", "GET", 200, 1500*time.Microsecond)
+	if line := received(); line != "api.http.request.duration.items_id.GET.200:1.500|ms" {
+		t.Errorf("unexpected statsd line %q", line)
+	}
+
+	tagged, _ := newStatsdMetrics(agent.LocalAddr().String(), "api.", true, []string{"env:test"})
+	tagged.ObserveStorage("redis", "get", errors.New("connection refused"), 2*time.Millisecond)
+	if line := received(); line != "api.storage.operation.duration:2.000|ms|#env:test,backend:redis,operation:get,outcome:error" {
+		t.Errorf("unexpected dogstatsd line %q", line)
+	}
+	tagged.CountCacheLookup(true)
+	if line := received(); line != "api.item_cache.lookups:1|c|#env:test,result:hit" {
+		t.Errorf("unexpected dogstatsd line %q", line)
+	}
+}
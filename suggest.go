@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const defaultSuggestLimit = 10
+
+// Suggestion is a name completion for type-ahead.
+type Suggestion struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// SuggestIndex completes prefixes to item names. Every word of a name starts
+// a key, so "fir" completes both "first item" and "the first item". The keys
+// are kept sorted, which makes the keys starting with a prefix one range
+// found by binary search.
+type SuggestIndex struct {
+	mu    sync.RWMutex
+	keys  []suggestKey
+	items map[int]Item
+}
+
+type suggestKey struct {
+	key string
+	id  int
+}
+
+func NewSuggestIndex() *SuggestIndex {
+	return &SuggestIndex{items: map[int]Item{}}
+}
+
+// suggestKeys returns the lowercased name from the start of each word on.
+func suggestKeys(name string) []string {
+	name = strings.ToLower(name)
+	var keys []string
+	wordStart := true
+	for i, r := range name {
+		letter := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if letter && wordStart {
+			keys = append(keys, name[i:])
+		}
+		wordStart = !letter
+	}
+	return keys
+}
+
+func (idx *SuggestIndex) Add(item Item) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(item.ID)
+	idx.items[item.ID] = item
+	for _, key := range suggestKeys(item.Name) {
+		i := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= key })
+		idx.keys = append(idx.keys, suggestKey{})
+		copy(idx.keys[i+1:], idx.keys[i:])
+		idx.keys[i] = suggestKey{key: key, id: item.ID}
+	}
+}
+
+func (idx *SuggestIndex) Remove(id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+func (idx *SuggestIndex) remove(id int) {
+	if _, ok := idx.items[id]; !ok {
+		return
+	}
+	delete(idx.items, id)
+	keys := idx.keys[:0]
+	for _, key := range idx.keys {
+		if key.id != id {
+			keys = append(keys, key)
+		}
+	}
+	idx.keys = keys
+}
+
+// Suggest completes prefix to at most limit names of items listed at t, the
+// most popular first: those rated by the most users, then the best rated.
+// Names that are alike come in order of their ID.
+func (idx *SuggestIndex) Suggest(prefix string, limit int, t time.Time) []Suggestion {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var matches []Item
+	seen := map[int]bool{}
+	for i := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= prefix }); i < len(idx.keys) && strings.HasPrefix(idx.keys[i].key, prefix); i++ {
+		item := idx.items[idx.keys[i].id]
+		if seen[item.ID] || item.State() != ItemStateActive || !item.PublishedAt(t) {
+			continue
+		}
+		seen[item.ID] = true
+		matches = append(matches, item)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := popularity(matches[i]), popularity(matches[j])
+		if a != b {
+			return a.count > b.count || (a.count == b.count && a.average > b.average)
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	suggestions := []Suggestion{}
+	for _, item := range matches[:min(limit, len(matches))] {
+		suggestions = append(suggestions, Suggestion{ID: item.ID, Name: item.Name})
+	}
+	return suggestions
+}
+
+type itemPopularity struct {
+	count   int
+	average float64
+}
+
+func popularity(item Item) itemPopularity {
+	if item.Rating == nil {
+		return itemPopularity{}
+	}
+	return itemPopularity{count: item.Rating.Count, average: item.Rating.Average}
+}
+
+// IndexStats counts the keys, one for every word of every name.
+func (idx *SuggestIndex) IndexStats() []IndexStats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return []IndexStats{{Name: "suggest_keys", Entries: uint64(len(idx.keys))}}
+}
+
+var suggestIndex *SuggestIndex
+
+// setupSuggestions fills the suggest index from the repository and routes
+// item writes through it.
+func setupSuggestions() error {
+	items, err := itemRepository.List(context.Background(), ItemFilter{})
+	if err != nil {
+		return err
+	}
+	index := NewSuggestIndex()
+	for _, item := range items {
+		index.Add(item)
+	}
+	suggestIndex = index
+	itemRepository = &suggestIndexingRepository{ItemRepository: itemRepository, index: index}
+	return nil
+}
+
+// suggestIndexingRepository keeps the suggest index in sync with every write
+// that goes through it, like searchIndexingRepository does for search.
+type suggestIndexingRepository struct {
+	ItemRepository
+	index *SuggestIndex
+}
+
+func (repo *suggestIndexingRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	created, err := repo.ItemRepository.Create(ctx, item)
+	if err == nil {
+		repo.index.Add(*created)
+	}
+	return created, err
+}
+
+func (repo *suggestIndexingRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	inserted, err := repo.ItemRepository.Insert(ctx, item)
+	if err == nil {
+		repo.index.Add(*inserted)
+	}
+	return inserted, err
+}
+
+func (repo *suggestIndexingRepository) Update(ctx context.Context, item Item) error {
+	err := repo.ItemRepository.Update(ctx, item)
+	if err == nil {
+		repo.index.Add(item)
+	}
+	return err
+}
+
+func (repo *suggestIndexingRepository) Delete(ctx context.Context, id int) error {
+	err := repo.ItemRepository.Delete(ctx, id)
+	if err == nil {
+		repo.index.Remove(id)
+	}
+	return err
+}
+
+// IndexStats reports the indexes of the wrapped repository and the suggest index.
+func (repo *suggestIndexingRepository) IndexStats() []IndexStats {
+	var stats []IndexStats
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		stats = reporter.IndexStats()
+	}
+	return append(stats, repo.index.IndexStats()...)
+}
+
+// Tx records what fn writes and only indexes it after the transaction commits.
+func (repo *suggestIndexingRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	var recorder *writeRecorder
+	err := repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		recorder = newWriteRecorder(tx)
+		return fn(recorder)
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range recorder.order {
+		if item := recorder.writes[id]; item != nil {
+			repo.index.Add(*item)
+		} else {
+			repo.index.Remove(id)
+		}
+	}
+	return nil
+}
+
+func suggestItems(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	prefix := params.Get("prefix")
+	if strings.TrimSpace(prefix) == "" {
+		ErrorCodeResponse(w, PrefixRequiredCode)
+		return
+	}
+	limit := defaultSuggestLimit
+	if l := params.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxLimit {
+			ErrorCodeResponse(w, InvalidLimitCode)
+			return
+		}
+	}
+
+	suggestions := suggestIndex.Suggest(prefix, limit, time.Now())
+	SetResponseMeta(w, ResponseMeta{Total: len(suggestions), Limit: limit})
+	SuccessResponse(w, suggestions)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_suggestItems(t *testing.T) {
+	defer func(repo ItemRepository, index *SuggestIndex) { itemRepository, suggestIndex = repo, index }(itemRepository, suggestIndex)
+	archived := time.Now()
+	itemRepository = NewInMemoryItemRepository(
+		Item{ID: 0, Name: "First aid kit"},
+		Item{ID: 1, Name: "The first item", Rating: &Rating{Average: 3, Count: 2, Sum: 6}},
+		Item{ID: 2, Name: "fire starter", Rating: &Rating{Average: 5, Count: 1, Sum: 5}},
+		Item{ID: 3, Name: "first edition", ArchivedAt: &archived},
+		Item{ID: 4, Name: "second"},
+	)
+	if err := setupSuggestions(); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/items/suggest", suggestItems)
+
+	suggest := func(query string) (int, []Suggestion) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/suggest?"+query, nil))
+		var suggestions []Suggestion
+		json.Unmarshal(rr.Body.Bytes(), &suggestions)
+		return rr.Code, suggestions
+	}
+
+	_, got := suggest("prefix=FIR")
+	want := []Suggestion{{1, "The first item"}, {2, "fire starter"}, {0, "First aid kit"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the most rated first and no archived items, got %v", got)
+	}
+	if _, got := suggest("prefix=fir&limit=1"); len(got) != 1 {
+		t.Errorf("expected the limit to apply, got %v", got)
+	}
+
+	ctx := context.Background()
+	itemRepository.Update(ctx, Item{ID: 0, Name: "Bandages"})
+	itemRepository.Delete(ctx, 2)
+	itemRepository.Tx(ctx, func(tx ItemRepository) error {
+		_, err := tx.Create(ctx, Item{Name: "Firewood"})
+		return err
+	})
+	_, got = suggest("prefix=fir")
+	want = []Suggestion{{1, "The first item"}, {5, "Firewood"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the index to follow the writes, got %v", got)
+	}
+
+	for _, query := range []string{"", "prefix=+", "prefix=fir&limit=0"} {
+		if code, _ := suggest(query); code != http.StatusBadRequest {
+			t.Errorf("suggesting with %q returned %d, want 400", query, code)
+		}
+	}
+}
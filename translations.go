@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
+)
+
+// Translation is the name and description of an item in another language.
+type Translation struct {
+	Name        string `json:"name" bson:"name"`
+	Description string `json:"description" bson:"description"`
+}
+
+// parseLanguage canonicalizes a BCP 47 language tag like "de-at" to "de-AT".
+func parseLanguage(lang string) (string, bool) {
+	tag, err := language.Parse(lang)
+	if err != nil || tag == language.Und {
+		return "", false
+	}
+	return tag.String(), true
+}
+
+// anyLanguage is what language.ParseAcceptLanguage makes of the * wildcard.
+var anyLanguage = language.Make("mul")
+
+// preferredLanguages are the languages the request accepts, most preferred
+// first, each followed by its language without region or script: "de-AT"
+// falls back to "de". Languages the client refuses with q=0 and the wildcard
+// are left out; the stored name and description come last anyway.
+func preferredLanguages(r *http.Request) []string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return nil
+	}
+	var langs []string
+	for _, tag := range tags {
+		if tag == anyLanguage {
+			continue
+		}
+		langs = append(langs, tag.String())
+		if base, confidence := tag.Base(); confidence == language.Exact && base.String() != tag.String() {
+			langs = append(langs, base.String())
+		}
+	}
+	return langs
+}
+
+// localize replaces the name and description of item with its translation
+// into the first of langs it has; it returns the language used, "" when item
+// is left as stored.
+func localize(item *Item, langs []string) string {
+	for _, lang := range langs {
+		if t, ok := item.Translations[lang]; ok {
+			item.Name, item.Description = t.Name, t.Description
+			return lang
+		}
+	}
+	return ""
+}
+
+// localizeItem translates item for the request and says so in
+// Content-Language.
+func localizeItem(w http.ResponseWriter, r *http.Request, item *Item) {
+	w.Header().Add("Vary", "Accept-Language")
+	if lang := localize(item, preferredLanguages(r)); lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+}
+
+// localizeItems translates every item for the request. The items may end up
+// in different languages, so there is no Content-Language.
+func localizeItems(w http.ResponseWriter, r *http.Request, items []Item) {
+	w.Header().Add("Vary", "Accept-Language")
+	langs := preferredLanguages(r)
+	if len(langs) == 0 {
+		return
+	}
+	for i := range items {
+		localize(&items[i], langs)
+	}
+}
+
+// validateTranslation validates a translation like the name and description
+// of an item.
+func validateTranslation(t Translation) []FieldError {
+	item := Item{Name: t.Name, Description: t.Description}
+	return append(validateItemFields(item), checkValidationRules(item, "name", "description")...)
+}
+
+// putItemTranslation adds or replaces the translation of an item into the
+// language in the path.
+func putItemTranslation(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDParam(r)
+	if err != nil {
+		ErrorCodeResponse(w, InvalidIDCode)
+		return
+	}
+	lang, ok := parseLanguage(mux.Vars(r)["lang"])
+	if !ok {
+		ErrorCodeResponse(w, InvalidLanguageCode)
+		return
+	}
+
+	var translation Translation
+	if err := decodeBody(r, &translation); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	if errs := validateTranslation(translation); len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
+	}
+
+	var item *Item
+	err = itemRepository.Tx(r.Context(), func(tx ItemRepository) error {
+		item, err = tx.Get(r.Context(), *id)
+		if err != nil {
+			return err
+		}
+		translations := make(map[string]Translation, len(item.Translations)+1)
+		for l, t := range item.Translations {
+			translations[l] = t
+		}
+		translations[lang] = translation
+		item.Translations = translations
+		return tx.Update(r.Context(), *item)
+	})
+	if err != nil {
+		RepositoryErrorResponse(w, err, "could not translate item")
+		return
+	}
+
+	SuccessResponse(w, item)
+}
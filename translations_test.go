@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_preferredLanguages(t *testing.T) {
+	cases := map[string]string{
+		"":                              "",
+		"de-AT":                         "de-AT,de",
+		"fr;q=0.5, pt-BR, *;q=0.1":      "pt-BR,pt,fr",
+		"en-GB;q=0.8, de;q=0, nl":       "nl,en-GB,en",
+		"not a language header at all!": "",
+	}
+	for header, want := range cases {
+		r := httptest.NewRequest("GET", "/items/", nil)
+		r.Header.Set("Accept-Language", header)
+		if got := strings.Join(preferredLanguages(r), ","); got != want {
+			t.Errorf("Accept-Language %q gave %q, want %q", header, got, want)
+		}
+	}
+}
+
+func Test_putItemTranslation(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "chair", Description: "to sit on"})
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}/translations/{lang}", putItemTranslation)
+	router.HandleFunc("/items/{id}", getItem).Methods(http.MethodGet)
+	router.HandleFunc("/items/{id}", updateItem).Methods(http.MethodPut)
+
+	for lang, body := range map[string]string{
+		"de":    `{"name":"Stuhl","description":"zum Sitzen"}`,
+		"pt-br": `{"name":"cadeira","description":"para sentar"}`,
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("PUT", "/items/0/translations/"+lang, bytes.NewBufferString(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("translating into %s returned %d %s", lang, rr.Code, rr.Body)
+		}
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PUT", "/items/0", bytes.NewBufferString(`{"name":"chair","description":"renamed"}`)))
+
+	cases := map[string]struct{ name, lang string }{
+		"de-CH, en;q=0.5": {"Stuhl", "de"},
+		"pt-BR":           {"cadeira", "pt-BR"},
+		"fr, de;q=0.2":    {"Stuhl", "de"},
+		"fr":              {"chair", ""},
+		"":                {"chair", ""},
+	}
+	for header, want := range cases {
+		req := httptest.NewRequest("GET", "/items/0", nil)
+		req.Header.Set("Accept-Language", header)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var item Item
+		json.Unmarshal(rr.Body.Bytes(), &item)
+		if item.Name != want.name || rr.Header().Get("Content-Language") != want.lang {
+			t.Errorf("Accept-Language %q gave %q in %q, want %q in %q", header, item.Name, rr.Header().Get("Content-Language"), want.name, want.lang)
+		}
+	}
+
+	for path, code := range map[string]string{
+		"/items/0/translations/klingon": "INVALID_LANGUAGE",
+		"/items/0/translations/fr":      "VALIDATION_FAILED",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("PUT", path, bytes.NewBufferString(`{"description":"no name"}`)))
+		if !strings.Contains(rr.Body.String(), code) {
+			t.Errorf("%s returned %d %s, want %s", path, rr.Code, rr.Body, code)
+		}
+	}
+}
+
+func Test_encryptedTranslations(t *testing.T) {
+	ctx := context.Background()
+	c, err := newFieldCipher([]string{newTestKey(t)}, []string{"description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := NewInMemoryItemRepository()
+	repo := &encryptingRepository{ItemRepository: stored, cipher: c}
+	created, _ := repo.Create(ctx, Item{Name: "chair", Translations: map[string]Translation{"de": {Name: "Stuhl", Description: "geheim"}}})
+
+	raw, _ := stored.Get(ctx, created.ID)
+	if raw.Translations["de"].Name != "Stuhl" || !strings.HasPrefix(raw.Translations["de"].Description, encryptedPrefix) {
+		t.Errorf("expected only the translated description to be encrypted, got %+v", raw.Translations)
+	}
+	item, _ := repo.Get(ctx, created.ID)
+	if item.Translations["de"].Description != "geheim" {
+		t.Errorf("expected the translation decrypted, got %+v", item.Translations)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// reapExpiredItems deletes the items whose ttl has passed; it runs as a job.
+// The deletes go through the repository like any other, so they are recorded
+// and announced as ItemDeleted events. An item another instance reaped first
+// is skipped.
+func reapExpiredItems(ctx context.Context) error {
+	items, err := itemRepository.List(ctx, ItemFilter{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, item := range items {
+		if item.DeleteAt == nil || item.DeleteAt.After(now) {
+			continue
+		}
+		if err := itemRepository.Delete(ctx, item.ID); err != nil && !errors.Is(err, NotFoundError) {
+			return err
+		}
+	}
+	return nil
+}
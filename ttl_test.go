@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_createItemWithTTL(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = NewInMemoryItemRepository()
+
+	rr := httptest.NewRecorder()
+	createItem(rr, httptest.NewRequest("POST", "/items/", bytes.NewBufferString(`{"name":"share link","ttl":"24h"}`)))
+	var created Item
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.DeleteAt == nil || time.Until(*created.DeleteAt) < 23*time.Hour {
+		t.Errorf("expected the item to be deleted in 24h, got %d %s", rr.Code, rr.Body)
+	}
+
+	for _, ttl := range []string{`"soon"`, `"-1h"`, `"0s"`} {
+		rr := httptest.NewRecorder()
+		createItem(rr, httptest.NewRequest("POST", "/items/", bytes.NewBufferString(`{"name":"share link","ttl":`+ttl+`}`)))
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "INVALID_TTL") {
+			t.Errorf("ttl %s returned %d %s, want INVALID_TTL", ttl, rr.Code, rr.Body)
+		}
+	}
+}
+
+func Test_reapExpiredItems(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	ctx := context.Background()
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	notifier := &recordingNotifier{}
+	itemRepository = &notifyingRepository{
+		ItemRepository: NewInMemoryItemRepository(
+			Item{ID: 0, Name: "kept"},
+			Item{ID: 1, Name: "expired", DeleteAt: &past},
+			Item{ID: 2, Name: "not yet", DeleteAt: &future},
+		),
+		notifier: notifier,
+	}
+
+	if err := reapExpiredItems(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := itemRepository.Get(ctx, 1); !errors.Is(err, NotFoundError) {
+		t.Errorf("expected the expired item to be deleted, got %v", err)
+	}
+	items, _ := itemRepository.List(ctx, ItemFilter{})
+	if len(items) != 2 {
+		t.Errorf("expected the other items to stay, got %+v", items)
+	}
+	if strings.Join(notifier.subjects, ",") != "items.1.deleted" {
+		t.Errorf("expected the deletion to be announced, got %v", notifier.subjects)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// NameTakenError is returned with -unique-names when another item has the
+// name already, ignoring case.
+type NameTakenError struct {
+	ID int
+}
+
+func (e *NameTakenError) Error() string {
+	return fmt.Sprintf("item %d has this name already", e.ID)
+}
+
+// uniqueNamesRepository refuses to store an item under a name another item
+// has, ignoring case. Every create and update checks the names inside a
+// transaction of the wrapped repository, so concurrent writes can't both
+// take a name: the in-memory, bolt, event sourced and raft backends run
+// transactions one at a time, and Redis retries a transaction when an item
+// it listed changed meanwhile. MongoDB transactions don't see each other's
+// inserts, so there a case-insensitive unique index backs the check up.
+type uniqueNamesRepository struct {
+	ItemRepository
+}
+
+func (repo *uniqueNamesRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	var created *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		var err error
+		created, err = tx.Create(ctx, item)
+		return err
+	})
+	return created, err
+}
+
+func (repo *uniqueNamesRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	var inserted *Item
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		var err error
+		inserted, err = tx.Insert(ctx, item)
+		return err
+	})
+	return inserted, err
+}
+
+func (repo *uniqueNamesRepository) Update(ctx context.Context, item Item) error {
+	return repo.Tx(ctx, func(tx ItemRepository) error {
+		return tx.Update(ctx, item)
+	})
+}
+
+func (repo *uniqueNamesRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		return fn(&uniqueNamesTx{ItemRepository: tx})
+	})
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *uniqueNamesRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+// uniqueNamesTx checks the names against the items as the transaction sees
+// them, its own writes included.
+type uniqueNamesTx struct {
+	ItemRepository
+}
+
+func (tx *uniqueNamesTx) Create(ctx context.Context, item Item) (*Item, error) {
+	if err := tx.checkName(ctx, item.Name, nil); err != nil {
+		return nil, err
+	}
+	return tx.ItemRepository.Create(ctx, item)
+}
+
+func (tx *uniqueNamesTx) Insert(ctx context.Context, item Item) (*Item, error) {
+	if err := tx.checkName(ctx, item.Name, nil); err != nil {
+		return nil, err
+	}
+	return tx.ItemRepository.Insert(ctx, item)
+}
+
+func (tx *uniqueNamesTx) Update(ctx context.Context, item Item) error {
+	if err := tx.checkName(ctx, item.Name, &item.ID); err != nil {
+		return err
+	}
+	return tx.ItemRepository.Update(ctx, item)
+}
+
+// checkName fails when an item other than the one with ID self has the name.
+func (tx *uniqueNamesTx) checkName(ctx context.Context, name string, self *int) error {
+	items, err := tx.ItemRepository.List(ctx, ItemFilter{})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if strings.EqualFold(item.Name, name) && (self == nil || item.ID != *self) {
+			return &NameTakenError{ID: item.ID}
+		}
+	}
+	return nil
+}
+
+// setupUniqueNames enforces unique item names when -unique-names is set.
+func setupUniqueNames(cfg Config) {
+	if cfg.UniqueNames {
+		itemRepository = &uniqueNamesRepository{ItemRepository: itemRepository}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_uniqueNamesRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := &uniqueNamesRepository{ItemRepository: NewInMemoryItemRepository(Item{ID: 0, Name: "Lamp"}, Item{ID: 1, Name: "Chair"})}
+
+	var taken *NameTakenError
+	if _, err := repo.Create(ctx, Item{Name: "lamp"}); !errors.As(err, &taken) || taken.ID != 0 {
+		t.Errorf("expected creating a lamp to conflict with item 0, got %v", err)
+	}
+	if err := repo.Update(ctx, Item{ID: 1, Name: "LAMP"}); !errors.As(err, &taken) || taken.ID != 0 {
+		t.Errorf("expected renaming the chair to conflict with item 0, got %v", err)
+	}
+	if err := repo.Update(ctx, Item{ID: 0, Name: "lamp", Description: "renamed in place"}); err != nil {
+		t.Errorf("expected an item to keep its own name, got %v", err)
+	}
+	if _, err := repo.Create(ctx, Item{Name: "Table"}); err != nil {
+		t.Errorf("expected a new name to be accepted, got %v", err)
+	}
+
+	err := repo.Tx(ctx, func(tx ItemRepository) error {
+		if _, err := tx.Create(ctx, Item{Name: "Stool"}); err != nil {
+			return err
+		}
+		_, err := tx.Create(ctx, Item{Name: "stool"})
+		return err
+	})
+	if !errors.As(err, &taken) {
+		t.Errorf("expected a transaction to see its own names, got %v", err)
+	}
+	if items, _ := repo.List(ctx, ItemFilter{NameContains: "tool"}); len(items) != 0 {
+		t.Errorf("expected the transaction to roll back, got %+v", items)
+	}
+}
+
+func Test_updateItemNameTaken(t *testing.T) {
+	defer func(repo ItemRepository) { itemRepository = repo }(itemRepository)
+	itemRepository = &uniqueNamesRepository{ItemRepository: NewInMemoryItemRepository(Item{ID: 0, Name: "first"}, Item{ID: 1, Name: "second"})}
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}", updateItem)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PUT", "/items/1", bytes.NewBufferString(`{"name":"First"}`)))
+	var problem Problem
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	if rr.Code != http.StatusConflict || problem.Code != "ITEM_NAME_TAKEN" || problem.ConflictingID == nil || *problem.ConflictingID != 0 {
+		t.Errorf("expected 409 ITEM_NAME_TAKEN with conflicting_id 0, got %d %s", rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvMediaType is the media type of the CSV export.
+const csvMediaType = "text/csv"
+
+// defaultUsageDays is how many days GET /admin/usage reports without ?from=.
+const defaultUsageDays = 30
+
+// UsageRecord is what one client did on one UTC day.
+type UsageRecord struct {
+	Day      string `json:"day"`
+	Client   string `json:"client"`
+	Requests int64  `json:"requests"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+// usageTracker adds up the requests and bytes of every client per day, to
+// attribute load to the teams behind the API tokens. Clients are told apart
+// like for rate limiting, except that requests without a token or user count
+// as anonymous; IPs aren't kept. The records are saved to a file every
+// minute, so a crash loses at most that much.
+type usageTracker struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]map[string]*UsageRecord
+	dirty   bool
+	now     func() time.Time
+}
+
+// usage accounts for the public API; nil without -usage-path.
+var usage *usageTracker
+
+func openUsageTracker(path string) (*usageTracker, error) {
+	tracker := &usageTracker{path: path, records: map[string]map[string]*UsageRecord{}, now: time.Now}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return tracker, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*UsageRecord
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		tracker.day(record.Day)[record.Client] = record
+	}
+	return tracker, nil
+}
+
+func (t *usageTracker) day(day string) map[string]*UsageRecord {
+	clients, ok := t.records[day]
+	if !ok {
+		clients = map[string]*UsageRecord{}
+		t.records[day] = clients
+	}
+	return clients
+}
+
+// record counts a request of client that read bytesIn and wrote bytesOut.
+func (t *usageTracker) record(client string, bytesIn, bytesOut int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	day := t.now().UTC().Format(time.DateOnly)
+	clients := t.day(day)
+	record, ok := clients[client]
+	if !ok {
+		record = &UsageRecord{Day: day, Client: client}
+		clients[client] = record
+	}
+	record.Requests++
+	record.BytesIn += bytesIn
+	record.BytesOut += bytesOut
+	t.dirty = true
+}
+
+// Report returns the records of the days from from to to, both included, by
+// day and client.
+func (t *usageTracker) Report(from, to time.Time) []UsageRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := []UsageRecord{}
+	for day, clients := range t.records {
+		if day < from.Format(time.DateOnly) || day > to.Format(time.DateOnly) {
+			continue
+		}
+		for _, record := range clients {
+			report = append(report, *record)
+		}
+	}
+	sortUsage(report)
+	return report
+}
+
+func sortUsage(records []UsageRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Day != records[j].Day {
+			return records[i].Day < records[j].Day
+		}
+		return records[i].Client < records[j].Client
+	})
+}
+
+// flush saves the records when they changed; it runs as a job.
+func (t *usageTracker) flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	var records []UsageRecord
+	for _, clients := range t.records {
+		for _, record := range clients {
+			records = append(records, *record)
+		}
+	}
+	sortUsage(records)
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(t.path, content); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes of the response body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// usageMiddleware records every request with the bytes of its body and of
+// the response body. Headers aren't counted.
+func usageMiddleware(tracker *usageTracker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := &countingReader{ReadCloser: r.Body}
+			r.Body = body
+			cw := &countingWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			tracker.record(callerName(r.Context()), body.n, cw.n)
+		})
+	}
+}
+
+// usageRange reads ?from= and ?to=, dates like 2024-05-01. To defaults to
+// today and from to the defaultUsageDays before it.
+func usageRange(r *http.Request, now time.Time) (time.Time, time.Time, bool) {
+	to := now.UTC().Truncate(24 * time.Hour)
+	if value := r.URL.Query().Get("to"); value != "" {
+		var err error
+		if to, err = time.Parse(time.DateOnly, value); err != nil {
+			return to, to, false
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultUsageDays)
+	if value := r.URL.Query().Get("from"); value != "" {
+		var err error
+		if from, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, false
+		}
+	}
+	return from, to, !from.After(to)
+}
+
+// usageReport returns the usage per day and client, as JSON or, at
+// /admin/usage.csv, as CSV with a header row.
+func usageReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := usageRange(r, usage.now())
+	if !ok {
+		ErrorCodeResponse(w, InvalidDateRangeCode)
+		return
+	}
+	report := usage.Report(from, to)
+	if fileMediaType(r) != csvMediaType {
+		SuccessResponse(w, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", csvMediaType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+	w.WriteHeader(http.StatusOK)
+	out := csv.NewWriter(w)
+	out.Write([]string{"day", "client", "requests", "bytes_in", "bytes_out"})
+	for _, record := range report {
+		out.Write([]string{
+			record.Day,
+			record.Client,
+			strconv.FormatInt(record.Requests, 10),
+			strconv.FormatInt(record.BytesIn, 10),
+			strconv.FormatInt(record.BytesOut, 10),
+		})
+	}
+	out.Flush()
+}
+
+// setupUsage switches usage accounting on when -usage-path is set.
+func setupUsage(cfg Config) error {
+	if cfg.UsagePath == "" {
+		return nil
+	}
+	tracker, err := openUsageTracker(cfg.UsagePath)
+	if err != nil {
+		return err
+	}
+	usage = tracker
+	jobs.Add(Job{Name: "usage-flush", Every: time.Minute, Run: tracker.flush})
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_usageAccounting(t *testing.T) {
+	original, repo := usage, itemRepository
+	t.Cleanup(func() { usage, itemRepository = original, repo })
+	path := filepath.Join(t.TempDir(), "usage.json")
+	tracker, err := openUsageTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return today }
+	tracker.record("token:old", 10, 20)
+	usage = tracker
+	itemRepository = NewInMemoryItemRepository(Item{ID: 0, Name: "first"})
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}}
+	router, admin := newRouter(cfg), newAdminRouter(cfg)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(path, "/admin/") {
+			admin.ServeHTTP(w, req)
+		} else {
+			router.ServeHTTP(w, req)
+		}
+		return w
+	}
+
+	tracker.now = func() time.Time { return today.AddDate(0, 0, 1) }
+	listed := do("GET", "/items/", "")
+	created := do("POST", "/items/", `{"name":"second"}`)
+
+	w := do("GET", "/admin/usage?from=2024-05-03&to=2024-05-03", "")
+	var report []UsageRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected a report, got %d %s", w.Code, w.Body)
+	}
+	expected := UsageRecord{Day: "2024-05-03", Client: "anonymous", Requests: 2, BytesIn: 17, BytesOut: int64(listed.Body.Len() + created.Body.Len())}
+	if len(report) != 1 || report[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+
+	w = do("GET", "/admin/usage.csv?to=2024-05-03", "")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), csvMediaType) || len(lines) != 3 {
+		t.Fatalf("expected a header and a row per day and client, got %v %q", w.Header(), w.Body)
+	}
+	if lines[0] != "day,client,requests,bytes_in,bytes_out" || lines[1] != "2024-05-02,token:old,1,10,20" {
+		t.Errorf("unexpected rows %q", lines)
+	}
+
+	for _, query := range []string{"from=yesterday", "from=2024-05-04&to=2024-05-03"} {
+		if w := do("GET", "/admin/usage?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be refused, got %d", query, w.Code)
+		}
+	}
+
+	if err := tracker.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openUsageTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Report(today, today); len(got) != 1 || got[0].Client != "token:old" {
+		t.Errorf("expected the records to survive a restart, got %+v", got)
+	}
+}
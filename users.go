@@ -0,0 +1,647 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	// maxPasswordBytes is as much of a password as bcrypt looks at.
+	maxPasswordBytes = 72
+
+	// refreshTokenPrefix starts every refresh token, like apiTokenPrefix.
+	refreshTokenPrefix = "spr_"
+)
+
+var (
+	ErrEmailTaken          = errors.New("email is already registered")
+	ErrInvalidRefreshToken = errors.New("refresh token is unknown, expired or its session has ended")
+	ErrRefreshTokenReused  = errors.New("refresh token was used already")
+)
+
+// The reasons a session ended, kept in Session.EndReason.
+const (
+	SessionEndedByLogout = "logout"
+	SessionEndedByReuse  = "refresh_token_reused"
+)
+
+// User is an account as it is stored, with the bcrypt hash of its password.
+type User struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Starred are the IDs of the items the user starred, in the order they
+	// were starred.
+	Starred []int `json:"starred,omitempty"`
+	// Ratings are the scores the user gave, by item ID.
+	Ratings map[int]int `json:"ratings,omitempty"`
+}
+
+// userScopes are the scopes of every user, see -user-scopes. Anyone can
+// register, so they only read items unless the operator says otherwise;
+// admin stays with API tokens.
+var userScopes = []string{ScopeItemsRead}
+
+func (u *User) HasScope(scope string) bool {
+	return slices.Contains(userScopes, scope)
+}
+
+// UserView is a user as the API shows it.
+type UserView struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u *User) view() UserView {
+	return UserView{ID: u.ID, Email: u.Email, CreatedAt: u.CreatedAt}
+}
+
+// Session is what a login starts and a logout ends. Its current refresh
+// token is stored as a SHA-256 hash, like an API token, and the access tokens
+// issued for it name it, so they stop working with it: the ended sessions are
+// the revocation list of the access tokens.
+type Session struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Hash   string `json:"hash"`
+	// RotatedHashes are the hashes of the refresh tokens the session was
+	// refreshed with, so Refresh can tell a replayed one from a made-up one.
+	RotatedHashes []string   `json:"rotated_hashes,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RefreshedAt   *time.Time `json:"refreshed_at,omitempty"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+	EndReason     string     `json:"end_reason,omitempty"`
+}
+
+func (s *Session) active(now time.Time) bool {
+	return s.EndedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// AuthTokens is the response of a login: a short-lived JWT to send as
+// "Authorization: Bearer" and the refresh token of the session.
+type AuthTokens struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int       `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// accessClaims are the claims of an access token: the user is the subject
+// and sid names the session.
+type accessClaims struct {
+	Session string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// userStore keeps the users and their sessions in a JSON file, saved on
+// every change.
+type userStore struct {
+	mu       sync.Mutex
+	path     string
+	users    map[string]*User
+	sessions map[string]*Session
+	secret   []byte
+	// previousSecret still verifies the access tokens signed before the
+	// secret changed, until they expire.
+	previousSecret []byte
+	accessTTL      time.Duration
+	refreshTTL     time.Duration
+	cost           int
+	now            func() time.Time
+
+	unknownUserOnce sync.Once
+	unknownUserHash []byte
+}
+
+// userFile is the content of the -users-path file.
+type userFile struct {
+	Users    []*User    `json:"users"`
+	Sessions []*Session `json:"sessions"`
+}
+
+// users holds the accounts behind /auth; nil unless -users-path is set.
+var users *userStore
+
+func openUserStore(path string, secret []byte, accessTTL, refreshTTL time.Duration) (*userStore, error) {
+	store := &userStore{
+		path:       path,
+		users:      map[string]*User{},
+		sessions:   map[string]*Session{},
+		secret:     secret,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		cost:       bcrypt.DefaultCost,
+		now:        time.Now,
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file userFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	for _, user := range file.Users {
+		store.users[user.ID] = user
+	}
+	for _, session := range file.Sessions {
+		store.sessions[session.ID] = session
+	}
+	return store, nil
+}
+
+// Register creates a user, failing with ErrEmailTaken when the email is in
+// use already.
+func (s *userStore) Register(email, password string) (UserView, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return UserView{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byEmail(email) != nil {
+		return UserView{}, ErrEmailTaken
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return UserView{}, err
+	}
+	user := &User{ID: id, Email: email, PasswordHash: string(hash), CreatedAt: s.now().UTC()}
+	s.users[id] = user
+	if err := s.save(); err != nil {
+		delete(s.users, id)
+		return UserView{}, err
+	}
+	return user.view(), nil
+}
+
+// Login checks the password and starts a session. Unknown emails take as
+// long to reject as wrong passwords, so logins don't tell which emails are
+// registered.
+func (s *userStore) Login(email, password string) (AuthTokens, bool, error) {
+	s.mu.Lock()
+	user := s.byEmail(email)
+	s.mu.Unlock()
+	hash := s.unknownUser()
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || user == nil {
+		return AuthTokens{}, false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := randomHex(8)
+	if err != nil {
+		return AuthTokens{}, false, err
+	}
+	now := s.now().UTC()
+	session := &Session{ID: id, UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(s.refreshTTL)}
+	tokens, err := s.issue(session, now)
+	if err != nil {
+		return AuthTokens{}, false, err
+	}
+	s.sessions[id] = session
+	if err := s.save(); err != nil {
+		delete(s.sessions, id)
+		return AuthTokens{}, false, err
+	}
+	return tokens, true, nil
+}
+
+// Refresh trades the refresh token of a session for a new access token and a
+// new refresh token; the old refresh token stops working. A refresh token
+// only ever works once, so when one the session was refreshed with before
+// comes back, it has been stolen by someone or from someone: Refresh then
+// ends the session, which locks out both, and returns ErrRefreshTokenReused.
+// Any other token, even one naming a live session, is just invalid; guessing
+// at a session's ID must not be a way to end it.
+func (s *userStore) Refresh(refreshToken string) (AuthTokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now().UTC()
+	session, ok := s.sessionOf(refreshToken)
+	if !ok || !session.active(now) {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+	hash := hashSecret(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(session.Hash), []byte(hash)) != 1 {
+		if !slices.Contains(session.RotatedHashes, hash) {
+			return AuthTokens{}, ErrInvalidRefreshToken
+		}
+		if err := s.end(session, now, SessionEndedByReuse); err != nil {
+			return AuthTokens{}, err
+		}
+		return AuthTokens{}, ErrRefreshTokenReused
+	}
+
+	oldHash, oldRefreshedAt, oldRotated := session.Hash, session.RefreshedAt, session.RotatedHashes
+	tokens, err := s.issue(session, now)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+	session.RefreshedAt = &now
+	session.RotatedHashes = append(slices.Clip(oldRotated), oldHash)
+	if err := s.save(); err != nil {
+		session.Hash, session.RefreshedAt, session.RotatedHashes = oldHash, oldRefreshedAt, oldRotated
+		return AuthTokens{}, err
+	}
+	return tokens, nil
+}
+
+// Logout ends the session; its refresh token and access tokens stop working.
+func (s *userStore) Logout(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionID]
+	if !ok || session.EndedAt != nil {
+		return NotFoundError
+	}
+	return s.end(session, s.now().UTC(), SessionEndedByLogout)
+}
+
+// LogoutRefreshToken ends the session of a current refresh token, for
+// clients whose access token has expired.
+func (s *userStore) LogoutRefreshToken(refreshToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessionOf(refreshToken)
+	if !ok || session.EndedAt != nil || subtle.ConstantTimeCompare([]byte(session.Hash), []byte(hashSecret(refreshToken))) != 1 {
+		return NotFoundError
+	}
+	return s.end(session, s.now().UTC(), SessionEndedByLogout)
+}
+
+// sessionOf returns the session a refresh token names, without checking its
+// secret.
+func (s *userStore) sessionOf(refreshToken string) (*Session, bool) {
+	rest, ok := strings.CutPrefix(refreshToken, refreshTokenPrefix)
+	if !ok {
+		return nil, false
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, false
+	}
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+func (s *userStore) end(session *Session, now time.Time, reason string) error {
+	session.EndedAt, session.EndReason = &now, reason
+	if err := s.save(); err != nil {
+		session.EndedAt, session.EndReason = nil, ""
+		return err
+	}
+	return nil
+}
+
+// Authenticate returns the user and session of a valid access token.
+func (s *userStore) Authenticate(accessToken string) (*User, string, bool) {
+	s.mu.Lock()
+	keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{s.secret}}
+	if s.previousSecret != nil {
+		keys.Keys = append(keys.Keys, s.previousSecret)
+	}
+	s.mu.Unlock()
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(accessToken, &claims, func(*jwt.Token) (interface{}, error) {
+		return keys, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithTimeFunc(s.now))
+	if err != nil {
+		return nil, "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[claims.Session]
+	if !ok || !session.active(s.now()) || session.UserID != claims.Subject {
+		return nil, "", false
+	}
+	user, ok := s.users[session.UserID]
+	if !ok {
+		return nil, "", false
+	}
+	copied := *user
+	return &copied, session.ID, true
+}
+
+// setSecret signs the access tokens from now on with secret.
+func (s *userStore) setSecret(secret []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previousSecret, s.secret = s.secret, secret
+}
+
+// prune forgets the sessions that ended or expired a day ago; it runs as a
+// job.
+func (s *userStore) prune(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.now().Add(-24 * time.Hour)
+	pruned := false
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(cutoff) || (session.EndedAt != nil && session.EndedAt.Before(cutoff)) {
+			delete(s.sessions, id)
+			pruned = true
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return s.save()
+}
+
+// issue gives session a new refresh token and returns it with a new access
+// token.
+func (s *userStore) issue(session *Session, now time.Time) (AuthTokens, error) {
+	random, err := randomHex(24)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+	refreshToken := refreshTokenPrefix + session.ID + "_" + random
+	accessToken, err := s.issueAccessToken(session, now)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+	session.Hash = hashSecret(refreshToken)
+	return AuthTokens{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.accessTTL / time.Second),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+func (s *userStore) issueAccessToken(session *Session, now time.Time) (string, error) {
+	claims := accessClaims{
+		Session: session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   session.UserID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+func (s *userStore) byEmail(email string) *User {
+	for _, user := range s.users {
+		if user.Email == email {
+			return user
+		}
+	}
+	return nil
+}
+
+// unknownUser returns the hash passwords are compared against when the
+// email is unknown.
+func (s *userStore) unknownUser() []byte {
+	s.unknownUserOnce.Do(func() {
+		s.unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("no such user"), s.cost)
+	})
+	return s.unknownUserHash
+}
+
+func (s *userStore) save() error {
+	file := userFile{Users: make([]*User, 0, len(s.users)), Sessions: make([]*Session, 0, len(s.sessions))}
+	for _, user := range s.users {
+		file.Users = append(file.Users, user)
+	}
+	for _, session := range s.sessions {
+		file.Sessions = append(file.Sessions, session)
+	}
+	sort.Slice(file.Users, func(i, j int) bool { return file.Users[i].CreatedAt.Before(file.Users[j].CreatedAt) })
+	sort.Slice(file.Sessions, func(i, j int) bool { return file.Sessions[i].CreatedAt.Before(file.Sessions[j].CreatedAt) })
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path, content)
+}
+
+type userContextKey struct{}
+
+// authenticatedUser is the user and session of an access token.
+type authenticatedUser struct {
+	user    *User
+	session string
+}
+
+// requestUser returns the user the request authenticated as, if any.
+func requestUser(r *http.Request) *User {
+	if authenticated, ok := r.Context().Value(userContextKey{}).(authenticatedUser); ok {
+		return authenticated.user
+	}
+	return nil
+}
+
+// accessTokenMiddleware authenticates requests whose "Authorization: Bearer"
+// header carries an access token rather than an API token, and rejects those
+// whose access token isn't valid.
+func accessTokenMiddleware(store *userStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			secret, ok := strings.CutPrefix(header, "Bearer ")
+			if header == "" || requestCaller(r) != nil || (ok && strings.HasPrefix(strings.TrimSpace(secret), apiTokenPrefix)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !ok {
+				ErrorCodeResponse(w, InvalidAccessTokenCode)
+				return
+			}
+			user, session, ok := store.Authenticate(strings.TrimSpace(secret))
+			if !ok {
+				ErrorCodeResponse(w, InvalidAccessTokenCode)
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey{}, authenticatedUser{user: user, session: session})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// credentials is the body of POST /auth/register and /auth/login.
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// normalizeEmail lowercases the email, so one address can't be registered
+// twice in different cases.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (c credentials) validate() []FieldError {
+	var errs []FieldError
+	if address, err := mail.ParseAddress(c.Email); err != nil || address.Address != c.Email {
+		errs = append(errs, newFieldError("email", InvalidEmailCode))
+	}
+	if utf8.RuneCountInString(c.Password) < minPasswordLength {
+		errs = append(errs, newFieldError("password", PasswordTooShortCode))
+	}
+	if len(c.Password) > maxPasswordBytes {
+		errs = append(errs, newFieldError("password", PasswordTooLongCode))
+	}
+	return errs
+}
+
+func register(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := decodeBody(r, &req); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+	if errs := req.validate(); len(errs) > 0 {
+		ValidationErrorResponse(w, errs)
+		return
+	}
+	user, err := users.Register(req.Email, req.Password)
+	if errors.Is(err, ErrEmailTaken) {
+		ErrorCodeResponse(w, EmailTakenCode)
+		return
+	}
+	if err != nil {
+		InternalErrorResponse(w, "could not register the user")
+		return
+	}
+	CreatedResponse(w, user)
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := decodeBody(r, &req); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	tokens, ok, err := users.Login(normalizeEmail(req.Email), req.Password)
+	if err != nil {
+		InternalErrorResponse(w, "could not log in")
+		return
+	}
+	if !ok {
+		ErrorCodeResponse(w, InvalidCredentialsCode)
+		return
+	}
+	SuccessResponse(w, tokens)
+}
+
+// refreshRequest is the body of POST /auth/refresh, and of /auth/logout
+// without an access token.
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := decodeBody(r, &req); err != nil {
+		ErrorCodeResponse(w, MalformedBodyCode)
+		return
+	}
+	tokens, err := users.Refresh(strings.TrimSpace(req.RefreshToken))
+	switch {
+	case errors.Is(err, ErrInvalidRefreshToken):
+		ErrorCodeResponse(w, InvalidRefreshTokenCode)
+	case errors.Is(err, ErrRefreshTokenReused):
+		log.Printf("refresh token reused, ended its session")
+		ErrorCodeResponse(w, RefreshTokenReusedCode)
+	case err != nil:
+		InternalErrorResponse(w, "could not refresh the session")
+	default:
+		SuccessResponse(w, tokens)
+	}
+}
+
+// logout ends the session of the access token the request carries or,
+// without one, of the refresh token in the body.
+func logout(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if authenticated, ok := r.Context().Value(userContextKey{}).(authenticatedUser); ok {
+		err = users.Logout(authenticated.session)
+	} else {
+		var req refreshRequest
+		if decodeBody(r, &req) != nil || req.RefreshToken == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			ErrorCodeResponse(w, InvalidAccessTokenCode)
+			return
+		}
+		err = users.LogoutRefreshToken(strings.TrimSpace(req.RefreshToken))
+	}
+	if err != nil && !errors.Is(err, NotFoundError) {
+		InternalErrorResponse(w, "could not log out")
+		return
+	}
+	NoContentResponse(w)
+}
+
+// registerAuthRoutes adds the account endpoints.
+func registerAuthRoutes(r *mux.Router) {
+	auth := r.PathPrefix("/auth").Subrouter()
+	auth.HandleFunc("/register", register).Methods(http.MethodPost)
+	auth.HandleFunc("/login", login).Methods(http.MethodPost)
+	auth.HandleFunc("/refresh", refresh).Methods(http.MethodPost)
+	auth.HandleFunc("/logout", logout).Methods(http.MethodPost)
+}
+
+// setupUsers opens the user store when -users-path is set. Without
+// -jwt-secret the access tokens are signed with a random key, so they don't
+// survive a restart.
+func setupUsers(cfg Config) error {
+	if cfg.UsersPath == "" {
+		return nil
+	}
+	scopes := strings.Split(cfg.UserScopes, ",")
+	for i, scope := range scopes {
+		scopes[i] = strings.TrimSpace(scope)
+		if scopes[i] != ScopeItemsRead && scopes[i] != ScopeItemsWrite {
+			return fmt.Errorf("user-scopes: users can't have the scope %q", scopes[i])
+		}
+	}
+	userScopes = scopes
+	secret := []byte(cfg.JWTSecret)
+	if len(secret) == 0 {
+		random, err := randomHex(32)
+		if err != nil {
+			return err
+		}
+		secret = []byte(random)
+		log.Print("no -jwt-secret set, access tokens are signed with a random key and stop working on restart")
+	}
+	store, err := openUserStore(cfg.UsersPath, secret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	if err != nil {
+		return err
+	}
+	users = store
+	jobs.Add(Job{Name: "session-prune", Every: time.Hour, Run: store.prune})
+	return nil
+}
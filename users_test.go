@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_userLifecycle(t *testing.T) {
+	defer func(original *userStore) { users = original }(users)
+	path := filepath.Join(t.TempDir(), "users.json")
+	store, err := openUserStore(path, []byte("test secret"), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.cost = bcrypt.MinCost
+	users = store
+	cfg := Config{RouteTimeout: time.Second, RouteTimeouts: RouteTimeouts{}, RequireAPIToken: true}
+	router := newRouter(cfg)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/auth/register", "", `{"email":"not an email","password":"short"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for invalid credentials, got %d", w.Code)
+	}
+	if w := do("POST", "/auth/register", "", `{"email":"Ada@Example.com","password":"correct horse"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 on register, got %d: %s", w.Code, w.Body)
+	}
+	if w := do("POST", "/auth/register", "", `{"email":"ada@example.com","password":"another one"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a taken email, got %d", w.Code)
+	}
+	if w := do("POST", "/auth/login", "", `{"email":"ada@example.com","password":"wrong password"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong password, got %d", w.Code)
+	}
+	if w := do("POST", "/auth/login", "", `{"email":"bob@example.com","password":"correct horse"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an unknown email, got %d", w.Code)
+	}
+
+	w := do("POST", "/auth/login", "", `{"email":"ada@example.com","password":"correct horse"}`)
+	var tokens AuthTokens
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.AccessToken == "" || !strings.HasPrefix(tokens.RefreshToken, refreshTokenPrefix) || tokens.ExpiresIn != 60 {
+		t.Fatalf("unexpected login response %d: %s", w.Code, w.Body)
+	}
+
+	if w := do("GET", "/items/", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	if w := do("GET", "/items/", tokens.AccessToken, ""); w.Code != http.StatusOK {
+		t.Errorf("expected the user to list items, got %d", w.Code)
+	}
+	if w := do("POST", "/items/", tokens.AccessToken, `{"name":"x"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected a registered user not to create items by default, got %d", w.Code)
+	}
+	isolate(t, &userScopes, []string{ScopeItemsRead, ScopeItemsWrite})
+	isolate(t, &itemRepository, ItemRepository(NewInMemoryItemRepository()))
+	if w := do("POST", "/items/", tokens.AccessToken, `{"name":"x"}`); w.Code != http.StatusCreated {
+		t.Errorf("expected -user-scopes to let the user create items, got %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/items/", "not.a.jwt", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a malformed access token, got %d", w.Code)
+	}
+
+	// the session survives a restart
+	reopened, err := openUserStore(path, []byte("test secret"), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _, ok := reopened.Authenticate(tokens.AccessToken); !ok || user.Email != "ada@example.com" {
+		t.Errorf("the access token does not work after reopening: %+v", user)
+	}
+
+	if w := do("POST", "/auth/logout", tokens.AccessToken, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 on logout, got %d", w.Code)
+	}
+	if w := do("GET", "/items/", tokens.AccessToken, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the access token to stop working after logout, got %d", w.Code)
+	}
+}
+
+func Test_accessTokenExpires(t *testing.T) {
+	store, err := openUserStore(filepath.Join(t.TempDir(), "users.json"), []byte("test secret"), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.cost = bcrypt.MinCost
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.Register("ada@example.com", "correct horse")
+	tokens, ok, err := store.Login("ada@example.com", "correct horse")
+	if !ok || err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	now = now.Add(59 * time.Second)
+	if _, _, ok := store.Authenticate(tokens.AccessToken); !ok {
+		t.Error("expected the access token to be valid before it expires")
+	}
+	now = now.Add(2 * time.Second)
+	if _, _, ok := store.Authenticate(tokens.AccessToken); ok {
+		t.Error("expected the access token to be rejected once expired")
+	}
+
+	other, _ := openUserStore(filepath.Join(t.TempDir(), "users.json"), []byte("other secret"), time.Minute, time.Hour)
+	other.sessions, other.users, other.now = store.sessions, store.users, store.now
+	now = now.Add(-time.Minute)
+	if _, _, ok := other.Authenticate(tokens.AccessToken); ok {
+		t.Error("expected an access token signed with another key to be rejected")
+	}
+}
+
+func Test_refreshTokenRotation(t *testing.T) {
+	store, err := openUserStore(filepath.Join(t.TempDir(), "users.json"), []byte("test secret"), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.cost = bcrypt.MinCost
+	store.Register("ada@example.com", "correct horse")
+	first, _, _ := store.Login("ada@example.com", "correct horse")
+
+	second, err := store.Refresh(first.RefreshToken)
+	if err != nil || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected a new refresh token, got %+v, %v", second, err)
+	}
+	if _, _, ok := store.Authenticate(second.AccessToken); !ok {
+		t.Error("expected the refreshed access token to work")
+	}
+
+	// a made-up token naming the session is invalid, and leaves it alone
+	sessionID := strings.Split(first.RefreshToken, "_")[1]
+	if _, err := store.Refresh(refreshTokenPrefix + sessionID + "_guessed"); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken for a token the session never had, got %v", err)
+	}
+	if _, _, ok := store.Authenticate(second.AccessToken); !ok {
+		t.Error("expected the session to survive a made-up token")
+	}
+
+	// replaying the first token ends the session for everyone
+	if _, err := store.Refresh(first.RefreshToken); err != ErrRefreshTokenReused {
+		t.Errorf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := store.Refresh(second.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("expected the current token to stop working after reuse, got %v", err)
+	}
+	if _, _, ok := store.Authenticate(second.AccessToken); ok {
+		t.Error("expected the access token to stop working after reuse")
+	}
+	for _, session := range store.sessions {
+		if session.EndReason != SessionEndedByReuse {
+			t.Errorf("expected the session to be ended for reuse, got %q", session.EndReason)
+		}
+	}
+
+	if _, err := store.Refresh("spr_unknown_token"); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken for an unknown token, got %v", err)
+	}
+
+	third, _, _ := store.Login("ada@example.com", "correct horse")
+	if err := store.LogoutRefreshToken(third.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Refresh(third.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("expected the refresh token to stop working after logout, got %v", err)
+	}
+}
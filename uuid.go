@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The values of -id-format.
+const (
+	IDFormatInt    = "int"
+	IDFormatUUIDv7 = "uuidv7"
+)
+
+// uuidIDs is set by -id-format uuidv7; the item routes then take UUIDs.
+var uuidIDs bool
+
+// newUUIDv7 returns a random UUID version 7 (RFC 9562), which starts with the
+// Unix time in milliseconds, so UUIDs made later sort after earlier ones.
+func newUUIDv7(now time.Time) string {
+	var b [16]byte
+	rand.Read(b[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// isUUID reports whether s is a UUID in its usual text form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'):
+			return false
+		}
+	}
+	return true
+}
+
+// uuidRepository gives every item it creates a UUIDv7, for -id-format
+// uuidv7. The integer IDs stay the key every backend stores the items under;
+// the UUID is the identifier clients see and use in paths, so they no longer
+// depend on the small, guessable numbers. Items created before the switch get
+// their UUID on their next update.
+type uuidRepository struct {
+	ItemRepository
+}
+
+func (repo *uuidRepository) Create(ctx context.Context, item Item) (*Item, error) {
+	item.UUID = newUUIDv7(itemClock())
+	return repo.ItemRepository.Create(ctx, item)
+}
+
+func (repo *uuidRepository) Insert(ctx context.Context, item Item) (*Item, error) {
+	item.UUID = newUUIDv7(itemClock())
+	return repo.ItemRepository.Insert(ctx, item)
+}
+
+func (repo *uuidRepository) Update(ctx context.Context, item Item) error {
+	if item.UUID == "" {
+		item.UUID = newUUIDv7(itemClock())
+	}
+	return repo.ItemRepository.Update(ctx, item)
+}
+
+func (repo *uuidRepository) Tx(ctx context.Context, fn func(tx ItemRepository) error) error {
+	return repo.ItemRepository.Tx(ctx, func(tx ItemRepository) error {
+		return fn(&uuidRepository{ItemRepository: tx})
+	})
+}
+
+// IndexStats reports the indexes of the wrapped repository.
+func (repo *uuidRepository) IndexStats() []IndexStats {
+	if reporter, ok := repo.ItemRepository.(indexStatsReporter); ok {
+		return reporter.IndexStats()
+	}
+	return nil
+}
+
+// uuidRouteMiddleware lets the item routes take UUIDs wherever they take an
+// ID, by swapping the UUID in the path for the item's integer ID before the
+// handler sees it. The backends index the UUIDs, so this is a lookup and not a
+// scan. Unknown UUIDs get a 404, and integer IDs a 400, as they are exactly
+// the guessable numbers the UUIDs are there to hide.
+func uuidRouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		for _, name := range []string{"id", "other"} {
+			if _, ok := vars[name]; !ok {
+				continue
+			}
+			if !isUUID(vars[name]) {
+				ErrorCodeResponse(w, UUIDRequiredCode)
+				return
+			}
+			items, err := itemRepository.List(r.Context(), ItemFilter{UUID: vars[name]})
+			if err != nil {
+				RepositoryErrorResponse(w, err, "could not look up the item")
+				return
+			}
+			if len(items) == 0 {
+				NotFoundResponse(w, "item with UUID does not exist")
+				return
+			}
+			vars[name] = strconv.Itoa(items[0].ID)
+		}
+		next.ServeHTTP(w, mux.SetURLVars(r, vars))
+	})
+}
+
+// setupUUIDs switches to UUIDv7 identifiers for -id-format uuidv7.
+func setupUUIDs(cfg Config) error {
+	switch cfg.IDFormat {
+	case "", IDFormatInt:
+		return nil
+	case IDFormatUUIDv7:
+		itemRepository = &uuidRepository{ItemRepository: itemRepository}
+		uuidIDs = true
+		if cfg.ReadOnly {
+			return nil
+		}
+		return indexUUIDs(context.Background(), itemRepository)
+	default:
+		return fmt.Errorf("unknown ID format %q", cfg.IDFormat)
+	}
+}
+
+// indexUUIDs makes sure the item routes can reach every item once they only
+// take UUIDs: items from before the switch get their UUID, and items the
+// backend's UUID index doesn't know yet, as it is younger than they are, are
+// written again to enter it.
+func indexUUIDs(ctx context.Context, repo ItemRepository) error {
+	items, err := repo.List(ctx, ItemFilter{})
+	if err != nil {
+		return fmt.Errorf("could not index the UUIDs: %w", err)
+	}
+	for _, item := range items {
+		if item.UUID != "" {
+			if found, err := repo.List(ctx, ItemFilter{UUID: item.UUID}); err != nil {
+				return fmt.Errorf("could not index the UUIDs: %w", err)
+			} else if len(found) > 0 {
+				continue
+			}
+		}
+		if err := repo.Update(ctx, item); err != nil {
+			return fmt.Errorf("could not give item %d a UUID: %w", item.ID, err)
+		}
+	}
+	return nil
+}
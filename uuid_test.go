@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_newUUIDv7(t *testing.T) {
+	at := time.UnixMilli(0x0123456789ab)
+	first, second := newUUIDv7(at), newUUIDv7(at.Add(time.Millisecond))
+	if !isUUID(first) || first[:13] != "01234567-89ab" || first[14] != '7' || !bytes.ContainsRune([]byte("89ab"), rune(first[19])) {
+		t.Errorf("expected a version 7 UUID starting with the time, got %s", first)
+	}
+	if first >= second {
+		t.Errorf("expected later UUIDs to sort after earlier ones, got %s and %s", first, second)
+	}
+	for _, s := range []string{"", "1", "01234567-89ab-7cde-8f01-23456789abcg", "0123456789ab-7cde-8f01-23456789abcdef"} {
+		if isUUID(s) {
+			t.Errorf("expected %q not to be a UUID", s)
+		}
+	}
+}
+
+func Test_uuidRoutes(t *testing.T) {
+	api := newTestAPI(t, Item{ID: 0, Name: "legacy"})
+	if err := setupUUIDs(Config{IDFormat: IDFormatUUIDv7}); err != nil {
+		t.Fatal(err)
+	}
+	api.Reroute()
+
+	created := decodeResponse[Item](t, api.Request("POST", "/items/", `{"name":"lamp","uuid":"ignored"}`), http.StatusCreated)
+	if !isUUID(created.UUID) {
+		t.Fatalf("expected the new item to get a UUID, got %+v", created)
+	}
+	if item := decodeResponse[Item](t, api.Request("GET", "/items/"+created.UUID, nil), http.StatusOK); item.ID != created.ID {
+		t.Errorf("expected the item by its UUID, got %+v", item)
+	}
+	if item := decodeResponse[Item](t, api.Request("PUT", "/items/"+created.UUID, `{"name":"desk lamp"}`), http.StatusOK); item.UUID != created.UUID {
+		t.Errorf("expected the update to keep the UUID, got %+v", item)
+	}
+	if w := api.Request("GET", "/items/01234567-89ab-7cde-8f01-23456789abcd", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown UUID, got %d", w.Code)
+	}
+
+	if w := api.Request("GET", "/items/"+strconv.Itoa(created.ID), nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for the integer ID, got %d", w.Code)
+	}
+	legacy, err := itemRepository.Get(t.Context(), 0)
+	if err != nil || !isUUID(legacy.UUID) {
+		t.Fatalf("expected an item from before the switch to get a UUID, got %+v %v", legacy, err)
+	}
+	if item := decodeResponse[Item](t, api.Request("PUT", "/items/"+legacy.UUID, `{"name":"legacy"}`), http.StatusOK); item.UUID != legacy.UUID {
+		t.Errorf("expected the item from before the switch by its UUID, got %+v", item)
+	}
+
+	if err := setupUUIDs(Config{IDFormat: "ulid"}); err == nil {
+		t.Error("expected an unknown ID format to be refused")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxItemNameLength        = 100
+	maxItemDescriptionLength = 1000
+	maxExternalIDLength      = 100
+	maxDuplicateCount        = 100
+	maxItemQuantity          = 1000000
+	// maxPriceDigits caps the digits before the decimal point of a price.
+	maxPriceDigits = 9
+	// maxLimit caps the limit parameter of the endpoints returning a page.
+	maxLimit = 100
+)
+
+// ErrorCode is a stable, machine readable identifier for a failure that
+// clients can map to their own messages. Codes are never renamed once shipped.
+type ErrorCode struct {
+	Code    string `json:"code"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// errorCatalog lists every ErrorCode in the order they were registered. It is
+// served on /errors, so the documentation can't drift from the code.
+var errorCatalog []ErrorCode
+
+func newErrorCode(code string, status int, message string) ErrorCode {
+	errorCode := ErrorCode{Code: code, Status: status, Message: message}
+	errorCatalog = append(errorCatalog, errorCode)
+	return errorCode
+}
+
+var (
+	InvalidIDCode                = newErrorCode("INVALID_ID", http.StatusBadRequest, "the ID in the path is not a number or too large")
+	UUIDRequiredCode             = newErrorCode("UUID_REQUIRED", http.StatusBadRequest, "the item routes take the item's UUID, not its number")
+	InvalidLanguageCode          = newErrorCode("INVALID_LANGUAGE", http.StatusBadRequest, "the language in the path is not a BCP 47 language tag like de or pt-BR")
+	InvalidRevisionCode          = newErrorCode("INVALID_REVISION", http.StatusBadRequest, "revision must be the sequence number of one of the item's events")
+	MalformedBodyCode            = newErrorCode("MALFORMED_BODY", http.StatusBadRequest, "the request body is not valid JSON for this endpoint")
+	InvalidDuplicateCountCode    = newErrorCode("INVALID_DUPLICATE_COUNT", http.StatusBadRequest, fmt.Sprintf("count must be a number from 1 to %d", maxDuplicateCount))
+	InvalidFilterCode            = newErrorCode("INVALID_FILTER", http.StatusBadRequest, "filters are ?currency= with an ISO 4217 code, ?state= with active, archived or all, or ?price[op]= and ?quantity[op]= with a number and op one of lt, lte, gt, gte and eq")
+	InvalidSortCode              = newErrorCode("INVALID_SORT", http.StatusBadRequest, "sort must be id, position or -rating")
+	InvalidSpreadsheetCode       = newErrorCode("INVALID_SPREADSHEET", http.StatusBadRequest, "the body must be an xlsx workbook of at most 10 MB whose first sheet starts with a header row")
+	InvalidColumnMappingCode     = newErrorCode("INVALID_COLUMN_MAPPING", http.StatusBadRequest, "column[field]= must name a column of the header row for one of name, description, quantity, price and currency, and some column must fill name, with match=id also id")
+	InvalidDuplicateHandlingCode = newErrorCode("INVALID_DUPLICATE_HANDLING", http.StatusBadRequest, "on_duplicate must be skip, overwrite, merge or fail, and match name or, with on_duplicate, id")
+	InvalidDateRangeCode         = newErrorCode("INVALID_DATE_RANGE", http.StatusBadRequest, "from and to must be dates like 2024-05-01, from not after to")
+	InvalidAuditActionCode       = newErrorCode("INVALID_AUDIT_ACTION", http.StatusBadRequest, "action must be create, update or delete")
+	InvalidItemIDCode            = newErrorCode("INVALID_ITEM_ID", http.StatusBadRequest, "item_id must be a number")
+	InvalidTimeRangeCode         = newErrorCode("INVALID_TIME_RANGE", http.StatusBadRequest, "from and to must be times like 2024-05-01T12:00:00Z, from before to")
+	InvalidSinceCode             = newErrorCode("INVALID_SINCE", http.StatusBadRequest, "since must be the sequence number of an event, 0 or more")
+	InvalidWaitCode              = newErrorCode("INVALID_WAIT", http.StatusBadRequest, "wait must be a duration like 30s, at most 1m")
+	InvalidPreconditionCode      = newErrorCode("INVALID_PRECONDITION", http.StatusBadRequest, "If-None-Match only supports *, items have no ETags")
+	InvalidJSONPatchCode         = newErrorCode("INVALID_JSON_PATCH", http.StatusBadRequest, "the body must be a JSON Patch array of add, remove, replace and test operations, each with a path like /name and, but for remove, a value")
+	MaintenanceModeCode          = newErrorCode("MAINTENANCE_MODE", http.StatusServiceUnavailable, "the API is in maintenance and takes no writes, try again later")
+	ReadOnlyCode                 = newErrorCode("READ_ONLY", http.StatusMethodNotAllowed, "this instance is a read-only replica, send writes to the primary")
+	InjectedFaultCode            = newErrorCode("INJECTED_FAULT", http.StatusInternalServerError, "the server failed this request on purpose, as -chaos-error-percent asks")
+	FixtureNotFoundCode          = newErrorCode("FIXTURE_NOT_FOUND", http.StatusNotFound, "the server replays fixtures and has none recorded for this request")
+	FixtureBodyTooLargeCode      = newErrorCode("FIXTURE_BODY_TOO_LARGE", http.StatusRequestEntityTooLarge, fmt.Sprintf("fixtures are recorded and replayed for request bodies of at most %d MB", maxFixtureBodyBytes>>20))
+	ExportNotFoundCode           = newErrorCode("EXPORT_NOT_FOUND", http.StatusNotFound, "the export does not exist or has expired, export the items again")
+	ExportsFullCode              = newErrorCode("EXPORTS_FULL", http.StatusServiceUnavailable, "too many exports are kept, try again once older ones have expired")
+	SnapshotNotFoundCode         = newErrorCode("SNAPSHOT_NOT_FOUND", http.StatusNotFound, "the snapshot does not exist or has expired, list the items again with snapshot=true")
+	SnapshotsFullCode            = newErrorCode("SNAPSHOTS_FULL", http.StatusServiceUnavailable, "too many paging snapshots are kept, try again once older ones have expired or list without snapshot=true")
+	JobNotFoundCode              = newErrorCode("JOB_NOT_FOUND", http.StatusNotFound, "the job does not exist or finished too long ago to be kept")
+	InvalidGroupByCode           = newErrorCode("INVALID_GROUP_BY", http.StatusBadRequest, "group_by must be currency or state")
+	SearchQueryRequiredCode      = newErrorCode("SEARCH_QUERY_REQUIRED", http.StatusBadRequest, "the q parameter must not be empty")
+	InvalidSearchModeCode        = newErrorCode("INVALID_SEARCH_MODE", http.StatusBadRequest, "mode must be match, prefix or fuzzy")
+	PrefixRequiredCode           = newErrorCode("PREFIX_REQUIRED", http.StatusBadRequest, "the prefix parameter must not be empty")
+	InvalidLimitCode             = newErrorCode("INVALID_LIMIT", http.StatusBadRequest, fmt.Sprintf("limit must be a number from 1 to %d", maxLimit))
+	InvalidOffsetCode            = newErrorCode("INVALID_OFFSET", http.StatusBadRequest, "offset must be a number of 0 or more")
+	UnsupportedMediaTypeCode     = newErrorCode("UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType, "request bodies must be sent as Content-Type: application/json")
+	NotAcceptableCode            = newErrorCode("NOT_ACCEPTABLE", http.StatusNotAcceptable, "responses are only available as application/json")
+	RateLimitedCode              = newErrorCode("RATE_LIMITED", http.StatusTooManyRequests, "too many requests, retry after the seconds in Retry-After")
+	QuotaExceededCode            = newErrorCode("QUOTA_EXCEEDED", http.StatusTooManyRequests, "the daily request quota is used up, it resets at midnight UTC")
+	InvalidAPITokenCode          = newErrorCode("INVALID_API_TOKEN", http.StatusUnauthorized, "the API token is unknown, revoked or expired")
+	APITokenRequiredCode         = newErrorCode("API_TOKEN_REQUIRED", http.StatusUnauthorized, "an API token or access token is required in Authorization: Bearer")
+	MissingScopeCode             = newErrorCode("MISSING_SCOPE", http.StatusForbidden, "the API token or user lacks the scope this endpoint requires")
+	InvalidAccessTokenCode       = newErrorCode("INVALID_ACCESS_TOKEN", http.StatusUnauthorized, "the access token is malformed or expired, or its session has ended")
+	InvalidRefreshTokenCode      = newErrorCode("INVALID_REFRESH_TOKEN", http.StatusUnauthorized, "the refresh token is unknown or expired, or its session has ended")
+	RefreshTokenReusedCode       = newErrorCode("REFRESH_TOKEN_REUSED", http.StatusUnauthorized, "the refresh token was used already, so its session has been ended; log in again")
+	InvalidCredentialsCode       = newErrorCode("INVALID_CREDENTIALS", http.StatusUnauthorized, "the email or password is wrong")
+	UserRequiredCode             = newErrorCode("USER_REQUIRED", http.StatusUnauthorized, "this endpoint is for users, log in and send the access token in Authorization: Bearer")
+	EmailTakenCode               = newErrorCode("EMAIL_TAKEN", http.StatusConflict, "a user with this email is registered already")
+	ItemIDTakenCode              = newErrorCode("ITEM_ID_TAKEN", http.StatusConflict, "an item with this ID exists already")
+	ItemExistsCode               = newErrorCode("ITEM_EXISTS", http.StatusPreconditionFailed, "If-None-Match: * only creates the item, and it exists already")
+	ExternalIDTakenCode          = newErrorCode("EXTERNAL_ID_TAKEN", http.StatusConflict, "another item has this external_id already, see conflicting_id")
+	JSONPatchTestFailedCode      = newErrorCode("JSON_PATCH_TEST_FAILED", http.StatusConflict, "a test operation of the patch failed, so the item was left unchanged")
+	DuplicateRowCode             = newErrorCode("DUPLICATE_ROW", http.StatusConflict, "a row matches a stored item and on_duplicate=fail, so nothing was imported; see conflicting_id")
+	ItemNameTakenCode            = newErrorCode("ITEM_NAME_TAKEN", http.StatusConflict, "another item has this name already, see conflicting_id")
+	APITokenNameRequiredCode     = newErrorCode("API_TOKEN_NAME_REQUIRED", http.StatusUnprocessableEntity, "name must not be empty")
+	UnknownScopeCode             = newErrorCode("UNKNOWN_SCOPE", http.StatusUnprocessableEntity, "scopes must be one or more of items:read, items:write and admin")
+	InvalidExpiryCode            = newErrorCode("INVALID_EXPIRY", http.StatusUnprocessableEntity, "the expiry must lie in the future, given as either expires_at or a duration in expires_in")
+	InvalidEmailCode             = newErrorCode("INVALID_EMAIL", http.StatusUnprocessableEntity, "email must be an email address like name@example.com")
+	PasswordTooShortCode         = newErrorCode("PASSWORD_TOO_SHORT", http.StatusUnprocessableEntity, fmt.Sprintf("password must be at least %d characters", minPasswordLength))
+	PasswordTooLongCode          = newErrorCode("PASSWORD_TOO_LONG", http.StatusUnprocessableEntity, fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes))
+	FieldRequiredCode            = newErrorCode("FIELD_REQUIRED", http.StatusUnprocessableEntity, "the field must not be empty under this deployment's validation rules")
+	FieldPatternMismatchCode     = newErrorCode("FIELD_PATTERN_MISMATCH", http.StatusUnprocessableEntity, "the field must match the pattern this deployment's validation rules set")
+	FieldTooShortCode            = newErrorCode("FIELD_TOO_SHORT", http.StatusUnprocessableEntity, "the field is shorter than this deployment's validation rules allow")
+	FieldTooLongCode             = newErrorCode("FIELD_TOO_LONG", http.StatusUnprocessableEntity, "the field is longer than this deployment's validation rules allow")
+	ValidationFailedCode         = newErrorCode("VALIDATION_FAILED", http.StatusUnprocessableEntity, "one or more fields are invalid, see errors")
+	ItemNameRequiredCode         = newErrorCode("ITEM_NAME_REQUIRED", http.StatusUnprocessableEntity, "name must not be empty")
+	ItemNameTooLongCode          = newErrorCode("ITEM_NAME_TOO_LONG", http.StatusUnprocessableEntity, fmt.Sprintf("name must be at most %d characters", maxItemNameLength))
+	ItemDescriptionTooLongCode   = newErrorCode("ITEM_DESCRIPTION_TOO_LONG", http.StatusUnprocessableEntity, fmt.Sprintf("description must be at most %d characters", maxItemDescriptionLength))
+	InvalidQuantityCode          = newErrorCode("INVALID_QUANTITY", http.StatusUnprocessableEntity, fmt.Sprintf("quantity must be a whole number from 0 to %d", maxItemQuantity))
+	InvalidPriceCode             = newErrorCode("INVALID_PRICE", http.StatusUnprocessableEntity, fmt.Sprintf("price must be a decimal string like \"9.99\", at least 0, with at most %d digits before the point and no more decimals than its currency has", maxPriceDigits))
+	InvalidCurrencyCode          = newErrorCode("INVALID_CURRENCY", http.StatusUnprocessableEntity, "currency must be an ISO 4217 code like EUR or USD")
+	PriceWithoutCurrencyCode     = newErrorCode("PRICE_WITHOUT_CURRENCY", http.StatusUnprocessableEntity, "price and currency must be given together")
+	InvalidScheduleCode          = newErrorCode("INVALID_SCHEDULE", http.StatusUnprocessableEntity, "expires_at must lie after publish_at")
+	InvalidClientIDCode          = newErrorCode("INVALID_CLIENT_ID", http.StatusUnprocessableEntity, "id must be a number from 0 to 9007199254740991")
+	InvalidExternalIDCode        = newErrorCode("INVALID_EXTERNAL_ID", http.StatusUnprocessableEntity, fmt.Sprintf("external_id must be at most %d characters and contain no /", maxExternalIDLength))
+	JSONPatchUnprocessableCode   = newErrorCode("JSON_PATCH_UNPROCESSABLE", http.StatusUnprocessableEntity, "the patch can't be applied to the item: a path leads nowhere or a value has the wrong type")
+	InvalidTTLCode               = newErrorCode("INVALID_TTL", http.StatusUnprocessableEntity, "ttl must be a positive duration like 30m or 24h")
+	InvalidMoveCode              = newErrorCode("INVALID_MOVE", http.StatusUnprocessableEntity, "give one of before or after with the ID of another item, or index with a place in the list")
+	InvalidMergeCode             = newErrorCode("INVALID_MERGE", http.StatusUnprocessableEntity, "source must be the ID of another item, and strategy keep or replace")
+	InvalidRatingCode            = newErrorCode("INVALID_RATING", http.StatusUnprocessableEntity, "score must be a whole number from 1 to 5")
+)
+
+// FieldError points at the field that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newFieldError(field string, code ErrorCode) FieldError {
+	return FieldError{Field: field, Code: code.Code, Message: code.Message}
+}
+
+// Problem is an RFC 7807 problem details document extended with the error
+// code and, for validation failures, the individual field errors.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Code   string       `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+	// ConflictingID is the item a conflict is with, like the one that has
+	// the name already for ITEM_NAME_TAKEN.
+	ConflictingID *int `json:"conflicting_id,omitempty"`
+}
+
+func newProblem(code ErrorCode) Problem {
+	return Problem{
+		Type:   "/errors#" + code.Code,
+		Title:  code.Message,
+		Status: code.Status,
+		Code:   code.Code,
+	}
+}
+
+// decimalPattern matches the numbers accepted in prices and filters.
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// validateItem applies the built-in validation and the -validation-rules.
+func validateItem(item Item) []FieldError {
+	errs := validateItemFields(item)
+	return append(errs, checkValidationRules(item, "name", "description", "quantity", "price", "currency")...)
+}
+
+func validateItemFields(item Item) []FieldError {
+	var errs []FieldError
+	if item.Name == "" {
+		errs = append(errs, newFieldError("name", ItemNameRequiredCode))
+	}
+	if utf8.RuneCountInString(item.Name) > maxItemNameLength {
+		errs = append(errs, newFieldError("name", ItemNameTooLongCode))
+	}
+	if utf8.RuneCountInString(item.Description) > maxItemDescriptionLength {
+		errs = append(errs, newFieldError("description", ItemDescriptionTooLongCode))
+	}
+	if item.Quantity < 0 || item.Quantity > maxItemQuantity {
+		errs = append(errs, newFieldError("quantity", InvalidQuantityCode))
+	}
+	if utf8.RuneCountInString(item.ExternalID) > maxExternalIDLength || strings.Contains(item.ExternalID, "/") {
+		errs = append(errs, newFieldError("external_id", InvalidExternalIDCode))
+	}
+	if item.PublishAt != nil && item.ExpiresAt != nil && !item.ExpiresAt.After(*item.PublishAt) {
+		errs = append(errs, newFieldError("expires_at", InvalidScheduleCode))
+	}
+	return append(errs, validatePrice(item.Price, item.Currency)...)
+}
+
+func validatePrice(price, currency string) []FieldError {
+	if price == "" && currency == "" {
+		return nil
+	}
+	if price == "" {
+		return []FieldError{newFieldError("price", PriceWithoutCurrencyCode)}
+	}
+	if currency == "" {
+		return []FieldError{newFieldError("currency", PriceWithoutCurrencyCode)}
+	}
+	decimals, known := currencyDecimals[currency]
+	if !known {
+		return []FieldError{newFieldError("currency", InvalidCurrencyCode)}
+	}
+	whole, fraction, _ := strings.Cut(price, ".")
+	if !decimalPattern.MatchString(price) || strings.HasPrefix(price, "-") || len(whole) > maxPriceDigits || len(fraction) > decimals ||
+		(len(whole) > 1 && whole[0] == '0') {
+		return []FieldError{newFieldError("price", InvalidPriceCode)}
+	}
+	return nil
+}
+
+func listErrorCodes(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, errorCatalog)
+}
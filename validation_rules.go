@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// FieldRule constrains one item field beyond the built-in validation.
+// Pattern and the lengths only apply to the text fields, name and
+// description.
+type FieldRule struct {
+	Required  bool   `json:"required"`
+	Pattern   string `json:"pattern"`
+	MinLength int    `json:"min_length"`
+	MaxLength int    `json:"max_length"`
+
+	pattern *regexp.Regexp
+}
+
+// ValidationRules are the rules a deployment adds in the -validation-rules
+// file, by field name, e.g. {"name": {"pattern": "^[A-Z]"}, "price":
+// {"required": true}}. They can only make validation stricter.
+type ValidationRules map[string]*FieldRule
+
+// ruleFields are the fields rules can apply to, and whether they are text.
+var ruleFields = map[string]bool{"name": true, "description": true, "quantity": false, "price": false, "currency": false}
+
+// validationRules are the rules in effect; a reload replaces them.
+var validationRules atomic.Pointer[ValidationRules]
+
+// loadValidationRules reads and checks the rules in the file at path.
+func loadValidationRules(path string) (ValidationRules, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var rules ValidationRules
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for field, rule := range rules {
+		text, known := ruleFields[field]
+		if !known {
+			return nil, fmt.Errorf("%s: rules can't apply to %q, only to name, description, quantity, price and currency", path, field)
+		}
+		if !text && (rule.Pattern != "" || rule.MinLength != 0 || rule.MaxLength != 0) {
+			return nil, fmt.Errorf("%s: %s: pattern, min_length and max_length only apply to name and description", path, field)
+		}
+		if rule.MinLength < 0 || rule.MaxLength < 0 || (rule.MaxLength > 0 && rule.MinLength > rule.MaxLength) {
+			return nil, fmt.Errorf("%s: %s: min_length and max_length must not be negative, and min_length not above max_length", path, field)
+		}
+		if rule.Pattern != "" {
+			if rule.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, field, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// check applies the rules for fields to the item.
+func (rules ValidationRules) check(item Item, fields ...string) []FieldError {
+	values := map[string]string{
+		"name":        item.Name,
+		"description": item.Description,
+		"price":       item.Price,
+		"currency":    item.Currency,
+	}
+	if item.Quantity != 0 {
+		values["quantity"] = strconv.Itoa(item.Quantity)
+	}
+
+	var errs []FieldError
+	for _, field := range fields {
+		rule, ok := rules[field]
+		if !ok {
+			continue
+		}
+		value := values[field]
+		length := utf8.RuneCountInString(value)
+		switch {
+		case value == "":
+			if rule.Required {
+				errs = append(errs, FieldError{Field: field, Code: FieldRequiredCode.Code, Message: field + " must not be empty"})
+			}
+		case rule.pattern != nil && !rule.pattern.MatchString(value):
+			errs = append(errs, FieldError{Field: field, Code: FieldPatternMismatchCode.Code, Message: fmt.Sprintf("%s must match %s", field, rule.Pattern)})
+		case length < rule.MinLength:
+			errs = append(errs, FieldError{Field: field, Code: FieldTooShortCode.Code, Message: fmt.Sprintf("%s must be at least %d characters", field, rule.MinLength)})
+		case rule.MaxLength > 0 && length > rule.MaxLength:
+			errs = append(errs, FieldError{Field: field, Code: FieldTooLongCode.Code, Message: fmt.Sprintf("%s must be at most %d characters", field, rule.MaxLength)})
+		}
+	}
+	return errs
+}
+
+// checkValidationRules applies the rules in effect for fields to the item.
+func checkValidationRules(item Item, fields ...string) []FieldError {
+	rules := validationRules.Load()
+	if rules == nil {
+		return nil
+	}
+	return rules.check(item, fields...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeValidationRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_loadValidationRules(t *testing.T) {
+	for _, content := range []string{
+		`{"tags": {"required": true}}`,
+		`{"price": {"pattern": "^9"}}`,
+		`{"name": {"min_length": 5, "max_length": 3}}`,
+		`{"name": {"pattern": "("}}`,
+		`{"name": {"maximum": 3}}`,
+	} {
+		if _, err := loadValidationRules(writeValidationRules(t, content)); err == nil {
+			t.Errorf("expected %s to be refused", content)
+		}
+	}
+}
+
+func Test_validationRules(t *testing.T) {
+	defer validationRules.Store(validationRules.Load())
+	rules, err := loadValidationRules(writeValidationRules(t, `{
+		"name": {"pattern": "^[A-Z]", "min_length": 3, "max_length": 10},
+		"description": {"required": true},
+		"price": {"required": true}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validationRules.Store(&rules)
+
+	codes := func(errs []FieldError) map[string]string {
+		result := map[string]string{}
+		for _, err := range errs {
+			result[err.Field] = err.Code
+		}
+		return result
+	}
+	cases := []struct {
+		item Item
+		want map[string]string
+	}{
+		{Item{Name: "Scarf", Description: "wool", Price: "1.00", Currency: "EUR"}, map[string]string{}},
+		{Item{Name: "scarf", Description: "wool", Price: "1.00", Currency: "EUR"}, map[string]string{"name": "FIELD_PATTERN_MISMATCH"}},
+		{Item{Name: "Sc", Description: "wool", Price: "1.00", Currency: "EUR"}, map[string]string{"name": "FIELD_TOO_SHORT"}},
+		{Item{Name: "Scarf of wool"}, map[string]string{"name": "FIELD_TOO_LONG", "description": "FIELD_REQUIRED", "price": "FIELD_REQUIRED"}},
+		{Item{}, map[string]string{"name": "ITEM_NAME_REQUIRED", "description": "FIELD_REQUIRED", "price": "FIELD_REQUIRED"}},
+	}
+	for _, tc := range cases {
+		if got := codes(validateItem(tc.item)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("validating %+v got %v, want %v", tc.item, got, tc.want)
+		}
+	}
+
+	if got := codes(validateTranslation(Translation{Name: "schal", Description: "Wolle"})); !reflect.DeepEqual(got, map[string]string{"name": "FIELD_PATTERN_MISMATCH"}) {
+		t.Errorf("expected the name rules but not the price rule to apply to translations, got %v", got)
+	}
+}
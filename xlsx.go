@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// xlsxMediaType is the media type of Excel workbooks.
+const xlsxMediaType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// maxXLSXColumns is how many columns a sheet has in Excel, up to XFD.
+const maxXLSXColumns = 16384
+
+// maxXLSXPartBytes caps each part of a workbook read once unzipped, so a small
+// upload that unzips to gigabytes is refused.
+var maxXLSXPartBytes int64 = 64 << 20
+
+// xlsxCell is a cell to write: a number when Number is set, text otherwise.
+type xlsxCell struct {
+	Value  string
+	Number bool
+}
+
+// xlsxRow is a row read from a sheet, with its number as Excel shows it.
+type xlsxRow struct {
+	Number int
+	Cells  []string
+}
+
+const (
+	xlsxMainNamespace = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelNamespace  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxPkgNamespace  = "http://schemas.openxmlformats.org/package/2006/relationships"
+)
+
+// xlsxParts are the parts of a workbook with one sheet besides the sheet
+// itself. Excel, LibreOffice and Google Sheets open it without styles.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="` + xlsxPkgNamespace + `">` +
+		`<Relationship Id="rId1" Type="` + xlsxRelNamespace + `/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="` + xlsxMainNamespace + `" xmlns:r="` + xlsxRelNamespace + `">` +
+		`<sheets><sheet name="Items" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + xlsxPkgNamespace + `">` +
+		`<Relationship Id="rId1" Type="` + xlsxRelNamespace + `/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// writeXLSX writes a workbook with the rows on its only sheet. Text is
+// written inline, so there is no shared strings table to build.
+func writeXLSX(w io.Writer, rows [][]xlsxCell) error {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var sheet strings.Builder
+	sheet.WriteString(xml.Header + `<worksheet xmlns="` + xlsxMainNamespace + `"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			ref := xlsxColumnName(j) + strconv.Itoa(i+1)
+			if cell.Number {
+				fmt.Fprintf(&sheet, `<c r="%s"><v>%s</v></c>`, ref, cell.Value)
+				continue
+			}
+			fmt.Fprintf(&sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			xml.EscapeText(&sheet, []byte(cell.Value))
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	if _, err := io.WriteString(f, sheet.String()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// xlsxColumnName turns a column index from 0 into its letters: A, …, Z, AA.
+func xlsxColumnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxColumnIndex turns the letters of a cell reference like "AB12" into the
+// column index from 0. Columns beyond XFD all come out as maxXLSXColumns.
+func xlsxColumnIndex(ref string) int {
+	i := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		i = min(i*26+int(r-'A'+1), maxXLSXColumns+1)
+	}
+	return i - 1
+}
+
+var errNotXLSX = errors.New("not an xlsx workbook")
+
+// xlsxText is rich or plain text, in shared strings and inline strings.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	text := t.T
+	for _, run := range t.Runs {
+		text += run.T
+	}
+	return text
+}
+
+// readXLSX reads the rows of the first sheet of a workbook, every cell as the
+// text it holds. Rows without any value are left out.
+func readXLSX(r io.ReaderAt, size int64) ([]xlsxRow, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errNotXLSX
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v any) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", errNotXLSX, name)
+		}
+		if f.UncompressedSize64 > uint64(maxXLSXPartBytes) {
+			return fmt.Errorf("%w: %s is larger than %d bytes", errNotXLSX, name, maxXLSXPartBytes)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		// the size in the zip may lie, so the reading stops at the cap too
+		return xml.NewDecoder(io.LimitReader(rc, maxXLSXPartBytes)).Decode(v)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("%w: the workbook has no sheets", errNotXLSX)
+	}
+	sheetName := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[0].RelID {
+			sheetName = rel.Target
+		}
+	}
+	if strings.HasPrefix(sheetName, "/") {
+		sheetName = strings.TrimPrefix(sheetName, "/")
+	} else {
+		sheetName = path.Join("xl", sheetName)
+	}
+
+	var sharedStrings struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Number int `xml:"r,attr"`
+			Cells  []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decode(sheetName, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows []xlsxRow
+	for i, row := range sheet.Rows {
+		number := row.Number
+		if number == 0 {
+			number = i + 1
+		}
+		var cells []string
+		empty := true
+		for j, cell := range row.Cells {
+			column := j
+			if cell.Ref != "" {
+				column = xlsxColumnIndex(cell.Ref)
+			}
+			if column >= maxXLSXColumns {
+				return nil, fmt.Errorf("%w: cell %s is beyond column XFD", errNotXLSX, cell.Ref)
+			}
+			if column < 0 || column < len(cells) {
+				return nil, fmt.Errorf("%w: cell %s is out of order", errNotXLSX, cell.Ref)
+			}
+			for len(cells) < column {
+				cells = append(cells, "")
+			}
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("%w: cell %s refers to a missing string", errNotXLSX, cell.Ref)
+				}
+				value = sharedStrings.Items[index].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "b":
+				value = map[string]string{"1": "TRUE", "0": "FALSE"}[cell.Value]
+			}
+			cells = append(cells, value)
+			empty = empty && value == ""
+		}
+		if !empty {
+			rows = append(rows, xlsxRow{Number: number, Cells: cells})
+		}
+	}
+	return rows, nil
+}